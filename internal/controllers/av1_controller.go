@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// ParseOBUs parses a temporal unit in the low overhead bitstream format (i.e. the Matroska/MP4/MPEG-TS samples),
+// the OBUs without obu_has_size_field take the rest of the data.
+// ref https://aomediacodec.github.io/av1-spec/#low-overhead-bitstream-format
+func ParseOBUs(data []byte) ([]entities.OBU, error) {
+	var obus []entities.OBU
+	var sequenceHeader *entities.AV1SequenceHeader
+
+	for len(data) > 0 {
+		obu, size, err := ParseOBU(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := obu.ParsePayload(sequenceHeader); err != nil {
+			return nil, err
+		}
+		if obu.SequenceHeader != nil {
+			sequenceHeader = obu.SequenceHeader
+		}
+		obus = append(obus, obu)
+		data = data[size:]
+	}
+
+	return obus, nil
+}
+
+// ParseOBU reads the OBU header and delimits its payload, returning the OBU and its whole size.
+// ref https://aomediacodec.github.io/av1-spec/#obu-header-syntax
+func ParseOBU(data []byte) (entities.OBU, int, error) {
+	if len(data) < 1 {
+		return entities.OBU{}, 0, fmt.Errorf("%w empty obu", entities.ErrInvalidBitstream)
+	}
+	if data[0]>>7&0x01 != 0 {
+		return entities.OBU{}, 0, fmt.Errorf("%w obu_forbidden_bit is not 0", entities.ErrInvalidBitstream)
+	}
+
+	obu := entities.OBU{Type: entities.OBUType(data[0] >> 3 & 0x0f)}
+	hasExtension := data[0]>>2&0x01 == 1
+	hasSize := data[0]>>1&0x01 == 1
+	index := 1
+
+	if hasExtension {
+		if len(data) < 2 {
+			return entities.OBU{}, 0, fmt.Errorf("%w truncated obu extension header", entities.ErrInvalidBitstream)
+		}
+		obu.TemporalID = data[1] >> 5
+		obu.SpatialID = data[1] >> 3 & 0x03
+		index++
+	}
+
+	size := len(data) - index
+	if hasSize {
+		obuSize, n, err := entities.ReadLEB128(data[index:])
+		if err != nil {
+			return entities.OBU{}, 0, err
+		}
+		index += n
+		if obuSize > uint64(len(data)-index) {
+			return entities.OBU{}, 0, fmt.Errorf("%w obu_size %d is bigger than the %d bytes left", entities.ErrInvalidBitstream, obuSize, len(data)-index)
+		}
+		size = int(obuSize)
+	}
+
+	obu.Payload = data[index : index+size]
+	return obu, index + size, nil
+}
@@ -0,0 +1,181 @@
+package controllers_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	av1TemporalDelimiterOBU = []byte{0x12, 0x00}
+	// main profile level 4.0 (seq_level_idx 8) 1920x1080 sequence header
+	av1SequenceHeaderOBU = []byte{0x0a, 0x08, 0x00, 0x00, 0x00, 0x42, 0xab, 0xbf, 0xc3, 0x78}
+	// CEA-708 ITU-T T.35 metadata
+	av1MetadataOBU   = []byte{0x2a, 0x0a, 0x04, 0xb5, 0x00, 0x31, 0x47, 0x41, 0x00, 0x00, 0x01, 0x94}
+	av1KeyFrameOBU   = []byte{0x32, 0x02, 0x10, 0x00}
+	av1InterFrameOBU = []byte{0x32, 0x02, 0x30, 0x00}
+)
+
+func temporalUnit(obus ...[]byte) []byte {
+	var data []byte
+	for _, obu := range obus {
+		data = append(data, obu...)
+	}
+	return data
+}
+
+func TestParseOBUs(t *testing.T) {
+	t.Parallel()
+
+	obus, err := controllers.ParseOBUs(temporalUnit(av1TemporalDelimiterOBU, av1SequenceHeaderOBU, av1MetadataOBU, av1KeyFrameOBU))
+
+	assert.Nil(t, err)
+	assert.Len(t, obus, 4)
+	assert.Equal(t, entities.OBUTemporalDelimiter, obus[0].Type)
+	assert.Empty(t, obus[0].Payload)
+
+	assert.Equal(t, entities.OBUSequenceHeader, obus[1].Type)
+	assert.Equal(t, &entities.AV1SequenceHeader{LevelIdx: 8, MaxFrameWidth: 1920, MaxFrameHeight: 1080}, obus[1].SequenceHeader)
+
+	assert.Equal(t, entities.OBUMetadata, obus[2].Type)
+	assert.Equal(t, [][]byte{{0xb5, 0x00, 0x31, 0x47, 0x41, 0x00, 0x00, 0x01, 0x94}}, obus[2].ITUTT35Payloads())
+
+	assert.Equal(t, entities.OBUFrame, obus[3].Type)
+	assert.Equal(t, entities.AV1KeyFrame, obus[3].FrameType)
+	for i, obu := range obus {
+		assert.Equal(t, i == 3, obu.IsKeyframe(), "obu %d", i)
+	}
+}
+
+func TestParseOBU(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		data       []byte
+		obuType    entities.OBUType
+		temporalID byte
+		spatialID  byte
+		payload    []byte
+		size       int
+	}{
+		{
+			name:    "with size field",
+			data:    append(append([]byte(nil), av1KeyFrameOBU...), 0xff),
+			obuType: entities.OBUFrame,
+			payload: []byte{0x10, 0x00},
+			size:    4,
+		},
+		{
+			name:    "without size field it takes the rest",
+			data:    []byte{0x30, 0x10, 0x00, 0xff},
+			obuType: entities.OBUFrame,
+			payload: []byte{0x10, 0x00, 0xff},
+			size:    4,
+		},
+		{
+			name:       "with extension header",
+			data:       []byte{0x36, 0x30, 0x01, 0x10},
+			obuType:    entities.OBUFrame,
+			temporalID: 1,
+			spatialID:  2,
+			payload:    []byte{0x10},
+			size:       4,
+		},
+		{
+			name:    "multi byte size field",
+			data:    append([]byte{0x7a, 0x80, 0x01}, make([]byte, 128)...),
+			obuType: entities.OBUPadding,
+			payload: make([]byte, 128),
+			size:    131,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			obu, size, err := controllers.ParseOBU(tt.data)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.obuType, obu.Type)
+			assert.Equal(t, tt.temporalID, obu.TemporalID)
+			assert.Equal(t, tt.spatialID, obu.SpatialID)
+			assert.Equal(t, tt.payload, obu.Payload)
+			assert.Equal(t, tt.size, size)
+		})
+	}
+}
+
+func TestParseOBUs_Invalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "forbidden bit", data: []byte{0x80 | 0x12, 0x00}},
+		{name: "truncated extension header", data: []byte{0x36}},
+		{name: "truncated size field", data: []byte{0x32, 0x80}},
+		{name: "size bigger than the data", data: []byte{0x32, 0x05, 0x10}},
+		{name: "truncated sequence header", data: []byte{0x0a, 0x02, 0x00, 0x00}},
+		{name: "empty frame header", data: []byte{0x32, 0x00}},
+		{name: "truncated metadata type", data: []byte{0x2a, 0x01, 0x80}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := controllers.ParseOBUs(tt.data)
+			assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+		})
+	}
+
+	_, _, err := controllers.ParseOBU(nil)
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+}
+
+func TestParseVideoUnits_AV1Keyframe(t *testing.T) {
+	t.Parallel()
+
+	units, err := controllers.ParseVideoUnits(entities.AV1, temporalUnit(av1TemporalDelimiterOBU, av1SequenceHeaderOBU, av1KeyFrameOBU))
+	assert.Nil(t, err)
+	assert.True(t, controllers.IsKeyframe(units))
+
+	units, err = controllers.ParseVideoUnits(entities.AV1, temporalUnit(av1TemporalDelimiterOBU, av1InterFrameOBU))
+	assert.Nil(t, err)
+	assert.False(t, controllers.IsKeyframe(units))
+
+	// a shown existing frame isn't a key frame, even when it shows one
+	units, err = controllers.ParseVideoUnits(entities.AV1, []byte{0x1a, 0x01, 0x80})
+	assert.Nil(t, err)
+	assert.False(t, controllers.IsKeyframe(units))
+}
+
+func FuzzParseOBUs(f *testing.F) {
+	f.Add(temporalUnit(av1TemporalDelimiterOBU, av1SequenceHeaderOBU, av1MetadataOBU, av1KeyFrameOBU))
+	f.Add([]byte{0x36, 0x30, 0x01, 0x10})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		obus, err := controllers.ParseOBUs(data)
+		if err != nil {
+			return
+		}
+		for _, obu := range obus {
+			assert.LessOrEqual(t, len(obu.Payload), len(data)-1)
+		}
+	})
+}
+
+func FuzzParseOBU(f *testing.F) {
+	f.Add(av1SequenceHeaderOBU)
+	f.Add([]byte{0x30, 0x10, 0x00, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		obu, size, err := controllers.ParseOBU(data)
+		if err != nil {
+			return
+		}
+		assert.LessOrEqual(t, size, len(data))
+		assert.LessOrEqual(t, len(obu.Payload), size)
+	})
+}
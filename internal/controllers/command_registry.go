@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// CommandRegistry dispatches the client commands to the handlers registered by a session.
+type CommandRegistry struct {
+	mu       sync.RWMutex
+	handlers map[entities.CommandType]entities.CommandHandler
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	r := &CommandRegistry{
+		handlers: make(map[entities.CommandType]entities.CommandHandler),
+	}
+	r.Register(entities.CommandPing, pingHandler)
+	return r
+}
+
+func (r *CommandRegistry) Register(t entities.CommandType, h entities.CommandHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[t] = h
+}
+
+func (r *CommandRegistry) Dispatch(cmd entities.Command) entities.CommandResponse {
+	response := entities.CommandResponse{
+		ID:   cmd.ID,
+		Type: cmd.Type,
+	}
+
+	if cmd.Version != entities.DataChannelProtocolVersion {
+		response.Error = fmt.Errorf("%w %d", entities.ErrUnsupportedProtocolVersion, cmd.Version).Error()
+		return response
+	}
+
+	r.mu.RLock()
+	h, ok := r.handlers[cmd.Type]
+	r.mu.RUnlock()
+	if !ok {
+		response.Error = fmt.Errorf("%w %s", entities.ErrUnsupportedCommand, cmd.Type).Error()
+		return response
+	}
+
+	result, err := h(cmd)
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+	response.Result = result
+
+	return response
+}
+
+func pingHandler(cmd entities.Command) (interface{}, error) {
+	args := entities.PingArgs{}
+	if len(cmd.Args) > 0 {
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return nil, fmt.Errorf("%w %s", entities.ErrInvalidCommandArgs, err.Error())
+		}
+	}
+	return entities.PongResult{
+		ClientTime: args.ClientTime,
+		ServerTime: time.Now().UnixMilli(),
+	}, nil
+}
@@ -0,0 +1,92 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandRegistry_Dispatch(t *testing.T) {
+	t.Parallel()
+	errPaused := errors.New("already paused")
+	tests := []struct {
+		name           string
+		cmd            entities.Command
+		expectedResult interface{}
+		expectedErr    string
+	}{
+		{
+			name:           "a registered command",
+			cmd:            entities.Command{Version: entities.DataChannelProtocolVersion, ID: "1", Type: entities.CommandResume},
+			expectedResult: "resumed",
+		},
+		{
+			name:        "an unknown command",
+			cmd:         entities.Command{Version: entities.DataChannelProtocolVersion, ID: "2", Type: "rewind"},
+			expectedErr: fmt.Errorf("%w rewind", entities.ErrUnsupportedCommand).Error(),
+		},
+		{
+			name:        "a command of another protocol version",
+			cmd:         entities.Command{Version: entities.DataChannelProtocolVersion + 1, ID: "3", Type: entities.CommandResume},
+			expectedErr: fmt.Errorf("%w %d", entities.ErrUnsupportedProtocolVersion, entities.DataChannelProtocolVersion+1).Error(),
+		},
+		{
+			name:        "the handler error is replied",
+			cmd:         entities.Command{Version: entities.DataChannelProtocolVersion, ID: "4", Type: entities.CommandPause},
+			expectedErr: errPaused.Error(),
+		},
+		{
+			name: "a ping with invalid arguments",
+			cmd: entities.Command{
+				Version: entities.DataChannelProtocolVersion,
+				ID:      "5",
+				Type:    entities.CommandPing,
+				Args:    json.RawMessage(`{"ClientTime":"now"}`),
+			},
+			expectedErr: entities.ErrInvalidCommandArgs.Error(),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := controllers.NewCommandRegistry()
+			r.Register(entities.CommandResume, func(cmd entities.Command) (interface{}, error) {
+				return "resumed", nil
+			})
+			r.Register(entities.CommandPause, func(cmd entities.Command) (interface{}, error) {
+				return nil, errPaused
+			})
+
+			response := r.Dispatch(tt.cmd)
+			assert.Equal(t, tt.cmd.ID, response.ID)
+			assert.Equal(t, tt.cmd.Type, response.Type)
+			assert.Equal(t, tt.expectedResult, response.Result)
+			if tt.expectedErr == "" {
+				assert.Empty(t, response.Error)
+			} else {
+				assert.Contains(t, response.Error, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestCommandRegistry_Ping(t *testing.T) {
+	t.Parallel()
+	response := controllers.NewCommandRegistry().Dispatch(entities.Command{
+		Version: entities.DataChannelProtocolVersion,
+		Type:    entities.CommandPing,
+		Args:    json.RawMessage(`{"ClientTime":42}`),
+	})
+
+	assert.Empty(t, response.Error)
+	pong, ok := response.Result.(entities.PongResult)
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), pong.ClientTime)
+	assert.NotZero(t, pong.ServerTime)
+}
@@ -16,6 +16,7 @@ type DonutEngine interface {
 	ServerIngredients() (*entities.StreamInfo, error)
 	ClientIngredients() (*entities.StreamInfo, error)
 	RecipeFor(server, client *entities.StreamInfo) (*entities.DonutRecipe, error)
+	Probe() (*entities.ProbeReport, error)
 	Serve(p *entities.DonutParameters)
 }
 
@@ -53,6 +54,21 @@ func (c *DonutEngineController) EngineFor(req *entities.RequestParams) (DonutEng
 	}, nil
 }
 
+// Probe describes the source of the request, the stream id is taken from the url when it's missing.
+func (c *DonutEngineController) Probe(req entities.RequestParams) (*entities.ProbeReport, error) {
+	if req.StreamID == "" {
+		req.StreamURL, req.StreamID = entities.SplitStreamURL(req.StreamURL)
+	}
+	if err := req.Valid(); err != nil {
+		return nil, err
+	}
+	donutEngine, err := c.EngineFor(&req)
+	if err != nil {
+		return nil, err
+	}
+	return donutEngine.Probe()
+}
+
 // TODO: try to use generics
 func (c *DonutEngineController) selectProberFor(req *entities.RequestParams) probers.DonutProber {
 	for _, p := range c.p.Probers {
@@ -89,53 +105,193 @@ func (d *donutEngine) ServerIngredients() (*entities.StreamInfo, error) {
 }
 
 func (d *donutEngine) ClientIngredients() (*entities.StreamInfo, error) {
+	if d.req.Output.IsMuxed() {
+		return entities.MuxedClientStreamInfo(), nil
+	}
 	return d.mapper.FromWebRTCSessionDescriptionToStreamInfo(d.req.Offer)
 }
 
+// Probe describes the source along the recipe a browser would get, every stream is annotated with
+// its action or why it isn't sent.
+func (d *donutEngine) Probe() (*entities.ProbeReport, error) {
+	appetizer, err := d.Appetizer()
+	if err != nil {
+		return nil, err
+	}
+	report, err := d.prober.Probe(appetizer)
+	if err != nil {
+		return nil, err
+	}
+	report.StreamURL, report.StreamID = d.req.StreamURL, d.req.StreamID
+
+	server := &entities.StreamInfo{}
+	for _, st := range report.Streams {
+		if st.Type == entities.VideoType || st.Type == entities.AudioType {
+			server.Streams = append(server.Streams, st.Stream)
+		}
+	}
+	recipe, recipeErr := d.RecipeFor(server, entities.BrowserClientStreamInfo())
+
+	videoSent, audioSent := false, false
+	for i := range report.Streams {
+		st := &report.Streams[i]
+		switch st.Type {
+		case entities.VideoType:
+			switch {
+			case videoSent:
+				st.Note = "not sent, only the first video stream is"
+			case recipeErr != nil:
+				st.Note = recipeErr.Error()
+			case recipe.Video.Action == entities.DonutBypass:
+				st.Action = entities.DonutBypass
+			default:
+				st.Action = recipe.Video.Action
+				st.Note = fmt.Sprintf("transcoded to %s", recipe.Video.Codec)
+			}
+			videoSent = true
+		case entities.AudioType:
+			// the audio is always transcoded, the other streams are sent once switched to
+			st.Action = entities.DonutTranscode
+			st.Note = fmt.Sprintf("transcoded to %s", entities.Opus)
+			if audioSent {
+				st.Note += " when selected (AudioStreamIndex/AudioLanguage)"
+			}
+			audioSent = true
+		case entities.SubtitleType:
+			if report.Container == string(entities.DonutFLVFormat) && st.Codec == entities.Text {
+				st.Note = "sent as timedMetadata messages (onTextData)"
+			} else if entities.IsSupportedSubtitle(st.Codec) {
+				st.Note = "sent as captions messages when selected (SubtitleLanguage)"
+			} else {
+				st.Note = "not sent, the bitmap subtitles are not supported"
+			}
+		case entities.DataType:
+			switch st.CodecName {
+			case "scte_35":
+				st.Note = "sent as event messages"
+			case "timed_id3":
+				st.Note = "sent as timedMetadata messages"
+			default:
+				st.Note = "not sent"
+			}
+		default:
+			st.Note = "not sent"
+		}
+	}
+	return report, nil
+}
+
 func (d *donutEngine) Serve(p *entities.DonutParameters) {
 	d.streamer.Stream(p)
 }
 
+// preferableVideoCodecs are the codecs to transcode to when the client can't play the server one,
+// H264 comes first since the browsers decode it in hardware.
+var preferableVideoCodecs = []entities.Codec{entities.H264, entities.VP8, entities.VP9, entities.AV1}
+
 func (d *donutEngine) RecipeFor(server, client *entities.StreamInfo) (*entities.DonutRecipe, error) {
-	// TODO: implement proper matching
-	//
-	// suggestions:
-	//  if client.medias.contains(server.media)
-	//     bypass, server.media
-	//  else
-	//     preferable = [vp8, opus]
-	//     if union(preferable, client.medias)
-	//         transcode, preferable
+	// TODO: implement proper matching for audio
 	appetizer, err := d.Appetizer()
 	if err != nil {
 		return nil, err
 	}
 
+	video, err := d.videoTaskFor(server, client)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &entities.DonutRecipe{
 		Input: appetizer,
-		Video: entities.DonutMediaTask{
-			// Action:               entities.DonutTranscode,
+		Video: video,
+		Audio: d.audioTaskFor(client),
+		Subtitle: entities.DonutSubtitleTask{
+			Language: d.req.SubtitleLanguage,
+		},
+	}
+
+	return r, nil
+}
+
+// audioTaskFor transcodes to Opus (WebRTC) unless the client only plays AAC (i.e. HLS, MSE and restream).
+// The audio is split into the frame size of the encoder (20ms for Opus), the encoders reject the other sizes.
+func (d *donutEngine) audioTaskFor(client *entities.StreamInfo) entities.DonutMediaTask {
+	task := entities.DonutMediaTask{
+		Action:            entities.DonutTranscode,
+		Codec:             entities.Opus,
+		DonutStreamFilter: entities.AudioResamplerFixedFrameFilter(48000, 960),
+		CodecContextOptions: []entities.LibAVOptionsCodecContext{
+			entities.SetSampleRate(48000),
+			entities.SetSampleFormat("fltp"),
+		},
+		StreamIndex: d.req.AudioStreamIndex,
+		Language:    d.req.AudioLanguage,
+	}
+	if !supportsCodec(client, entities.Opus) && supportsCodec(client, entities.AAC) {
+		task.Codec = entities.AAC
+		// the AAC encoder requires frames of exactly 1024 samples
+		task.DonutStreamFilter = entities.AudioResamplerFixedFrameFilter(48000, 1024)
+		task.CodecContextOptions = append(task.CodecContextOptions, entities.SetBitRate(128_000))
+	}
+	return task
+}
+
+// videoTaskFor bypasses the server video when the client is able to decode it (only H264 for now),
+// otherwise it transcodes to the first preferable codec the client supports.
+func (d *donutEngine) videoTaskFor(server, client *entities.StreamInfo) (entities.DonutMediaTask, error) {
+	serverStream := entities.Stream{Codec: entities.H264, Type: entities.VideoType}
+	if streams := server.VideoStreams(); len(streams) > 0 {
+		serverStream = streams[0]
+	}
+
+	if serverStream.Codec == entities.H264 && d.req.Output.IsMuxed() {
+		// there's nothing to negotiate, the players (and the destinations) take the H264 profiles
+		return entities.DonutMediaTask{
 			Action:               entities.DonutBypass,
 			Codec:                entities.H264,
 			DonutBitStreamFilter: &entities.DonutH264AnnexB,
-			// CodecContextOptions: []entities.LibAVOptionsCodecContext{
-			// 	entities.SetBitRate(100_000),
-			// 	entities.SetBaselineProfile(),
-			// 	entities.SetGopSize(30),
-			// },
-		},
-		Audio: entities.DonutMediaTask{
-			Action:            entities.DonutTranscode,
-			Codec:             entities.Opus,
-			DonutStreamFilter: entities.AudioResamplerFilter(48000),
+		}, nil
+	}
+	if serverStream.Codec == entities.H264 {
+		if fmtp, ok := d.mapper.FromH264StreamToClientFmtp(serverStream, client); ok {
+			return entities.DonutMediaTask{
+				Action:               entities.DonutBypass,
+				Codec:                entities.H264,
+				Fmtp:                 fmtp,
+				DonutBitStreamFilter: &entities.DonutH264AnnexB,
+			}, nil
+		}
+	}
+
+	for _, codec := range preferableVideoCodecs {
+		if !supportsCodec(client, codec) {
+			continue
+		}
+		task := entities.DonutMediaTask{
+			Action: entities.DonutTranscode,
+			Codec:  codec,
 			CodecContextOptions: []entities.LibAVOptionsCodecContext{
-				entities.SetSampleRate(48000),
-				entities.SetSampleFormat("fltp"),
+				entities.SetBitRate(1_000_000),
+				entities.SetGopSize(60),
 			},
-		},
+			EncoderOptions: entities.LowLatencyEncoderOptions,
+		}
+		if codec == entities.H264 {
+			task.CodecContextOptions = append(task.CodecContextOptions, entities.SetBaselineProfile())
+		}
+		return task, nil
 	}
 
-	return r, nil
+	return entities.DonutMediaTask{}, fmt.Errorf("server video %s: %w", serverStream.Codec, entities.ErrMissingCompatibleStreams)
+}
+
+func supportsCodec(info *entities.StreamInfo, codec entities.Codec) bool {
+	for _, st := range info.Streams {
+		if st.Codec == codec {
+			return true
+		}
+	}
+	return false
 }
 
 func (d *donutEngine) Appetizer() (entities.DonutAppetizer, error) {
@@ -0,0 +1,175 @@
+package engine_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers/engine"
+	"github.com/flavioribeiro/donut/internal/controllers/probers"
+	"github.com/flavioribeiro/donut/internal/controllers/streamers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// fakeProber reports the same streams for any source.
+type fakeProber struct {
+	container string
+	streams   []entities.ProbedStream
+}
+
+func (p *fakeProber) StreamInfo(req entities.DonutAppetizer) (*entities.StreamInfo, error) {
+	info := &entities.StreamInfo{}
+	for _, st := range p.streams {
+		info.Streams = append(info.Streams, st.Stream)
+	}
+	return info, nil
+}
+
+func (p *fakeProber) Probe(req entities.DonutAppetizer) (*entities.ProbeReport, error) {
+	streams := make([]entities.ProbedStream, len(p.streams))
+	copy(streams, p.streams)
+	return &entities.ProbeReport{Container: p.container, Streams: streams}, nil
+}
+
+func (p *fakeProber) Match(req *entities.RequestParams) bool {
+	return true
+}
+
+type fakeStreamer struct{}
+
+func (s *fakeStreamer) Stream(p *entities.DonutParameters) {}
+
+func (s *fakeStreamer) Match(req *entities.RequestParams) bool {
+	return true
+}
+
+func video(codec entities.Codec, fmtp string) entities.ProbedStream {
+	return entities.ProbedStream{Stream: entities.Stream{Type: entities.VideoType, Codec: codec, Fmtp: fmtp}}
+}
+
+func audio(language string) entities.ProbedStream {
+	return entities.ProbedStream{Stream: entities.Stream{Type: entities.AudioType, Codec: entities.AAC, Language: language}}
+}
+
+func subtitle(codec entities.Codec) entities.ProbedStream {
+	return entities.ProbedStream{Stream: entities.Stream{Type: entities.SubtitleType, Codec: codec}}
+}
+
+func data(codecName string) entities.ProbedStream {
+	return entities.ProbedStream{Stream: entities.Stream{Type: entities.DataType, Codec: entities.UnknownCodec}, CodecName: codecName}
+}
+
+func TestDonutEngineController_Probe(t *testing.T) {
+	t.Parallel()
+	type annotation struct {
+		action entities.DonutMediaTaskAction
+		note   string
+	}
+	transcodedAudio := annotation{entities.DonutTranscode, "transcoded to opus"}
+
+	tests := []struct {
+		name      string
+		container string
+		streams   []entities.ProbedStream
+		expected  []annotation
+	}{
+		{
+			name:      "an H264 video the browsers decode is bypassed",
+			container: "mpegts",
+			streams:   []entities.ProbedStream{video(entities.H264, "packetization-mode=1;profile-level-id=4d0029"), audio("")},
+			expected:  []annotation{{entities.DonutBypass, ""}, transcodedAudio},
+		},
+		{
+			name:      "an H264 video the browsers don't decode is transcoded",
+			container: "mpegts",
+			// high 10
+			streams:  []entities.ProbedStream{video(entities.H264, "packetization-mode=1;profile-level-id=6e0029"), audio("")},
+			expected: []annotation{{entities.DonutTranscode, "transcoded to h264"}, transcodedAudio},
+		},
+		{
+			name:      "an H265 video is transcoded",
+			container: "mpegts",
+			streams:   []entities.ProbedStream{video(entities.H265, ""), audio("")},
+			expected:  []annotation{{entities.DonutTranscode, "transcoded to h264"}, transcodedAudio},
+		},
+		{
+			name:      "only the first video stream is sent",
+			container: "mpegts",
+			streams:   []entities.ProbedStream{video(entities.H264, "packetization-mode=1;profile-level-id=42e01f"), video(entities.H265, "")},
+			expected:  []annotation{{entities.DonutBypass, ""}, {"", "not sent, only the first video stream is"}},
+		},
+		{
+			name:      "the extra audio streams are sent when selected",
+			container: "mpegts",
+			streams:   []entities.ProbedStream{audio("eng"), audio("por"), audio("spa")},
+			expected: []annotation{
+				transcodedAudio,
+				{entities.DonutTranscode, "transcoded to opus when selected (AudioStreamIndex/AudioLanguage)"},
+				{entities.DonutTranscode, "transcoded to opus when selected (AudioStreamIndex/AudioLanguage)"},
+			},
+		},
+		{
+			name:      "the FLV text subtitles are timed metadata",
+			container: "flv",
+			streams:   []entities.ProbedStream{subtitle(entities.Text)},
+			expected:  []annotation{{"", "sent as timedMetadata messages (onTextData)"}},
+		},
+		{
+			name:      "the text subtitles and teletext are captions, the bitmap ones are not sent",
+			container: "mpegts",
+			streams:   []entities.ProbedStream{subtitle(entities.Text), subtitle(entities.WebVTT), subtitle(entities.DVBTeletext), subtitle(entities.DVBSubtitle)},
+			expected: []annotation{
+				{"", "sent as captions messages when selected (SubtitleLanguage)"},
+				{"", "sent as captions messages when selected (SubtitleLanguage)"},
+				{"", "sent as captions messages when selected (SubtitleLanguage)"},
+				{"", "not sent, the bitmap subtitles are not supported"},
+			},
+		},
+		{
+			name:      "the SCTE-35 and ID3 data streams are sent as messages",
+			container: "mpegts",
+			streams:   []entities.ProbedStream{data("scte_35"), data("timed_id3"), data("klv")},
+			expected: []annotation{
+				{"", "sent as event messages"},
+				{"", "sent as timedMetadata messages"},
+				{"", "not sent"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := engine.NewDonutEngineController(engine.DonutEngineParams{
+				Streamers: []streamers.DonutStreamer{&fakeStreamer{}},
+				Probers:   []probers.DonutProber{&fakeProber{container: tt.container, streams: tt.streams}},
+				Mapper:    mapper.NewMapper(zap.NewNop().Sugar()),
+			})
+
+			report, err := c.Probe(entities.RequestParams{StreamURL: "srt://localhost:40052", StreamID: "stream"})
+			assert.Nil(t, err)
+			assert.Equal(t, "srt://localhost:40052", report.StreamURL)
+			assert.Equal(t, "stream", report.StreamID)
+			var annotations []annotation
+			for _, st := range report.Streams {
+				annotations = append(annotations, annotation{st.Action, st.Note})
+			}
+			assert.Equal(t, tt.expected, annotations)
+		})
+	}
+}
+
+func TestDonutEngineController_ProbeStreamIDFromURL(t *testing.T) {
+	t.Parallel()
+	c := engine.NewDonutEngineController(engine.DonutEngineParams{
+		Streamers: []streamers.DonutStreamer{&fakeStreamer{}},
+		Probers:   []probers.DonutProber{&fakeProber{container: "flv"}},
+		Mapper:    mapper.NewMapper(zap.NewNop().Sugar()),
+	})
+
+	report, err := c.Probe(entities.RequestParams{StreamURL: "rtmp://localhost/live/key"})
+	assert.Nil(t, err)
+	assert.Equal(t, "rtmp://localhost/live", report.StreamURL)
+	assert.Equal(t, "key", report.StreamID)
+}
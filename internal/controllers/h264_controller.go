@@ -1,18 +1,30 @@
 package controllers
 
 import (
-	"bytes"
 	"fmt"
 
 	"github.com/flavioribeiro/donut/internal/entities"
 )
 
+// ParseNALUs parses an Annex-B byte stream (i.e. MPEG-TS), the NALs are prefixed by 3 or 4 bytes start codes.
 func ParseNALUs(data []byte) (entities.NALUs, error) {
-	var nalus entities.NALUs
+	return parseNALUs(SplitAnnexB(data))
+}
+
+// ParseAVCCNALUs parses the AVCC format (i.e. MP4, FLV and Matroska), the NALs are prefixed by their size
+// coded in lengthSize bytes, it's the lengthSizeMinusOne + 1 from the AVCDecoderConfigurationRecord.
+func ParseAVCCNALUs(data []byte, lengthSize int) (entities.NALUs, error) {
+	rawNALUs, err := SplitAVCC(data, lengthSize)
+	if err != nil {
+		return entities.NALUs{}, err
+	}
+	return parseNALUs(rawNALUs)
+}
 
-	rawNALUs := bytes.Split(data, []byte{0x00, 0x00, 0x01})
+func parseNALUs(rawNALUs [][]byte) (entities.NALUs, error) {
+	var nalus entities.NALUs
 
-	for _, rawNALU := range rawNALUs[1:] {
+	for _, rawNALU := range rawNALUs {
 		nal, err := ParseNAL(rawNALU)
 		if err != nil {
 			return entities.NALUs{}, err
@@ -24,36 +36,156 @@ func ParseNALUs(data []byte) (entities.NALUs, error) {
 	return nalus, nil
 }
 
+// ParseNAL parses a NAL unit without the start code or the size prefix.
 func ParseNAL(data []byte) (entities.NAL, error) {
 	index := 0
 	n := entities.NAL{}
+	if len(data) == 0 {
+		return entities.NAL{}, fmt.Errorf("%w empty nal", entities.ErrInvalidBitstream)
+	}
 	if data[index]>>7&0x01 != 0 {
-		return entities.NAL{}, fmt.Errorf("forbidden_zero_bit is not 0")
+		return entities.NAL{}, fmt.Errorf("%w forbidden_zero_bit is not 0", entities.ErrInvalidBitstream)
 	}
 	n.RefIDC = (data[index] >> 5) & 0x03
 	n.UnitType = entities.NALUnitType(data[index] & 0x1f)
-	numBytesInRBSP := 0
 	nalUnitHeaderBytes := 1
 	n.HeaderBytes = data[:nalUnitHeaderBytes]
 
 	index += nalUnitHeaderBytes
 
-	n.RBSPByte = make([]byte, 0, 16)
-	i := 0
-	for i = index; i < len(data); i++ {
-		if (i+2) < len(data) && (data[i] == 0x00 && data[i+1] == 0x00 && data[i+2] == 0x03) {
-			n.RBSPByte = append(n.RBSPByte, data[i], data[i+1])
-			i += 2
-			numBytesInRBSP += 2
-			// 0x03
+	n.RBSPByte = removeEmulationPrevention(data[index:])
+
+	if err := n.ParseRBSP(); err != nil {
+		return entities.NAL{}, err
+	}
+
+	return n, nil
+}
+
+// SplitAnnexB splits the byte stream at the 0x000001 start codes, the zeros before them (the 4 bytes start codes
+// and the trailing_zero_8bits) are dropped, so are the data before the first start code and the empty NALs.
+// Rec. ITU-T H.264 (08/2021) p.331
+func SplitAnnexB(data []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0x00 || data[i+1] != 0x00 || data[i+2] != 0x01 {
+			continue
+		}
+		if start >= 0 {
+			nalus = appendNALU(nalus, data[start:i])
+		}
+		i += 2
+		start = i + 1
+	}
+	if start >= 0 {
+		nalus = appendNALU(nalus, data[start:])
+	}
+	return nalus
+}
+
+func appendNALU(nalus [][]byte, nalu []byte) [][]byte {
+	end := len(nalu)
+	for end > 0 && nalu[end-1] == 0x00 {
+		end--
+	}
+	if end == 0 {
+		return nalus
+	}
+	return append(nalus, nalu[:end])
+}
+
+// SplitAVCC splits the NALs prefixed by their size in lengthSize (1, 2 or 4) big endian bytes.
+func SplitAVCC(data []byte, lengthSize int) ([][]byte, error) {
+	if lengthSize != 1 && lengthSize != 2 && lengthSize != 4 {
+		return nil, fmt.Errorf("%w nal length size must be 1, 2 or 4 not %d", entities.ErrInvalidBitstream, lengthSize)
+	}
+	var nalus [][]byte
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nil, fmt.Errorf("%w truncated nal length", entities.ErrInvalidBitstream)
+		}
+		size := 0
+		for _, b := range data[:lengthSize] {
+			size = size<<8 | int(b)
+		}
+		data = data[lengthSize:]
+		if size > len(data) {
+			return nil, fmt.Errorf("%w nal length %d is bigger than the %d bytes left", entities.ErrInvalidBitstream, size, len(data))
+		}
+		if size > 0 {
+			nalus = append(nalus, data[:size])
+		}
+		data = data[size:]
+	}
+	return nalus, nil
+}
+
+// removeEmulationPrevention drops the emulation_prevention_three_byte following two zeros (both H264 and H265),
+// the zeros count restarts after each dropped byte since they belong to the same escaped sequence.
+// Rec. ITU-T H.264 (08/2021) p.64
+func removeEmulationPrevention(data []byte) []byte {
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		rbsp = append(rbsp, b)
+		if b == 0x00 {
+			zeros++
 		} else {
-			n.RBSPByte = append(n.RBSPByte, data[i])
-			numBytesInRBSP++
+			zeros = 0
 		}
 	}
-	index += numBytesInRBSP
+	return rbsp
+}
 
-	n.ParseRBSP()
+// SplitH264ExtraData returns the parameter sets NALs of the extradata, it's either an AVCDecoderConfigurationRecord
+// (i.e. MP4, FLV and Matroska) or an Annex-B byte stream.
+// ISO/IEC 14496-15 (2019) p.13
+func SplitH264ExtraData(extradata []byte) ([][]byte, error) {
+	if len(extradata) == 0 || extradata[0] != 1 {
+		return SplitAnnexB(extradata), nil
+	}
+	if len(extradata) < 6 {
+		return nil, fmt.Errorf("%w truncated avc decoder configuration record", entities.ErrInvalidBitstream)
+	}
 
-	return n, nil
+	var nalus [][]byte
+	data := extradata[5:]
+	readParameterSets := func(count int) error {
+		for i := 0; i < count; i++ {
+			if len(data) < 2 {
+				return fmt.Errorf("%w truncated parameter set length", entities.ErrInvalidBitstream)
+			}
+			size := int(data[0])<<8 | int(data[1])
+			data = data[2:]
+			if size > len(data) {
+				return fmt.Errorf("%w parameter set length %d is bigger than the %d bytes left", entities.ErrInvalidBitstream, size, len(data))
+			}
+			if size > 0 {
+				nalus = append(nalus, data[:size])
+			}
+			data = data[size:]
+		}
+		return nil
+	}
+
+	// numOfSequenceParameterSets has 3 reserved bits
+	numOfSPS := int(data[0] & 0x1f)
+	data = data[1:]
+	if err := readParameterSets(numOfSPS); err != nil {
+		return nil, err
+	}
+	if len(data) < 1 {
+		return nil, fmt.Errorf("%w missing the pps count", entities.ErrInvalidBitstream)
+	}
+	numOfPPS := int(data[0])
+	data = data[1:]
+	if err := readParameterSets(numOfPPS); err != nil {
+		return nil, err
+	}
+	return nalus, nil
 }
@@ -0,0 +1,251 @@
+package controllers_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	// libx264 640x360 SPS and PPS
+	spsNAL = []byte{
+		0x67, 0x64, 0x00, 0x1e, 0xac, 0xd9, 0x40, 0xa0, 0x2f, 0xf9, 0x70, 0x11, 0x00, 0x00, 0x03, 0x03,
+		0xe9, 0x00, 0x00, 0xea, 0x60, 0x0f, 0x16, 0x2d, 0x96,
+	}
+	ppsNAL = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+	// CEA-708 user data registered SEI, the payload holds an escaped 0x000003
+	seiNAL = []byte{0x06, 0x04, 0x09, 0xb5, 0x00, 0x31, 0x47, 0x41, 0x00, 0x00, 0x03, 0x01, 0x94, 0x80}
+	idrNAL = []byte{0x65, 0x88, 0x84, 0x00, 0x33, 0xff}
+)
+
+func annexB(startCode []byte, nalus ...[]byte) []byte {
+	var data []byte
+	for _, nalu := range nalus {
+		data = append(data, startCode...)
+		data = append(data, nalu...)
+	}
+	return data
+}
+
+func TestParseNALUs_StartCodes(t *testing.T) {
+	t.Parallel()
+
+	for _, startCode := range [][]byte{{0x00, 0x00, 0x01}, {0x00, 0x00, 0x00, 0x01}} {
+		nalus, err := controllers.ParseNALUs(annexB(startCode, spsNAL, ppsNAL, seiNAL, idrNAL))
+
+		assert.Nil(t, err)
+		assert.Len(t, nalus.Units, 4)
+		assert.Equal(t, entities.SequenceParameterSet, nalus.Units[0].UnitType)
+		assert.Equal(t, 640, nalus.Units[0].SPS.Width())
+		assert.Equal(t, entities.PictureParameterSet, nalus.Units[1].UnitType)
+		assert.Equal(t, entities.SupplementalEnhancementInformation, nalus.Units[2].UnitType)
+		assert.Equal(t, idrNAL[1:], nalus.Units[3].RBSPByte)
+		assert.True(t, nalus.Units[3].IsKeyframe())
+	}
+}
+
+func TestParseNALUs_TrailingZerosAndGarbage(t *testing.T) {
+	t.Parallel()
+	data := []byte{0xde, 0xad}
+	data = append(data, annexB([]byte{0x00, 0x00, 0x00, 0x01}, ppsNAL)...)
+	data = append(data, 0x00, 0x00)
+	data = append(data, annexB([]byte{0x00, 0x00, 0x01}, idrNAL, []byte{})...)
+
+	nalus, err := controllers.ParseNALUs(data)
+
+	assert.Nil(t, err)
+	assert.Len(t, nalus.Units, 2)
+	assert.Equal(t, ppsNAL[:1], nalus.Units[0].HeaderBytes)
+	assert.Equal(t, ppsNAL[1:], nalus.Units[0].RBSPByte)
+	assert.Equal(t, idrNAL[1:], nalus.Units[1].RBSPByte)
+}
+
+func TestParseNALUs_Empty(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range [][]byte{nil, {}, {0x00, 0x00, 0x01}, {0x00, 0x00, 0x00, 0x01, 0x00}} {
+		nalus, err := controllers.ParseNALUs(data)
+
+		assert.Nil(t, err)
+		assert.Empty(t, nalus.Units)
+	}
+}
+
+func TestParseNAL_Invalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := controllers.ParseNAL(nil)
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+
+	_, err = controllers.ParseNAL([]byte{0x80 | 0x65})
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+
+	_, err = controllers.ParseNAL([]byte{0x06, 0xff, 0xff})
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+}
+
+func TestParseNAL_EmulationPrevention(t *testing.T) {
+	t.Parallel()
+
+	nal, err := controllers.ParseNAL([]byte{0x65, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x03, 0x00, 0x03})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x03}, nal.RBSPByte)
+}
+
+func TestParseNAL_SEI(t *testing.T) {
+	t.Parallel()
+
+	nal, err := controllers.ParseNAL(seiNAL)
+
+	assert.Nil(t, err)
+	assert.Len(t, nal.SEIs, 1)
+	assert.Equal(t, entities.SEIPayloadTypeUserDataRegisteredITUTT35, nal.SEIs[0].PayloadType)
+	assert.Equal(t, 9, nal.SEIs[0].PayloadSize)
+	assert.Equal(t, [][]byte{{0xb5, 0x00, 0x31, 0x47, 0x41, 0x00, 0x00, 0x01, 0x94}}, nal.ITUTT35Payloads())
+	userData, err := nal.SEIs[0].UserDataRegistered()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0xb5), userData.CountryCode)
+	assert.Equal(t, []byte{0x00, 0x31, 0x47, 0x41, 0x00, 0x00, 0x01, 0x94}, userData.Payload)
+}
+
+func TestParseNAL_MultipleSEIs(t *testing.T) {
+	t.Parallel()
+	data := []byte{byte(entities.SupplementalEnhancementInformation)}
+	// pic timing with a full clock timestamp of 01:02:03:04
+	data = append(data, 0x01, 0x06, 0x08, 0x04, 0x04, 0x0c, 0x20, 0x80)
+	// recovery point of 0 frames with exact match
+	data = append(data, 0x06, 0x01, 0xc0)
+	// user data unregistered with an uuid followed by 4 bytes
+	data = append(data, 0x05, 0x14)
+	data = append(data, 0xdc, 0x45, 0xe9, 0xbd, 0xe6, 0xd9, 0x48, 0xb7, 0x96, 0x2c, 0xd8, 0x20, 0xd9, 0x23, 0xee, 0xef)
+	data = append(data, 'd', 'n', 't', '!')
+	data = append(data, 0x80)
+
+	nal, err := controllers.ParseNAL(data)
+
+	assert.Nil(t, err)
+	assert.Len(t, nal.SEIs, 3)
+	assert.Empty(t, nal.ITUTT35Payloads())
+
+	sps := &entities.SPS{VUI: &entities.VUI{PicStructPresent: true}}
+	picTiming, err := nal.SEIs[0].PicTiming(sps)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0), picTiming.PicStruct)
+	assert.Len(t, picTiming.ClockTimestamps, 1)
+	assert.True(t, picTiming.ClockTimestamps[0].FullTimestamp)
+	assert.Equal(t, "01:02:03:04", picTiming.ClockTimestamps[0].String())
+	_, err = nal.SEIs[0].PicTiming(nil)
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+
+	recoveryPoint, err := nal.SEIs[1].RecoveryPoint()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), recoveryPoint.RecoveryFrameCnt)
+	assert.True(t, recoveryPoint.ExactMatch)
+	assert.False(t, recoveryPoint.BrokenLink)
+
+	userData, err := nal.SEIs[2].UserDataUnregistered()
+	assert.Nil(t, err)
+	assert.Equal(t, "dc45e9bd-e6d9-48b7-962c-d820d923eeef", userData.UUIDString())
+	assert.Equal(t, []byte("dnt!"), userData.Payload)
+	_, err = nal.SEIs[2].UserDataRegistered()
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+}
+
+func TestParseAVCCNALUs(t *testing.T) {
+	t.Parallel()
+	data := []byte{0x00, 0x00, 0x00, byte(len(ppsNAL))}
+	data = append(data, ppsNAL...)
+	data = append(data, 0x00, 0x00, 0x00, byte(len(idrNAL)))
+	data = append(data, idrNAL...)
+
+	nalus, err := controllers.ParseAVCCNALUs(data, 4)
+
+	assert.Nil(t, err)
+	assert.Len(t, nalus.Units, 2)
+	assert.Equal(t, entities.PictureParameterSet, nalus.Units[0].UnitType)
+	assert.Equal(t, entities.CodedSliceIDRPicture, nalus.Units[1].UnitType)
+
+	_, err = controllers.ParseAVCCNALUs(data[:len(data)-1], 4)
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+
+	_, err = controllers.ParseAVCCNALUs(data, 3)
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+}
+
+func FuzzParseNALUs(f *testing.F) {
+	f.Add(annexB([]byte{0x00, 0x00, 0x01}, spsNAL, ppsNAL, seiNAL, idrNAL))
+	f.Add(annexB([]byte{0x00, 0x00, 0x00, 0x01}, seiNAL, idrNAL))
+	f.Add([]byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		nalus, err := controllers.ParseNALUs(data)
+		if err != nil {
+			return
+		}
+		for _, nal := range nalus.Units {
+			assert.Len(t, nal.HeaderBytes, 1)
+		}
+	})
+}
+
+func FuzzParseAVCCNALUs(f *testing.F) {
+	f.Add([]byte{0x00, 0x00, 0x00, 0x06, 0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, 4)
+	f.Add([]byte{0x06, 0x65, 0x88, 0x84, 0x00, 0x33, 0xff}, 1)
+
+	f.Fuzz(func(t *testing.T, data []byte, lengthSize int) {
+		controllers.ParseAVCCNALUs(data, lengthSize)
+	})
+}
+
+func FuzzParseNAL(f *testing.F) {
+	for _, nal := range [][]byte{spsNAL, ppsNAL, seiNAL, idrNAL} {
+		f.Add(nal)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		nal, err := controllers.ParseNAL(data)
+		if err != nil {
+			return
+		}
+		assert.LessOrEqual(t, len(nal.RBSPByte), len(data)-1)
+	})
+}
+
+// FuzzParseSEI goes through ParseNAL since the SEI parsing is done while reading the RBSP.
+func FuzzParseSEI(f *testing.F) {
+	f.Add(seiNAL[1:])
+	f.Add([]byte{0xff, 0xff, 0x05, 0xff, 0x01, 0x00})
+
+	f.Fuzz(func(t *testing.T, rbsp []byte) {
+		nal, err := controllers.ParseNAL(append([]byte{byte(entities.SupplementalEnhancementInformation)}, rbsp...))
+		if err != nil {
+			return
+		}
+		assert.NotEmpty(t, nal.SEIs)
+		for _, sei := range nal.SEIs {
+			assert.LessOrEqual(t, len(sei.Payload), sei.PayloadSize)
+		}
+	})
+}
+
+func TestSplitH264ExtraData(t *testing.T) {
+	t.Parallel()
+	avcC := []byte{0x01, 0x64, 0x00, 0x1e, 0xff, 0xe1, 0x00, byte(len(spsNAL))}
+	avcC = append(avcC, spsNAL...)
+	avcC = append(avcC, 0x01, 0x00, byte(len(ppsNAL)))
+	avcC = append(avcC, ppsNAL...)
+
+	nalus, err := controllers.SplitH264ExtraData(avcC)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{spsNAL, ppsNAL}, nalus)
+
+	nalus, err = controllers.SplitH264ExtraData(annexB([]byte{0x00, 0x00, 0x00, 0x01}, spsNAL, ppsNAL))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{spsNAL, ppsNAL}, nalus)
+
+	_, err = controllers.SplitH264ExtraData(avcC[:len(avcC)-1])
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+}
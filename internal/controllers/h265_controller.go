@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+func ParseH265NALUs(data []byte) ([]entities.H265NAL, error) {
+	var nalus []entities.H265NAL
+
+	for _, rawNALU := range SplitAnnexB(data) {
+		nal, err := ParseH265NAL(rawNALU)
+		if err != nil {
+			return nil, err
+		}
+		nalus = append(nalus, nal)
+	}
+
+	return nalus, nil
+}
+
+// ParseH265NAL parses a NAL unit without the start code.
+// Rec. ITU-T H.265 (08/2021) p.66
+func ParseH265NAL(data []byte) (entities.H265NAL, error) {
+	nalUnitHeaderBytes := 2
+	if len(data) < nalUnitHeaderBytes {
+		return entities.H265NAL{}, fmt.Errorf("%w h265 nal shorter than its header", entities.ErrInvalidBitstream)
+	}
+	if data[0]>>7&0x01 != 0 {
+		return entities.H265NAL{}, fmt.Errorf("%w forbidden_zero_bit is not 0", entities.ErrInvalidBitstream)
+	}
+	temporalIDPlus1 := data[1] & 0x07
+	if temporalIDPlus1 == 0 {
+		return entities.H265NAL{}, fmt.Errorf("%w nuh_temporal_id_plus1 is 0", entities.ErrInvalidBitstream)
+	}
+
+	n := entities.H265NAL{
+		UnitType:    entities.H265NALUnitType(data[0] >> 1 & 0x3f),
+		LayerID:     (data[0]&0x01)<<5 | data[1]>>3,
+		TemporalID:  temporalIDPlus1 - 1,
+		HeaderBytes: data[:nalUnitHeaderBytes],
+		RBSPByte:    removeEmulationPrevention(data[nalUnitHeaderBytes:]),
+	}
+
+	if err := n.ParseRBSP(); err != nil {
+		return entities.H265NAL{}, err
+	}
+
+	return n, nil
+}
@@ -0,0 +1,153 @@
+package controllers_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	// main profile level 4 1920x1080 VPS, SPS and PPS, their 0x000000 are escaped
+	h265VPSNAL = []byte{
+		0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x03, 0x00, 0x78, 0x80,
+	}
+	h265SPSNAL = []byte{
+		0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x11, 0x07, 0xcb, 0xc0,
+	}
+	h265PPSNAL = []byte{0x44, 0x01, 0xe0}
+	// CEA-708 user data registered prefix SEI, the same payload as the H264 one
+	h265SEINAL    = []byte{0x4e, 0x01, 0x04, 0x09, 0xb5, 0x00, 0x31, 0x47, 0x41, 0x00, 0x00, 0x03, 0x01, 0x94, 0x80}
+	h265IDRNAL    = []byte{0x26, 0x01, 0xaf, 0x08, 0x40}
+	h265TrailRNAL = []byte{0x02, 0x01, 0xd0, 0x08, 0x40}
+)
+
+func TestParseH265NALUs(t *testing.T) {
+	t.Parallel()
+
+	nalus, err := controllers.ParseH265NALUs(annexB([]byte{0x00, 0x00, 0x00, 0x01}, h265VPSNAL, h265SPSNAL, h265PPSNAL, h265SEINAL, h265IDRNAL))
+
+	assert.Nil(t, err)
+	assert.Len(t, nalus, 5)
+	assert.Equal(t, entities.H265VPSNUT, nalus[0].UnitType)
+	assert.Equal(t, byte(1), nalus[0].VPS.MaxLayers)
+	assert.Equal(t, byte(1), nalus[0].VPS.ProfileTierLevel.ProfileIDC)
+	assert.Equal(t, byte(120), nalus[0].VPS.ProfileTierLevel.LevelIDC)
+
+	assert.Equal(t, entities.H265SPSNUT, nalus[1].UnitType)
+	assert.Equal(t, 1920, nalus[1].SPS.Width())
+	assert.Equal(t, 1080, nalus[1].SPS.Height())
+	assert.Equal(t, uint32(8), nalus[1].SPS.BitDepthLuma)
+
+	assert.Equal(t, entities.H265PPSNUT, nalus[2].UnitType)
+	assert.Equal(t, &entities.H265PPS{}, nalus[2].PPS)
+
+	assert.Equal(t, entities.H265PrefixSEINUT, nalus[3].UnitType)
+	assert.Equal(t, [][]byte{{0xb5, 0x00, 0x31, 0x47, 0x41, 0x00, 0x00, 0x01, 0x94}}, nalus[3].ITUTT35Payloads())
+
+	assert.Equal(t, entities.H265IDRWRADL, nalus[4].UnitType)
+	assert.Equal(t, h265IDRNAL[:2], nalus[4].HeaderBytes)
+	for i, nal := range nalus {
+		assert.Equal(t, i == 4, nal.IsKeyframe(), "nal %d", i)
+	}
+}
+
+func TestParseH265NAL_Header(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		data       []byte
+		unitType   entities.H265NALUnitType
+		layerID    byte
+		temporalID byte
+		keyframe   bool
+	}{
+		{name: "idr", data: h265IDRNAL, unitType: entities.H265IDRWRADL, keyframe: true},
+		{name: "cra", data: []byte{0x2a, 0x01}, unitType: entities.H265CRA, keyframe: true},
+		{name: "bla", data: []byte{0x20, 0x01}, unitType: entities.H265BLAWLP, keyframe: true},
+		{name: "trail", data: h265TrailRNAL, unitType: entities.H265TrailR},
+		{name: "rasl", data: []byte{0x12, 0x01}, unitType: entities.H265RASLR},
+		{name: "layer and temporal ids", data: []byte{0x03, 0x0b}, unitType: entities.H265TrailR, layerID: 33, temporalID: 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			nal, err := controllers.ParseH265NAL(tt.data)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.unitType, nal.UnitType)
+			assert.Equal(t, tt.layerID, nal.LayerID)
+			assert.Equal(t, tt.temporalID, nal.TemporalID)
+			assert.Equal(t, tt.keyframe, nal.IsKeyframe())
+		})
+	}
+}
+
+func TestParseH265NAL_Invalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "shorter than the header", data: []byte{0x26}},
+		{name: "forbidden zero bit", data: []byte{0x80 | 0x26, 0x01}},
+		{name: "temporal id plus 1 is 0", data: []byte{0x26, 0x00}},
+		{name: "truncated vps", data: h265VPSNAL[:8]},
+		{name: "truncated sps", data: h265SPSNAL[:16]},
+		{name: "truncated pps", data: []byte{0x44, 0x01, 0x00}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := controllers.ParseH265NAL(tt.data)
+			assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+		})
+	}
+}
+
+func TestParseVideoUnits_H265Keyframe(t *testing.T) {
+	t.Parallel()
+
+	units, err := controllers.ParseVideoUnits(entities.H265, annexB([]byte{0x00, 0x00, 0x01}, h265VPSNAL, h265SPSNAL, h265PPSNAL, h265IDRNAL))
+	assert.Nil(t, err)
+	assert.True(t, controllers.IsKeyframe(units))
+
+	units, err = controllers.ParseVideoUnits(entities.H265, annexB([]byte{0x00, 0x00, 0x01}, h265SEINAL, h265TrailRNAL))
+	assert.Nil(t, err)
+	assert.False(t, controllers.IsKeyframe(units))
+	assert.Len(t, units[0].ITUTT35Payloads(), 1)
+}
+
+func FuzzParseH265NAL(f *testing.F) {
+	for _, nal := range [][]byte{h265VPSNAL, h265SPSNAL, h265PPSNAL, h265SEINAL, h265IDRNAL} {
+		f.Add(nal)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		nal, err := controllers.ParseH265NAL(data)
+		if err != nil {
+			return
+		}
+		assert.Len(t, nal.HeaderBytes, 2)
+		assert.LessOrEqual(t, len(nal.RBSPByte), len(data)-2)
+		if nal.SPS != nil {
+			assert.Greater(t, nal.SPS.Width(), 0)
+			assert.Greater(t, nal.SPS.Height(), 0)
+		}
+	})
+}
+
+func FuzzParseH265NALUs(f *testing.F) {
+	f.Add(annexB([]byte{0x00, 0x00, 0x01}, h265VPSNAL, h265SPSNAL, h265PPSNAL, h265SEINAL, h265IDRNAL))
+	f.Add([]byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		controllers.ParseH265NALUs(data)
+	})
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+const id3HeaderSize = 10
+
+// ParseID3 parses the ID3v2 (v2.3 and v2.4) tags found in data,
+// a timed metadata packet may carry more than one tag.
+// ref https://id3.org/id3v2.4.0-structure
+func ParseID3(data []byte) ([]entities.ID3Frame, error) {
+	var frames []entities.ID3Frame
+	for len(data) >= id3HeaderSize && bytes.HasPrefix(data, []byte("ID3")) {
+		version := data[3]
+		flags := data[5]
+		size := int(syncSafeUint32(data[6:10]))
+		if version != 3 && version != 4 {
+			return nil, fmt.Errorf("%w unsupported version 2.%d", entities.ErrInvalidID3, version)
+		}
+		if id3HeaderSize+size > len(data) {
+			return nil, fmt.Errorf("%w tag size %d is bigger than the packet", entities.ErrInvalidID3, size)
+		}
+		tag := data[id3HeaderSize : id3HeaderSize+size]
+
+		data = data[id3HeaderSize+size:]
+		if flags&0x10 != 0 && len(data) >= id3HeaderSize {
+			// footer (v2.4 only)
+			data = data[id3HeaderSize:]
+		}
+
+		// v2.3 unsynchronises the whole tag, v2.4 each frame and its sizes are the unsynchronised ones
+		unsynchronised := flags&0x80 != 0
+		if unsynchronised && version == 3 {
+			tag = removeID3Unsynchronisation(tag)
+		}
+		if flags&0x40 != 0 {
+			tag = skipID3ExtendedHeader(tag, version)
+		}
+
+		tagFrames, err := parseID3Frames(tag, version, unsynchronised)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, tagFrames...)
+	}
+	return frames, nil
+}
+
+func skipID3ExtendedHeader(tag []byte, version byte) []byte {
+	if len(tag) < 4 {
+		return nil
+	}
+	// v2.3 size doesn't include the size itself
+	size := int(binary.BigEndian.Uint32(tag[:4])) + 4
+	if version == 4 {
+		size = int(syncSafeUint32(tag[:4]))
+	}
+	if size > len(tag) {
+		return nil
+	}
+	return tag[size:]
+}
+
+func parseID3Frames(tag []byte, version byte, unsynchronised bool) ([]entities.ID3Frame, error) {
+	var frames []entities.ID3Frame
+	for len(tag) >= id3HeaderSize {
+		// padding
+		if tag[0] == 0x00 {
+			break
+		}
+
+		id := string(tag[:4])
+		size := int(binary.BigEndian.Uint32(tag[4:8]))
+		if version == 4 {
+			size = int(syncSafeUint32(tag[4:8]))
+		}
+		if id3HeaderSize+size > len(tag) {
+			return nil, fmt.Errorf("%w frame %s size %d is bigger than the tag", entities.ErrInvalidID3, id, size)
+		}
+		body := tag[id3HeaderSize : id3HeaderSize+size]
+		if version == 4 {
+			formatFlags := tag[9]
+			// data length indicator
+			if formatFlags&0x01 != 0 && len(body) >= 4 {
+				body = body[4:]
+			}
+			if unsynchronised || formatFlags&0x02 != 0 {
+				body = removeID3Unsynchronisation(body)
+			}
+		}
+		frames = append(frames, parseID3Frame(id, body))
+		tag = tag[id3HeaderSize+size:]
+	}
+	return frames, nil
+}
+
+// ref https://id3.org/id3v2.4.0-frames
+func parseID3Frame(id string, body []byte) entities.ID3Frame {
+	frame := entities.ID3Frame{ID: id}
+	switch {
+	case id == "TXXX" && len(body) > 0:
+		parts := splitID3Text(body[0], body[1:], 2)
+		frame.Description = parts[0]
+		if len(parts) > 1 {
+			frame.Value = parts[1]
+		}
+	case strings.HasPrefix(id, "T") && len(body) > 0:
+		// v2.4 multiple values are null separated
+		frame.Value = strings.Join(splitID3Text(body[0], body[1:], -1), "/")
+	case id == "WXXX" && len(body) > 0:
+		parts := splitID3Text(body[0], body[1:], 2)
+		frame.Description = parts[0]
+		if len(parts) > 1 {
+			frame.Value = parts[1]
+		}
+	case strings.HasPrefix(id, "W"):
+		frame.Value = decodeLatin1(bytes.TrimRight(body, "\x00"))
+	case id == "PRIV":
+		owner, data, _ := bytes.Cut(body, []byte{0x00})
+		frame.Description = decodeLatin1(owner)
+		frame.Data = data
+	default:
+		frame.Data = body
+	}
+	return frame
+}
+
+// splitID3Text decodes the null separated strings according to the text encoding byte.
+func splitID3Text(encoding byte, data []byte, n int) []string {
+	terminator := []byte{0x00}
+	if encoding == 1 || encoding == 2 {
+		terminator = []byte{0x00, 0x00}
+	}
+
+	var result []string
+	for len(data) > 0 && (n < 0 || len(result) < n-1) {
+		i := indexID3Terminator(data, terminator)
+		if i < 0 {
+			break
+		}
+		result = append(result, decodeID3Text(encoding, data[:i]))
+		data = data[i+len(terminator):]
+	}
+	if len(data) > 0 || len(result) == 0 {
+		result = append(result, decodeID3Text(encoding, bytes.TrimSuffix(data, terminator)))
+	}
+	return result
+}
+
+// indexID3Terminator finds the terminator, the UTF-16 ones must be aligned to the code units.
+func indexID3Terminator(data, terminator []byte) int {
+	for i := 0; i+len(terminator) <= len(data); i += len(terminator) {
+		if bytes.Equal(data[i:i+len(terminator)], terminator) {
+			return i
+		}
+	}
+	return -1
+}
+
+func decodeID3Text(encoding byte, data []byte) string {
+	switch encoding {
+	case 0:
+		return decodeLatin1(data)
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(data) >= 2 && encoding == 1 {
+			// byte order mark
+			bigEndian = data[0] == 0xfe && data[1] == 0xff
+			data = data[2:]
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			if bigEndian {
+				units = append(units, binary.BigEndian.Uint16(data[i:]))
+			} else {
+				units = append(units, binary.LittleEndian.Uint16(data[i:]))
+			}
+		}
+		return string(utf16.Decode(units))
+	default:
+		return string(data)
+	}
+}
+
+func decodeLatin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// removeID3Unsynchronisation drops the 0x00 inserted after each 0xff.
+func removeID3Unsynchronisation(data []byte) []byte {
+	if !bytes.Contains(data, []byte{0xff, 0x00}) {
+		return data
+	}
+	result := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		result = append(result, data[i])
+		if data[i] == 0xff && i+1 < len(data) && data[i+1] == 0x00 {
+			i++
+		}
+	}
+	return result
+}
+
+// syncSafeUint32 decodes the 28 bits integers which have the most significant bit of every byte zeroed.
+func syncSafeUint32(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
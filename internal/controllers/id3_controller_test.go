@@ -0,0 +1,173 @@
+package controllers_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func syncSafe(size int) []byte {
+	return []byte{byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+}
+
+func id3Tag(version, flags byte, frames ...[]byte) []byte {
+	var body []byte
+	for _, frame := range frames {
+		body = append(body, frame...)
+	}
+	tag := append([]byte{'I', 'D', '3', version, 0x00, flags}, syncSafe(len(body))...)
+	return append(tag, body...)
+}
+
+// id3Frame has the v2.4 sync safe size, the v2.3 one is a plain big endian integer.
+func id3Frame(version byte, id string, formatFlags byte, body []byte) []byte {
+	size := syncSafe(len(body))
+	if version == 3 {
+		size = binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	}
+	frame := append(append([]byte(id), size...), 0x00, formatFlags)
+	return append(frame, body...)
+}
+
+func TestParseID3(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []entities.ID3Frame
+	}{
+		{
+			name: "v2.4 text frames",
+			data: id3Tag(4, 0x00,
+				id3Frame(4, "TIT2", 0, []byte("\x03Caf\xc3\xa9")),
+				id3Frame(4, "TPE1", 0, []byte("\x00A\x00B\x00")),
+			),
+			expected: []entities.ID3Frame{
+				{ID: "TIT2", Value: "Café"},
+				{ID: "TPE1", Value: "A/B"},
+			},
+		},
+		{
+			name: "v2.3 utf-16 txxx with byte order mark",
+			data: id3Tag(3, 0x00,
+				id3Frame(3, "TXXX", 0, []byte{0x01, 0xff, 0xfe, 'k', 0x00, 0x00, 0x00, 0xff, 0xfe, 'v', 0x00}),
+			),
+			expected: []entities.ID3Frame{{ID: "TXXX", Description: "k", Value: "v"}},
+		},
+		{
+			name: "priv owner and data",
+			data: id3Tag(4, 0x00,
+				id3Frame(4, "PRIV", 0, []byte("com.apple.streaming.transportStreamTimestamp\x00\x00\x00\x00\x00\x00\x01\x5f\x90")),
+			),
+			expected: []entities.ID3Frame{{
+				ID:          "PRIV",
+				Description: "com.apple.streaming.transportStreamTimestamp",
+				Data:        []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x5f, 0x90},
+			}},
+		},
+		{
+			name: "v2.3 unsynchronised tag",
+			// the frame size is the synchronised one, the tag carries its unsynchronised data
+			data: id3Tag(3, 0x80,
+				append(id3Frame(3, "PRIV", 0, []byte("o\x00\xff\xe0\xff"))[:10], "o\x00\xff\x00\xe0\xff\x00"...),
+			),
+			expected: []entities.ID3Frame{{ID: "PRIV", Description: "o", Data: []byte{0xff, 0xe0, 0xff}}},
+		},
+		{
+			name: "v2.4 unsynchronised frame with data length indicator",
+			data: id3Tag(4, 0x00,
+				id3Frame(4, "PRIV", 0x03, append(syncSafe(5), []byte("o\x00\xff\x00\xe0\xff\x00")...)),
+			),
+			expected: []entities.ID3Frame{{ID: "PRIV", Description: "o", Data: []byte{0xff, 0xe0, 0xff}}},
+		},
+		{
+			name: "v2.4 unsynchronised tag",
+			data: id3Tag(4, 0x80,
+				id3Frame(4, "PRIV", 0x00, []byte("o\x00\xff\x00\xe0")),
+			),
+			expected: []entities.ID3Frame{{ID: "PRIV", Description: "o", Data: []byte{0xff, 0xe0}}},
+		},
+		{
+			name: "v2.3 size isn't sync safe",
+			data: id3Tag(3, 0x00,
+				id3Frame(3, "PRIV", 0, append([]byte("o\x00"), make([]byte, 0x80)...)),
+			),
+			expected: []entities.ID3Frame{{ID: "PRIV", Description: "o", Data: make([]byte, 0x80)}},
+		},
+		{
+			name: "empty frame and padding",
+			data: id3Tag(4, 0x00,
+				id3Frame(4, "TIT2", 0, nil),
+				make([]byte, 16),
+			),
+			expected: []entities.ID3Frame{{ID: "TIT2", Data: []byte{}}},
+		},
+		{
+			name: "extended header",
+			data: id3Tag(4, 0x40,
+				append(syncSafe(6), 0x01, 0x00),
+				id3Frame(4, "TIT2", 0, []byte("\x00a")),
+			),
+			expected: []entities.ID3Frame{{ID: "TIT2", Value: "a"}},
+		},
+		{
+			name: "several tags",
+			data: append(
+				id3Tag(4, 0x00, id3Frame(4, "TIT2", 0, []byte("\x00a"))),
+				id3Tag(4, 0x00, id3Frame(4, "TIT2", 0, []byte("\x00b")))...,
+			),
+			expected: []entities.ID3Frame{{ID: "TIT2", Value: "a"}, {ID: "TIT2", Value: "b"}},
+		},
+		{
+			name: "no tag",
+			data: []byte("ID3"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			frames, err := controllers.ParseID3(tt.data)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, frames)
+		})
+	}
+}
+
+func TestParseID3_Invalid(t *testing.T) {
+	t.Parallel()
+	tag := id3Tag(4, 0x00, id3Frame(4, "TIT2", 0, []byte("\x00a")))
+	frameBiggerThanTag := id3Tag(4, 0x00, id3Frame(4, "TIT2", 0, []byte("\x00a")))
+	// the last byte of the frame size, after the tag header and the frame id
+	frameBiggerThanTag[17] = 0x7f
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "unsupported version", data: id3Tag(2, 0x00)},
+		{name: "tag bigger than the packet", data: tag[:len(tag)-1]},
+		{name: "frame bigger than the tag", data: frameBiggerThanTag},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := controllers.ParseID3(tt.data)
+			assert.ErrorIs(t, err, entities.ErrInvalidID3)
+		})
+	}
+}
+
+func FuzzParseID3(f *testing.F) {
+	f.Add(id3Tag(4, 0x80, id3Frame(4, "TXXX", 0x03, append(syncSafe(4), []byte("\x01\xff\xfe\x00")...))))
+	f.Add(id3Tag(3, 0x40, []byte{0x00, 0x00, 0x00, 0x06}, id3Frame(3, "PRIV", 0, []byte("o\x00\xff"))))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		controllers.ParseID3(data)
+	})
+}
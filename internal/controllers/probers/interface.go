@@ -4,5 +4,6 @@ import "github.com/flavioribeiro/donut/internal/entities"
 
 type DonutProber interface {
 	StreamInfo(req entities.DonutAppetizer) (*entities.StreamInfo, error)
+	Probe(req entities.DonutAppetizer) (*entities.ProbeReport, error)
 	Match(req *entities.RequestParams) bool
 }
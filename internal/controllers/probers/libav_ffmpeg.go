@@ -51,6 +51,52 @@ func (c *LibAVFFmpeg) StreamInfo(req entities.DonutAppetizer) (*entities.StreamI
 	closer := astikit.NewCloser()
 	defer closer.Close()
 
+	inputFormatContext, err := c.openInput(req, closer)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := []entities.Stream{}
+	for _, is := range inputFormatContext.Streams() {
+		if is.CodecParameters().MediaType() != astiav.MediaTypeAudio &&
+			is.CodecParameters().MediaType() != astiav.MediaTypeVideo {
+			c.l.Info("skipping media type", is.CodecParameters().MediaType())
+			continue
+		}
+		streams = append(streams, c.m.FromLibAVStreamToEntityStream(is))
+	}
+	si := entities.StreamInfo{Streams: streams}
+
+	return &si, nil
+}
+
+// Probe describes the container and every stream, including the subtitle and data ones.
+func (c *LibAVFFmpeg) Probe(req entities.DonutAppetizer) (*entities.ProbeReport, error) {
+	closer := astikit.NewCloser()
+	defer closer.Close()
+
+	inputFormatContext, err := c.openInput(req, closer)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &entities.ProbeReport{BitRate: inputFormatContext.BitRate()}
+	if inputFormat := inputFormatContext.InputFormat(); inputFormat != nil {
+		report.Container = inputFormat.Name()
+	}
+	if duration := inputFormatContext.Duration(); duration != astiav.NoPtsValue && duration > 0 {
+		report.DurationMS = astiav.RescaleQ(duration, astiav.NewRational(1, astiav.TimeBase), astiav.NewRational(1, 1000))
+	}
+	for _, is := range inputFormatContext.Streams() {
+		report.Streams = append(report.Streams, entities.ProbedStream{
+			Stream:    c.m.FromLibAVStreamToEntityStream(is),
+			CodecName: is.CodecParameters().CodecID().Name(),
+		})
+	}
+	return report, nil
+}
+
+func (c *LibAVFFmpeg) openInput(req entities.DonutAppetizer, closer *astikit.Closer) (*astiav.FormatContext, error) {
 	var inputFormatContext *astiav.FormatContext
 	if inputFormatContext = astiav.AllocFormatContext(); inputFormatContext == nil {
 		return nil, entities.ErrFFmpegLibAVFormatContextIsNil
@@ -71,19 +117,7 @@ func (c *LibAVFFmpeg) StreamInfo(req entities.DonutAppetizer) (*entities.StreamI
 	if err := inputFormatContext.FindStreamInfo(nil); err != nil {
 		return nil, fmt.Errorf("error while inputFormatContext.FindStreamInfo %w", err)
 	}
-
-	streams := []entities.Stream{}
-	for _, is := range inputFormatContext.Streams() {
-		if is.CodecParameters().MediaType() != astiav.MediaTypeAudio &&
-			is.CodecParameters().MediaType() != astiav.MediaTypeVideo {
-			c.l.Info("skipping media type", is.CodecParameters().MediaType())
-			continue
-		}
-		streams = append(streams, c.m.FromLibAVStreamToEntityStream(is))
-	}
-	si := entities.StreamInfo{Streams: streams}
-
-	return &si, nil
+	return inputFormatContext, nil
 }
 
 // TODO: merge common behavior (streamer / prober)
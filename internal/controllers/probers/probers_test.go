@@ -62,7 +62,7 @@ func TestSrtMpegTs_StreamInfo_264(t *testing.T) {
 
 	assert.Nil(t, err)
 	assert.NotNil(t, streamInfo)
-	assert.ElementsMatch(t, ffmpeg.ExpectedStreams(), streamInfo.Streams)
+	assert.ElementsMatch(t, ffmpeg.ExpectedStreams(), teststreaming.WithoutBitRate(streamInfo.Streams))
 }
 
 func TestSrtMpegTs_StreamInfo_265(t *testing.T) {
@@ -93,5 +93,5 @@ func TestSrtMpegTs_StreamInfo_265(t *testing.T) {
 
 	assert.Nil(t, err)
 	assert.NotNil(t, streamInfo)
-	assert.ElementsMatch(t, ffmpeg.ExpectedStreams(), streamInfo.Streams)
+	assert.ElementsMatch(t, ffmpeg.ExpectedStreams(), teststreaming.WithoutBitRate(streamInfo.Streams))
 }
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// rtpOutboundMTU is the same MTU used by the pion sample tracks.
+const rtpOutboundMTU = 1200
+
+// RTPTimestampTrack packetizes the frames itself, writing the RTP packets with the timestamps derived from
+// the source PTS, while the pion sample tracks synthesize them from the frames duration and drift over time.
+type RTPTimestampTrack struct {
+	*webrtc.TrackLocalStaticRTP
+
+	mu          sync.Mutex
+	payloader   rtp.Payloader
+	sequencer   rtp.Sequencer
+	timestamper *entities.RTPTimestamper
+}
+
+func NewRTPTimestampTrack(codec webrtc.RTPCodecCapability, payloader rtp.Payloader, id, streamID string) (*RTPTimestampTrack, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(codec, id, streamID)
+	if err != nil {
+		return nil, err
+	}
+	return &RTPTimestampTrack{
+		TrackLocalStaticRTP: track,
+		payloader:           payloader,
+		sequencer:           rtp.NewRandomSequencer(),
+		timestamper:         entities.NewRTPTimestamper(codec.ClockRate, rand.Uint32()),
+	}, nil
+}
+
+// WriteFrame packetizes the frame, all its packets share the same timestamp and the last one has the marker bit.
+func (t *RTPTimestampTrack) WriteFrame(data []byte, mediaCtx entities.MediaFrameContext) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timestamp := t.timestamper.Next(int64(mediaCtx.PTS), mediaCtx.TimeBaseNum, mediaCtx.TimeBaseDen, mediaCtx.Duration)
+	payloads := t.payloader.Payload(rtpOutboundMTU-12, data)
+	for i, payload := range payloads {
+		if err := t.WriteRTP(&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         i == len(payloads)-1,
+				SequenceNumber: t.sequencer.NextSequenceNumber(),
+				Timestamp:      timestamp,
+			},
+			Payload: payload,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package controllers
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+const spliceInfoTableID = 0xfc
+
+// "CUEI" identifies the SCTE-35 splice descriptors
+const spliceDescriptorIdentifier = 0x43554549
+
+// ParseSpliceInfoSection parses a SCTE-35 splice_info_section.
+// ANSI/SCTE 35 2022 p.28
+func ParseSpliceInfoSection(data []byte) (entities.SpliceInfo, error) {
+	info := entities.SpliceInfo{}
+	if len(data) < 17 {
+		return info, fmt.Errorf("%w section is too short (%d bytes)", entities.ErrInvalidSpliceInfo, len(data))
+	}
+	if data[0] != spliceInfoTableID {
+		return info, fmt.Errorf("%w unexpected table id 0x%x", entities.ErrInvalidSpliceInfo, data[0])
+	}
+
+	sectionLength := int(binary.BigEndian.Uint16(data[1:3]) & 0x0fff)
+	if 3+sectionLength > len(data) || sectionLength < 14 {
+		return info, fmt.Errorf("%w invalid section length %d", entities.ErrInvalidSpliceInfo, sectionLength)
+	}
+	section := data[:3+sectionLength]
+	if crc := binary.BigEndian.Uint32(section[len(section)-4:]); crc != mpegCRC32(section[:len(section)-4]) {
+		return info, fmt.Errorf("%w crc mismatch", entities.ErrInvalidSpliceInfo)
+	}
+
+	info.ProtocolVersion = section[3]
+	info.Encrypted = section[4]>>7 == 1
+	info.PTSAdjustment = int64(section[4]&0x01)<<32 | int64(binary.BigEndian.Uint32(section[5:9]))
+	info.Tier = uint16(section[10])<<4 | uint16(section[11]>>4)
+	commandLength := int(section[11]&0x0f)<<8 | int(section[12])
+	info.CommandType = entities.SpliceCommandType(section[13])
+
+	if info.Encrypted {
+		// there's no way to read the command without the control word
+		return info, nil
+	}
+
+	r := &spliceReader{data: section[14 : len(section)-4]}
+	var err error
+	switch info.CommandType {
+	case entities.SpliceInsertCommand:
+		var insert entities.SpliceInsert
+		insert, err = r.readSpliceInsert()
+		info.SpliceInsert = &insert
+	case entities.TimeSignalCommand:
+		var t entities.SpliceTime
+		t, err = r.readSpliceTime()
+		info.TimeSignal = &t
+	case entities.SpliceNull, entities.BandwidthReservation:
+	default:
+		// splice_schedule and private commands are skipped, the legacy 0xfff length means unknown
+		if commandLength == 0x0fff {
+			return info, nil
+		}
+	}
+	if err != nil {
+		return info, err
+	}
+	if commandLength != 0x0fff {
+		// the descriptors start right after the command, whatever has been read from it
+		r.offset = commandLength
+	}
+
+	descriptorLoopLength, err := r.readUint16()
+	if err != nil {
+		return info, err
+	}
+	descriptors, err := r.slice(int(descriptorLoopLength))
+	if err != nil {
+		return info, err
+	}
+	info.Segmentations, err = parseSegmentationDescriptors(descriptors)
+	if err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
+
+func parseSegmentationDescriptors(data []byte) ([]entities.Segmentation, error) {
+	var result []entities.Segmentation
+	r := &spliceReader{data: data}
+	for r.remaining() > 0 {
+		tag, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		length, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		descriptor, err := r.slice(int(length))
+		if err != nil {
+			return nil, err
+		}
+		if entities.SpliceDescriptorTag(tag) != entities.SegmentationDescriptor {
+			continue
+		}
+
+		dr := &spliceReader{data: descriptor}
+		identifier, err := dr.readUint32()
+		if err != nil {
+			return nil, err
+		}
+		if identifier != spliceDescriptorIdentifier {
+			continue
+		}
+		seg, err := dr.readSegmentation()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, seg)
+	}
+	return result, nil
+}
+
+// spliceReader reads the (byte aligned) SCTE-35 structures.
+type spliceReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *spliceReader) remaining() int {
+	return len(r.data) - r.offset
+}
+
+func (r *spliceReader) slice(n int) ([]byte, error) {
+	if n < 0 || r.remaining() < n {
+		return nil, fmt.Errorf("%w unexpected end of section", entities.ErrInvalidSpliceInfo)
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b, nil
+}
+
+func (r *spliceReader) readByte() (byte, error) {
+	b, err := r.slice(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *spliceReader) readUint16() (uint16, error) {
+	b, err := r.slice(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (r *spliceReader) readUint32() (uint32, error) {
+	b, err := r.slice(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// read33Bits reads a 33 bits value stored in 5 bytes, the most significant bit is the last of the first byte.
+func (r *spliceReader) read33Bits() (first byte, v int64, err error) {
+	b, err := r.slice(5)
+	if err != nil {
+		return 0, 0, err
+	}
+	return b[0], int64(b[0]&0x01)<<32 | int64(binary.BigEndian.Uint32(b[1:])), nil
+}
+
+// ANSI/SCTE 35 2022 p.45
+func (r *spliceReader) readSpliceTime() (entities.SpliceTime, error) {
+	b, err := r.readByte()
+	if err != nil {
+		return entities.SpliceTime{}, err
+	}
+	if b>>7 == 0 {
+		return entities.SpliceTime{}, nil
+	}
+	r.offset--
+	_, pts, err := r.read33Bits()
+	if err != nil {
+		return entities.SpliceTime{}, err
+	}
+	return entities.SpliceTime{Specified: true, PTS: pts}, nil
+}
+
+// ANSI/SCTE 35 2022 p.36
+func (r *spliceReader) readSpliceInsert() (entities.SpliceInsert, error) {
+	insert := entities.SpliceInsert{}
+	var err error
+	if insert.EventID, err = r.readUint32(); err != nil {
+		return insert, err
+	}
+	b, err := r.readByte()
+	if err != nil {
+		return insert, err
+	}
+	insert.Cancel = b>>7 == 1
+	if insert.Cancel {
+		return insert, nil
+	}
+
+	if b, err = r.readByte(); err != nil {
+		return insert, err
+	}
+	insert.OutOfNetwork = b>>7&0x01 == 1
+	insert.ProgramSplice = b>>6&0x01 == 1
+	hasDuration := b>>5&0x01 == 1
+	insert.Immediate = b>>4&0x01 == 1
+
+	if insert.ProgramSplice && !insert.Immediate {
+		if insert.SpliceTime, err = r.readSpliceTime(); err != nil {
+			return insert, err
+		}
+	}
+	if !insert.ProgramSplice {
+		componentCount, err := r.readByte()
+		if err != nil {
+			return insert, err
+		}
+		for i := 0; i < int(componentCount); i++ {
+			// component_tag
+			if _, err := r.readByte(); err != nil {
+				return insert, err
+			}
+			if !insert.Immediate {
+				// the components splice at the same time in practice, keeping the first one
+				t, err := r.readSpliceTime()
+				if err != nil {
+					return insert, err
+				}
+				if i == 0 {
+					insert.SpliceTime = t
+				}
+			}
+		}
+	}
+	if hasDuration {
+		// break_duration() ANSI/SCTE 35 2022 p.46
+		first, duration, err := r.read33Bits()
+		if err != nil {
+			return insert, err
+		}
+		insert.AutoReturn = first>>7 == 1
+		insert.BreakDuration = duration
+	}
+
+	if insert.UniqueProgramID, err = r.readUint16(); err != nil {
+		return insert, err
+	}
+	if insert.AvailNum, err = r.readByte(); err != nil {
+		return insert, err
+	}
+	if insert.AvailsExpected, err = r.readByte(); err != nil {
+		return insert, err
+	}
+	return insert, nil
+}
+
+// ANSI/SCTE 35 2022 p.62
+func (r *spliceReader) readSegmentation() (entities.Segmentation, error) {
+	seg := entities.Segmentation{}
+	var err error
+	if seg.EventID, err = r.readUint32(); err != nil {
+		return seg, err
+	}
+	b, err := r.readByte()
+	if err != nil {
+		return seg, err
+	}
+	seg.Cancel = b>>7 == 1
+	if seg.Cancel {
+		return seg, nil
+	}
+
+	if b, err = r.readByte(); err != nil {
+		return seg, err
+	}
+	programSegmentation := b>>7&0x01 == 1
+	hasDuration := b>>6&0x01 == 1
+
+	if !programSegmentation {
+		componentCount, err := r.readByte()
+		if err != nil {
+			return seg, err
+		}
+		// component_tag (8), reserved (7) and pts_offset (33)
+		if _, err := r.slice(int(componentCount) * 6); err != nil {
+			return seg, err
+		}
+	}
+	if hasDuration {
+		b, err := r.slice(5)
+		if err != nil {
+			return seg, err
+		}
+		seg.Duration = int64(b[0])<<32 | int64(binary.BigEndian.Uint32(b[1:]))
+	}
+
+	if seg.UPIDType, err = r.readByte(); err != nil {
+		return seg, err
+	}
+	upidLength, err := r.readByte()
+	if err != nil {
+		return seg, err
+	}
+	if seg.UPID, err = r.slice(int(upidLength)); err != nil {
+		return seg, err
+	}
+
+	typeID, err := r.readByte()
+	if err != nil {
+		return seg, err
+	}
+	seg.TypeID = entities.SegmentationType(typeID)
+	if seg.SegmentNum, err = r.readByte(); err != nil {
+		return seg, err
+	}
+	if seg.SegmentsExpected, err = r.readByte(); err != nil {
+		return seg, err
+	}
+	return seg, nil
+}
+
+// mpegCRC32 is the CRC used by the MPEG-2 sections (polynomial 0x04c11db7, not reflected).
+func mpegCRC32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
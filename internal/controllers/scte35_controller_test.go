@@ -0,0 +1,210 @@
+package controllers_test
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+// the sample sections of ANSI/SCTE 35 2022 p.110
+const (
+	timeSignalPlacementStartSection = "/DA0AAAAAAAA///wBQb+cr0AUAAeAhxDVUVJSAAAjn/PAAGlmbAICAAAAAAsoKGKNAIAmsnRfg=="
+	spliceInsertSection             = "/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo="
+	timeSignalPlacementEndSection   = "/DAvAAAAAAAA///wBQb+dGKQoAAZAhdDVUVJSAAAjn+fCAgAAAAALKChijUCAKnMZ1g="
+	spliceNullSection               = "/DARAAAAAAAAAP/wAAAAAHpPv/8="
+)
+
+func decodeSection(t testing.TB, section string) []byte {
+	data, err := base64.StdEncoding.DecodeString(section)
+	assert.Nil(t, err)
+	return data
+}
+
+// withCRC replaces the CRC_32 of the section, the MPEG-2 one isn't reflected unlike hash/crc32.
+func withCRC(section []byte) []byte {
+	crc := uint32(0xffffffff)
+	for _, b := range section[:len(section)-4] {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(section[len(section)-4:], crc)
+	return section
+}
+
+func TestParseSpliceInfoSection(t *testing.T) {
+	t.Parallel()
+	ti := []byte{0x00, 0x00, 0x00, 0x00, 0x2c, 0xa0, 0xa1, 0x8a}
+	tests := []struct {
+		name     string
+		section  string
+		expected entities.SpliceInfo
+	}{
+		{
+			name:    "time_signal with a placement opportunity start",
+			section: timeSignalPlacementStartSection,
+			expected: entities.SpliceInfo{
+				Tier:        0x0fff,
+				CommandType: entities.TimeSignalCommand,
+				TimeSignal:  &entities.SpliceTime{Specified: true, PTS: 0x072bd0050},
+				Segmentations: []entities.Segmentation{{
+					EventID:          0x4800008e,
+					Duration:         0x0001a599b0,
+					UPIDType:         0x08,
+					UPID:             ti,
+					TypeID:           entities.SegmentationProviderPlacementOpportunityStart,
+					SegmentNum:       2,
+					SegmentsExpected: 0,
+				}},
+			},
+		},
+		{
+			name:    "splice_insert out of network with an avail descriptor",
+			section: spliceInsertSection,
+			expected: entities.SpliceInfo{
+				Tier:        0x0fff,
+				CommandType: entities.SpliceInsertCommand,
+				SpliceInsert: &entities.SpliceInsert{
+					EventID:       0x4800008f,
+					OutOfNetwork:  true,
+					ProgramSplice: true,
+					SpliceTime:    entities.SpliceTime{Specified: true, PTS: 0x07369c02e},
+					AutoReturn:    true,
+					BreakDuration: 0x00052ccf5,
+				},
+			},
+		},
+		{
+			name:    "time_signal with a placement opportunity end",
+			section: timeSignalPlacementEndSection,
+			expected: entities.SpliceInfo{
+				Tier:        0x0fff,
+				CommandType: entities.TimeSignalCommand,
+				TimeSignal:  &entities.SpliceTime{Specified: true, PTS: 0x0746290a0},
+				Segmentations: []entities.Segmentation{{
+					EventID:    0x4800008e,
+					UPIDType:   0x08,
+					UPID:       ti,
+					TypeID:     entities.SegmentationProviderPlacementOpportunityEnd,
+					SegmentNum: 2,
+				}},
+			},
+		},
+		{
+			name:    "splice_null",
+			section: spliceNullSection,
+			expected: entities.SpliceInfo{
+				Tier:        0x0fff,
+				CommandType: entities.SpliceNull,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			info, err := controllers.ParseSpliceInfoSection(decodeSection(t, tt.section))
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, info)
+		})
+	}
+}
+
+func TestParseSpliceInfoSection_SplicePTS(t *testing.T) {
+	t.Parallel()
+	// a pts_adjustment wrapping the splice time around the 33 bits
+	section := decodeSection(t, spliceInsertSection)
+	section[4] |= 0x01
+	binary.BigEndian.PutUint32(section[5:9], 0xffffffff)
+
+	info, err := controllers.ParseSpliceInfoSection(withCRC(section))
+
+	assert.Nil(t, err)
+	assert.Equal(t, entities.MaxPTS33Bits, info.PTSAdjustment)
+	pts, ok := info.SplicePTS()
+	assert.True(t, ok)
+	assert.Equal(t, int64(0x07369c02e-1), pts)
+}
+
+func TestParseSpliceInfoSection_Invalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{
+			name:   "shorter than the header",
+			modify: func(s []byte) []byte { return s[:16] },
+		},
+		{
+			name:   "unexpected table id",
+			modify: func(s []byte) []byte { s[0] = 0x00; return withCRC(s) },
+		},
+		{
+			name:   "truncated section",
+			modify: func(s []byte) []byte { return s[:len(s)-1] },
+		},
+		{
+			name:   "crc mismatch",
+			modify: func(s []byte) []byte { s[len(s)-1] ^= 0x01; return s },
+		},
+		{
+			name: "corrupted payload",
+			// the splice time of the splice_insert
+			modify: func(s []byte) []byte { s[20] ^= 0x01; return s },
+		},
+		{
+			name: "descriptor loop bigger than the section",
+			modify: func(s []byte) []byte {
+				binary.BigEndian.PutUint16(s[34:36], 0x00ff)
+				return withCRC(s)
+			},
+		},
+		{
+			name: "descriptor bigger than the loop",
+			modify: func(s []byte) []byte {
+				s[37] = 0x09
+				return withCRC(s)
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := controllers.ParseSpliceInfoSection(tt.modify(decodeSection(t, spliceInsertSection)))
+			assert.ErrorIs(t, err, entities.ErrInvalidSpliceInfo)
+		})
+	}
+}
+
+func TestParseSpliceInfoSection_TruncatedSegmentation(t *testing.T) {
+	t.Parallel()
+	section := decodeSection(t, timeSignalPlacementStartSection)
+	// the upid length goes past the segmentation descriptor
+	section[39] = 0x20
+
+	_, err := controllers.ParseSpliceInfoSection(withCRC(section))
+	assert.ErrorIs(t, err, entities.ErrInvalidSpliceInfo)
+}
+
+func FuzzParseSpliceInfoSection(f *testing.F) {
+	for _, section := range []string{timeSignalPlacementStartSection, spliceInsertSection, timeSignalPlacementEndSection, spliceNullSection} {
+		data, _ := base64.StdEncoding.DecodeString(section)
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		controllers.ParseSpliceInfoSection(data)
+	})
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// Session is a single client streaming session.
+type Session struct {
+	ID        string
+	Params    entities.RequestParams
+	CreatedAt time.Time
+
+	mu    sync.RWMutex
+	stats entities.SessionStats
+
+	sinksMu sync.Mutex
+	sinks   []entities.MediaSink
+	// closed is set once the sinks are closed, no sink can be added afterwards
+	closed bool
+	// outputStreams are replayed to the sinks added mid stream
+	outputStreams map[entities.MediaType]entities.OutputStream
+	// keyframe is the last video keyframe, the snapshots are taken from it
+	keyframe *entities.Keyframe
+}
+
+func (s *Session) SetStats(st entities.SessionStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = st
+}
+
+// Stats returns the latest stats snapshot of the session.
+func (s *Session) Stats() entities.SessionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stats
+}
+
+// AddSink makes the sink receive the session frames, starting with the current output streams.
+// It fails once the session is over, the sink would never be closed.
+func (s *Session) AddSink(sink entities.MediaSink) error {
+	s.sinksMu.Lock()
+	defer s.sinksMu.Unlock()
+	if s.closed {
+		return fmt.Errorf("%w %s", entities.ErrSessionClosed, s.ID)
+	}
+	for _, mediaType := range []entities.MediaType{entities.VideoType, entities.AudioType} {
+		if st, ok := s.outputStreams[mediaType]; ok {
+			if err := sink.OnOutputStream(st); err != nil {
+				return err
+			}
+		}
+	}
+	s.sinks = append(s.sinks, sink)
+	return nil
+}
+
+// RemoveSink stops sending the frames to the sink, it doesn't close it.
+func (s *Session) RemoveSink(sink entities.MediaSink) {
+	s.sinksMu.Lock()
+	defer s.sinksMu.Unlock()
+	for i, current := range s.sinks {
+		if current == sink {
+			s.sinks = append(s.sinks[:i], s.sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Session) OnOutputStream(st entities.OutputStream) error {
+	s.sinksMu.Lock()
+	defer s.sinksMu.Unlock()
+	if s.outputStreams == nil {
+		s.outputStreams = make(map[entities.MediaType]entities.OutputStream)
+	}
+	s.outputStreams[st.Type] = st
+
+	var firstErr error
+	for _, sink := range s.sinks {
+		if err := sink.OnOutputStream(st); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// OnFrame sends the frame to every sink, it returns the first error.
+func (s *Session) OnFrame(mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) error {
+	s.sinksMu.Lock()
+	defer s.sinksMu.Unlock()
+	if st, ok := s.outputStreams[entities.VideoType]; ok && mediaType == entities.VideoType && c.Keyframe {
+		s.keyframe = &entities.Keyframe{Stream: st, Data: append([]byte(nil), data...), CapturedAt: time.Now()}
+	}
+	var firstErr error
+	for _, sink := range s.sinks {
+		if err := sink.OnFrame(mediaType, data, c); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Keyframe returns the last video keyframe sent.
+func (s *Session) Keyframe() (entities.Keyframe, bool) {
+	s.sinksMu.Lock()
+	defer s.sinksMu.Unlock()
+	if s.keyframe == nil {
+		return entities.Keyframe{}, false
+	}
+	return *s.keyframe, true
+}
+
+// CloseSinks closes and removes every sink, once the session is over.
+func (s *Session) CloseSinks() error {
+	s.sinksMu.Lock()
+	sinks := s.sinks
+	s.sinks = nil
+	s.closed = true
+	s.sinksMu.Unlock()
+
+	var firstErr error
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SessionController keeps track of the active sessions.
+type SessionController struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+func NewSessionController() *SessionController {
+	return &SessionController{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (c *SessionController) Create(params entities.RequestParams) (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		ID:        id,
+		Params:    params,
+		CreatedAt: time.Now(),
+		stats:     entities.SessionStats{SessionID: id},
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions[id] = s
+	return s, nil
+}
+
+func (c *SessionController) Get(id string) (*Session, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.sessions[id]
+	return s, ok
+}
+
+// List returns the active sessions, oldest first.
+func (c *SessionController) List() []*Session {
+	c.mu.RLock()
+	result := make([]*Session, 0, len(c.sessions))
+	for _, s := range c.sessions {
+		result = append(result, s)
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+func (c *SessionController) Remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, id)
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
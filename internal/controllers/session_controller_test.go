@@ -0,0 +1,103 @@
+package controllers_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSink struct {
+	streams []entities.OutputStream
+	frames  []entities.MediaType
+	err     error
+	closed  bool
+}
+
+func (s *fakeSink) OnOutputStream(st entities.OutputStream) error {
+	s.streams = append(s.streams, st)
+	return nil
+}
+
+func (s *fakeSink) OnFrame(mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) error {
+	s.frames = append(s.frames, mediaType)
+	return s.err
+}
+
+func (s *fakeSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestSession_Sinks(t *testing.T) {
+	t.Parallel()
+	session, err := controllers.NewSessionController().Create(entities.RequestParams{})
+	require.NoError(t, err)
+
+	video := entities.OutputStream{Stream: entities.Stream{Type: entities.VideoType, Codec: entities.H264}}
+	audio := entities.OutputStream{Stream: entities.Stream{Type: entities.AudioType, Codec: entities.Opus}}
+	require.NoError(t, session.OnOutputStream(video))
+	require.NoError(t, session.OnOutputStream(audio))
+
+	// a sink added mid stream gets the current output streams first
+	first := &fakeSink{err: errors.New("disk full")}
+	require.NoError(t, session.AddSink(first))
+	assert.Equal(t, []entities.OutputStream{video, audio}, first.streams)
+
+	second := &fakeSink{}
+	require.NoError(t, session.AddSink(second))
+
+	assert.EqualError(t, session.OnFrame(entities.VideoType, []byte{0x65}, entities.MediaFrameContext{}), "disk full")
+	assert.Equal(t, []entities.MediaType{entities.VideoType}, second.frames, "a failing sink doesn't hold the others")
+
+	session.RemoveSink(first)
+	require.NoError(t, session.OnFrame(entities.AudioType, []byte{0xfc}, entities.MediaFrameContext{}))
+	assert.Len(t, first.frames, 1)
+	assert.Len(t, second.frames, 2)
+
+	require.NoError(t, session.CloseSinks())
+	assert.False(t, first.closed)
+	assert.True(t, second.closed)
+}
+
+func TestSession_Keyframe(t *testing.T) {
+	t.Parallel()
+	session, err := controllers.NewSessionController().Create(entities.RequestParams{})
+	require.NoError(t, err)
+
+	keyframe := entities.MediaFrameContext{Keyframe: true}
+	require.NoError(t, session.OnFrame(entities.VideoType, []byte{0x65}, keyframe))
+	_, ok := session.Keyframe()
+	assert.False(t, ok, "the keyframe can't be decoded without its stream")
+
+	video := entities.OutputStream{Stream: entities.Stream{Type: entities.VideoType, Codec: entities.H264}}
+	require.NoError(t, session.OnOutputStream(video))
+	data := []byte{0x65, 0x01}
+	require.NoError(t, session.OnFrame(entities.VideoType, data, keyframe))
+	require.NoError(t, session.OnFrame(entities.VideoType, []byte{0x41}, entities.MediaFrameContext{}))
+	require.NoError(t, session.OnFrame(entities.AudioType, []byte{0xfc}, keyframe))
+	data[1] = 0xff
+
+	kf, ok := session.Keyframe()
+	require.True(t, ok)
+	assert.Equal(t, video, kf.Stream)
+	assert.Equal(t, []byte{0x65, 0x01}, kf.Data, "the streamer reuses its buffers")
+}
+
+func TestSession_AddSinkAfterClose(t *testing.T) {
+	t.Parallel()
+	session, err := controllers.NewSessionController().Create(entities.RequestParams{})
+	require.NoError(t, err)
+	require.NoError(t, session.AddSink(&fakeSink{}))
+	require.NoError(t, session.CloseSinks())
+
+	// i.e. a recording started while the session is ending
+	late := &fakeSink{}
+	assert.ErrorIs(t, session.AddSink(late), entities.ErrSessionClosed)
+	require.NoError(t, session.OnFrame(entities.VideoType, []byte{0x65}, entities.MediaFrameContext{}))
+	assert.Empty(t, late.frames)
+	assert.False(t, late.closed, "the caller closes the sink it couldn't add")
+}
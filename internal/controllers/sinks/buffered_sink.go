@@ -0,0 +1,114 @@
+package sinks
+
+import (
+	"sync"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// sinkInput is either an output stream or a frame, copied since the streamer reuses its buffers.
+// It's queued for the sinks muxing on their own goroutine (i.e. recorder, restreamer).
+type sinkInput struct {
+	stream    *entities.OutputStream
+	mediaType entities.MediaType
+	data      []byte
+	frameCtx  entities.MediaFrameContext
+}
+
+// bufferedSink queues the streams and frames of a session for a sink muxing them on its own goroutine, the
+// streamer (and the session holding its sinks) is never held by it. The frames are dropped once the buffer is
+// full. The streams are required to mux, so they're kept aside instead, the latest one per media type, and the
+// frames are dropped until the buffer is drained and the streams are handed over, to keep them in order.
+type bufferedSink struct {
+	inputs    chan sinkInput
+	done      chan struct{}
+	onDropped func()
+
+	mu      sync.Mutex
+	closed  bool
+	pending []entities.OutputStream
+}
+
+func newBufferedSink(size int, onDropped func()) *bufferedSink {
+	// the pending streams are handed over once the buffer is drained, so it must hold an input at least
+	if size < 1 {
+		size = 1
+	}
+	return &bufferedSink{
+		inputs:    make(chan sinkInput, size),
+		done:      make(chan struct{}),
+		onDropped: onDropped,
+	}
+}
+
+func (b *bufferedSink) OnOutputStream(st entities.OutputStream) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	if len(b.pending) == 0 {
+		select {
+		case b.inputs <- sinkInput{stream: &st}:
+			return nil
+		default:
+		}
+	}
+	for i, pending := range b.pending {
+		if pending.Type == st.Type {
+			b.pending[i] = st
+			return nil
+		}
+	}
+	b.pending = append(b.pending, st)
+	return nil
+}
+
+func (b *bufferedSink) OnFrame(mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	if len(b.pending) > 0 {
+		b.onDropped()
+		return nil
+	}
+	select {
+	case b.inputs <- sinkInput{mediaType: mediaType, data: append([]byte(nil), data...), frameCtx: c}:
+	default:
+		b.onDropped()
+	}
+	return nil
+}
+
+// Close waits for the buffered inputs to be muxed.
+func (b *bufferedSink) Close() error {
+	b.closeInputs()
+	<-b.done
+	return nil
+}
+
+// closeInputs stops queuing, the sink goroutine ends once the buffered inputs are muxed.
+func (b *bufferedSink) closeInputs() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.inputs)
+	}
+}
+
+// next returns the next input for the sink goroutine, it's false once the sink is closed and drained.
+func (b *bufferedSink) next() (sinkInput, bool) {
+	b.mu.Lock()
+	if len(b.pending) > 0 && len(b.inputs) == 0 && !b.closed {
+		st := b.pending[0]
+		b.pending = b.pending[1:]
+		b.mu.Unlock()
+		return sinkInput{stream: &st}, true
+	}
+	b.mu.Unlock()
+	in, ok := <-b.inputs
+	return in, ok
+}
@@ -0,0 +1,173 @@
+package sinks_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+var errFakeMuxer = errors.New("fake muxer has failed")
+
+// fakeMuxer writes "init" along the first frame (the header) and the frames data, as they are, when flushed.
+// Like the libav one it waits for a video keyframe and the streams can't change once it has started.
+type fakeMuxer struct {
+	format string
+	url    string
+	w      io.Writer
+
+	streams map[entities.MediaType]entities.OutputStream
+	started bool
+	pending bytes.Buffer
+	origin  time.Duration
+	last    time.Duration
+	bytes   int64
+	closed  bool
+	// fail makes WriteFrame fail once started
+	fail bool
+	// block holds the first frame written until it's closed, i.e. connecting to an unresponsive destination
+	block chan struct{}
+}
+
+// fakeMuxers is the MuxerFactory creating the fake muxers, the first failing ones fail at their first frame.
+type fakeMuxers struct {
+	mu      sync.Mutex
+	created []*fakeMuxer
+	failing int
+	block   chan struct{}
+}
+
+func (f *fakeMuxers) new(format, url string, w io.Writer, options map[string]string) sinks.Muxer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	mx := &fakeMuxer{
+		format:  format,
+		url:     url,
+		w:       w,
+		streams: make(map[entities.MediaType]entities.OutputStream),
+		fail:    len(f.created) < f.failing,
+		block:   f.block,
+	}
+	f.created = append(f.created, mx)
+	return mx
+}
+
+func (f *fakeMuxers) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.created)
+}
+
+func (f *fakeMuxers) last() *fakeMuxer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.created[len(f.created)-1]
+}
+
+func (mx *fakeMuxer) SetStream(out entities.OutputStream) bool {
+	if mx.started {
+		return false
+	}
+	mx.streams[out.Type] = out
+	return true
+}
+
+func (mx *fakeMuxer) WriteFrame(mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) (bool, error) {
+	if _, ok := mx.streams[mediaType]; !ok {
+		return false, nil
+	}
+	dts, _ := c.PTSDuration()
+	if !mx.started && mx.block != nil {
+		<-mx.block
+	}
+	if !mx.started {
+		_, hasVideo := mx.streams[entities.VideoType]
+		if hasVideo && (mediaType != entities.VideoType || !c.Keyframe) {
+			return false, nil
+		}
+		if mx.fail {
+			return false, errFakeMuxer
+		}
+		mx.started = true
+		mx.origin = dts
+		if mx.w != nil {
+			mx.w.Write([]byte("init"))
+		}
+	}
+	mx.pending.Write(data)
+	mx.bytes += int64(len(data))
+	mx.last = dts
+	return true, nil
+}
+
+func (mx *fakeMuxer) Flush() error {
+	if mx.w != nil && mx.pending.Len() > 0 {
+		mx.w.Write(mx.pending.Bytes())
+	}
+	mx.pending.Reset()
+	return nil
+}
+
+func (mx *fakeMuxer) Duration() time.Duration {
+	return mx.last - mx.origin
+}
+
+func (mx *fakeMuxer) Bytes() int64 {
+	return mx.bytes
+}
+
+func (mx *fakeMuxer) Dropped() int64 {
+	return 0
+}
+
+func (mx *fakeMuxer) Started() bool {
+	return mx.started
+}
+
+func (mx *fakeMuxer) Close() error {
+	mx.closed = true
+	return mx.Flush()
+}
+
+func videoStream() entities.OutputStream {
+	return entities.OutputStream{Stream: entities.Stream{Type: entities.VideoType, Codec: entities.H264}}
+}
+
+func audioStream() entities.OutputStream {
+	return entities.OutputStream{Stream: entities.Stream{Type: entities.AudioType, Codec: entities.AAC, SampleRate: 48000}}
+}
+
+// videoFrame is the i-th frame of a 10 fps video with a keyframe every 2s, its data is its index.
+func videoFrame(i int) ([]byte, entities.MediaFrameContext) {
+	return []byte{byte(i)}, entities.MediaFrameContext{
+		PTS:         i * 100,
+		DTS:         i * 100,
+		Duration:    100 * time.Millisecond,
+		TimeBaseNum: 1,
+		TimeBaseDen: 1000,
+		Keyframe:    i%20 == 0,
+	}
+}
+
+// feed sends the video frames [from, to) to the sink.
+func feed(t *testing.T, sink entities.MediaSink, from, to int) {
+	for i := from; i < to; i++ {
+		data, c := videoFrame(i)
+		assert.Nil(t, sink.OnFrame(entities.VideoType, data, c))
+	}
+}
+
+// frames is the data of the video frames [from, to).
+func frames(from, to int) []byte {
+	var data []byte
+	for i := from; i < to; i++ {
+		data = append(data, byte(i))
+	}
+	return data
+}
@@ -0,0 +1,142 @@
+package sinks
+
+import (
+	"context"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/controllers/engine"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+)
+
+// headlessSession is a session without a viewer (i.e. HLS, restream), its frames only go to its sinks.
+type headlessSession struct {
+	session *controllers.Session
+	l       *zap.SugaredLogger
+	cancel  context.CancelFunc
+	engine  engine.DonutEngine
+	params  *entities.DonutParameters
+}
+
+// sessionStarter prepares the headless sessions, they go through the same engine as the WebRTC ones
+// with a recipe for the params output.
+type sessionStarter struct {
+	l          *zap.SugaredLogger
+	donut      *engine.DonutEngineController
+	sessions   *controllers.SessionController
+	recordings *RecordingController
+}
+
+// start prepares the session along the sinks (and its recording, when requested), it's streamed by serve.
+func (s *sessionStarter) start(params entities.RequestParams, newSinks func(l *zap.SugaredLogger) ([]entities.MediaSink, error)) (*headlessSession, error) {
+	if err := params.Valid(); err != nil {
+		return nil, err
+	}
+	s.l.Infof("RequestParams %s", params.String())
+
+	donutEngine, err := s.donut.EngineFor(&params)
+	if err != nil {
+		return nil, err
+	}
+	serverStreamInfo, err := donutEngine.ServerIngredients()
+	if err != nil {
+		return nil, err
+	}
+	clientStreamInfo, err := donutEngine.ClientIngredients()
+	if err != nil {
+		return nil, err
+	}
+	donutRecipe, err := donutEngine.RecipeFor(serverStreamInfo, clientStreamInfo)
+	if err != nil {
+		return nil, err
+	}
+	s.l.Infof("DonutRecipe %#v", donutRecipe)
+
+	session, err := s.sessions.Create(params)
+	if err != nil {
+		return nil, err
+	}
+	l := s.l.With("session", session.ID)
+	sinks, err := newSinks(l)
+	if err != nil {
+		s.sessions.Remove(session.ID)
+		return nil, err
+	}
+	for _, sink := range sinks {
+		if err := session.AddSink(sink); err != nil {
+			session.CloseSinks()
+			s.sessions.Remove(session.ID)
+			return nil, err
+		}
+	}
+	if params.Record != nil {
+		recordingParams := *params.Record
+		recordingParams.SessionID = session.ID
+		if _, err := s.recordings.Start(recordingParams); err != nil {
+			session.CloseSinks()
+			s.sessions.Remove(session.ID)
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	donutParameters := &entities.DonutParameters{
+		Cancel:    cancel,
+		Ctx:       ctx,
+		SessionID: session.ID,
+
+		Recipe: *donutRecipe,
+
+		OnClose: cancel,
+		OnError: func(err error) {
+			l.Errorw("error while streaming", "error", err)
+		},
+		OnOutputStream: func(st *entities.OutputStream) error {
+			if err := session.OnOutputStream(*st); err != nil {
+				l.Warnw("error while sending the output stream to the sinks", "error", err)
+			}
+			return nil
+		},
+		OnVideoFrame: func(data []byte, c entities.MediaFrameContext) error {
+			sendToSinks(l, session, entities.VideoType, data, c)
+			return nil
+		},
+		OnAudioFrame: func(data []byte, c entities.MediaFrameContext) error {
+			sendToSinks(l, session, entities.AudioType, data, c)
+			return nil
+		},
+		OnStats: func(st entities.StreamerStats) {
+			session.SetStats(entities.SessionStats{
+				SessionID: session.ID,
+				Timestamp: time.Now().UnixMilli(),
+				LatencyMS: float64(st.PipelineLatencyMS),
+				Streamer:  st,
+			})
+		},
+	}
+	return &headlessSession{
+		session: session,
+		l:       l,
+		cancel:  cancel,
+		engine:  donutEngine,
+		params:  donutParameters,
+	}, nil
+}
+
+// serve streams the session until it ends or it's canceled, then its sinks are closed.
+func (s *sessionStarter) serve(hs *headlessSession) {
+	hs.engine.Serve(hs.params)
+	hs.cancel()
+	if err := hs.session.CloseSinks(); err != nil {
+		hs.l.Errorw("error while closing the session sinks", "error", err)
+	}
+	s.sessions.Remove(hs.session.ID)
+}
+
+// sendToSinks doesn't fail the streaming, a sink failing (i.e. a recording) must not end the others.
+func sendToSinks(l *zap.SugaredLogger, session *controllers.Session, mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) {
+	if err := session.OnFrame(mediaType, data, c); err != nil {
+		l.Warnw("error while sending the frame to the sinks", "error", err)
+	}
+}
@@ -0,0 +1,115 @@
+package sinks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/controllers/engine"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"go.uber.org/zap"
+)
+
+// HLSController runs the HLS sessions, they go through the same engine as the WebRTC ones
+// with a recipe for the HLS players (H264 and AAC) and the packager as their sink.
+type HLSController struct {
+	c       *entities.Config
+	m       *mapper.Mapper
+	starter sessionStarter
+
+	mu      sync.RWMutex
+	streams map[string]*hlsStream
+}
+
+type hlsStream struct {
+	packager *HLSPackager
+	cancel   context.CancelFunc
+}
+
+func NewHLSController(
+	c *entities.Config,
+	l *zap.SugaredLogger,
+	m *mapper.Mapper,
+	donut *engine.DonutEngineController,
+	sessions *controllers.SessionController,
+	recordings *RecordingController,
+) *HLSController {
+	return &HLSController{
+		c: c,
+		m: m,
+		starter: sessionStarter{
+			l:          l,
+			donut:      donut,
+			sessions:   sessions,
+			recordings: recordings,
+		},
+		streams: make(map[string]*hlsStream),
+	}
+}
+
+// Start starts packaging the stream, it's served until the stream ends or it's stopped. Once ended it's
+// still served for a window of segments, so the players get its last segments and the end of the playlist.
+func (hc *HLSController) Start(params entities.RequestParams) (entities.HLSStream, error) {
+	params.Output = entities.DonutHLS
+	var packager *HLSPackager
+	hs, err := hc.starter.start(params, func(l *zap.SugaredLogger) ([]entities.MediaSink, error) {
+		packager = NewHLSPackager(hc.c, l, NewLibAVMuxerFactory(l, hc.m))
+		return []entities.MediaSink{packager}, nil
+	})
+	if err != nil {
+		return entities.HLSStream{}, err
+	}
+
+	id := hs.session.ID
+	stream := &hlsStream{packager: packager, cancel: hs.cancel}
+	hc.mu.Lock()
+	hc.streams[id] = stream
+	hc.mu.Unlock()
+
+	go func() {
+		hc.starter.serve(hs)
+		retention := time.Duration(hc.c.HLSPlaylistSegments*hc.c.HLSSegmentDurationMS) * time.Millisecond
+		time.AfterFunc(retention, func() {
+			hc.remove(id, stream)
+		})
+	}()
+
+	return entities.HLSStream{
+		SessionID:   id,
+		PlaylistURL: entities.HLSPlaylistURL(id),
+	}, nil
+}
+
+// Stop stops the stream, its session ends along, and it's no longer served.
+func (hc *HLSController) Stop(id string) error {
+	hc.mu.Lock()
+	s, ok := hc.streams[id]
+	delete(hc.streams, id)
+	hc.mu.Unlock()
+	if !ok {
+		return entities.ErrMissingSession
+	}
+	s.cancel()
+	return nil
+}
+
+// remove evicts the ended stream, unless it was already stopped.
+func (hc *HLSController) remove(id string, s *hlsStream) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.streams[id] == s {
+		delete(hc.streams, id)
+	}
+}
+
+func (hc *HLSController) Packager(id string) (*HLSPackager, bool) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	s, ok := hc.streams[id]
+	if !ok {
+		return nil, false
+	}
+	return s.packager, true
+}
@@ -0,0 +1,314 @@
+package sinks
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+)
+
+// HLSPackager is a media sink packaging the session frames into in memory fMP4 segments, each
+// made of parts (LL-HLS) of a single fragment. The segments start at a keyframe and a new init segment
+// (with a discontinuity) is created whenever the streams change (i.e. switching the audio stream).
+type HLSPackager struct {
+	l        *zap.SugaredLogger
+	newMuxer MuxerFactory
+
+	segmentTarget time.Duration
+	partTarget    time.Duration
+	windowSize    int
+
+	mu sync.Mutex
+	// changed is closed (and replaced) whenever the playlist changes, it wakes up the blocking requests
+	changed  chan struct{}
+	playlist entities.HLSPlaylist
+	inits    map[int][]byte
+	parts    map[int][][]byte
+
+	streams map[entities.MediaType]entities.OutputStream
+	muxer   Muxer
+	buf     bytes.Buffer
+
+	initSequence  int
+	nextSequence  int
+	discontinuity bool
+
+	segmentStart    time.Duration
+	partStart       time.Duration
+	partIndependent bool
+	// end is the end of the last frame written, it's the end of the last part
+	end time.Duration
+}
+
+func NewHLSPackager(c *entities.Config, l *zap.SugaredLogger, newMuxer MuxerFactory) *HLSPackager {
+	segmentTarget := time.Duration(c.HLSSegmentDurationMS) * time.Millisecond
+	return &HLSPackager{
+		l:             l,
+		newMuxer:      newMuxer,
+		segmentTarget: segmentTarget,
+		partTarget:    time.Duration(c.HLSPartDurationMS) * time.Millisecond,
+		windowSize:    c.HLSPlaylistSegments,
+		changed:       make(chan struct{}),
+		playlist: entities.HLSPlaylist{
+			TargetDuration: segmentTarget,
+			PartTarget:     time.Duration(c.HLSPartDurationMS) * time.Millisecond,
+		},
+		inits:        make(map[int][]byte),
+		parts:        make(map[int][][]byte),
+		streams:      make(map[entities.MediaType]entities.OutputStream),
+		initSequence: -1,
+	}
+}
+
+func (p *HLSPackager) OnOutputStream(st entities.OutputStream) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streams[st.Type] = st
+	if p.muxer == nil || p.muxer.SetStream(st) {
+		return nil
+	}
+	// the streams can't change within an init segment
+	err := p.finish()
+	p.discontinuity = true
+	return err
+}
+
+func (p *HLSPackager) OnFrame(mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.playlist.Ended {
+		return nil
+	}
+	if p.muxer == nil {
+		p.muxer = p.newMuxer("mp4", "", &p.buf, fragmentedMP4Options)
+		for _, st := range p.streams {
+			p.muxer.SetStream(st)
+		}
+	}
+
+	_, dts, hasTimestamps := frameTimestamps(c)
+	started := p.muxer.Started()
+	if started && hasTimestamps && p.isCutPoint(mediaType) {
+		independent := mediaType == entities.AudioType || c.Keyframe
+		if independent && dts-p.segmentStart >= p.segmentTarget {
+			if err := p.endPart(dts, true, independent); err != nil {
+				return err
+			}
+		} else if p.partTarget > 0 && dts-p.partStart >= p.partTarget {
+			if err := p.endPart(dts, false, independent); err != nil {
+				return err
+			}
+		}
+	}
+
+	written, err := p.muxer.WriteFrame(mediaType, data, c)
+	if err != nil {
+		p.l.Errorw("error while packaging hls", "error", err)
+		p.closeMuxer()
+		return err
+	}
+	if !written {
+		return nil
+	}
+	if !started {
+		// the init segment was written along the first frame
+		p.initSequence++
+		p.inits[p.initSequence] = append([]byte(nil), p.buf.Bytes()...)
+		p.buf.Reset()
+		p.startSegment(dts)
+	}
+	if end := dts + c.Duration; end > p.end {
+		p.end = end
+	}
+	return nil
+}
+
+// Close ends the playlist, the segments are still served.
+func (p *HLSPackager) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.playlist.Ended {
+		return nil
+	}
+	err := p.finish()
+	p.playlist.Ended = true
+	p.notify()
+	return err
+}
+
+// isCutPoint tells whether a part could end before the frame, the video frames or the audio ones without video.
+func (p *HLSPackager) isCutPoint(mediaType entities.MediaType) bool {
+	if _, hasVideo := p.streams[entities.VideoType]; hasVideo {
+		return mediaType == entities.VideoType
+	}
+	return mediaType == entities.AudioType
+}
+
+func (p *HLSPackager) startSegment(start time.Duration) {
+	p.playlist.Segments = append(p.playlist.Segments, entities.HLSSegment{
+		Sequence:      p.nextSequence,
+		InitSequence:  p.initSequence,
+		Discontinuity: p.discontinuity,
+	})
+	p.nextSequence++
+	p.discontinuity = false
+	p.segmentStart, p.partStart, p.end = start, start, start
+	p.partIndependent = true
+}
+
+// endPart ends the fragment being written at the given time, along with its segment when endSegment is set.
+func (p *HLSPackager) endPart(at time.Duration, endSegment, nextIndependent bool) error {
+	if err := p.muxer.Flush(); err != nil {
+		return err
+	}
+	seg := &p.playlist.Segments[len(p.playlist.Segments)-1]
+	if p.buf.Len() > 0 {
+		part := entities.HLSPart{Duration: at - p.partStart, Independent: p.partIndependent}
+		seg.Parts = append(seg.Parts, part)
+		seg.Duration += part.Duration
+		p.parts[seg.Sequence] = append(p.parts[seg.Sequence], append([]byte(nil), p.buf.Bytes()...))
+		p.buf.Reset()
+		p.partStart, p.partIndependent = at, nextIndependent
+	}
+
+	if endSegment && len(seg.Parts) > 0 {
+		seg.Complete = true
+		if seg.Duration > p.playlist.TargetDuration {
+			// the segments are longer than the target when the keyframes are further apart
+			p.playlist.TargetDuration = seg.Duration
+		}
+		p.startSegment(at)
+		p.slideWindow()
+	}
+	p.notify()
+	return nil
+}
+
+// finish ends the last part and segment and closes the muxer, the next frame starts a new init segment.
+func (p *HLSPackager) finish() error {
+	if p.muxer == nil {
+		return nil
+	}
+	var err error
+	if p.muxer.Started() {
+		err = p.endPart(p.end, true, true)
+		// the last segment was just started, it's empty
+		p.playlist.Segments = p.playlist.Segments[:len(p.playlist.Segments)-1]
+		p.nextSequence--
+	}
+	p.closeMuxer()
+	return err
+}
+
+func (p *HLSPackager) closeMuxer() {
+	if err := p.muxer.Close(); err != nil {
+		p.l.Warnw("error while closing the hls muxer", "error", err)
+	}
+	p.muxer = nil
+	// the trailer isn't part of any segment
+	p.buf.Reset()
+}
+
+// slideWindow drops the oldest segments from the playlist, their data is kept for another window
+// since the players may still be loading them.
+func (p *HLSPackager) slideWindow() {
+	complete := len(p.playlist.Segments) - 1
+	for complete > p.windowSize {
+		p.playlist.Segments = p.playlist.Segments[1:]
+		complete--
+		if p.playlist.Segments[0].Discontinuity {
+			p.playlist.DiscontinuitySequence++
+		}
+	}
+
+	oldest := p.playlist.Segments[0].Sequence - p.windowSize
+	for sequence := range p.parts {
+		if sequence < oldest {
+			delete(p.parts, sequence)
+		}
+	}
+	for initSequence := range p.inits {
+		if initSequence < p.playlist.Segments[0].InitSequence-1 {
+			delete(p.inits, initSequence)
+		}
+	}
+}
+
+func (p *HLSPackager) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// waitFor waits until the condition is met (checked whenever the playlist changes) or the timeout.
+func (p *HLSPackager) waitFor(cond func() bool, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		p.mu.Lock()
+		met := cond()
+		changed := p.changed
+		p.mu.Unlock()
+		if met {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// Playlist renders the media playlist, once it has the part (a negative one is the whole segment)
+// when blocking. It waits at most for three target durations, it returns false without waiting when
+// the segment is too far ahead.
+func (p *HLSPackager) Playlist(block bool, sequence, part int) (string, bool) {
+	if block {
+		p.mu.Lock()
+		tooFarAhead := p.playlist.IsTooFarAhead(sequence)
+		p.mu.Unlock()
+		if tooFarAhead {
+			return "", false
+		}
+		p.waitFor(func() bool { return p.playlist.HasPart(sequence, part) }, 3*p.segmentTarget)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.playlist.M3U8(), true
+}
+
+func (p *HLSPackager) Init(initSequence int) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data, ok := p.inits[initSequence]
+	return data, ok
+}
+
+// Segment is the concatenation of the segment parts, only the complete segments are available.
+func (p *HLSPackager) Segment(sequence int) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	parts, ok := p.parts[sequence]
+	if !ok || !p.playlist.HasPart(sequence, -1) {
+		return nil, false
+	}
+	return bytes.Join(parts, nil), true
+}
+
+// Part waits for the part being written, the one announced by the preload hint.
+func (p *HLSPackager) Part(sequence, part int) ([]byte, bool) {
+	var data []byte
+	ok := p.waitFor(func() bool {
+		parts := p.parts[sequence]
+		if part < len(parts) {
+			data = parts[part]
+			return true
+		}
+		nextSequence, nextPart := p.playlist.NextPart()
+		// only the next part is waited for, the others are either gone or too far ahead
+		return p.playlist.Ended || sequence != nextSequence || part != nextPart
+	}, 3*p.partTarget+p.segmentTarget)
+	return data, ok && data != nil
+}
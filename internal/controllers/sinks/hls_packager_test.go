@@ -0,0 +1,188 @@
+package sinks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newHLSPackager(muxers *fakeMuxers) *sinks.HLSPackager {
+	c := &entities.Config{
+		HLSSegmentDurationMS: 2000,
+		HLSPartDurationMS:    500,
+		HLSPlaylistSegments:  2,
+	}
+	return sinks.NewHLSPackager(c, zap.NewNop().Sugar(), muxers.new)
+}
+
+func TestHLSPackager_SegmentsAndParts(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	p := newHLSPackager(muxers)
+	assert.Nil(t, p.OnOutputStream(videoStream()))
+
+	// the frames before the first keyframe aren't packaged
+	data, c := videoFrame(19)
+	assert.Nil(t, p.OnFrame(entities.VideoType, data, c))
+	feed(t, p, 0, 41)
+
+	init, ok := p.Init(0)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("init"), init)
+
+	// a part each 500ms and a segment at each keyframe (2s)
+	for part := 0; part < 4; part++ {
+		data, ok := p.Part(0, part)
+		assert.True(t, ok)
+		assert.Equal(t, frames(part*5, part*5+5), data, "part %d", part)
+	}
+	segment, ok := p.Segment(0)
+	assert.True(t, ok)
+	assert.Equal(t, frames(0, 20), segment)
+	segment, ok = p.Segment(1)
+	assert.True(t, ok)
+	assert.Equal(t, frames(20, 40), segment)
+	_, ok = p.Segment(2)
+	assert.False(t, ok, "the segment is still in progress")
+
+	playlist, ok := p.Playlist(false, 0, 0)
+	assert.True(t, ok)
+	assert.Contains(t, playlist, `#EXT-X-PART:DURATION=0.500,URI="part0.0.m4s",INDEPENDENT=YES`+"\n"+
+		`#EXT-X-PART:DURATION=0.500,URI="part0.1.m4s"`+"\n")
+	assert.Contains(t, playlist, "#EXTINF:2.000,\nseg0.m4s\n")
+	assert.Contains(t, playlist, "#EXTINF:2.000,\nseg1.m4s\n")
+	assert.Contains(t, playlist, `#EXT-X-PRELOAD-HINT:TYPE=PART,URI="part2.0.m4s"`)
+
+	// the last segment ends along the packager
+	assert.Nil(t, p.Close())
+	segment, ok = p.Segment(2)
+	assert.True(t, ok)
+	assert.Equal(t, frames(40, 41), segment)
+	playlist, _ = p.Playlist(false, 0, 0)
+	assert.Contains(t, playlist, "#EXTINF:0.100,\nseg2.m4s\n#EXT-X-ENDLIST\n")
+	assert.True(t, muxers.last().closed)
+}
+
+func TestHLSPackager_StreamChange(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	p := newHLSPackager(muxers)
+	assert.Nil(t, p.OnOutputStream(videoStream()))
+	feed(t, p, 0, 25)
+
+	// i.e. an audio stream is selected, the muxer is started again at the next keyframe
+	assert.Nil(t, p.OnOutputStream(audioStream()))
+	assert.True(t, muxers.last().closed)
+	feed(t, p, 25, 61)
+	assert.Equal(t, 2, muxers.count())
+
+	segment, ok := p.Segment(1)
+	assert.True(t, ok)
+	assert.Equal(t, frames(20, 25), segment, "the segment ends along the streams")
+	segment, ok = p.Segment(2)
+	assert.True(t, ok)
+	assert.Equal(t, frames(40, 60), segment, "the frames before the keyframe are skipped")
+	_, ok = p.Init(1)
+	assert.True(t, ok)
+
+	playlist, _ := p.Playlist(false, 0, 0)
+	assert.Contains(t, playlist, "#EXTINF:0.500,\nseg1.m4s\n#EXT-X-DISCONTINUITY\n"+`#EXT-X-MAP:URI="init1.mp4"`)
+}
+
+func TestHLSPackager_Window(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	p := newHLSPackager(muxers)
+	assert.Nil(t, p.OnOutputStream(videoStream()))
+	feed(t, p, 0, 121)
+
+	// the playlist has the last 2 complete segments, the data is kept for another window
+	playlist, _ := p.Playlist(false, 0, 0)
+	assert.Contains(t, playlist, "#EXT-X-MEDIA-SEQUENCE:4\n")
+	assert.NotContains(t, playlist, "seg3.m4s")
+	for sequence := 0; sequence < 6; sequence++ {
+		_, ok := p.Segment(sequence)
+		assert.Equal(t, sequence >= 2, ok, "segment %d", sequence)
+	}
+	_, ok := p.Part(1, 0)
+	assert.False(t, ok)
+}
+
+func TestHLSPackager_InitEviction(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	p := newHLSPackager(muxers)
+	assert.Nil(t, p.OnOutputStream(videoStream()))
+	feed(t, p, 0, 21)
+	assert.Nil(t, p.OnOutputStream(audioStream()))
+	feed(t, p, 30, 61)
+	assert.Nil(t, p.OnOutputStream(videoStream()))
+	feed(t, p, 70, 161)
+
+	// the window starts at the third init segment, the previous one is still kept
+	playlist, _ := p.Playlist(false, 0, 0)
+	assert.Contains(t, playlist, `#EXT-X-MAP:URI="init2.mp4"`)
+	assert.NotContains(t, playlist, `init1.mp4`)
+	_, ok := p.Init(0)
+	assert.False(t, ok)
+	_, ok = p.Init(1)
+	assert.True(t, ok)
+	_, ok = p.Init(2)
+	assert.True(t, ok)
+}
+
+func TestHLSPackager_Blocking(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	p := newHLSPackager(muxers)
+	assert.Nil(t, p.OnOutputStream(videoStream()))
+	feed(t, p, 0, 6)
+
+	type result struct {
+		data []byte
+		ok   bool
+	}
+	parts := make(chan result, 1)
+	go func() {
+		data, ok := p.Part(0, 1)
+		parts <- result{data, ok}
+	}()
+	playlists := make(chan string, 1)
+	go func() {
+		playlist, _ := p.Playlist(true, 0, 1)
+		playlists <- playlist
+	}()
+
+	select {
+	case <-parts:
+		t.Fatal("the part is returned before it's written")
+	case <-playlists:
+		t.Fatal("the playlist is returned before it has the part")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	feed(t, p, 6, 11)
+	select {
+	case r := <-parts:
+		assert.True(t, r.ok)
+		assert.Equal(t, frames(5, 10), r.data)
+	case <-time.After(time.Second):
+		t.Fatal("the part is still waited for")
+	}
+	select {
+	case playlist := <-playlists:
+		assert.Contains(t, playlist, `URI="part0.1.m4s"`)
+	case <-time.After(time.Second):
+		t.Fatal("the playlist is still waited for")
+	}
+
+	// only the next part is waited for, the blocking reloads too far ahead are rejected
+	_, ok := p.Part(0, 5)
+	assert.False(t, ok)
+	_, ok = p.Playlist(true, 3, 0)
+	assert.False(t, ok)
+}
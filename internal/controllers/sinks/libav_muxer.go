@@ -0,0 +1,349 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/asticode/go-astikit"
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"go.uber.org/zap"
+)
+
+var nanosecondTimeBase = astiav.NewRational(1, int(time.Second))
+
+var annexBStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// fragmentedMP4Options fragment the mp4 only when the muxer is flushed, i.e. a HLS part or a MSE fragment.
+var fragmentedMP4Options = map[string]string{"movflags": "frag_custom+empty_moov+default_base_moof"}
+
+// Muxer writes the output frames into a container, the sinks create one with their MuxerFactory.
+type Muxer interface {
+	// SetStream adds the stream or replaces it, it returns false once the streams can't change anymore.
+	SetStream(out entities.OutputStream) bool
+	// WriteFrame returns false when the frame was skipped (i.e. waiting for a keyframe).
+	WriteFrame(mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) (bool, error)
+	// Flush ends the current fragment, when the format is fragmented.
+	Flush() error
+	Duration() time.Duration
+	Bytes() int64
+	Dropped() int64
+	// Started tells whether the header was written.
+	Started() bool
+	Close() error
+}
+
+// MuxerFactory creates the muxer for the libav format name (i.e. mp4, matroska), writing to w or to the url
+// when w is nil. The options are the muxer private ones (i.e. movflags).
+type MuxerFactory func(format, url string, w io.Writer, options map[string]string) Muxer
+
+// NewLibAVMuxerFactory creates the LibAVMuxer ones.
+func NewLibAVMuxerFactory(l *zap.SugaredLogger, m *mapper.Mapper) MuxerFactory {
+	return func(format, url string, w io.Writer, options map[string]string) Muxer {
+		if w != nil {
+			return NewLibAVWriterMuxer(l, m, format, w, options)
+		}
+		return NewLibAVMuxer(l, m, format, url, options)
+	}
+}
+
+// LibAVMuxer muxes the output frames (the ones sent to the client) with libav. The header is only written
+// at the first video keyframe, so the output starts with one, and its timestamps start from it.
+// The H264 parameter sets are taken from that keyframe when the extradata lacks them (i.e. MPEG-TS sources).
+type LibAVMuxer struct {
+	l *zap.SugaredLogger
+	m *mapper.Mapper
+
+	format  string
+	url     string
+	options map[string]string
+	// writer replaces the url when set, the muxer writes to it through a custom io context
+	writer io.Writer
+
+	streams map[entities.MediaType]*muxedStream
+
+	closer        *astikit.Closer
+	fc            *astiav.FormatContext
+	pb            *astiav.IOContext
+	pkt           *astiav.Packet
+	headerWritten bool
+
+	// origin is the DTS of the first frame written
+	origin  time.Duration
+	lastDTS time.Duration
+	bytes   int64
+	dropped int64
+}
+
+type muxedStream struct {
+	out     entities.OutputStream
+	st      *astiav.Stream
+	lastDTS int64
+	hasDTS  bool
+}
+
+// NewLibAVMuxer creates the muxer for the libav format name (i.e. mp4, matroska) writing to the url,
+// the options are the muxer private ones (i.e. movflags).
+func NewLibAVMuxer(l *zap.SugaredLogger, m *mapper.Mapper, format, url string, options map[string]string) *LibAVMuxer {
+	return &LibAVMuxer{
+		l:       l,
+		m:       m,
+		format:  format,
+		url:     url,
+		options: options,
+		streams: make(map[entities.MediaType]*muxedStream),
+	}
+}
+
+// NewLibAVWriterMuxer creates the muxer writing to w instead of a file (i.e. the in memory HLS segments),
+// the format must not require seeking the output (i.e. a fragmented mp4).
+func NewLibAVWriterMuxer(l *zap.SugaredLogger, m *mapper.Mapper, format string, w io.Writer, options map[string]string) *LibAVMuxer {
+	mx := NewLibAVMuxer(l, m, format, "", options)
+	mx.writer = w
+	return mx
+}
+
+// SetStream adds the stream or replaces it, it returns false once the header is written since
+// the streams can't change anymore.
+func (mx *LibAVMuxer) SetStream(out entities.OutputStream) bool {
+	if mx.headerWritten {
+		return false
+	}
+	if out.Codec == entities.H264 {
+		out.ExtraData = h264AnnexBExtraData(out.ExtraData)
+	}
+	mx.streams[out.Type] = &muxedStream{out: out}
+	return true
+}
+
+// WriteFrame writes the frame, it returns false when the frame was skipped (i.e. waiting for a keyframe).
+func (mx *LibAVMuxer) WriteFrame(mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) (bool, error) {
+	ms, ok := mx.streams[mediaType]
+	if !ok {
+		return false, nil
+	}
+	pts, dts, ok := frameTimestamps(c)
+	if !ok {
+		mx.dropped++
+		return false, nil
+	}
+
+	if !mx.headerWritten {
+		_, hasVideo := mx.streams[entities.VideoType]
+		if hasVideo && (mediaType != entities.VideoType || !c.Keyframe) {
+			return false, nil
+		}
+		if err := mx.writeHeader(data); err != nil {
+			return false, err
+		}
+		mx.origin, mx.lastDTS = dts, dts
+	}
+	if dts < mx.origin {
+		return false, nil
+	}
+
+	// the muxers reject the non increasing DTS of a stream
+	pktDTS := astiav.RescaleQ(int64(dts-mx.origin), nanosecondTimeBase, ms.st.TimeBase())
+	if ms.hasDTS && pktDTS <= ms.lastDTS {
+		mx.dropped++
+		return false, nil
+	}
+	ms.lastDTS, ms.hasDTS = pktDTS, true
+
+	mx.pkt.Unref()
+	if err := mx.pkt.FromData(data); err != nil {
+		return false, err
+	}
+	mx.pkt.SetStreamIndex(ms.st.Index())
+	mx.pkt.SetDts(pktDTS)
+	mx.pkt.SetPts(astiav.RescaleQ(int64(pts-mx.origin), nanosecondTimeBase, ms.st.TimeBase()))
+	mx.pkt.SetDuration(astiav.RescaleQ(int64(c.Duration), nanosecondTimeBase, ms.st.TimeBase()))
+	if c.Keyframe || mediaType == entities.AudioType {
+		mx.pkt.SetFlags(astiav.NewPacketFlags(astiav.PacketFlagKey))
+	}
+	if err := mx.fc.WriteInterleavedFrame(mx.pkt); err != nil {
+		return false, fmt.Errorf("writing frame failed: %w", err)
+	}
+	mx.bytes += int64(len(data))
+	if dts > mx.lastDTS {
+		mx.lastDTS = dts
+	}
+	return true, nil
+}
+
+func (mx *LibAVMuxer) writeHeader(keyframe []byte) (err error) {
+	mx.closer = astikit.NewCloser()
+	defer func() {
+		if err != nil {
+			mx.closer.Close()
+		}
+	}()
+
+	if mx.fc, err = astiav.AllocOutputFormatContext(nil, mx.format, mx.url); err != nil {
+		return fmt.Errorf("allocating output format context failed: %w", err)
+	}
+	if mx.fc == nil {
+		return errors.New("output format context is nil")
+	}
+	mx.closer.Add(mx.fc.Free)
+
+	// video first, some players expect it
+	for _, mediaType := range []entities.MediaType{entities.VideoType, entities.AudioType} {
+		ms, ok := mx.streams[mediaType]
+		if !ok {
+			continue
+		}
+		if ms.out.Codec == entities.H264 && len(ms.out.ExtraData) == 0 {
+			ms.out.ExtraData = h264AnnexBExtraData(keyframe)
+		}
+		if ms.st = mx.fc.NewStream(nil); ms.st == nil {
+			return errors.New("output stream is nil")
+		}
+		if err = mx.m.FromOutputStreamToLibAVCodecParameters(ms.out, ms.st.CodecParameters()); err != nil {
+			return err
+		}
+		if mediaType == entities.AudioType && ms.out.SampleRate > 0 {
+			ms.st.SetTimeBase(astiav.NewRational(1, ms.out.SampleRate))
+		} else {
+			ms.st.SetTimeBase(astiav.NewRational(1, 90000))
+		}
+	}
+
+	if mx.writer != nil {
+		if mx.pb, err = astiav.AllocIOContext(4096, nil, nil, mx.writer.Write); err != nil {
+			return fmt.Errorf("allocating io context failed: %w", err)
+		}
+		mx.closer.Add(mx.pb.Free)
+		mx.fc.SetPb(mx.pb)
+	} else if !mx.fc.OutputFormat().Flags().Has(astiav.IOFormatFlagNofile) {
+		if mx.pb, err = astiav.OpenIOContext(mx.url, astiav.NewIOContextFlags(astiav.IOContextFlagWrite)); err != nil {
+			return fmt.Errorf("opening %s failed: %w", mx.url, err)
+		}
+		mx.closer.AddWithError(mx.pb.Close)
+		mx.fc.SetPb(mx.pb)
+	}
+
+	options := astiav.NewDictionary()
+	defer options.Free()
+	for k, v := range mx.options {
+		if err = options.Set(k, v, astiav.NewDictionaryFlags()); err != nil {
+			return err
+		}
+	}
+	if err = mx.fc.WriteHeader(options); err != nil {
+		return fmt.Errorf("writing header failed: %w", err)
+	}
+	mx.headerWritten = true
+	// the header is written apart from the frames (i.e. the HLS init segment)
+	if mx.pb != nil {
+		mx.pb.Flush()
+	}
+
+	mx.pkt = astiav.AllocPacket()
+	mx.closer.Add(mx.pkt.Free)
+	return nil
+}
+
+// Flush writes the frames queued for interleaving and ends the current fragment, when the format is
+// fragmented with movflags=frag_custom (i.e. a HLS part).
+func (mx *LibAVMuxer) Flush() error {
+	if !mx.headerWritten {
+		return nil
+	}
+	if err := mx.fc.WriteInterleavedFrame(nil); err != nil {
+		return fmt.Errorf("flushing interleaved frames failed: %w", err)
+	}
+	if err := mx.fc.WriteFrame(nil); err != nil {
+		return fmt.Errorf("flushing fragment failed: %w", err)
+	}
+	if mx.pb != nil {
+		mx.pb.Flush()
+	}
+	return nil
+}
+
+// Duration is the time span written so far.
+func (mx *LibAVMuxer) Duration() time.Duration {
+	if !mx.headerWritten {
+		return 0
+	}
+	return mx.lastDTS - mx.origin
+}
+
+// Bytes is the media data written so far, without the container overhead.
+func (mx *LibAVMuxer) Bytes() int64 {
+	return mx.bytes
+}
+
+// Dropped is the number of frames dropped for their missing or non increasing timestamps.
+func (mx *LibAVMuxer) Dropped() int64 {
+	return mx.dropped
+}
+
+// Started tells whether the header was written.
+func (mx *LibAVMuxer) Started() bool {
+	return mx.headerWritten
+}
+
+// Close writes the trailer, when the header was written, and releases the libav resources.
+func (mx *LibAVMuxer) Close() error {
+	if mx.closer == nil {
+		return nil
+	}
+	var err error
+	if mx.headerWritten {
+		if err = mx.fc.WriteTrailer(); err != nil {
+			err = fmt.Errorf("writing trailer failed: %w", err)
+		}
+	}
+	if closeErr := mx.closer.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	mx.closer = nil
+	mx.headerWritten = false
+	return err
+}
+
+// frameTimestamps returns the PTS and DTS, either one replaces the other when it's missing.
+func frameTimestamps(c entities.MediaFrameContext) (time.Duration, time.Duration, bool) {
+	pts, hasPTS := c.PTSDuration()
+	dtsCtx := c
+	dtsCtx.PTS = c.DTS
+	dts, hasDTS := dtsCtx.PTSDuration()
+	switch {
+	case hasPTS && hasDTS:
+		return pts, dts, true
+	case hasPTS:
+		return pts, pts, true
+	case hasDTS:
+		return dts, dts, true
+	}
+	return 0, 0, false
+}
+
+// h264AnnexBExtraData returns the SPS and PPS found at the data (avcC or Annex-B) as Annex-B,
+// the muxers convert it (and the Annex-B frames) to their format. It's nil without both.
+func h264AnnexBExtraData(data []byte) []byte {
+	nalus, err := controllers.SplitH264ExtraData(data)
+	if err != nil {
+		return nil
+	}
+	var sps, pps, out []byte
+	for _, nalu := range nalus {
+		switch entities.NALUnitType(nalu[0] & 0x1f) {
+		case entities.SequenceParameterSet:
+			sps = nalu
+		case entities.PictureParameterSet:
+			pps = nalu
+		}
+	}
+	if sps == nil || pps == nil {
+		return nil
+	}
+	out = append(append(out, annexBStartCode...), sps...)
+	return append(append(out, annexBStartCode...), pps...)
+}
@@ -0,0 +1,215 @@
+package sinks
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+)
+
+// MSEFragment is either an init segment, along with its MIME type, or a moof/mdat fragment.
+type MSEFragment struct {
+	MimeType string
+	Data     []byte
+}
+
+// MSEFragmenter is a media sink cutting the session frames into fMP4 fragments for Media Source Extensions,
+// either one per video frame or one per GOP. The fragments are buffered for the WebSocket writer, when it
+// doesn't keep up they're dropped until the next keyframe so the player can still decode the next ones.
+type MSEFragmenter struct {
+	l        *zap.SugaredLogger
+	newMuxer MuxerFactory
+
+	perGOP bool
+
+	mu        sync.Mutex
+	fragments chan MSEFragment
+	closed    bool
+
+	streams map[entities.MediaType]entities.OutputStream
+	muxer   Muxer
+	buf     bytes.Buffer
+
+	// independent tells whether the fragment being written starts with a keyframe
+	independent bool
+	// waitKeyframe is set once a fragment is dropped
+	waitKeyframe bool
+	dropped      int64
+}
+
+func NewMSEFragmenter(c *entities.Config, l *zap.SugaredLogger, newMuxer MuxerFactory) *MSEFragmenter {
+	return &MSEFragmenter{
+		l:         l,
+		newMuxer:  newMuxer,
+		perGOP:    c.MSEFragmentPerGOP,
+		fragments: make(chan MSEFragment, c.MSEBufferedFragments),
+		streams:   make(map[entities.MediaType]entities.OutputStream),
+	}
+}
+
+// Fragments are closed along the fragmenter.
+func (f *MSEFragmenter) Fragments() <-chan MSEFragment {
+	return f.fragments
+}
+
+func (f *MSEFragmenter) OnOutputStream(st entities.OutputStream) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.streams[st.Type] = st
+	if f.muxer == nil || f.muxer.SetStream(st) {
+		return nil
+	}
+	// the streams can't change within an init segment, a new one is sent at the next keyframe
+	err := f.flush()
+	f.closeMuxer()
+	return err
+}
+
+func (f *MSEFragmenter) OnFrame(mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	if f.muxer == nil {
+		f.muxer = f.newMuxer("mp4", "", &f.buf, fragmentedMP4Options)
+		for _, st := range f.streams {
+			f.muxer.SetStream(st)
+		}
+	}
+
+	started := f.muxer.Started()
+	independent := mediaType == entities.AudioType || c.Keyframe
+	if started && f.isCutPoint(mediaType) && (independent || !f.perGOP) {
+		if err := f.flush(); err != nil {
+			return err
+		}
+		f.independent = independent
+	}
+
+	written, err := f.muxer.WriteFrame(mediaType, data, c)
+	if err != nil {
+		f.l.Errorw("error while fragmenting mse", "error", err)
+		f.closeMuxer()
+		return err
+	}
+	if written && !started {
+		// the init segment was written along the first frame
+		f.send(MSEFragment{MimeType: mseMimeType(f.streams, data), Data: f.takeBuffer()})
+		f.independent = true
+	}
+	return nil
+}
+
+// Close ends the fragments, the ones buffered are still sent.
+func (f *MSEFragmenter) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	var err error
+	if f.muxer != nil {
+		err = f.flush()
+		f.closeMuxer()
+	}
+	f.closed = true
+	close(f.fragments)
+	return err
+}
+
+// Dropped is the number of fragments the WebSocket didn't keep up with.
+func (f *MSEFragmenter) Dropped() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dropped
+}
+
+// isCutPoint tells whether a fragment could end before the frame, the video frames or the audio ones without video.
+func (f *MSEFragmenter) isCutPoint(mediaType entities.MediaType) bool {
+	if _, hasVideo := f.streams[entities.VideoType]; hasVideo {
+		return mediaType == entities.VideoType
+	}
+	return mediaType == entities.AudioType
+}
+
+// flush ends the fragment being written.
+func (f *MSEFragmenter) flush() error {
+	if f.muxer == nil || !f.muxer.Started() {
+		return nil
+	}
+	if err := f.muxer.Flush(); err != nil {
+		return err
+	}
+	if f.buf.Len() == 0 {
+		return nil
+	}
+	data := f.takeBuffer()
+	if f.waitKeyframe && !f.independent {
+		f.dropped++
+		return nil
+	}
+	f.send(MSEFragment{Data: data})
+	return nil
+}
+
+func (f *MSEFragmenter) send(fragment MSEFragment) {
+	select {
+	case f.fragments <- fragment:
+		f.waitKeyframe = false
+	default:
+		f.dropped++
+		f.waitKeyframe = true
+		if fragment.MimeType != "" {
+			// the player can't decode anything without the init segment, it's created again
+			f.closeMuxer()
+		}
+	}
+}
+
+func (f *MSEFragmenter) takeBuffer() []byte {
+	data := append([]byte(nil), f.buf.Bytes()...)
+	f.buf.Reset()
+	return data
+}
+
+func (f *MSEFragmenter) closeMuxer() {
+	if err := f.muxer.Close(); err != nil {
+		f.l.Warnw("error while closing the mse muxer", "error", err)
+	}
+	f.muxer = nil
+	// the trailer isn't part of any fragment
+	f.buf.Reset()
+}
+
+// mseMimeType is the SourceBuffer type of the streams (RFC 6381 codecs), the H264 profile is taken
+// from the keyframe when the extradata lacks the SPS (i.e. MPEG-TS sources).
+func mseMimeType(streams map[entities.MediaType]entities.OutputStream, keyframe []byte) string {
+	var codecs []string
+	if st, ok := streams[entities.VideoType]; ok && st.Codec == entities.H264 {
+		codec := "avc1.42e01f"
+		extraData := h264AnnexBExtraData(st.ExtraData)
+		if extraData == nil {
+			extraData = h264AnnexBExtraData(keyframe)
+		}
+		for _, nalu := range controllers.SplitAnnexB(extraData) {
+			if len(nalu) >= 4 && entities.NALUnitType(nalu[0]&0x1f) == entities.SequenceParameterSet {
+				codec = fmt.Sprintf("avc1.%02x%02x%02x", nalu[1], nalu[2], nalu[3])
+			}
+		}
+		codecs = append(codecs, codec)
+	}
+	if st, ok := streams[entities.AudioType]; ok {
+		switch st.Codec {
+		case entities.AAC:
+			codecs = append(codecs, "mp4a.40.2")
+		case entities.Opus:
+			codecs = append(codecs, "opus")
+		}
+	}
+	return fmt.Sprintf("video/mp4; codecs=%q", strings.Join(codecs, ","))
+}
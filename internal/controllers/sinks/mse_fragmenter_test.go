@@ -0,0 +1,185 @@
+package sinks_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newMSEFragmenter(muxers *fakeMuxers, perGOP bool, buffered int) *sinks.MSEFragmenter {
+	c := &entities.Config{
+		MSEFragmentPerGOP:    perGOP,
+		MSEBufferedFragments: buffered,
+	}
+	return sinks.NewMSEFragmenter(c, zap.NewNop().Sugar(), muxers.new)
+}
+
+// drain returns the fragments buffered.
+func drain(f *sinks.MSEFragmenter) []sinks.MSEFragment {
+	var result []sinks.MSEFragment
+	for {
+		select {
+		case fragment, ok := <-f.Fragments():
+			if !ok {
+				return result
+			}
+			result = append(result, fragment)
+		default:
+			return result
+		}
+	}
+}
+
+func TestMSEFragmenter_Fragments(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		perGOP   bool
+		expected [][]byte
+	}{
+		{
+			name:     "a fragment per video frame",
+			expected: [][]byte{frames(0, 1), frames(1, 2), frames(2, 3)},
+		},
+		{
+			name:     "a fragment per GOP",
+			perGOP:   true,
+			expected: [][]byte{frames(0, 20), frames(20, 40), frames(40, 43)},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			muxers := &fakeMuxers{}
+			f := newMSEFragmenter(muxers, tt.perGOP, 256)
+			assert.Nil(t, f.OnOutputStream(videoStream()))
+			feed(t, f, 0, 43)
+			assert.Nil(t, f.Close())
+
+			fragments := drain(f)
+			assert.Equal(t, []byte("init"), fragments[0].Data)
+			assert.NotEmpty(t, fragments[0].MimeType)
+			for i, expected := range tt.expected {
+				assert.Equal(t, expected, fragments[i+1].Data, "fragment %d", i+1)
+				assert.Empty(t, fragments[i+1].MimeType)
+			}
+			if tt.perGOP {
+				assert.Len(t, fragments, 4)
+			} else {
+				assert.Len(t, fragments, 44)
+			}
+			assert.Zero(t, f.Dropped())
+		})
+	}
+}
+
+func TestMSEFragmenter_DropsUntilKeyframe(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	f := newMSEFragmenter(muxers, false, 2)
+	assert.Nil(t, f.OnOutputStream(videoStream()))
+
+	// the init segment and the first frame fill the buffer, the next ones are dropped
+	feed(t, f, 0, 10)
+	fragments := drain(f)
+	assert.Len(t, fragments, 2)
+	assert.Equal(t, frames(0, 1), fragments[1].Data)
+
+	// the player can't decode the frames without their keyframe, so it's waited for
+	feed(t, f, 10, 22)
+	fragments = drain(f)
+	assert.Len(t, fragments, 1)
+	assert.Equal(t, frames(20, 21), fragments[0].Data)
+	assert.Equal(t, int64(19), f.Dropped())
+	assert.Equal(t, 1, muxers.count())
+}
+
+func TestMSEFragmenter_RecreatesDroppedInit(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	f := newMSEFragmenter(muxers, false, 2)
+	assert.Nil(t, f.OnOutputStream(videoStream()))
+	feed(t, f, 0, 2)
+
+	// the streams change while the buffer is full, the new init segment is dropped
+	assert.Nil(t, f.OnOutputStream(audioStream()))
+	feed(t, f, 2, 22)
+	assert.Equal(t, 3, muxers.count())
+	assert.True(t, muxers.created[1].closed)
+	fragments := drain(f)
+	assert.Len(t, fragments, 2)
+
+	// it's created again along the next keyframe
+	feed(t, f, 22, 42)
+	fragments = drain(f)
+	assert.Len(t, fragments, 2)
+	assert.Equal(t, []byte("init"), fragments[0].Data)
+	assert.NotEmpty(t, fragments[0].MimeType)
+	assert.Equal(t, frames(40, 41), fragments[1].Data)
+}
+
+func TestMSEFragmenter_MimeType(t *testing.T) {
+	t.Parallel()
+	sps := []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9}
+	pps := []byte{0x68, 0xeb, 0xe3, 0xcb}
+	annexB := func(nalus ...[]byte) []byte {
+		var data []byte
+		for _, nalu := range nalus {
+			data = append(append(data, 0x00, 0x00, 0x00, 0x01), nalu...)
+		}
+		return data
+	}
+	idr := []byte{0x65, 0x88, 0x84}
+
+	tests := []struct {
+		name      string
+		extraData []byte
+		keyframe  []byte
+		audio     entities.Codec
+		expected  string
+	}{
+		{
+			name:      "profile, constraints and level from the extradata",
+			extraData: annexB(sps, pps),
+			keyframe:  annexB(idr),
+			audio:     entities.AAC,
+			expected:  `video/mp4; codecs="avc1.64001f,mp4a.40.2"`,
+		},
+		{
+			name:     "profile, constraints and level from the keyframe",
+			keyframe: annexB(sps, pps, idr),
+			audio:    entities.Opus,
+			expected: `video/mp4; codecs="avc1.64001f,opus"`,
+		},
+		{
+			name:     "constrained baseline without any parameter set",
+			keyframe: annexB(idr),
+			expected: `video/mp4; codecs="avc1.42e01f"`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			f := newMSEFragmenter(&fakeMuxers{}, false, 256)
+			video := videoStream()
+			video.ExtraData = tt.extraData
+			assert.Nil(t, f.OnOutputStream(video))
+			if tt.audio != "" {
+				audio := audioStream()
+				audio.Codec = tt.audio
+				assert.Nil(t, f.OnOutputStream(audio))
+			}
+			_, c := videoFrame(0)
+			assert.Nil(t, f.OnFrame(entities.VideoType, tt.keyframe, c))
+
+			fragments := drain(f)
+			assert.Len(t, fragments, 1)
+			assert.Equal(t, tt.expected, fragments[0].MimeType)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package sinks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+)
+
+// Recorder is a media sink muxing the session frames into files, a new file is started at the first
+// keyframe after the current one reaches the rotation limits. The frames are muxed by its own goroutine,
+// they're dropped when the disk doesn't keep up so the streaming is never held.
+type Recorder struct {
+	*bufferedSink
+
+	l        *zap.SugaredLogger
+	newMuxer MuxerFactory
+
+	dir      string
+	rotation entities.RecordingRotation
+
+	mu        sync.Mutex
+	recording entities.Recording
+
+	// only used by the muxing goroutine
+	streams map[entities.MediaType]entities.OutputStream
+	muxer   Muxer
+	failed  bool
+}
+
+func NewRecorder(c *entities.Config, l *zap.SugaredLogger, newMuxer MuxerFactory, id string, params entities.RecordingParams) (*Recorder, error) {
+	dir := filepath.Join(c.RecordingsDir, params.SessionID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	rotation := entities.RecordingRotation{
+		MaxDuration: time.Duration(c.RecordingMaxDurationSec) * time.Second,
+		MaxSize:     int64(c.RecordingMaxSizeMB) << 20,
+	}
+	if params.MaxDurationSec > 0 {
+		rotation.MaxDuration = time.Duration(params.MaxDurationSec) * time.Second
+	}
+	if params.MaxSizeMB > 0 {
+		rotation.MaxSize = int64(params.MaxSizeMB) << 20
+	}
+
+	r := &Recorder{
+		l:        l.With("recording", id),
+		newMuxer: newMuxer,
+		dir:      dir,
+		rotation: rotation,
+		recording: entities.Recording{
+			ID:        id,
+			SessionID: params.SessionID,
+			Format:    params.Format,
+			StartedAt: time.Now(),
+		},
+		streams: make(map[entities.MediaType]entities.OutputStream),
+	}
+	r.bufferedSink = newBufferedSink(c.RecordingBufferedFrames, r.onDropped)
+	go r.run()
+	return r, nil
+}
+
+// Recording returns a snapshot of the recording.
+func (r *Recorder) Recording() entities.Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.recording
+	rec.Files = append([]string(nil), r.recording.Files...)
+	return rec
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	for {
+		in, ok := r.next()
+		if !ok {
+			break
+		}
+		if r.failed {
+			r.onDropped()
+			continue
+		}
+		var err error
+		if in.stream != nil {
+			err = r.onStream(*in.stream)
+		} else {
+			err = r.onFrame(in)
+		}
+		if err != nil {
+			r.l.Errorw("recording has failed", "error", err)
+			r.failed = true
+			r.mu.Lock()
+			r.recording.Error = err.Error()
+			r.mu.Unlock()
+		}
+	}
+
+	if err := r.closeMuxer(); err != nil {
+		r.l.Errorw("error while closing the recording", "error", err)
+	}
+	now := time.Now()
+	r.mu.Lock()
+	r.recording.StoppedAt = &now
+	r.mu.Unlock()
+	r.l.Infof("recording has stopped")
+}
+
+func (r *Recorder) onStream(st entities.OutputStream) error {
+	r.streams[st.Type] = st
+	if r.muxer == nil {
+		return nil
+	}
+	// the streams can't change within a file (i.e. switching the audio stream)
+	if !r.muxer.SetStream(st) {
+		return r.rotate()
+	}
+	return nil
+}
+
+func (r *Recorder) onFrame(in sinkInput) error {
+	if r.muxer == nil {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	if r.isRotationPoint(in) && r.rotation.ShouldRotate(r.muxer.Duration(), r.muxer.Bytes()) {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	dropped := r.muxer.Dropped()
+	written, err := r.muxer.WriteFrame(in.mediaType, in.data, in.frameCtx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if written {
+		r.recording.Bytes += int64(len(in.data))
+	}
+	r.recording.DroppedFrames += r.muxer.Dropped() - dropped
+	return nil
+}
+
+// isRotationPoint tells whether a new file could start at the frame, the video keyframes
+// or any audio frame when there's no video.
+func (r *Recorder) isRotationPoint(in sinkInput) bool {
+	if _, hasVideo := r.streams[entities.VideoType]; hasVideo {
+		return in.mediaType == entities.VideoType && in.frameCtx.Keyframe
+	}
+	return in.mediaType == entities.AudioType
+}
+
+// rotate closes the current file and prepares the next one, it's only created at the next keyframe.
+func (r *Recorder) rotate() error {
+	if err := r.closeMuxer(); err != nil {
+		return err
+	}
+
+	format, ext := "mp4", "mp4"
+	options := map[string]string{"movflags": "frag_keyframe+empty_moov+default_base_moof"}
+	if r.recording.Format == entities.RecordingMatroska {
+		format, ext, options = "matroska", "mkv", nil
+	}
+
+	r.mu.Lock()
+	name := fmt.Sprintf("%s-%s-%03d.%s", r.recording.ID, r.recording.StartedAt.Format("20060102T150405"), len(r.recording.Files), ext)
+	path := filepath.Join(r.dir, name)
+	r.recording.Files = append(r.recording.Files, path)
+	r.mu.Unlock()
+
+	r.muxer = r.newMuxer(format, path, nil, options)
+	for _, st := range r.streams {
+		r.muxer.SetStream(st)
+	}
+	r.l.Infof("recording to %s", path)
+	return nil
+}
+
+func (r *Recorder) closeMuxer() error {
+	if r.muxer == nil {
+		return nil
+	}
+	err := r.muxer.Close()
+	r.muxer = nil
+	return err
+}
+
+func (r *Recorder) onDropped() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recording.DroppedFrames++
+}
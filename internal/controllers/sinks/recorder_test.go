@@ -0,0 +1,96 @@
+package sinks_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newRecorder(t *testing.T, muxers *fakeMuxers, params entities.RecordingParams) *sinks.Recorder {
+	c := &entities.Config{
+		RecordingsDir:           t.TempDir(),
+		RecordingMaxDurationSec: 3600,
+		RecordingBufferedFrames: 256,
+	}
+	params.SessionID = "session"
+	r, err := sinks.NewRecorder(c, zap.NewNop().Sugar(), muxers.new, "recording", params)
+	require.NoError(t, err)
+	return r
+}
+
+func TestRecorder_Rotation(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	r := newRecorder(t, muxers, entities.RecordingParams{Format: entities.RecordingFMP4, MaxDurationSec: 4})
+	assert.Nil(t, r.OnOutputStream(videoStream()))
+	feed(t, r, 0, 102)
+	assert.Nil(t, r.Close())
+
+	// a new file starts at the first keyframe past 4s (frame 60)
+	assert.Equal(t, 2, muxers.count())
+	assert.Equal(t, int64(60), muxers.created[0].bytes)
+	assert.Equal(t, int64(42), muxers.created[1].bytes)
+	for _, mx := range muxers.created {
+		assert.True(t, mx.closed, "the files are finalized")
+		assert.Equal(t, "mp4", mx.format)
+		assert.Contains(t, mx.url, string(filepath.Separator)+"session"+string(filepath.Separator)+"recording-")
+	}
+
+	rec := r.Recording()
+	assert.Len(t, rec.Files, 2)
+	assert.Equal(t, muxers.created[0].url, rec.Files[0])
+	assert.Regexp(t, `-000\.mp4$`, rec.Files[0])
+	assert.Regexp(t, `-001\.mp4$`, rec.Files[1])
+	assert.Equal(t, int64(102), rec.Bytes)
+	assert.Zero(t, rec.DroppedFrames)
+	assert.Empty(t, rec.Error)
+	assert.NotNil(t, rec.StoppedAt)
+}
+
+func TestRecorder_StreamChange(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	r := newRecorder(t, muxers, entities.RecordingParams{Format: entities.RecordingMatroska})
+	assert.Nil(t, r.OnOutputStream(videoStream()))
+	feed(t, r, 0, 30)
+
+	// i.e. the audio stream is switched, the file can't change its streams
+	assert.Nil(t, r.OnOutputStream(audioStream()))
+	feed(t, r, 30, 50)
+	assert.Nil(t, r.Close())
+
+	assert.Equal(t, 2, muxers.count())
+	assert.True(t, muxers.created[0].closed)
+	assert.Equal(t, int64(30), muxers.created[0].bytes)
+	assert.Len(t, muxers.created[1].streams, 2)
+	assert.Equal(t, int64(10), muxers.created[1].bytes, "the next file starts at a keyframe")
+	assert.Equal(t, "matroska", muxers.created[1].format)
+
+	rec := r.Recording()
+	assert.Regexp(t, `-001\.mkv$`, rec.Files[1])
+	assert.Equal(t, int64(40), rec.Bytes)
+}
+
+func TestRecorder_Failure(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{failing: 1}
+	r := newRecorder(t, muxers, entities.RecordingParams{Format: entities.RecordingFMP4})
+	assert.Nil(t, r.OnOutputStream(videoStream()))
+
+	// i.e. a full disk, the session goes on while the next frames are dropped
+	feed(t, r, 0, 10)
+	assert.Nil(t, r.Close())
+
+	rec := r.Recording()
+	assert.Equal(t, errFakeMuxer.Error(), rec.Error)
+	assert.Equal(t, int64(9), rec.DroppedFrames)
+	assert.Zero(t, rec.Bytes)
+	assert.NotNil(t, rec.StoppedAt)
+	assert.Equal(t, 1, muxers.count())
+	assert.True(t, muxers.last().closed)
+}
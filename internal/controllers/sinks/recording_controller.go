@@ -0,0 +1,122 @@
+package sinks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"go.uber.org/zap"
+)
+
+// RecordingController starts and stops the session recordings, the stopped ones are kept
+// so their files can still be listed.
+type RecordingController struct {
+	c        *entities.Config
+	l        *zap.SugaredLogger
+	m        *mapper.Mapper
+	sessions *controllers.SessionController
+
+	mu        sync.RWMutex
+	recorders map[string]*recordingEntry
+}
+
+type recordingEntry struct {
+	recorder *Recorder
+	session  *controllers.Session
+}
+
+func NewRecordingController(c *entities.Config, l *zap.SugaredLogger, m *mapper.Mapper, sessions *controllers.SessionController) *RecordingController {
+	return &RecordingController{
+		c:         c,
+		l:         l,
+		m:         m,
+		sessions:  sessions,
+		recorders: make(map[string]*recordingEntry),
+	}
+}
+
+func (rc *RecordingController) Start(params entities.RecordingParams) (entities.Recording, error) {
+	if err := params.Valid(); err != nil {
+		return entities.Recording{}, err
+	}
+	session, ok := rc.sessions.Get(params.SessionID)
+	if !ok {
+		return entities.Recording{}, entities.ErrMissingSession
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, e := range rc.recorders {
+		if e.session == session && e.recorder.Recording().StoppedAt == nil {
+			return entities.Recording{}, entities.ErrRecordingInProgress
+		}
+	}
+
+	id, err := newRecordingID()
+	if err != nil {
+		return entities.Recording{}, err
+	}
+	recorder, err := NewRecorder(rc.c, rc.l, NewLibAVMuxerFactory(rc.l, rc.m), id, params)
+	if err != nil {
+		return entities.Recording{}, err
+	}
+	if err := session.AddSink(recorder); err != nil {
+		recorder.Close()
+		return entities.Recording{}, err
+	}
+	rc.recorders[id] = &recordingEntry{recorder: recorder, session: session}
+	return recorder.Recording(), nil
+}
+
+// Stop stops the recording once its buffered frames are written.
+func (rc *RecordingController) Stop(id string) (entities.Recording, error) {
+	rc.mu.RLock()
+	e, ok := rc.recorders[id]
+	rc.mu.RUnlock()
+	if !ok {
+		return entities.Recording{}, entities.ErrMissingRecording
+	}
+
+	e.session.RemoveSink(e.recorder)
+	if err := e.recorder.Close(); err != nil {
+		return entities.Recording{}, err
+	}
+	return e.recorder.Recording(), nil
+}
+
+func (rc *RecordingController) Get(id string) (entities.Recording, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	e, ok := rc.recorders[id]
+	if !ok {
+		return entities.Recording{}, false
+	}
+	return e.recorder.Recording(), true
+}
+
+// List returns the recordings, oldest first.
+func (rc *RecordingController) List() []entities.Recording {
+	rc.mu.RLock()
+	result := make([]entities.Recording, 0, len(rc.recorders))
+	for _, e := range rc.recorders {
+		result = append(result, e.recorder.Recording())
+	}
+	rc.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+func newRecordingID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
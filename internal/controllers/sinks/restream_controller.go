@@ -0,0 +1,158 @@
+package sinks
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/controllers/engine"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"go.uber.org/zap"
+)
+
+// RestreamController runs the restream jobs, sessions relaying their source to the RTMP/SRT
+// destinations (one restreamer sink each). The stopped ones are kept for a while so their errors can be listed.
+type RestreamController struct {
+	c       *entities.Config
+	m       *mapper.Mapper
+	starter sessionStarter
+
+	mu   sync.RWMutex
+	jobs map[string]*restreamJob
+}
+
+type restreamJob struct {
+	restreamers []*Restreamer
+	cancel      context.CancelFunc
+	startedAt   time.Time
+	stoppedAt   *time.Time
+}
+
+func NewRestreamController(
+	c *entities.Config,
+	l *zap.SugaredLogger,
+	m *mapper.Mapper,
+	donut *engine.DonutEngineController,
+	sessions *controllers.SessionController,
+	recordings *RecordingController,
+) *RestreamController {
+	return &RestreamController{
+		c: c,
+		m: m,
+		starter: sessionStarter{
+			l:          l,
+			donut:      donut,
+			sessions:   sessions,
+			recordings: recordings,
+		},
+		jobs: make(map[string]*restreamJob),
+	}
+}
+
+// Start relays the source until it ends or it's stopped.
+func (rc *RestreamController) Start(params entities.RestreamParams) (entities.Restream, error) {
+	params.Source.Output = entities.DonutRestream
+	if err := params.Valid(); err != nil {
+		return entities.Restream{}, err
+	}
+
+	var restreamers []*Restreamer
+	hs, err := rc.starter.start(params.Source, func(l *zap.SugaredLogger) ([]entities.MediaSink, error) {
+		var sinks []entities.MediaSink
+		for _, destination := range params.Destinations {
+			restreamer, err := NewRestreamer(rc.c, l, NewLibAVMuxerFactory(l.With("destination", destination), rc.m), destination)
+			if err != nil {
+				for _, sink := range sinks {
+					sink.Close()
+				}
+				return nil, err
+			}
+			restreamers = append(restreamers, restreamer)
+			sinks = append(sinks, restreamer)
+		}
+		return sinks, nil
+	})
+	if err != nil {
+		return entities.Restream{}, err
+	}
+
+	id := hs.session.ID
+	job := &restreamJob{restreamers: restreamers, cancel: hs.cancel, startedAt: time.Now()}
+	rc.mu.Lock()
+	rc.jobs[id] = job
+	rc.mu.Unlock()
+
+	go func() {
+		rc.starter.serve(hs)
+		now := time.Now()
+		rc.mu.Lock()
+		job.stoppedAt = &now
+		rc.mu.Unlock()
+		time.AfterFunc(time.Duration(rc.c.RestreamRetentionSec)*time.Second, func() {
+			rc.remove(id, job)
+		})
+	}()
+
+	return rc.restream(id, job), nil
+}
+
+// Stop stops the job, its session ends along.
+func (rc *RestreamController) Stop(id string) error {
+	rc.mu.RLock()
+	job, ok := rc.jobs[id]
+	rc.mu.RUnlock()
+	if !ok {
+		return entities.ErrMissingRestream
+	}
+	job.cancel()
+	return nil
+}
+
+// remove evicts the stopped job.
+func (rc *RestreamController) remove(id string, job *restreamJob) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.jobs[id] == job {
+		delete(rc.jobs, id)
+	}
+}
+
+func (rc *RestreamController) Get(id string) (entities.Restream, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	job, ok := rc.jobs[id]
+	if !ok {
+		return entities.Restream{}, false
+	}
+	return rc.restream(id, job), true
+}
+
+// List returns the restreams, oldest first.
+func (rc *RestreamController) List() []entities.Restream {
+	rc.mu.RLock()
+	result := make([]entities.Restream, 0, len(rc.jobs))
+	for id, job := range rc.jobs {
+		result = append(result, rc.restream(id, job))
+	}
+	rc.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+func (rc *RestreamController) restream(id string, job *restreamJob) entities.Restream {
+	r := entities.Restream{
+		SessionID: id,
+		StartedAt: job.startedAt,
+		StoppedAt: job.stoppedAt,
+	}
+	for _, restreamer := range job.restreamers {
+		r.Destinations = append(r.Destinations, restreamer.Destination())
+	}
+	return r
+}
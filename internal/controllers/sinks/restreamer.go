@@ -0,0 +1,202 @@
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+)
+
+// Restreamer is a media sink pushing the session frames to a RTMP or SRT destination. The frames are
+// muxed by its own goroutine, they're dropped when the destination doesn't keep up. Whenever the
+// destination fails it's reconnected, after the backoff delay, at the next keyframe.
+type Restreamer struct {
+	*bufferedSink
+
+	l        *zap.SugaredLogger
+	newMuxer MuxerFactory
+
+	format       string
+	backoff      entities.RestreamBackoff
+	closeTimeout time.Duration
+
+	mu          sync.Mutex
+	destination entities.RestreamDestination
+
+	// only used by the muxing goroutine
+	streams  map[entities.MediaType]entities.OutputStream
+	muxer    Muxer
+	attempts int
+	retryAt  time.Time
+	failed   bool
+}
+
+func NewRestreamer(c *entities.Config, l *zap.SugaredLogger, newMuxer MuxerFactory, destination string) (*Restreamer, error) {
+	format, err := entities.RestreamFormat(destination)
+	if err != nil {
+		return nil, err
+	}
+	r := &Restreamer{
+		l:        l.With("destination", destination),
+		newMuxer: newMuxer,
+		format:   format,
+		backoff: entities.RestreamBackoff{
+			Min:         time.Duration(c.RestreamReconnectMinMS) * time.Millisecond,
+			Max:         time.Duration(c.RestreamReconnectMaxMS) * time.Millisecond,
+			MaxAttempts: c.RestreamMaxReconnects,
+		},
+		closeTimeout: time.Duration(c.RestreamCloseTimeoutMS) * time.Millisecond,
+		destination: entities.RestreamDestination{
+			URL:   destination,
+			State: entities.RestreamConnecting,
+		},
+		streams: make(map[entities.MediaType]entities.OutputStream),
+	}
+	r.bufferedSink = newBufferedSink(c.RestreamBufferedFrames, r.onDropped)
+	go r.run()
+	return r, nil
+}
+
+// Close stops pushing once the buffered frames are written. The libav IO can't be interrupted (i.e. connecting
+// to an unreachable destination), so it gives up waiting after the close timeout, the muxing goroutine
+// stops on its own once the IO returns.
+func (r *Restreamer) Close() error {
+	r.closeInputs()
+	select {
+	case <-r.done:
+	case <-time.After(r.closeTimeout):
+		r.l.Warnw("restream is still closing, the destination doesn't respond", "timeout", r.closeTimeout)
+	}
+	return nil
+}
+
+// Destination returns a snapshot of the destination.
+func (r *Restreamer) Destination() entities.RestreamDestination {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.destination
+}
+
+func (r *Restreamer) run() {
+	defer close(r.done)
+	for {
+		in, ok := r.next()
+		if !ok {
+			break
+		}
+		if in.stream != nil {
+			r.onStream(*in.stream)
+			continue
+		}
+		if r.failed {
+			r.onDropped()
+			continue
+		}
+		if err := r.onFrame(in); err != nil {
+			r.onError(err)
+		}
+	}
+
+	if err := r.closeMuxer(); err != nil {
+		r.l.Warnw("error while closing the restream", "error", err)
+	}
+	r.mu.Lock()
+	if r.destination.State != entities.RestreamFailed {
+		r.destination.State = entities.RestreamStopped
+	}
+	r.mu.Unlock()
+	r.l.Infof("restream has stopped")
+}
+
+func (r *Restreamer) onStream(st entities.OutputStream) {
+	r.streams[st.Type] = st
+	if r.muxer == nil || r.muxer.SetStream(st) {
+		return
+	}
+	// the streams can't change within a connection (i.e. switching the audio stream), so it's started again
+	if err := r.closeMuxer(); err != nil {
+		r.l.Warnw("error while closing the restream", "error", err)
+	}
+	r.setState(entities.RestreamConnecting)
+}
+
+func (r *Restreamer) onFrame(in sinkInput) error {
+	if r.muxer == nil {
+		if time.Now().Before(r.retryAt) {
+			r.onDropped()
+			return nil
+		}
+		r.muxer = r.newMuxer(r.format, r.destination.URL, nil, nil)
+		for _, st := range r.streams {
+			r.muxer.SetStream(st)
+		}
+	}
+
+	// the destination is connected along the first keyframe
+	started := r.muxer.Started()
+	dropped := r.muxer.Dropped()
+	written, err := r.muxer.WriteFrame(in.mediaType, in.data, in.frameCtx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if written {
+		r.destination.Bytes += int64(len(in.data))
+		if !started {
+			r.l.Infof("restream is live")
+			r.destination.State = entities.RestreamLive
+			r.destination.Error = ""
+			r.attempts = 0
+		}
+	}
+	r.destination.DroppedFrames += r.muxer.Dropped() - dropped
+	return nil
+}
+
+// onError drops the connection, it's reconnected once the backoff delay is elapsed.
+func (r *Restreamer) onError(err error) {
+	r.l.Errorw("restream has failed", "error", err)
+	if closeErr := r.closeMuxer(); closeErr != nil {
+		r.l.Warnw("error while closing the restream", "error", closeErr)
+	}
+	r.attempts++
+	delay, ok := r.backoff.Delay(r.attempts)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.destination.Error = err.Error()
+	r.destination.DroppedFrames++
+	if !ok {
+		r.l.Errorw("restream gave up reconnecting", "attempts", r.attempts-1)
+		r.failed = true
+		r.destination.State = entities.RestreamFailed
+		return
+	}
+	r.retryAt = time.Now().Add(delay)
+	r.destination.State = entities.RestreamReconnecting
+	r.destination.Reconnects++
+}
+
+func (r *Restreamer) closeMuxer() error {
+	if r.muxer == nil {
+		return nil
+	}
+	err := r.muxer.Close()
+	r.muxer = nil
+	return err
+}
+
+func (r *Restreamer) setState(state entities.RestreamState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.destination.State = state
+}
+
+func (r *Restreamer) onDropped() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.destination.DroppedFrames++
+}
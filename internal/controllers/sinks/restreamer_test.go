@@ -0,0 +1,200 @@
+package sinks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newRestreamer(t *testing.T, muxers *fakeMuxers, reconnectMinMS, maxReconnects int) *sinks.Restreamer {
+	return newRestreamerWithConfig(t, muxers, &entities.Config{
+		RestreamReconnectMinMS: reconnectMinMS,
+		RestreamReconnectMaxMS: reconnectMinMS,
+		RestreamMaxReconnects:  maxReconnects,
+		RestreamBufferedFrames: 512,
+		RestreamCloseTimeoutMS: 5_000,
+	})
+}
+
+func newRestreamerWithConfig(t *testing.T, muxers *fakeMuxers, c *entities.Config) *sinks.Restreamer {
+	r, err := sinks.NewRestreamer(c, zap.NewNop().Sugar(), muxers.new, "rtmp://localhost/live/key")
+	assert.Nil(t, err)
+	return r
+}
+
+func waitForState(t *testing.T, r *sinks.Restreamer, state entities.RestreamState) {
+	assert.Eventually(t, func() bool {
+		return r.Destination().State == state
+	}, time.Second, time.Millisecond, "state %s", state)
+}
+
+func TestRestreamer_Live(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	r := newRestreamer(t, muxers, 0, 0)
+	assert.Equal(t, entities.RestreamConnecting, r.Destination().State)
+	assert.Nil(t, r.OnOutputStream(videoStream()))
+
+	// the destination is connected along the first keyframe
+	feed(t, r, 5, 25)
+	waitForState(t, r, entities.RestreamLive)
+	assert.Nil(t, r.Close())
+
+	destination := r.Destination()
+	assert.Equal(t, entities.RestreamStopped, destination.State)
+	assert.Equal(t, "rtmp://localhost/live/key", destination.URL)
+	assert.Equal(t, int64(5), destination.Bytes)
+	assert.Zero(t, destination.DroppedFrames)
+	assert.Zero(t, destination.Reconnects)
+	assert.Equal(t, 1, muxers.count())
+	assert.Equal(t, "flv", muxers.last().format)
+	assert.Equal(t, "rtmp://localhost/live/key", muxers.last().url)
+	assert.True(t, muxers.last().closed)
+}
+
+func TestRestreamer_Failures(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		failing        int
+		reconnectMinMS int
+		maxReconnects  int
+		expected       entities.RestreamDestination
+		muxers         int
+	}{
+		{
+			name:    "it's reconnected at the next keyframe",
+			failing: 1,
+			expected: entities.RestreamDestination{
+				State:         entities.RestreamStopped,
+				Reconnects:    1,
+				Bytes:         6,
+				DroppedFrames: 1,
+			},
+			muxers: 2,
+		},
+		{
+			name:           "the frames are dropped until the backoff delay is elapsed",
+			failing:        1,
+			reconnectMinMS: 3_600_000,
+			expected: entities.RestreamDestination{
+				State:         entities.RestreamStopped,
+				Reconnects:    1,
+				DroppedFrames: 26,
+				Error:         errFakeMuxer.Error(),
+			},
+			muxers: 1,
+		},
+		{
+			name:          "it gives up after the max reconnects",
+			failing:       2,
+			maxReconnects: 1,
+			expected: entities.RestreamDestination{
+				State:         entities.RestreamFailed,
+				Reconnects:    1,
+				DroppedFrames: 7,
+				Error:         errFakeMuxer.Error(),
+			},
+			muxers: 2,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			muxers := &fakeMuxers{failing: tt.failing}
+			r := newRestreamer(t, muxers, tt.reconnectMinMS, tt.maxReconnects)
+			assert.Nil(t, r.OnOutputStream(videoStream()))
+			feed(t, r, 0, 26)
+			assert.Nil(t, r.Close())
+
+			tt.expected.URL = "rtmp://localhost/live/key"
+			assert.Equal(t, tt.expected, r.Destination())
+			assert.Equal(t, tt.muxers, muxers.count())
+			for _, mx := range muxers.created {
+				assert.True(t, mx.closed)
+			}
+		})
+	}
+}
+
+func TestRestreamer_StreamChange(t *testing.T) {
+	t.Parallel()
+	muxers := &fakeMuxers{}
+	r := newRestreamer(t, muxers, 0, 0)
+	assert.Nil(t, r.OnOutputStream(videoStream()))
+	feed(t, r, 0, 5)
+	waitForState(t, r, entities.RestreamLive)
+
+	// i.e. an audio stream is selected, it's connected again at the next keyframe
+	assert.Nil(t, r.OnOutputStream(audioStream()))
+	waitForState(t, r, entities.RestreamConnecting)
+	feed(t, r, 5, 21)
+	waitForState(t, r, entities.RestreamLive)
+	assert.Nil(t, r.Close())
+
+	assert.Equal(t, 2, muxers.count())
+	assert.True(t, muxers.created[0].closed)
+	assert.Len(t, muxers.last().streams, 2)
+	destination := r.Destination()
+	assert.Equal(t, int64(6), destination.Bytes)
+	assert.Zero(t, destination.Reconnects)
+}
+
+func TestRestreamer_UnresponsiveDestination(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		closeIt bool
+	}{
+		{name: "closing doesn't wait for it", closeIt: true},
+		{name: "the stream changes follow the buffered frames"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			muxers := &fakeMuxers{block: make(chan struct{})}
+			r := newRestreamerWithConfig(t, muxers, &entities.Config{
+				RestreamBufferedFrames: 4,
+				RestreamCloseTimeoutMS: 50,
+			})
+			assert.Nil(t, r.OnOutputStream(videoStream()))
+
+			// the keyframe connects the destination, which doesn't respond
+			feed(t, r, 0, 1)
+			assert.Eventually(t, func() bool { return muxers.count() == 1 }, time.Second, time.Millisecond)
+
+			// the session isn't held, 4 frames are buffered and the stream waits behind them
+			feed(t, r, 1, 20)
+			assert.Nil(t, r.OnOutputStream(audioStream()))
+			feed(t, r, 20, 21)
+			assert.Equal(t, int64(16), r.Destination().DroppedFrames)
+
+			if tt.closeIt {
+				started := time.Now()
+				assert.Nil(t, r.Close())
+				assert.Less(t, time.Since(started), time.Second)
+
+				close(muxers.block)
+				waitForState(t, r, entities.RestreamStopped)
+				assert.Equal(t, 1, muxers.count())
+				return
+			}
+
+			close(muxers.block)
+			// the audio stream restarts the connection once the buffered frames are written
+			assert.Eventually(t, func() bool { return muxers.count() == 1 && r.Destination().Bytes == 5 }, time.Second, time.Millisecond)
+			waitForState(t, r, entities.RestreamConnecting)
+			assert.Nil(t, r.Close())
+			first := muxers.created[0]
+			assert.True(t, first.closed)
+			assert.Len(t, first.streams, 1)
+			assert.Equal(t, int64(5), first.bytes)
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package snapshots
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/asticode/go-astikit"
+	"github.com/flavioribeiro/donut/internal/controllers/engine"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"go.uber.org/fx"
+)
+
+// LibAVSnapshotter decodes the keyframes with libav and encodes them to the requested format.
+type LibAVSnapshotter struct {
+	c     *entities.Config
+	m     *mapper.Mapper
+	donut *engine.DonutEngineController
+}
+
+type ResultLibAVSnapshotter struct {
+	fx.Out
+	LibAVSnapshotter Snapshotter
+}
+
+func NewLibAVSnapshotter(
+	c *entities.Config,
+	m *mapper.Mapper,
+	donut *engine.DonutEngineController,
+) ResultLibAVSnapshotter {
+	return ResultLibAVSnapshotter{
+		LibAVSnapshotter: &LibAVSnapshotter{
+			c:     c,
+			m:     m,
+			donut: donut,
+		},
+	}
+}
+
+// FromKeyframe decodes the keyframe, its stream is the session output one.
+func (s *LibAVSnapshotter) FromKeyframe(keyframe entities.Keyframe, params entities.SnapshotParams) (entities.Snapshot, error) {
+	closer := astikit.NewCloser()
+	defer closer.Close()
+
+	st := keyframe.Stream
+	if len(st.ExtraData) > 0 && st.ExtraData[0] == 1 {
+		// the avcC/hvcC extradata doesn't match the Annex-B frames, their parameter sets are in band
+		st.ExtraData = nil
+	}
+	cp := astiav.AllocCodecParameters()
+	closer.Add(cp.Free)
+	if err := s.m.FromOutputStreamToLibAVCodecParameters(st, cp); err != nil {
+		return entities.Snapshot{}, err
+	}
+	decoder, err := newSnapshotDecoder(cp, closer)
+	if err != nil {
+		return entities.Snapshot{}, err
+	}
+
+	pkt := astiav.AllocPacket()
+	closer.Add(pkt.Free)
+	if err := pkt.FromData(keyframe.Data); err != nil {
+		return entities.Snapshot{}, err
+	}
+	pkt.SetFlags(astiav.NewPacketFlags(astiav.PacketFlagKey))
+	decoded, err := decoder.decode(pkt)
+	if err == nil && !decoded {
+		decoded, err = decoder.decode(nil)
+	}
+	if err != nil {
+		return entities.Snapshot{}, err
+	}
+	if !decoded {
+		return entities.Snapshot{}, entities.ErrMissingKeyframe
+	}
+	return encodeSnapshot(decoder.frame, params)
+}
+
+// Probe reads the stream until its first video keyframe is decoded.
+func (s *LibAVSnapshotter) Probe(params entities.SnapshotParams) (entities.Snapshot, error) {
+	closer := astikit.NewCloser()
+	defer closer.Close()
+
+	req := params.RequestParams()
+	donutEngine, err := s.donut.EngineFor(&req)
+	if err != nil {
+		return entities.Snapshot{}, err
+	}
+	appetizer, err := donutEngine.Appetizer()
+	if err != nil {
+		return entities.Snapshot{}, err
+	}
+
+	inputFormatContext := astiav.AllocFormatContext()
+	if inputFormatContext == nil {
+		return entities.Snapshot{}, entities.ErrFFmpegLibAVFormatContextIsNil
+	}
+	closer.Add(inputFormatContext.Free)
+
+	// the blocking reads are interrupted once the probe times out
+	timeout := time.Duration(s.c.SnapshotProbeTimeoutMS) * time.Millisecond
+	deadline := time.Now().Add(timeout)
+	interrupter := inputFormatContext.SetInterruptCallback()
+	timer := time.AfterFunc(timeout, interrupter.Interrupt)
+	defer timer.Stop()
+
+	var inputFormat *astiav.InputFormat
+	if appetizer.Format != "" {
+		if inputFormat = astiav.FindInputFormat(appetizer.Format.String()); inputFormat == nil {
+			return entities.Snapshot{}, fmt.Errorf("ffmpeg/libav: could not find %s input format", appetizer.Format)
+		}
+	}
+	var inputOptions *astiav.Dictionary
+	if len(appetizer.Options) > 0 {
+		inputOptions = astiav.NewDictionary()
+		closer.Add(inputOptions.Free)
+		for k, v := range appetizer.Options {
+			inputOptions.Set(k.String(), v, 0)
+		}
+	}
+
+	if err := inputFormatContext.OpenInput(appetizer.URL, inputFormat, inputOptions); err != nil {
+		return entities.Snapshot{}, fmt.Errorf("error while inputFormatContext.OpenInput: (%s) %w", appetizer.URL, err)
+	}
+	closer.Add(inputFormatContext.CloseInput)
+	if err := inputFormatContext.FindStreamInfo(nil); err != nil {
+		return entities.Snapshot{}, fmt.Errorf("error while inputFormatContext.FindStreamInfo %w", err)
+	}
+
+	var videoStream *astiav.Stream
+	for _, is := range inputFormatContext.Streams() {
+		if is.CodecParameters().MediaType() == astiav.MediaTypeVideo {
+			videoStream = is
+			break
+		}
+	}
+	if videoStream == nil {
+		return entities.Snapshot{}, fmt.Errorf("%w for the snapshot", entities.ErrMissingCompatibleStreams)
+	}
+	decoder, err := newSnapshotDecoder(videoStream.CodecParameters(), closer)
+	if err != nil {
+		return entities.Snapshot{}, err
+	}
+
+	pkt := astiav.AllocPacket()
+	closer.Add(pkt.Free)
+	keyframeFound := false
+	for time.Now().Before(deadline) {
+		if err := inputFormatContext.ReadFrame(pkt); err != nil {
+			if time.Now().After(deadline) {
+				break
+			}
+			return entities.Snapshot{}, fmt.Errorf("error while inputFormatContext.ReadFrame %w", err)
+		}
+		// the decoding starts at a keyframe, so the picture isn't corrupted
+		keyframeFound = keyframeFound || (pkt.StreamIndex() == videoStream.Index() && pkt.Flags().Has(astiav.PacketFlagKey))
+		if pkt.StreamIndex() != videoStream.Index() || !keyframeFound {
+			pkt.Unref()
+			continue
+		}
+		decoded, err := decoder.decode(pkt)
+		pkt.Unref()
+		if err != nil {
+			return entities.Snapshot{}, err
+		}
+		if decoded {
+			snapshot, err := encodeSnapshot(decoder.frame, params)
+			snapshot.CapturedAt = time.Now()
+			return snapshot, err
+		}
+	}
+	return entities.Snapshot{}, fmt.Errorf("%w within %s", entities.ErrMissingKeyframe, timeout)
+}
+
+// snapshotDecoder decodes the first picture of a video stream.
+type snapshotDecoder struct {
+	cc    *astiav.CodecContext
+	frame *astiav.Frame
+}
+
+func newSnapshotDecoder(cp *astiav.CodecParameters, closer *astikit.Closer) (*snapshotDecoder, error) {
+	codec := astiav.FindDecoder(cp.CodecID())
+	if codec == nil {
+		return nil, errors.New("ffmpeg/libav: codec is missing")
+	}
+	cc := astiav.AllocCodecContext(codec)
+	if cc == nil {
+		return nil, errors.New("ffmpeg/libav: codec context is nil")
+	}
+	closer.Add(cc.Free)
+
+	if err := cp.ToCodecContext(cc); err != nil {
+		return nil, fmt.Errorf("ffmpeg/libav: updating codec context failed %w", err)
+	}
+	if err := cc.Open(codec, nil); err != nil {
+		return nil, fmt.Errorf("ffmpeg/libav: opening codec context failed %w", err)
+	}
+
+	d := &snapshotDecoder{cc: cc, frame: astiav.AllocFrame()}
+	closer.Add(d.frame.Free)
+	return d, nil
+}
+
+// decode sends the packet (nil flushes the decoder), it returns true once the picture is decoded.
+func (d *snapshotDecoder) decode(pkt *astiav.Packet) (bool, error) {
+	if err := d.cc.SendPacket(pkt); err != nil && !errors.Is(err, astiav.ErrEagain) && !errors.Is(err, astiav.ErrEof) {
+		return false, fmt.Errorf("ffmpeg/libav: sending packet failed %w", err)
+	}
+	if err := d.cc.ReceiveFrame(d.frame); err != nil {
+		if errors.Is(err, astiav.ErrEof) || errors.Is(err, astiav.ErrEagain) {
+			return false, nil
+		}
+		return false, fmt.Errorf("ffmpeg/libav: receiving frame failed %w", err)
+	}
+	return true, nil
+}
+
+// snapshotEncoder is the image encoder of the format, WebP is only available along libwebp.
+func snapshotEncoder(format entities.SnapshotFormat) *astiav.Codec {
+	switch format {
+	case entities.SnapshotJPEG:
+		return astiav.FindEncoder(astiav.CodecIDMjpeg)
+	case entities.SnapshotPNG:
+		return astiav.FindEncoder(astiav.CodecIDPng)
+	case entities.SnapshotWebP:
+		return astiav.FindEncoderByName("libwebp")
+	}
+	return nil
+}
+
+// encodeSnapshot scales the picture to the requested size and encodes it to the requested format.
+func encodeSnapshot(frame *astiav.Frame, params entities.SnapshotParams) (entities.Snapshot, error) {
+	closer := astikit.NewCloser()
+	defer closer.Close()
+
+	codec := snapshotEncoder(params.Format)
+	if codec == nil {
+		return entities.Snapshot{}, fmt.Errorf("%w %s: there is no encoder", entities.ErrUnsupportedSnapshotFormat, params.Format)
+	}
+	width, height := entities.SnapshotSize(frame.Width(), frame.Height(), params.Width, params.Height)
+	pixelFormat := astiav.PixelFormatYuv420P
+	if pixelFormats := codec.PixelFormats(); len(pixelFormats) > 0 {
+		pixelFormat = pixelFormats[0]
+	}
+
+	ssc, err := astiav.CreateSoftwareScaleContext(
+		frame.Width(), frame.Height(), frame.PixelFormat(),
+		width, height, pixelFormat,
+		astiav.NewSoftwareScaleContextFlags(astiav.SoftwareScaleContextFlagBicubic),
+	)
+	if err != nil {
+		return entities.Snapshot{}, fmt.Errorf("ffmpeg/libav: creating scale context failed %w", err)
+	}
+	closer.Add(ssc.Free)
+	scaled := astiav.AllocFrame()
+	closer.Add(scaled.Free)
+	if err := ssc.ScaleFrame(frame, scaled); err != nil {
+		return entities.Snapshot{}, fmt.Errorf("ffmpeg/libav: scaling frame failed %w", err)
+	}
+
+	cc := astiav.AllocCodecContext(codec)
+	if cc == nil {
+		return entities.Snapshot{}, errors.New("ffmpeg/libav: codec context is nil")
+	}
+	closer.Add(cc.Free)
+	cc.SetWidth(width)
+	cc.SetHeight(height)
+	cc.SetPixelFormat(pixelFormat)
+	cc.SetTimeBase(astiav.NewRational(1, 25))
+	if err := cc.Open(codec, nil); err != nil {
+		return entities.Snapshot{}, fmt.Errorf("ffmpeg/libav: opening codec context failed %w", err)
+	}
+
+	if err := cc.SendFrame(scaled); err != nil {
+		return entities.Snapshot{}, fmt.Errorf("ffmpeg/libav: sending frame failed %w", err)
+	}
+	if err := cc.SendFrame(nil); err != nil {
+		return entities.Snapshot{}, fmt.Errorf("ffmpeg/libav: flushing encoder failed %w", err)
+	}
+	pkt := astiav.AllocPacket()
+	closer.Add(pkt.Free)
+	if err := cc.ReceivePacket(pkt); err != nil {
+		return entities.Snapshot{}, fmt.Errorf("ffmpeg/libav: receiving packet failed %w", err)
+	}
+
+	return entities.Snapshot{
+		Data:   append([]byte(nil), pkt.Data()...),
+		Format: params.Format,
+		Width:  width,
+		Height: height,
+	}, nil
+}
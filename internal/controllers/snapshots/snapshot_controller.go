@@ -0,0 +1,123 @@
+package snapshots
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+)
+
+// Snapshotter takes the snapshots, decoding a session keyframe or probing the stream for its next keyframe.
+type Snapshotter interface {
+	FromKeyframe(keyframe entities.Keyframe, params entities.SnapshotParams) (entities.Snapshot, error)
+	Probe(params entities.SnapshotParams) (entities.Snapshot, error)
+}
+
+// SnapshotController takes the stream snapshots from the last keyframe of a session playing the stream,
+// otherwise (or when that keyframe is already expired) the stream is probed. The snapshots are cached
+// for a while, the alike requests made meanwhile wait for the one being taken.
+type SnapshotController struct {
+	c           *entities.Config
+	l           *zap.SugaredLogger
+	snapshotter Snapshotter
+	sessions    *controllers.SessionController
+
+	mu      sync.Mutex
+	cache   map[string]entities.Snapshot
+	pending map[string]chan struct{}
+}
+
+func NewSnapshotController(
+	c *entities.Config,
+	l *zap.SugaredLogger,
+	snapshotter Snapshotter,
+	sessions *controllers.SessionController,
+) *SnapshotController {
+	return &SnapshotController{
+		c:           c,
+		l:           l,
+		snapshotter: snapshotter,
+		sessions:    sessions,
+		cache:       make(map[string]entities.Snapshot),
+		pending:     make(map[string]chan struct{}),
+	}
+}
+
+func (sc *SnapshotController) Snapshot(params entities.SnapshotParams) (entities.Snapshot, error) {
+	if err := params.Valid(); err != nil {
+		return entities.Snapshot{}, err
+	}
+	key := params.Key()
+	ttl := time.Duration(sc.c.SnapshotCacheTTLMS) * time.Millisecond
+
+	for {
+		sc.mu.Lock()
+		if snapshot, ok := sc.cache[key]; ok && time.Since(snapshot.CapturedAt) < ttl {
+			sc.mu.Unlock()
+			return snapshot, nil
+		}
+		pending, ok := sc.pending[key]
+		if !ok {
+			sc.pending[key] = make(chan struct{})
+			sc.mu.Unlock()
+			break
+		}
+		sc.mu.Unlock()
+		// the snapshot is cached once taken, it's taken again when it failed
+		<-pending
+	}
+
+	snapshot, err := sc.take(params, ttl)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if err == nil {
+		sc.cache[key] = snapshot
+	}
+	sc.evict(ttl)
+	close(sc.pending[key])
+	delete(sc.pending, key)
+	return snapshot, err
+}
+
+func (sc *SnapshotController) take(params entities.SnapshotParams, ttl time.Duration) (entities.Snapshot, error) {
+	for _, session := range sc.sessionsFor(params) {
+		keyframe, ok := session.Keyframe()
+		// the keyframe of a long GOP or of a stalled source would be cached already expired
+		if !ok || time.Since(keyframe.CapturedAt) >= ttl {
+			continue
+		}
+		snapshot, err := sc.snapshotter.FromKeyframe(keyframe, params)
+		if err != nil {
+			sc.l.Warnw("error while taking the snapshot from the session", "session", session.ID, "error", err)
+			continue
+		}
+		snapshot.SessionID = session.ID
+		snapshot.CapturedAt = keyframe.CapturedAt
+		return snapshot, nil
+	}
+	return sc.snapshotter.Probe(params)
+}
+
+// sessionsFor returns the sessions playing the stream, the latest first.
+func (sc *SnapshotController) sessionsFor(params entities.SnapshotParams) []*controllers.Session {
+	var result []*controllers.Session
+	sessions := sc.sessions.List()
+	for i := len(sessions) - 1; i >= 0; i-- {
+		if sessions[i].Params.StreamURL == params.StreamURL && sessions[i].Params.StreamID == params.StreamID {
+			result = append(result, sessions[i])
+		}
+	}
+	return result
+}
+
+// evict drops the expired snapshots, it's called with the lock held.
+func (sc *SnapshotController) evict(ttl time.Duration) {
+	for key, snapshot := range sc.cache {
+		if time.Since(snapshot.CapturedAt) >= ttl {
+			delete(sc.cache, key)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package snapshots_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/controllers/snapshots"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var errFakeSnapshotter = errors.New("fake snapshotter has failed")
+
+// fakeSnapshotter returns the keyframe data as the snapshot, or "probe" when probing. The probes wait
+// for release, when it's set, and the first failing ones fail.
+type fakeSnapshotter struct {
+	mu            sync.Mutex
+	probes        int
+	fromKeyframes int
+	failing       int
+	release       chan struct{}
+}
+
+func (f *fakeSnapshotter) FromKeyframe(keyframe entities.Keyframe, params entities.SnapshotParams) (entities.Snapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fromKeyframes++
+	return entities.Snapshot{Data: keyframe.Data, Format: params.Format}, nil
+}
+
+func (f *fakeSnapshotter) Probe(params entities.SnapshotParams) (entities.Snapshot, error) {
+	if f.release != nil {
+		<-f.release
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probes++
+	if f.probes <= f.failing {
+		return entities.Snapshot{}, errFakeSnapshotter
+	}
+	return entities.Snapshot{Data: []byte("probe"), Format: params.Format, CapturedAt: time.Now()}, nil
+}
+
+func (f *fakeSnapshotter) calls() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fromKeyframes, f.probes
+}
+
+func newSnapshotController(f *fakeSnapshotter, sessions *controllers.SessionController, ttlMS int) *snapshots.SnapshotController {
+	c := &entities.Config{SnapshotCacheTTLMS: ttlMS}
+	return snapshots.NewSnapshotController(c, zap.NewNop().Sugar(), f, sessions)
+}
+
+func snapshotParams(format entities.SnapshotFormat) entities.SnapshotParams {
+	return entities.SnapshotParams{StreamURL: "srt://localhost:40052", StreamID: "stream", Format: format}
+}
+
+// playingSession creates a session of the stream which has sent a keyframe.
+func playingSession(t *testing.T, sessions *controllers.SessionController) *controllers.Session {
+	params := snapshotParams(entities.SnapshotJPEG)
+	session, err := sessions.Create(params.RequestParams())
+	assert.Nil(t, err)
+	assert.Nil(t, session.OnOutputStream(entities.OutputStream{Stream: entities.Stream{Type: entities.VideoType, Codec: entities.H264}}))
+	assert.Nil(t, session.OnFrame(entities.VideoType, []byte("keyframe"), entities.MediaFrameContext{Keyframe: true}))
+	return session
+}
+
+func TestSnapshotController_FromSession(t *testing.T) {
+	t.Parallel()
+	f := &fakeSnapshotter{}
+	sessions := controllers.NewSessionController()
+	session := playingSession(t, sessions)
+	keyframe, _ := session.Keyframe()
+	sc := newSnapshotController(f, sessions, 60_000)
+
+	snapshot, err := sc.Snapshot(snapshotParams(entities.SnapshotJPEG))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("keyframe"), snapshot.Data)
+	assert.Equal(t, session.ID, snapshot.SessionID)
+	assert.Equal(t, keyframe.CapturedAt, snapshot.CapturedAt)
+
+	// it's cached, the other formats are taken on their own
+	_, err = sc.Snapshot(snapshotParams(entities.SnapshotJPEG))
+	assert.Nil(t, err)
+	_, err = sc.Snapshot(snapshotParams(entities.SnapshotPNG))
+	assert.Nil(t, err)
+	fromKeyframes, probes := f.calls()
+	assert.Equal(t, 2, fromKeyframes)
+	assert.Zero(t, probes)
+}
+
+func TestSnapshotController_ExpiredKeyframe(t *testing.T) {
+	t.Parallel()
+	f := &fakeSnapshotter{}
+	sessions := controllers.NewSessionController()
+	playingSession(t, sessions)
+	sc := newSnapshotController(f, sessions, 20)
+
+	// i.e. a long GOP or a stalled source, the session keyframe would be cached already expired
+	time.Sleep(30 * time.Millisecond)
+	snapshot, err := sc.Snapshot(snapshotParams(entities.SnapshotJPEG))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("probe"), snapshot.Data)
+	assert.Empty(t, snapshot.SessionID)
+	fromKeyframes, probes := f.calls()
+	assert.Zero(t, fromKeyframes)
+	assert.Equal(t, 1, probes)
+}
+
+func TestSnapshotController_Cache(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		ttlMS    int
+		failing  int
+		wait     time.Duration
+		expected int
+	}{
+		{name: "the snapshot is cached", ttlMS: 60_000, expected: 1},
+		{name: "the snapshot is taken again once expired", ttlMS: 20, wait: 30 * time.Millisecond, expected: 2},
+		{name: "the failed snapshot isn't cached", ttlMS: 60_000, failing: 1, expected: 2},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			f := &fakeSnapshotter{failing: tt.failing}
+			sc := newSnapshotController(f, controllers.NewSessionController(), tt.ttlMS)
+
+			_, err := sc.Snapshot(snapshotParams(entities.SnapshotJPEG))
+			assert.Equal(t, tt.failing > 0, errors.Is(err, errFakeSnapshotter))
+			time.Sleep(tt.wait)
+			snapshot, err := sc.Snapshot(snapshotParams(entities.SnapshotJPEG))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("probe"), snapshot.Data)
+			_, probes := f.calls()
+			assert.Equal(t, tt.expected, probes)
+		})
+	}
+}
+
+func TestSnapshotController_Coalescing(t *testing.T) {
+	t.Parallel()
+	f := &fakeSnapshotter{release: make(chan struct{})}
+	sc := newSnapshotController(f, controllers.NewSessionController(), 60_000)
+
+	const requests = 8
+	results := make(chan entities.Snapshot, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			snapshot, err := sc.Snapshot(snapshotParams(entities.SnapshotJPEG))
+			assert.Nil(t, err)
+			results <- snapshot
+		}()
+	}
+
+	// the requests made while the snapshot is being taken wait for it
+	time.Sleep(50 * time.Millisecond)
+	close(f.release)
+	for i := 0; i < requests; i++ {
+		select {
+		case snapshot := <-results:
+			assert.Equal(t, []byte("probe"), snapshot.Data)
+		case <-time.After(time.Second):
+			t.Fatal("the request is still waiting")
+		}
+	}
+	_, probes := f.calls()
+	assert.Equal(t, 1, probes)
+}
@@ -2,27 +2,36 @@ package streamers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/asticode/go-astiav"
 	"github.com/asticode/go-astikit"
+	"github.com/flavioribeiro/donut/internal/controllers"
 	"github.com/flavioribeiro/donut/internal/entities"
 	"github.com/flavioribeiro/donut/internal/mapper"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
+// LibAVFFmpegStreamer is shared by the sessions, each Stream call runs its own libAVPipeline.
 type LibAVFFmpegStreamer struct {
+	// pipeline holds the dependencies shared by the sessions, each one streams with a copy of it
+	pipeline libAVPipeline
+}
+
+// libAVPipeline holds the state of a single session, its logs are tagged with the session id.
+type libAVPipeline struct {
 	c *entities.Config
 	l *zap.SugaredLogger
 	m *mapper.Mapper
 
-	lastAudioFrameDTS     float64
-	currentAudioFrameSize float64
+	loggers *libAVLoggers
 }
 
 type LibAVFFmpegStreamerParams struct {
@@ -40,9 +49,12 @@ type ResultLibAVFFmpegStreamer struct {
 func NewLibAVFFmpegStreamer(p LibAVFFmpegStreamerParams) ResultLibAVFFmpegStreamer {
 	return ResultLibAVFFmpegStreamer{
 		LibAVFFmpegStreamer: &LibAVFFmpegStreamer{
-			c: p.C,
-			l: p.L,
-			m: p.M,
+			pipeline: libAVPipeline{
+				c:       p.C,
+				l:       p.L,
+				m:       p.M,
+				loggers: newLibAVLoggers(p.L),
+			},
 		},
 	}
 }
@@ -55,6 +67,9 @@ func (c *LibAVFFmpegStreamer) Match(req *entities.RequestParams) bool {
 }
 
 type streamContext struct {
+	// stream is the last stream info sent to the client
+	stream entities.Stream
+
 	// IN
 	inputStream     *astiav.Stream
 	decCodec        *astiav.Codec
@@ -75,30 +90,77 @@ type streamContext struct {
 	// Bit stream filter
 	bsfContext *astiav.BitStreamFilterContext
 	bsfPacket  *astiav.Packet
+
+	// bypass is only set when the video is bypassed
+	bypass *controllers.VideoBypass
+
+	// duration is created with the time base of the first packet sent
+	duration *entities.FrameDuration
+
+	// silence is only set for the transcoded audio when the A/V sync correction is enabled
+	silence *silenceSource
+
+	// prepared is set once the output, filters and bit stream filters are built
+	prepared bool
 }
 
 type libAVParams struct {
 	inputFormatContext *astiav.FormatContext
 	streams            map[int]*streamContext
+	stats              *streamerStats
+
+	// SCTE-35 data streams and the events waiting for the video to reach their splice time
+	spliceStreams  map[int]*astiav.Stream
+	pendingSplices []entities.SpliceEvent
+	hasVideo       bool
+
+	// ignoredStreams are the streams not sent, they're only logged once
+	ignoredStreams map[int]bool
+
+	// timed metadata (ID3, FLV onMetaData/onTextData)
+	metadataStreams     map[int]entities.TimedMetadataType
+	lastOnMetaData      map[string]string
+	lastOnMetaDataCheck time.Time
+	lastVideoPTSMS      int64
+
+	subtitle *subtitleContext
+
+	avSync *entities.AVSync
+
+	// audio streams available and the selected one, the client can request another one
+	audioStreams        []entities.Stream
+	audioIndex          int
+	requestedAudioIndex atomic.Int64
+
+	// state changed by the client commands, keyframeRequested makes the encoder emit a keyframe
+	// or, when bypassing, the video wait for the next one
+	paused            atomic.Bool
+	keyframeRequested atomic.Bool
+	videoBitRate      atomic.Int64
 }
 
 func (c *LibAVFFmpegStreamer) Stream(donut *entities.DonutParameters) {
+	c.pipeline.loggers.install()
+	pipeline := c.pipeline
+	pipeline.l = c.pipeline.l.With("session", donut.SessionID)
+	pipeline.stream(donut)
+}
+
+func (c *libAVPipeline) stream(donut *entities.DonutParameters) {
 	c.l.Infof("streaming has started for %#v", donut)
 
 	closer := astikit.NewCloser()
 	defer closer.Close()
 
 	p := &libAVParams{
-		streams: make(map[int]*streamContext),
+		streams:         make(map[int]*streamContext),
+		spliceStreams:   make(map[int]*astiav.Stream),
+		metadataStreams: make(map[int]entities.TimedMetadataType),
+		ignoredStreams:  make(map[int]bool),
+		stats:           newStreamerStats(),
+		avSync:          newAVSync(c.c),
 	}
 
-	// it's useful for debugging
-	// astiav.SetLogLevel(astiav.LogLevelDebug)
-	astiav.SetLogLevel(astiav.LogLevelInfo)
-	astiav.SetLogCallback(func(_ astiav.Classer, l astiav.LogLevel, fmt, msg string) {
-		c.l.Infof("ffmpeg %s: - %s", c.libAVLogToString(l), strings.TrimSpace(msg))
-	})
-
 	c.l.Infof("preparing input")
 	if err := c.prepareInput(p, closer, donut); err != nil {
 		c.onError(err, donut)
@@ -123,6 +185,20 @@ func (c *LibAVFFmpegStreamer) Stream(donut *entities.DonutParameters) {
 		return
 	}
 
+	for _, s := range p.activeStreams() {
+		s.prepared = true
+		if err := c.notifyOutputStream(s, donut); err != nil {
+			c.onError(err, donut)
+			return
+		}
+	}
+
+	c.registerCommands(p, donut)
+
+	statsDone := make(chan struct{})
+	defer close(statsDone)
+	go c.reportStats(p, donut, statsDone)
+
 	inPkt := astiav.AllocPacket()
 	closer.Add(inPkt.Free)
 
@@ -144,9 +220,60 @@ func (c *LibAVFFmpegStreamer) Stream(donut *entities.DonutParameters) {
 				c.onError(err, donut)
 			}
 
+			p.stats.inputBytes.Add(int64(inPkt.Size()))
+
+			if err := c.switchAudioStream(p, closer, donut); err != nil {
+				c.onError(err, donut)
+				return
+			}
+
+			if _, ok := p.spliceStreams[inPkt.StreamIndex()]; ok {
+				c.onSpliceInfoPacket(p, inPkt, donut)
+				inPkt.Unref()
+				continue
+			}
+
+			if p.subtitle != nil && p.subtitle.inputStream.Index() == inPkt.StreamIndex() {
+				if err := c.onSubtitlePacket(p, inPkt, donut); err != nil {
+					c.onError(err, donut)
+					return
+				}
+				inPkt.Unref()
+				continue
+			}
+
+			c.classifyNewStream(p, inPkt.StreamIndex())
+			if t, ok := p.metadataStreams[inPkt.StreamIndex()]; ok {
+				c.onTimedMetadataPacket(p, inPkt, t, donut)
+				inPkt.Unref()
+				continue
+			}
+			if isFLVInput(p) {
+				c.checkOnMetaData(p, donut, false)
+			}
+
 			s, ok := p.streams[inPkt.StreamIndex()]
 			if !ok {
-				c.l.Warnf("skipping to process stream id=%d", inPkt.StreamIndex())
+				inPkt.Unref()
+				continue
+			}
+			if s.inputStream.CodecParameters().MediaType() == astiav.MediaTypeAudio && inPkt.StreamIndex() != p.audioIndex {
+				// only the selected audio stream is decoded
+				inPkt.Unref()
+				continue
+			}
+			if s.inputStream.CodecParameters().MediaType() == astiav.MediaTypeVideo {
+				p.stats.onVideoPacketRead(inPkt.Pts())
+				if inPkt.Pts() != astiav.NoPtsValue {
+					p.lastVideoPTSMS = astiav.RescaleQ(inPkt.Pts(), s.inputStream.TimeBase(), millisecondTimeBase)
+				}
+				c.sendDueSpliceEvents(p, inPkt, s, donut)
+			}
+
+			// the input is still consumed while paused, otherwise live sources would pile up
+			if p.paused.Load() {
+				p.stats.droppedFrames.Add(1)
+				inPkt.Unref()
 				continue
 			}
 
@@ -166,17 +293,71 @@ func (c *LibAVFFmpegStreamer) Stream(donut *entities.DonutParameters) {
 	}
 }
 
-func (c *LibAVFFmpegStreamer) onError(err error, p *entities.DonutParameters) {
+func (c *libAVPipeline) registerCommands(p *libAVParams, donut *entities.DonutParameters) {
+	if donut.RegisterCommand == nil {
+		return
+	}
+	isVideoTranscode := donut.Recipe.Video.Action == entities.DonutTranscode
+	videoEncoder := ""
+	for _, s := range p.activeStreams() {
+		if s.encCodec != nil && s.inputStream.CodecParameters().MediaType() == astiav.MediaTypeVideo {
+			videoEncoder = s.encCodec.Name()
+		}
+	}
+
+	c.registerSwitchAudioTrack(p, donut)
+
+	donut.RegisterCommand(entities.CommandPause, func(cmd entities.Command) (interface{}, error) {
+		p.paused.Store(true)
+		return nil, nil
+	})
+
+	donut.RegisterCommand(entities.CommandResume, func(cmd entities.Command) (interface{}, error) {
+		// viewers can only decode the video from a keyframe onwards
+		p.keyframeRequested.Store(true)
+		p.paused.Store(false)
+		return nil, nil
+	})
+
+	donut.RegisterCommand(entities.CommandRequestKeyframe, func(cmd entities.Command) (interface{}, error) {
+		if !isVideoTranscode {
+			return nil, fmt.Errorf("%w: keyframes can only be requested while transcoding video", entities.ErrUnsupportedCommand)
+		}
+		p.keyframeRequested.Store(true)
+		return nil, nil
+	})
+
+	donut.RegisterCommand(entities.CommandChangeQuality, func(cmd entities.Command) (interface{}, error) {
+		if !isVideoTranscode {
+			return nil, fmt.Errorf("%w: quality can only be changed while transcoding video", entities.ErrUnsupportedCommand)
+		}
+		args := entities.ChangeQualityArgs{}
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return nil, fmt.Errorf("%w %s", entities.ErrInvalidCommandArgs, err.Error())
+		}
+		if args.BitRate <= 0 {
+			return nil, fmt.Errorf("%w bit rate must be positive", entities.ErrInvalidCommandArgs)
+		}
+		if !bitRateReconfigurableEncoders[videoEncoder] {
+			return nil, fmt.Errorf("%w: the %s encoder can't change its bit rate once open", entities.ErrUnsupportedCommand, videoEncoder)
+		}
+		p.videoBitRate.Store(args.BitRate)
+		return nil, nil
+	})
+}
+
+func (c *libAVPipeline) onError(err error, p *entities.DonutParameters) {
 	if p.OnError != nil {
 		p.OnError(err)
 	}
 }
 
-func (c *LibAVFFmpegStreamer) prepareInput(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
+func (c *libAVPipeline) prepareInput(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
 	if p.inputFormatContext = astiav.AllocFormatContext(); p.inputFormatContext == nil {
 		return errors.New("ffmpeg/libav: input format context is nil")
 	}
 	closer.Add(p.inputFormatContext.Free)
+	closer.Add(c.loggers.register(p.inputFormatContext, c.l))
 
 	inputFormat, err := c.defineInputFormat(donut.Recipe.Input.Format.String())
 	if err != nil {
@@ -192,7 +373,23 @@ func (c *LibAVFFmpegStreamer) prepareInput(p *libAVParams, closer *astikit.Close
 		return fmt.Errorf("ffmpeg/libav: finding stream info failed %w", err)
 	}
 
+	var subtitleStreams []*astiav.Stream
 	for _, is := range p.inputFormatContext.Streams() {
+		if isSCTE35Stream(is) {
+			c.l.Infof("reading scte-35 stream index = %d", is.Index())
+			p.spliceStreams[is.Index()] = is
+			continue
+		}
+		if t, ok := timedMetadataStreamType(p, is); ok {
+			c.l.Infof("reading %s timed metadata stream index = %d", t, is.Index())
+			p.metadataStreams[is.Index()] = t
+			continue
+		}
+		if is.CodecParameters().MediaType() == astiav.MediaTypeSubtitle {
+			subtitleStreams = append(subtitleStreams, is)
+			continue
+		}
+
 		if is.CodecParameters().MediaType() != astiav.MediaTypeAudio &&
 			is.CodecParameters().MediaType() != astiav.MediaTypeVideo {
 			c.l.Infof("skipping media type %s", is.CodecParameters().MediaType().String())
@@ -209,6 +406,7 @@ func (c *LibAVFFmpegStreamer) prepareInput(p *libAVParams, closer *astikit.Close
 			return errors.New("ffmpeg/libav: codec context is nil")
 		}
 		closer.Add(s.decCodecContext.Free)
+		closer.Add(c.loggers.register(s.decCodecContext, c.l))
 
 		if err := is.CodecParameters().ToCodecContext(s.decCodecContext); err != nil {
 			return fmt.Errorf("ffmpeg/libav: updating codec context failed %w", err)
@@ -229,279 +427,347 @@ func (c *LibAVFFmpegStreamer) prepareInput(p *libAVParams, closer *astikit.Close
 		closer.Add(s.decFrame.Free)
 
 		p.streams[is.Index()] = s
+		p.hasVideo = p.hasVideo || is.CodecParameters().MediaType() == astiav.MediaTypeVideo
 
-		if donut.OnStream != nil {
-			stream := c.m.FromLibAVStreamToEntityStream(is)
-			err := donut.OnStream(&stream)
-			if err != nil {
-				return err
-			}
+		s.stream = c.m.FromLibAVStreamToEntityStream(is)
+		if s.stream.Type == entities.AudioType {
+			// notified once the audio stream is selected
+			p.audioStreams = append(p.audioStreams, s.stream)
+			continue
+		}
+		if s.stream.Type == entities.VideoType {
+			s.stream.Action = donut.Recipe.Video.Action
 		}
+		if err := c.notifyStream(s, donut); err != nil {
+			return err
+		}
+	}
+
+	if err := c.prepareAudioStreams(p, donut); err != nil {
+		return err
+	}
+
+	if err := c.prepareSubtitles(p, subtitleStreams, donut); err != nil {
+		return err
+	}
+
+	if isFLVInput(p) {
+		// the onMetaData read while probing the input
+		c.checkOnMetaData(p, donut, true)
 	}
 	return nil
 }
 
-func (c *LibAVFFmpegStreamer) prepareOutput(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
-	for _, is := range p.inputFormatContext.Streams() {
-		s, ok := p.streams[is.Index()]
-		if !ok {
-			c.l.Infof("skipping absent stream index = %d", is.Index())
-			continue
+func (c *libAVPipeline) prepareOutput(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
+	for _, s := range p.activeStreams() {
+		if err := c.prepareStreamOutput(s, closer, donut); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
-		isVideoBypass := donut.Recipe.Video.Action == entities.DonutBypass
-		if isVideo && isVideoBypass {
-			c.l.Infof("bypass video for %+v", s.inputStream)
-			continue
-		}
+func (c *libAVPipeline) prepareStreamOutput(s *streamContext, closer *astikit.Closer, donut *entities.DonutParameters) error {
+	isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
+	isVideoBypass := donut.Recipe.Video.Action == entities.DonutBypass
+	if isVideo && isVideoBypass {
+		c.l.Infof("bypass video for %+v", s.inputStream)
+		s.bypass = controllers.NewVideoBypass(s.stream.Codec, s.inputStream.CodecParameters().ExtraData())
+		return nil
+	}
 
-		isAudio := s.decCodecContext.MediaType() == astiav.MediaTypeAudio
-		isAudioBypass := donut.Recipe.Audio.Action == entities.DonutBypass
-		if isAudio && isAudioBypass {
-			c.l.Infof("bypass audio for %+v", s.inputStream)
-			continue
-		}
+	isAudio := s.decCodecContext.MediaType() == astiav.MediaTypeAudio
+	isAudioBypass := donut.Recipe.Audio.Action == entities.DonutBypass
+	if isAudio && isAudioBypass {
+		c.l.Infof("bypass audio for %+v", s.inputStream)
+		return nil
+	}
 
-		var codecID astiav.CodecID
-		if isAudio {
-			audioCodecID, err := c.m.FromStreamCodecToLibAVCodecID(donut.Recipe.Audio.Codec)
-			if err != nil {
-				return err
-			}
-			codecID = audioCodecID
-		}
-		if isVideo {
-			videoCodecID, err := c.m.FromStreamCodecToLibAVCodecID(donut.Recipe.Video.Codec)
-			if err != nil {
-				return err
-			}
-			codecID = videoCodecID
-		}
+	task := donut.Recipe.Audio
+	if isVideo {
+		task = donut.Recipe.Video
+	}
 
-		if s.encCodec = astiav.FindEncoder(codecID); s.encCodec == nil {
-			// TODO: migrate error to entity
-			return fmt.Errorf("cannot find a libav encoder for %+v", codecID)
-		}
+	var err error
+	if s.encCodec, err = c.findEncoder(task.Codec); err != nil {
+		return err
+	}
 
-		if s.encCodecContext = astiav.AllocCodecContext(s.encCodec); s.encCodecContext == nil {
-			return errors.New("ffmpeg/libav: codec context is nil")
+	if s.encCodecContext = astiav.AllocCodecContext(s.encCodec); s.encCodecContext == nil {
+		return errors.New("ffmpeg/libav: codec context is nil")
+	}
+	closer.Add(s.encCodecContext.Free)
+	closer.Add(c.loggers.register(s.encCodecContext, c.l))
+
+	if isAudio {
+		if v := s.encCodec.ChannelLayouts(); len(v) > 0 {
+			s.encCodecContext.SetChannelLayout(v[0])
+		} else {
+			s.encCodecContext.SetChannelLayout(s.decCodecContext.ChannelLayout())
+		}
+		s.encCodecContext.SetChannels(s.decCodecContext.Channels())
+		s.encCodecContext.SetSampleRate(s.decCodecContext.SampleRate())
+		if v := s.encCodec.SampleFormats(); len(v) > 0 {
+			s.encCodecContext.SetSampleFormat(v[0])
+		} else {
+			s.encCodecContext.SetSampleFormat(s.decCodecContext.SampleFormat())
 		}
-		closer.Add(s.encCodecContext.Free)
+		s.encCodecContext.SetTimeBase(s.decCodecContext.TimeBase())
 
-		if isAudio {
-			if v := s.encCodec.ChannelLayouts(); len(v) > 0 {
-				s.encCodecContext.SetChannelLayout(v[0])
-			} else {
-				s.encCodecContext.SetChannelLayout(s.decCodecContext.ChannelLayout())
+		// overriding with user provide config
+		if len(donut.Recipe.Audio.CodecContextOptions) > 0 {
+			for _, opt := range donut.Recipe.Audio.CodecContextOptions {
+				opt(s.encCodecContext)
 			}
-			s.encCodecContext.SetChannels(s.decCodecContext.Channels())
-			s.encCodecContext.SetSampleRate(s.decCodecContext.SampleRate())
-			if v := s.encCodec.SampleFormats(); len(v) > 0 {
-				s.encCodecContext.SetSampleFormat(v[0])
-			} else {
-				s.encCodecContext.SetSampleFormat(s.decCodecContext.SampleFormat())
-			}
-			s.encCodecContext.SetTimeBase(s.decCodecContext.TimeBase())
+		}
+	}
 
-			// overriding with user provide config
-			if len(donut.Recipe.Audio.CodecContextOptions) > 0 {
-				for _, opt := range donut.Recipe.Audio.CodecContextOptions {
-					opt(s.encCodecContext)
-				}
-			}
+	if isVideo {
+		if v := s.encCodec.PixelFormats(); len(v) > 0 {
+			s.encCodecContext.SetPixelFormat(v[0])
+		} else {
+			s.encCodecContext.SetPixelFormat(s.decCodecContext.PixelFormat())
+		}
+		s.encCodecContext.SetSampleAspectRatio(s.decCodecContext.SampleAspectRatio())
+		s.encCodecContext.SetTimeBase(s.decCodecContext.TimeBase())
+		s.encCodecContext.SetHeight(s.decCodecContext.Height())
+		s.encCodecContext.SetWidth(s.decCodecContext.Width())
+		// the rate control of some encoders (i.e. libsvtav1) falls back to the time base otherwise
+		if s.decCodecContext.Framerate().Num() > 0 {
+			s.encCodecContext.SetFramerate(s.decCodecContext.Framerate())
 		}
 
-		if isVideo {
-			if v := s.encCodec.PixelFormats(); len(v) > 0 {
-				s.encCodecContext.SetPixelFormat(v[0])
-			} else {
-				s.encCodecContext.SetPixelFormat(s.decCodecContext.PixelFormat())
-			}
-			s.encCodecContext.SetSampleAspectRatio(s.decCodecContext.SampleAspectRatio())
-			s.encCodecContext.SetTimeBase(s.decCodecContext.TimeBase())
-			s.encCodecContext.SetHeight(s.decCodecContext.Height())
-			s.encCodecContext.SetWidth(s.decCodecContext.Width())
-			// s.encCodecContext.SetFramerate(s.inputStream.AvgFrameRate())
-
-			// overriding with user provide config
-			if len(donut.Recipe.Video.CodecContextOptions) > 0 {
-				for _, opt := range donut.Recipe.Video.CodecContextOptions {
-					opt(s.encCodecContext)
-				}
+		// overriding with user provide config
+		if len(donut.Recipe.Video.CodecContextOptions) > 0 {
+			for _, opt := range donut.Recipe.Video.CodecContextOptions {
+				opt(s.encCodecContext)
 			}
 		}
+	}
 
-		if s.decCodecContext.Flags().Has(astiav.CodecContextFlagGlobalHeader) {
-			s.encCodecContext.SetFlags(s.encCodecContext.Flags().Add(astiav.CodecContextFlagGlobalHeader))
-		}
+	if s.decCodecContext.Flags().Has(astiav.CodecContextFlagGlobalHeader) {
+		s.encCodecContext.SetFlags(s.encCodecContext.Flags().Add(astiav.CodecContextFlagGlobalHeader))
+	}
 
-		if err := s.encCodecContext.Open(s.encCodec, nil); err != nil {
-			return fmt.Errorf("opening encoder context failed: %w", err)
-		}
+	encoderOptions := c.defineEncoderOptions(task.EncoderOptions[s.encCodec.Name()], closer)
+	if err := s.encCodecContext.Open(s.encCodec, encoderOptions); err != nil {
+		return fmt.Errorf("opening encoder %s context failed: %w", s.encCodec.Name(), err)
 	}
+	c.l.Infof("encoding with %s options %+v", s.encCodec.Name(), task.EncoderOptions[s.encCodec.Name()])
 	return nil
 }
 
-func (c *LibAVFFmpegStreamer) prepareFilters(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
-	for _, s := range p.streams {
-
-		isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
-		isVideoBypass := donut.Recipe.Video.Action == entities.DonutBypass
-		if isVideo && isVideoBypass {
-			c.l.Infof("bypass video for %+v", s.inputStream)
-			continue
+// findEncoder picks the first preferred encoder available, falling back to the libav default one.
+func (c *libAVPipeline) findEncoder(codec entities.Codec) (*astiav.Codec, error) {
+	for _, name := range c.m.FromStreamCodecToLibAVEncoderNames(codec) {
+		if encoder := astiav.FindEncoderByName(name); encoder != nil {
+			return encoder, nil
 		}
+	}
 
-		isAudio := s.decCodecContext.MediaType() == astiav.MediaTypeAudio
-		isAudioBypass := donut.Recipe.Audio.Action == entities.DonutBypass
-		if isAudio && isAudioBypass {
-			c.l.Infof("bypass audio for %+v", s.inputStream)
-			continue
-		}
+	codecID, err := c.m.FromStreamCodecToLibAVCodecID(codec)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s: %s", entities.ErrFFmpegLibAVEncoderNotFound, codec, err)
+	}
+	if encoder := astiav.FindEncoder(codecID); encoder != nil {
+		return encoder, nil
+	}
+	return nil, fmt.Errorf("%w for %s", entities.ErrFFmpegLibAVEncoderNotFound, codec)
+}
 
-		var args astiav.FilterArgs
-		var buffersrc, buffersink *astiav.Filter
-		var content string
-		var err error
+func (c *libAVPipeline) defineEncoderOptions(opts entities.DonutEncoderOptions, closer *astikit.Closer) *astiav.Dictionary {
+	var dic *astiav.Dictionary
+	if len(opts) > 0 {
+		dic = &astiav.Dictionary{}
+		closer.Add(dic.Free)
 
-		if s.filterGraph = astiav.AllocFilterGraph(); s.filterGraph == nil {
-			return errors.New("main: graph is nil")
+		for k, v := range opts {
+			dic.Set(k, v, 0)
 		}
-		closer.Add(s.filterGraph.Free)
+	}
+	return dic
+}
 
-		outputs := astiav.AllocFilterInOut()
-		if outputs == nil {
-			return errors.New("main: outputs is nil")
+func (c *libAVPipeline) prepareFilters(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
+	for _, s := range p.activeStreams() {
+		if err := c.prepareStreamFilters(s, closer, donut); err != nil {
+			return err
 		}
-		closer.Add(outputs.Free)
+	}
+	return nil
+}
 
-		inputs := astiav.AllocFilterInOut()
-		if inputs == nil {
-			return errors.New("main: inputs is nil")
-		}
-		closer.Add(inputs.Free)
+func (c *libAVPipeline) prepareStreamFilters(s *streamContext, closer *astikit.Closer, donut *entities.DonutParameters) error {
+	isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
+	isVideoBypass := donut.Recipe.Video.Action == entities.DonutBypass
+	if isVideo && isVideoBypass {
+		c.l.Infof("bypass video for %+v", s.inputStream)
+		return nil
+	}
 
-		if isAudio {
-			args = astiav.FilterArgs{
-				"channel_layout": s.decCodecContext.ChannelLayout().String(),
-				"sample_fmt":     s.decCodecContext.SampleFormat().Name(),
-				"sample_rate":    strconv.Itoa(s.decCodecContext.SampleRate()),
-				"time_base":      s.decCodecContext.TimeBase().String(),
-			}
-			buffersrc = astiav.FindFilterByName("abuffer")
-			buffersink = astiav.FindFilterByName("abuffersink")
-			if donut.Recipe.Audio.DonutStreamFilter != nil {
-				content = string(*donut.Recipe.Audio.DonutStreamFilter)
-			} else {
-				content = "anull" /* passthrough (dummy) filter for audio */
-			}
-		}
+	isAudio := s.decCodecContext.MediaType() == astiav.MediaTypeAudio
+	isAudioBypass := donut.Recipe.Audio.Action == entities.DonutBypass
+	if isAudio && isAudioBypass {
+		c.l.Infof("bypass audio for %+v", s.inputStream)
+		return nil
+	}
 
-		if isVideo {
-			args = astiav.FilterArgs{
-				"pix_fmt":      strconv.Itoa(int(s.decCodecContext.PixelFormat())),
-				"pixel_aspect": s.decCodecContext.SampleAspectRatio().String(),
-				"time_base":    s.decCodecContext.TimeBase().String(),
-				"video_size":   strconv.Itoa(s.decCodecContext.Width()) + "x" + strconv.Itoa(s.decCodecContext.Height()),
-			}
-			buffersrc = astiav.FindFilterByName("buffer")
-			buffersink = astiav.FindFilterByName("buffersink")
-			if donut.Recipe.Video.DonutStreamFilter != nil {
-				content = string(*donut.Recipe.Video.DonutStreamFilter)
-			} else {
-				content = "null" /* passthrough (dummy) filter for video */
-			}
-		}
+	var args astiav.FilterArgs
+	var buffersrc, buffersink *astiav.Filter
+	var content string
+	var err error
+
+	if s.filterGraph = astiav.AllocFilterGraph(); s.filterGraph == nil {
+		return errors.New("main: graph is nil")
+	}
+	closer.Add(s.filterGraph.Free)
+
+	outputs := astiav.AllocFilterInOut()
+	if outputs == nil {
+		return errors.New("main: outputs is nil")
+	}
+	closer.Add(outputs.Free)
+
+	inputs := astiav.AllocFilterInOut()
+	if inputs == nil {
+		return errors.New("main: inputs is nil")
+	}
+	closer.Add(inputs.Free)
 
-		if buffersrc == nil {
-			return errors.New("main: buffersrc is nil")
+	if isAudio {
+		args = astiav.FilterArgs{
+			"channel_layout": s.decCodecContext.ChannelLayout().String(),
+			"sample_fmt":     s.decCodecContext.SampleFormat().Name(),
+			"sample_rate":    strconv.Itoa(s.decCodecContext.SampleRate()),
+			"time_base":      s.decCodecContext.TimeBase().String(),
 		}
-		if buffersink == nil {
-			return errors.New("main: buffersink is nil")
+		buffersrc = astiav.FindFilterByName("abuffer")
+		buffersink = astiav.FindFilterByName("abuffersink")
+		if donut.Recipe.Audio.DonutStreamFilter != nil {
+			content = string(*donut.Recipe.Audio.DonutStreamFilter)
+		} else {
+			content = "anull" /* passthrough (dummy) filter for audio */
 		}
+	}
 
-		if s.buffersrcContext, err = s.filterGraph.NewFilterContext(buffersrc, "in", args); err != nil {
-			return fmt.Errorf("main: creating buffersrc context failed: %w", err)
+	if isVideo {
+		args = astiav.FilterArgs{
+			"pix_fmt":      strconv.Itoa(int(s.decCodecContext.PixelFormat())),
+			"pixel_aspect": s.decCodecContext.SampleAspectRatio().String(),
+			"time_base":    s.decCodecContext.TimeBase().String(),
+			"video_size":   strconv.Itoa(s.decCodecContext.Width()) + "x" + strconv.Itoa(s.decCodecContext.Height()),
 		}
-		if s.buffersinkContext, err = s.filterGraph.NewFilterContext(buffersink, "out", nil); err != nil {
-			return fmt.Errorf("main: creating buffersink context failed: %w", err)
+		buffersrc = astiav.FindFilterByName("buffer")
+		buffersink = astiav.FindFilterByName("buffersink")
+		if donut.Recipe.Video.DonutStreamFilter != nil {
+			content = string(*donut.Recipe.Video.DonutStreamFilter)
+		} else if s.decCodecContext.PixelFormat() != s.encCodecContext.PixelFormat() {
+			content = "format=pix_fmts=" + s.encCodecContext.PixelFormat().Name()
+		} else {
+			content = "null" /* passthrough (dummy) filter for video */
 		}
+	}
 
-		outputs.SetName("in")
-		outputs.SetFilterContext(s.buffersrcContext)
-		outputs.SetPadIdx(0)
-		outputs.SetNext(nil)
+	if buffersrc == nil {
+		return errors.New("main: buffersrc is nil")
+	}
+	if buffersink == nil {
+		return errors.New("main: buffersink is nil")
+	}
 
-		inputs.SetName("out")
-		inputs.SetFilterContext(s.buffersinkContext)
-		inputs.SetPadIdx(0)
-		inputs.SetNext(nil)
+	if s.buffersrcContext, err = s.filterGraph.NewFilterContext(buffersrc, "in", args); err != nil {
+		return fmt.Errorf("main: creating buffersrc context failed: %w", err)
+	}
+	if s.buffersinkContext, err = s.filterGraph.NewFilterContext(buffersink, "out", nil); err != nil {
+		return fmt.Errorf("main: creating buffersink context failed: %w", err)
+	}
 
-		if err = s.filterGraph.Parse(content, inputs, outputs); err != nil {
-			return fmt.Errorf("main: parsing filter failed: %w", err)
-		}
+	outputs.SetName("in")
+	outputs.SetFilterContext(s.buffersrcContext)
+	outputs.SetPadIdx(0)
+	outputs.SetNext(nil)
 
-		if err = s.filterGraph.Configure(); err != nil {
-			return fmt.Errorf("main: configuring filter failed: %w", err)
-		}
+	inputs.SetName("out")
+	inputs.SetFilterContext(s.buffersinkContext)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
 
-		s.filterFrame = astiav.AllocFrame()
-		closer.Add(s.filterFrame.Free)
+	if err = s.filterGraph.Parse(content, inputs, outputs); err != nil {
+		return fmt.Errorf("main: parsing filter failed: %w", err)
+	}
 
-		s.encPkt = astiav.AllocPacket()
-		closer.Add(s.encPkt.Free)
+	if err = s.filterGraph.Configure(); err != nil {
+		return fmt.Errorf("main: configuring filter failed: %w", err)
 	}
-	return nil
-}
 
-func (c *LibAVFFmpegStreamer) prepareBitStreamFilters(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
-	for _, s := range p.streams {
-		isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
-		isAudio := s.decCodecContext.MediaType() == astiav.MediaTypeAudio
-		var currentMedia *entities.DonutMediaTask
+	s.filterFrame = astiav.AllocFrame()
+	closer.Add(s.filterFrame.Free)
 
-		if isAudio {
-			currentMedia = &donut.Recipe.Audio
-		} else if isVideo {
-			currentMedia = &donut.Recipe.Video
-		} else {
-			c.l.Warnf("ignoring bit stream filter for media type %s", s.decCodecContext.MediaType().String())
-			continue
-		}
+	s.encPkt = astiav.AllocPacket()
+	closer.Add(s.encPkt.Free)
 
-		if currentMedia.DonutBitStreamFilter == nil {
-			c.l.Infof("no bit stream filter configured for %s", s.decCodecContext.String())
-			continue
+	if isAudio && c.c.AVSyncThresholdMS > 0 {
+		if s.silence, err = c.prepareSilenceSource(s, closer); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		bsf := astiav.FindBitStreamFilterByName(string(*currentMedia.DonutBitStreamFilter))
-		if bsf == nil {
-			return fmt.Errorf("can not find the filter %s", string(*currentMedia.DonutBitStreamFilter))
+func (c *libAVPipeline) prepareBitStreamFilters(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
+	for _, s := range p.activeStreams() {
+		if err := c.prepareStreamBitStreamFilter(s, closer, donut); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		var err error
-		s.bsfContext, err = astiav.AllocBitStreamFilterContext(bsf)
-		if err != nil {
-			return fmt.Errorf("error while allocating bit stream context %w", err)
-		}
-		closer.Add(s.bsfContext.Free)
+func (c *libAVPipeline) prepareStreamBitStreamFilter(s *streamContext, closer *astikit.Closer, donut *entities.DonutParameters) error {
+	isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
+	isAudio := s.decCodecContext.MediaType() == astiav.MediaTypeAudio
+	var currentMedia *entities.DonutMediaTask
 
-		s.bsfContext.SetTimeBaseIn(s.inputStream.TimeBase())
-		if err := s.inputStream.CodecParameters().Copy(s.bsfContext.CodecParametersIn()); err != nil {
-			return fmt.Errorf("error while copying codec parameters %w", err)
-		}
+	if isAudio {
+		currentMedia = &donut.Recipe.Audio
+	} else if isVideo {
+		currentMedia = &donut.Recipe.Video
+	} else {
+		c.l.Warnf("ignoring bit stream filter for media type %s", s.decCodecContext.MediaType().String())
+		return nil
+	}
 
-		if err := s.bsfContext.Initialize(); err != nil {
-			return fmt.Errorf("error while initiating %w", err)
-		}
-		s.bsfPacket = astiav.AllocPacket()
-		closer.Add(s.bsfPacket.Free)
+	if currentMedia.DonutBitStreamFilter == nil {
+		c.l.Infof("no bit stream filter configured for %s", s.decCodecContext.String())
+		return nil
+	}
+
+	bsf := astiav.FindBitStreamFilterByName(string(*currentMedia.DonutBitStreamFilter))
+	if bsf == nil {
+		return fmt.Errorf("can not find the filter %s", string(*currentMedia.DonutBitStreamFilter))
+	}
+
+	var err error
+	s.bsfContext, err = astiav.AllocBitStreamFilterContext(bsf)
+	if err != nil {
+		return fmt.Errorf("error while allocating bit stream context %w", err)
+	}
+	closer.Add(s.bsfContext.Free)
+
+	s.bsfContext.SetTimeBaseIn(s.inputStream.TimeBase())
+	if err := s.inputStream.CodecParameters().Copy(s.bsfContext.CodecParametersIn()); err != nil {
+		return fmt.Errorf("error while copying codec parameters %w", err)
 	}
+
+	if err := s.bsfContext.Initialize(); err != nil {
+		return fmt.Errorf("error while initiating %w", err)
+	}
+	s.bsfPacket = astiav.AllocPacket()
+	closer.Add(s.bsfPacket.Free)
 	return nil
 }
 
-func (c *LibAVFFmpegStreamer) processPacket(p *libAVParams, pkt *astiav.Packet, s *streamContext, donut *entities.DonutParameters) error {
+func (c *libAVPipeline) processPacket(p *libAVParams, pkt *astiav.Packet, s *streamContext, donut *entities.DonutParameters) error {
 	isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
 	isAudio := s.decCodecContext.MediaType() == astiav.MediaTypeAudio
 	var currentMedia *entities.DonutMediaTask
@@ -517,28 +783,57 @@ func (c *LibAVFFmpegStreamer) processPacket(p *libAVParams, pkt *astiav.Packet,
 
 	byPass := currentMedia.Action == entities.DonutBypass
 	if isVideo && byPass {
+		// the viewers resuming can only decode the video from a keyframe onwards
+		if p.keyframeRequested.CompareAndSwap(true, false) {
+			s.bypass.Reset()
+		}
+		wasStarted := s.bypass.Started()
+		data, keyframe, ok := s.bypass.Process(pkt.Data(), pkt.Flags().Has(astiav.PacketFlagKey))
+		if !ok {
+			p.stats.droppedFrames.Add(1)
+			return nil
+		}
+		if !wasStarted {
+			c.l.Infof("bypass video starts at a keyframe after dropping %d packets", s.bypass.Dropped())
+		}
+		if sps, changed := s.bypass.SPSChanged(); changed {
+			if err := c.onStreamChange(s, c.m.FromH264SPSToEntityStream(s.stream, sps), donut); err != nil {
+				return err
+			}
+		}
 		if donut.OnVideoFrame != nil {
 			pkt.RescaleTs(s.inputStream.TimeBase(), s.decCodecContext.TimeBase())
-			if err := donut.OnVideoFrame(pkt.Data(), entities.MediaFrameContext{
-				PTS:      int(pkt.Pts()),
-				DTS:      int(pkt.Dts()),
-				Duration: c.defineVideoDuration(s, pkt),
-			}); err != nil {
+			frameCtx := entities.MediaFrameContext{
+				PTS:         int(pkt.Pts()),
+				DTS:         int(pkt.Dts()),
+				Duration:    c.defineDuration(s, pkt, s.decCodecContext.TimeBase()),
+				TimeBaseNum: s.decCodecContext.TimeBase().Num(),
+				TimeBaseDen: s.decCodecContext.TimeBase().Den(),
+				Keyframe:    keyframe,
+			}
+			if err := donut.OnVideoFrame(data, frameCtx); err != nil {
 				return err
 			}
+			p.stats.onVideoFrameSent(pkt.Pts(), len(data))
+			c.onAVSyncOutput(p, true, frameCtx)
 		}
 		return nil
 	}
 	if isAudio && byPass {
 		if donut.OnAudioFrame != nil {
 			pkt.RescaleTs(s.inputStream.TimeBase(), s.decCodecContext.TimeBase())
-			if err := donut.OnAudioFrame(pkt.Data(), entities.MediaFrameContext{
-				PTS:      int(pkt.Pts()),
-				DTS:      int(pkt.Dts()),
-				Duration: c.defineAudioDuration(s, pkt),
-			}); err != nil {
+			frameCtx := entities.MediaFrameContext{
+				PTS:         int(pkt.Pts()),
+				DTS:         int(pkt.Dts()),
+				Duration:    c.defineDuration(s, pkt, s.decCodecContext.TimeBase()),
+				TimeBaseNum: s.decCodecContext.TimeBase().Num(),
+				TimeBaseDen: s.decCodecContext.TimeBase().Den(),
+			}
+			if err := donut.OnAudioFrame(pkt.Data(), frameCtx); err != nil {
 				return err
 			}
+			p.stats.outputBytes.Add(int64(pkt.Size()))
+			c.onAVSyncOutput(p, false, frameCtx)
 		}
 		return nil
 	}
@@ -558,6 +853,9 @@ func (c *LibAVFFmpegStreamer) processPacket(p *libAVParams, pkt *astiav.Packet,
 			}
 			return err
 		}
+		if err := c.onStreamChange(s, c.m.FromLibAVFrameToEntityStream(s.stream, s.decFrame), donut); err != nil {
+			return err
+		}
 		if err := c.filterAndEncode(p, s.decFrame, s, donut); err != nil {
 			return err
 		}
@@ -565,7 +863,22 @@ func (c *LibAVFFmpegStreamer) processPacket(p *libAVParams, pkt *astiav.Packet,
 	return nil
 }
 
-func (c *LibAVFFmpegStreamer) applyBitStreamFilter(p *libAVParams, pkt *astiav.Packet, s *streamContext, donut *entities.DonutParameters) error {
+// onStreamChange notifies the client whenever the stream parameters change mid-stream, they're read
+// from the decoded frames or, for the bypassed H264 video, from its SPS.
+func (c *libAVPipeline) onStreamChange(s *streamContext, stream entities.Stream, donut *entities.DonutParameters) error {
+	if stream == s.stream {
+		return nil
+	}
+	c.l.Infof("stream parameters have changed from %#v to %#v", s.stream, stream)
+	s.stream = stream
+
+	if donut.OnStream != nil {
+		return donut.OnStream(&stream)
+	}
+	return nil
+}
+
+func (c *libAVPipeline) applyBitStreamFilter(p *libAVParams, pkt *astiav.Packet, s *streamContext, donut *entities.DonutParameters) error {
 	if err := s.bsfContext.SendPacket(pkt); err != nil && !errors.Is(err, astiav.ErrEagain) {
 		return fmt.Errorf("sending bit stream packet failed: %w", err)
 	}
@@ -584,7 +897,7 @@ func (c *LibAVFFmpegStreamer) applyBitStreamFilter(p *libAVParams, pkt *astiav.P
 	return nil
 }
 
-func (c *LibAVFFmpegStreamer) filterAndEncode(p *libAVParams, f *astiav.Frame, s *streamContext, donut *entities.DonutParameters) (err error) {
+func (c *libAVPipeline) filterAndEncode(p *libAVParams, f *astiav.Frame, s *streamContext, donut *entities.DonutParameters) (err error) {
 	if err = s.buffersrcContext.BuffersrcAddFrame(f, astiav.NewBuffersrcFlags(astiav.BuffersrcFlagKeepRef)); err != nil {
 		return fmt.Errorf("adding frame failed: %w", err)
 	}
@@ -600,6 +913,19 @@ func (c *LibAVFFmpegStreamer) filterAndEncode(p *libAVParams, f *astiav.Frame, s
 		}
 		// TODO: should we avoid setting the picture type for audio?
 		s.filterFrame.SetPictureType(astiav.PictureTypeNone)
+		isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
+		if isVideo && p.keyframeRequested.CompareAndSwap(true, false) {
+			s.filterFrame.SetPictureType(astiav.PictureTypeI)
+		}
+		if !isVideo {
+			var keep bool
+			if keep, err = c.correctAudio(p, s.filterFrame, s, donut); err != nil {
+				return fmt.Errorf("main: a/v sync failed: %w", err)
+			}
+			if !keep {
+				continue
+			}
+		}
 		if err = c.encodeFrame(p, s.filterFrame, s, donut); err != nil {
 			err = fmt.Errorf("main: encoding and writing frame failed: %w", err)
 			return
@@ -608,19 +934,25 @@ func (c *LibAVFFmpegStreamer) filterAndEncode(p *libAVParams, f *astiav.Frame, s
 	return nil
 }
 
-func (c *LibAVFFmpegStreamer) encodeFrame(p *libAVParams, f *astiav.Frame, s *streamContext, donut *entities.DonutParameters) (err error) {
+// bitRateReconfigurableEncoders reconfigure themselves when the bit rate of the open encoder changes,
+// the others (i.e. libvpx) keep the one they were opened with.
+var bitRateReconfigurableEncoders = map[string]bool{"libx264": true}
+
+func (c *libAVPipeline) encodeFrame(p *libAVParams, f *astiav.Frame, s *streamContext, donut *entities.DonutParameters) (err error) {
 	s.encPkt.Unref()
 
-	// when converting from aac to opus using filters,
-	// the np samples are bigger than the frame size
-	// to fix the error "more samples than frame size"
-	if f != nil {
-		f.SetNbSamples(s.encCodecContext.FrameSize())
+	if s.decCodecContext.MediaType() == astiav.MediaTypeVideo {
+		if bitRate := p.videoBitRate.Swap(0); bitRate > 0 {
+			s.encCodecContext.SetBitRate(bitRate)
+		}
 	}
 
 	if err = s.encCodecContext.SendFrame(f); err != nil {
 		return fmt.Errorf("sending frame failed: %w", err)
 	}
+	if f != nil {
+		p.stats.encoderQueue.Add(1)
+	}
 
 	for {
 		if err = s.encCodecContext.ReceivePacket(s.encPkt); err != nil {
@@ -630,6 +962,9 @@ func (c *LibAVFFmpegStreamer) encodeFrame(p *libAVParams, f *astiav.Frame, s *st
 			}
 			return fmt.Errorf("receiving packet failed: %w", err)
 		}
+		if p.stats.encoderQueue.Add(-1) < 0 {
+			p.stats.encoderQueue.Store(0)
+		}
 
 		// TODO: check if we need to swap
 		// pkt.RescaleTs(s.inputStream.TimeBase(), s.decCodecContext.TimeBase())
@@ -638,26 +973,37 @@ func (c *LibAVFFmpegStreamer) encodeFrame(p *libAVParams, f *astiav.Frame, s *st
 		isVideo := s.decCodecContext.MediaType() == astiav.MediaTypeVideo
 		if isVideo {
 			if donut.OnVideoFrame != nil {
-				if err := donut.OnVideoFrame(s.encPkt.Data(), entities.MediaFrameContext{
-					PTS:      int(s.encPkt.Pts()),
-					DTS:      int(s.encPkt.Dts()),
-					Duration: c.defineVideoDuration(s, s.encPkt),
-				}); err != nil {
+				frameCtx := entities.MediaFrameContext{
+					PTS:         int(s.encPkt.Pts()),
+					DTS:         int(s.encPkt.Dts()),
+					Duration:    c.defineDuration(s, s.encPkt, s.encCodecContext.TimeBase()),
+					TimeBaseNum: s.encCodecContext.TimeBase().Num(),
+					TimeBaseDen: s.encCodecContext.TimeBase().Den(),
+					Keyframe:    s.encPkt.Flags().Has(astiav.PacketFlagKey),
+				}
+				if err := donut.OnVideoFrame(s.encPkt.Data(), frameCtx); err != nil {
 					return err
 				}
+				p.stats.onVideoFrameSent(s.encPkt.Pts(), s.encPkt.Size())
+				c.onAVSyncOutput(p, true, frameCtx)
 			}
 		}
 
 		isAudio := s.decCodecContext.MediaType() == astiav.MediaTypeAudio
 		if isAudio {
 			if donut.OnAudioFrame != nil {
-				if err := donut.OnAudioFrame(s.encPkt.Data(), entities.MediaFrameContext{
-					PTS:      int(s.encPkt.Pts()),
-					DTS:      int(s.encPkt.Dts()),
-					Duration: c.defineAudioDuration(s, s.encPkt),
-				}); err != nil {
+				frameCtx := entities.MediaFrameContext{
+					PTS:         int(s.encPkt.Pts()),
+					DTS:         int(s.encPkt.Dts()),
+					Duration:    c.defineDuration(s, s.encPkt, s.encCodecContext.TimeBase()),
+					TimeBaseNum: s.encCodecContext.TimeBase().Num(),
+					TimeBaseDen: s.encCodecContext.TimeBase().Den(),
+				}
+				if err := donut.OnAudioFrame(s.encPkt.Data(), frameCtx); err != nil {
 					return err
 				}
+				p.stats.outputBytes.Add(int64(s.encPkt.Size()))
+				c.onAVSyncOutput(p, false, frameCtx)
 			}
 		}
 	}
@@ -665,7 +1011,7 @@ func (c *LibAVFFmpegStreamer) encodeFrame(p *libAVParams, f *astiav.Frame, s *st
 	return nil
 }
 
-func (c *LibAVFFmpegStreamer) defineInputFormat(streamFormat string) (*astiav.InputFormat, error) {
+func (c *libAVPipeline) defineInputFormat(streamFormat string) (*astiav.InputFormat, error) {
 	var inputFormat *astiav.InputFormat
 	if streamFormat != "" {
 		inputFormat = astiav.FindInputFormat(streamFormat)
@@ -676,7 +1022,7 @@ func (c *LibAVFFmpegStreamer) defineInputFormat(streamFormat string) (*astiav.In
 	return inputFormat, nil
 }
 
-func (c *LibAVFFmpegStreamer) defineInputOptions(p *entities.DonutParameters, closer *astikit.Closer) *astiav.Dictionary {
+func (c *libAVPipeline) defineInputOptions(p *entities.DonutParameters, closer *astikit.Closer) *astiav.Dictionary {
 	var dic *astiav.Dictionary
 	if len(p.Recipe.Input.Options) > 0 {
 		dic = &astiav.Dictionary{}
@@ -689,76 +1035,39 @@ func (c *LibAVFFmpegStreamer) defineInputOptions(p *entities.DonutParameters, cl
 	return dic
 }
 
-func (c *LibAVFFmpegStreamer) defineAudioDuration(s *streamContext, pkt *astiav.Packet) time.Duration {
-	audioDuration := time.Duration(0)
-	if s.inputStream.CodecParameters().MediaType() == astiav.MediaTypeAudio {
-
-		// Audio
-		//
-		// dur = 12.416666ms
-		// sample = 48000
-		// frameSize = 596 (it can be variable for opus)
-		// 1s = dur * (sample/frameSize)
-		// ref https://developer.apple.com/documentation/coreaudiotypes/audiostreambasicdescription/1423257-mframesperpacket
-
-		// TODO: properly handle wraparound / roll over
-		// or explore av frame_size https://ffmpeg.org/doxygen/trunk/structAVCodecContext.html#aec57f0d859a6df8b479cd93ca3a44a33
-		// and libAV pts roll over
-		if float64(pkt.Dts())-c.lastAudioFrameDTS > 0 {
-			c.currentAudioFrameSize = float64(pkt.Dts()) - c.lastAudioFrameDTS
-		}
-
-		c.lastAudioFrameDTS = float64(pkt.Dts())
-		sampleRate := float64(s.encCodecContext.SampleRate())
-		audioDuration = time.Duration((c.currentAudioFrameSize / sampleRate) * float64(time.Second))
+// defineDuration computes the packet duration from the timestamps deltas of its stream,
+// the decode timestamps are preferred since the presentation ones are reordered with B-frames.
+func (c *libAVPipeline) defineDuration(s *streamContext, pkt *astiav.Packet, timeBase astiav.Rational) time.Duration {
+	if s.duration == nil {
+		s.duration = entities.NewFrameDuration(timeBase.Num(), timeBase.Den(), c.defineFallbackDuration(s))
+	}
+	ts := pkt.Dts()
+	if ts == astiav.NoPtsValue {
+		ts = pkt.Pts()
+	}
+	if ts == astiav.NoPtsValue {
+		return s.duration.Current()
 	}
-	return audioDuration
+	return s.duration.Next(ts)
 }
 
-func (c *LibAVFFmpegStreamer) defineVideoDuration(s *streamContext, _ *astiav.Packet) time.Duration {
-	videoDuration := time.Duration(0)
-	if s.inputStream.CodecParameters().MediaType() == astiav.MediaTypeVideo {
-		// Video
-		//
-		// dur = 0,033333
-		// sample = 30
-		// frameSize = 1
-		// 1s = dur * (sample/frameSize)
-
-		// we're assuming fixed video frame rate
-		videoDuration = time.Duration((float64(1) / float64(s.inputStream.AvgFrameRate().Num())) * float64(time.Second))
+// defineFallbackDuration estimates the first packet duration, when there's no previous timestamp yet.
+func (c *libAVPipeline) defineFallbackDuration(s *streamContext) time.Duration {
+	codecParams := s.inputStream.CodecParameters()
+	if codecParams.MediaType() == astiav.MediaTypeAudio {
+		// i.e. 1024 samples for AAC and 960 for 20ms of Opus
+		if codecParams.FrameSize() > 0 && codecParams.SampleRate() > 0 {
+			return time.Duration(codecParams.FrameSize()) * time.Second / time.Duration(codecParams.SampleRate())
+		}
+		return 20 * time.Millisecond
 	}
-	return videoDuration
-}
 
-// TODO: move this either to a mapper or make a PR for astiav
-func (*LibAVFFmpegStreamer) libAVLogToString(l astiav.LogLevel) string {
-	const _Ciconst_AV_LOG_DEBUG = 0x30
-	const _Ciconst_AV_LOG_ERROR = 0x10
-	const _Ciconst_AV_LOG_FATAL = 0x8
-	const _Ciconst_AV_LOG_INFO = 0x20
-	const _Ciconst_AV_LOG_PANIC = 0x0
-	const _Ciconst_AV_LOG_QUIET = -0x8
-	const _Ciconst_AV_LOG_VERBOSE = 0x28
-	const _Ciconst_AV_LOG_WARNING = 0x18
-	switch l {
-	case _Ciconst_AV_LOG_WARNING:
-		return "WARN"
-	case _Ciconst_AV_LOG_VERBOSE:
-		return "VERBOSE"
-	case _Ciconst_AV_LOG_QUIET:
-		return "QUIET"
-	case _Ciconst_AV_LOG_PANIC:
-		return "PANIC"
-	case _Ciconst_AV_LOG_INFO:
-		return "INFO"
-	case _Ciconst_AV_LOG_FATAL:
-		return "FATAL"
-	case _Ciconst_AV_LOG_DEBUG:
-		return "DEBUG"
-	case _Ciconst_AV_LOG_ERROR:
-		return "ERROR"
-	default:
-		return "UNKNOWN LEVEL"
+	frameRate := s.decCodecContext.Framerate()
+	if frameRate.Num() <= 0 || frameRate.Den() <= 0 {
+		frameRate = s.inputStream.AvgFrameRate()
+	}
+	if frameRate.Num() > 0 && frameRate.Den() > 0 {
+		return time.Duration(frameRate.Den()) * time.Second / time.Duration(frameRate.Num())
 	}
+	return time.Second / 30
 }
@@ -0,0 +1,176 @@
+package streamers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/asticode/go-astiav"
+	"github.com/asticode/go-astikit"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// activeStreams returns the streams being sent, the audio ones not selected are left out.
+func (p *libAVParams) activeStreams() []*streamContext {
+	var result []*streamContext
+	for _, s := range p.streams {
+		if s.inputStream.CodecParameters().MediaType() == astiav.MediaTypeAudio && s.inputStream.Index() != p.audioIndex {
+			continue
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].inputStream.Index() < result[j].inputStream.Index()
+	})
+	return result
+}
+
+// selectAudioStream picks the audio stream by index, by language or the first one when none is given.
+func selectAudioStream(streams []entities.Stream, index *int, language string) (entities.Stream, bool) {
+	for _, st := range streams {
+		switch {
+		case index != nil:
+			if int(st.Index) == *index {
+				return st, true
+			}
+		case language != "":
+			if matchesLanguage(st.Language, language) {
+				return st, true
+			}
+		default:
+			return st, true
+		}
+	}
+	return entities.Stream{}, false
+}
+
+// prepareAudioStreams selects the audio stream requested and notifies all of them,
+// the ones not selected have no action.
+func (c *libAVPipeline) prepareAudioStreams(p *libAVParams, donut *entities.DonutParameters) error {
+	p.audioIndex = -1
+	if len(p.audioStreams) == 0 {
+		return nil
+	}
+	sort.Slice(p.audioStreams, func(i, j int) bool {
+		return p.audioStreams[i].Index < p.audioStreams[j].Index
+	})
+
+	selected, ok := selectAudioStream(p.audioStreams, donut.Recipe.Audio.StreamIndex, donut.Recipe.Audio.Language)
+	if !ok {
+		c.l.Warnw("requested audio stream not found, using the default one",
+			"index", donut.Recipe.Audio.StreamIndex,
+			"language", donut.Recipe.Audio.Language,
+		)
+		selected, _ = selectAudioStream(p.audioStreams, nil, "")
+	}
+	p.audioIndex = int(selected.Index)
+	p.requestedAudioIndex.Store(int64(selected.Index))
+	c.l.Infof("selected audio stream index = %d (%s)", selected.Index, selected.Language)
+
+	for _, st := range p.audioStreams {
+		s := p.streams[int(st.Index)]
+		if int(st.Index) == p.audioIndex {
+			s.stream.Action = donut.Recipe.Audio.Action
+		}
+		if err := c.notifyStream(s, donut); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *libAVPipeline) registerSwitchAudioTrack(p *libAVParams, donut *entities.DonutParameters) {
+	donut.RegisterCommand(entities.CommandSwitchAudioTrack, func(cmd entities.Command) (interface{}, error) {
+		args := entities.SwitchAudioTrackArgs{}
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return nil, fmt.Errorf("%w %s", entities.ErrInvalidCommandArgs, err.Error())
+		}
+		if args.Index == nil && args.Language == "" {
+			return nil, fmt.Errorf("%w either index or language must be given", entities.ErrInvalidCommandArgs)
+		}
+		st, ok := selectAudioStream(p.audioStreams, args.Index, args.Language)
+		if !ok {
+			return nil, entities.ErrMissingAudioStream
+		}
+		// the switch happens in the streaming loop
+		p.requestedAudioIndex.Store(int64(st.Index))
+		st.Action = donut.Recipe.Audio.Action
+		return st, nil
+	})
+}
+
+// switchAudioStream applies the audio stream requested by the client,
+// the pipeline (encoder, filters) of a stream is only built once it's selected.
+func (c *libAVPipeline) switchAudioStream(p *libAVParams, closer *astikit.Closer, donut *entities.DonutParameters) error {
+	requested := int(p.requestedAudioIndex.Load())
+	if p.audioIndex < 0 || requested == p.audioIndex {
+		return nil
+	}
+	s, ok := p.streams[requested]
+	if !ok {
+		return fmt.Errorf("%w index %d", entities.ErrMissingAudioStream, requested)
+	}
+
+	if !s.prepared {
+		if err := c.prepareStreamOutput(s, closer, donut); err != nil {
+			return err
+		}
+		if err := c.prepareStreamFilters(s, closer, donut); err != nil {
+			return err
+		}
+		if err := c.prepareStreamBitStreamFilter(s, closer, donut); err != nil {
+			return err
+		}
+		s.prepared = true
+	}
+
+	c.l.Infof("switching audio stream from index = %d to %d", p.audioIndex, requested)
+	previous := p.streams[p.audioIndex]
+	previous.stream.Action = ""
+	s.stream.Action = donut.Recipe.Audio.Action
+	p.audioIndex = requested
+	// the stream was idle, its previous timestamp is no longer related
+	if s.duration != nil {
+		s.duration.Reset()
+	}
+	p.avSync.ResetAudio()
+
+	if err := c.notifyStream(previous, donut); err != nil {
+		return err
+	}
+	if err := c.notifyStream(s, donut); err != nil {
+		return err
+	}
+	return c.notifyOutputStream(s, donut)
+}
+
+func (c *libAVPipeline) notifyStream(s *streamContext, donut *entities.DonutParameters) error {
+	if donut.OnStream == nil {
+		return nil
+	}
+	stream := s.stream
+	return donut.OnStream(&stream)
+}
+
+// notifyOutputStream describes the stream as it's sent, either the bypassed input or the encoder output.
+func (c *libAVPipeline) notifyOutputStream(s *streamContext, donut *entities.DonutParameters) error {
+	if donut.OnOutputStream == nil {
+		return nil
+	}
+	var st entities.OutputStream
+	if s.encCodecContext != nil {
+		task := donut.Recipe.Audio
+		if s.decCodecContext.MediaType() == astiav.MediaTypeVideo {
+			task = donut.Recipe.Video
+		}
+		st.Stream = c.m.FromLibAVCodecContextToEntityStream(task.Codec, s.encCodecContext)
+		st.ExtraData = s.encCodecContext.ExtraData()
+		st.FrameSize = s.encCodecContext.FrameSize()
+	} else {
+		st.Stream = c.m.FromLibAVStreamToEntityStream(s.inputStream)
+		st.ExtraData = s.inputStream.CodecParameters().ExtraData()
+		st.FrameSize = s.inputStream.CodecParameters().FrameSize()
+	}
+	st.Index = uint16(s.inputStream.Index())
+	return donut.OnOutputStream(&st)
+}
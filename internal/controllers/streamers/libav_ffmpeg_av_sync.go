@@ -0,0 +1,134 @@
+package streamers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/asticode/go-astikit"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+var nanosecondTimeBase = astiav.NewRational(1, int(time.Second))
+
+// silenceSource generates the silent frames inserted by the A/V sync, in the audio encoder format.
+type silenceSource struct {
+	filterGraph       *astiav.FilterGraph
+	buffersinkContext *astiav.FilterContext
+	frame             *astiav.Frame
+}
+
+func newAVSync(c *entities.Config) *entities.AVSync {
+	return entities.NewAVSync(
+		time.Duration(c.AVSyncThresholdMS)*time.Millisecond,
+		time.Duration(c.AVSyncMaxCorrectionMS)*time.Millisecond,
+	)
+}
+
+func (c *libAVPipeline) prepareSilenceSource(s *streamContext, closer *astikit.Closer) (*silenceSource, error) {
+	src := &silenceSource{}
+	if src.filterGraph = astiav.AllocFilterGraph(); src.filterGraph == nil {
+		return nil, errors.New("silence: graph is nil")
+	}
+	closer.Add(src.filterGraph.Free)
+
+	inputs := astiav.AllocFilterInOut()
+	if inputs == nil {
+		return nil, errors.New("silence: inputs is nil")
+	}
+	defer inputs.Free()
+
+	buffersink := astiav.FindFilterByName("abuffersink")
+	if buffersink == nil {
+		return nil, errors.New("silence: buffersink is nil")
+	}
+	var err error
+	if src.buffersinkContext, err = src.filterGraph.NewFilterContext(buffersink, "out", nil); err != nil {
+		return nil, fmt.Errorf("silence: creating buffersink context failed: %w", err)
+	}
+
+	inputs.SetName("out")
+	inputs.SetFilterContext(src.buffersinkContext)
+	inputs.SetPadIdx(0)
+	inputs.SetNext(nil)
+
+	content := fmt.Sprintf("anullsrc=channel_layout=%s:sample_rate=%d:nb_samples=%d,aformat=sample_fmts=%s",
+		s.encCodecContext.ChannelLayout().String(),
+		s.encCodecContext.SampleRate(),
+		c.audioFrameSize(s, nil),
+		s.encCodecContext.SampleFormat().Name(),
+	)
+	if err = src.filterGraph.Parse(content, inputs, nil); err != nil {
+		return nil, fmt.Errorf("silence: parsing filter failed: %w", err)
+	}
+	if err = src.filterGraph.Configure(); err != nil {
+		return nil, fmt.Errorf("silence: configuring filter failed: %w", err)
+	}
+
+	src.frame = astiav.AllocFrame()
+	closer.Add(src.frame.Free)
+	return src, nil
+}
+
+// correctAudio inserts a silent frame before f or returns false when f must be dropped, to keep the audio
+// close to the video.
+func (c *libAVPipeline) correctAudio(p *libAVParams, f *astiav.Frame, s *streamContext, donut *entities.DonutParameters) (bool, error) {
+	if s.silence == nil || f.Pts() == astiav.NoPtsValue || s.encCodecContext.SampleRate() <= 0 {
+		return true, nil
+	}
+	// the frames keep the input time base until they're encoded
+	timeBase := s.inputStream.TimeBase()
+	pts := time.Duration(astiav.RescaleQ(f.Pts(), timeBase, nanosecondTimeBase))
+	frameDuration := time.Duration(c.audioFrameSize(s, f)) * time.Second / time.Duration(s.encCodecContext.SampleRate())
+
+	switch p.avSync.CorrectAudio(frameDuration) {
+	case entities.AVSyncDropAudio:
+		c.l.Infof("a/v sync is dropping the audio frame at %s", pts)
+		p.stats.droppedFrames.Add(1)
+		return false, nil
+	case entities.AVSyncInsertSilence:
+		c.l.Infof("a/v sync is inserting %s of silence at %s", frameDuration, pts)
+		if err := c.encodeSilence(p, s, f.Pts()-astiav.RescaleQ(int64(frameDuration), nanosecondTimeBase, timeBase), donut); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (c *libAVPipeline) encodeSilence(p *libAVParams, s *streamContext, pts int64, donut *entities.DonutParameters) error {
+	s.silence.frame.Unref()
+	if err := s.silence.buffersinkContext.BuffersinkGetFrame(s.silence.frame, astiav.NewBuffersinkFlags()); err != nil {
+		return fmt.Errorf("silence: getting frame failed: %w", err)
+	}
+	s.silence.frame.SetPts(pts)
+	return c.encodeFrame(p, s.silence.frame, s, donut)
+}
+
+// audioFrameSize is the number of samples of f, otherwise the one per encoded frame (20ms for the encoders
+// without a fixed one). The filters already split the audio into frames the encoder takes.
+func (c *libAVPipeline) audioFrameSize(s *streamContext, f *astiav.Frame) int {
+	if f != nil && f.NbSamples() > 0 {
+		return f.NbSamples()
+	}
+	if n := s.encCodecContext.FrameSize(); n > 0 {
+		return n
+	}
+	return s.encCodecContext.SampleRate() / 50
+}
+
+// onAVSyncOutput measures the A/V offset of the frames sent.
+func (c *libAVPipeline) onAVSyncOutput(p *libAVParams, isVideo bool, frameCtx entities.MediaFrameContext) {
+	pts, ok := frameCtx.PTSDuration()
+	if !ok {
+		return
+	}
+	if isVideo {
+		p.avSync.OnVideoOutput(pts)
+		return
+	}
+	p.avSync.OnAudioOutput(pts)
+	if offset, ok := p.avSync.Offset(); ok {
+		p.stats.onAVSync(offset, p.avSync.Correction())
+	}
+}
@@ -0,0 +1,92 @@
+package streamers
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/asticode/go-astiav"
+	"go.uber.org/zap"
+)
+
+// libAVLoggers routes the libav logs to the logger of the session owning the context (format or codec)
+// which logged them, the libav log callback is process wide so it's set only once.
+type libAVLoggers struct {
+	once     sync.Once
+	mu       sync.RWMutex
+	loggers  map[astiav.Classer]*zap.SugaredLogger
+	fallback *zap.SugaredLogger
+}
+
+func newLibAVLoggers(l *zap.SugaredLogger) *libAVLoggers {
+	return &libAVLoggers{
+		loggers:  make(map[astiav.Classer]*zap.SugaredLogger),
+		fallback: l,
+	}
+}
+
+func (ls *libAVLoggers) install() {
+	ls.once.Do(func() {
+		// it's useful for debugging
+		// astiav.SetLogLevel(astiav.LogLevelDebug)
+		astiav.SetLogLevel(astiav.LogLevelInfo)
+		astiav.SetLogCallback(func(c astiav.Classer, l astiav.LogLevel, fmt, msg string) {
+			ls.loggerFor(c).Infof("ffmpeg %s: - %s", libAVLogToString(l), strings.TrimSpace(msg))
+		})
+	})
+}
+
+// register tags the logs of the classer with the session logger until the returned func is called.
+func (ls *libAVLoggers) register(c astiav.Classer, l *zap.SugaredLogger) func() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.loggers[c] = l
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		delete(ls.loggers, c)
+	}
+}
+
+func (ls *libAVLoggers) loggerFor(c astiav.Classer) *zap.SugaredLogger {
+	if c == nil {
+		return ls.fallback
+	}
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	if l, ok := ls.loggers[c]; ok {
+		return l
+	}
+	return ls.fallback
+}
+
+// TODO: move this either to a mapper or make a PR for astiav
+func libAVLogToString(l astiav.LogLevel) string {
+	const _Ciconst_AV_LOG_DEBUG = 0x30
+	const _Ciconst_AV_LOG_ERROR = 0x10
+	const _Ciconst_AV_LOG_FATAL = 0x8
+	const _Ciconst_AV_LOG_INFO = 0x20
+	const _Ciconst_AV_LOG_PANIC = 0x0
+	const _Ciconst_AV_LOG_QUIET = -0x8
+	const _Ciconst_AV_LOG_VERBOSE = 0x28
+	const _Ciconst_AV_LOG_WARNING = 0x18
+	switch l {
+	case _Ciconst_AV_LOG_WARNING:
+		return "WARN"
+	case _Ciconst_AV_LOG_VERBOSE:
+		return "VERBOSE"
+	case _Ciconst_AV_LOG_QUIET:
+		return "QUIET"
+	case _Ciconst_AV_LOG_PANIC:
+		return "PANIC"
+	case _Ciconst_AV_LOG_INFO:
+		return "INFO"
+	case _Ciconst_AV_LOG_FATAL:
+		return "FATAL"
+	case _Ciconst_AV_LOG_DEBUG:
+		return "DEBUG"
+	case _Ciconst_AV_LOG_ERROR:
+		return "ERROR"
+	default:
+		return "UNKNOWN LEVEL"
+	}
+}
@@ -0,0 +1,117 @@
+package streamers
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// onMetaDataCheckInterval throttles the comparison of the FLV metadata,
+// libav keeps the updated event flag set once onMetaData changes.
+const onMetaDataCheckInterval = time.Second
+
+var millisecondTimeBase = astiav.NewRational(1, 1000)
+
+func isFLVInput(p *libAVParams) bool {
+	return p.inputFormatContext.InputFormat() != nil &&
+		strings.Contains(p.inputFormatContext.InputFormat().Name(), "flv")
+}
+
+// timedMetadataStreamType tells which kind of timed metadata the stream carries, if any.
+func timedMetadataStreamType(p *libAVParams, is *astiav.Stream) (entities.TimedMetadataType, bool) {
+	switch {
+	case is.CodecParameters().CodecID() == astiav.CodecIDTimedId3:
+		return entities.TimedMetadataID3, true
+	case is.CodecParameters().CodecID() == astiav.CodecIDText && isFLVInput(p):
+		// libav exposes the AMF onTextData as a text subtitle stream
+		return entities.TimedMetadataOnTextData, true
+	}
+	return "", false
+}
+
+// classifyNewStream handles the streams created once the input is already prepared, i.e. the FLV demuxer only
+// creates the onTextData stream when the first tag is read. The other unknown streams are ignored.
+func (c *libAVPipeline) classifyNewStream(p *libAVParams, index int) {
+	if _, ok := p.streams[index]; ok {
+		return
+	}
+	if _, ok := p.metadataStreams[index]; ok || p.ignoredStreams[index] {
+		return
+	}
+	streams := p.inputFormatContext.Streams()
+	if index >= 0 && index < len(streams) {
+		if t, ok := timedMetadataStreamType(p, streams[index]); ok {
+			c.l.Infof("reading %s timed metadata stream index = %d", t, index)
+			p.metadataStreams[index] = t
+			return
+		}
+	}
+	c.l.Warnf("skipping to process stream id=%d", index)
+	p.ignoredStreams[index] = true
+}
+
+func (c *libAVPipeline) onTimedMetadataPacket(p *libAVParams, pkt *astiav.Packet, t entities.TimedMetadataType, donut *entities.DonutParameters) {
+	md := &entities.TimedMetadata{
+		Type:  t,
+		PTSMS: p.lastVideoPTSMS,
+	}
+	if pkt.Pts() != astiav.NoPtsValue {
+		md.PTSMS = astiav.RescaleQ(pkt.Pts(), p.inputFormatContext.Streams()[pkt.StreamIndex()].TimeBase(), millisecondTimeBase)
+	}
+
+	switch t {
+	case entities.TimedMetadataID3:
+		frames, err := controllers.ParseID3(pkt.Data())
+		if err != nil {
+			c.l.Warnw("ignoring invalid id3 packet", "error", err)
+			return
+		}
+		md.Frames = frames
+	case entities.TimedMetadataOnTextData:
+		md.Text = strings.TrimRight(string(pkt.Data()), "\x00")
+	}
+
+	c.sendTimedMetadata(md, donut)
+}
+
+// checkOnMetaData sends the FLV onMetaData whenever it differs from the last one sent.
+// go-astiav can't clear the libav metadata updated flag, it stays set once onMetaData changes,
+// so only the fields sent successfully are kept and a failed send is retried at the next check.
+func (c *libAVPipeline) checkOnMetaData(p *libAVParams, donut *entities.DonutParameters, force bool) {
+	if !force {
+		if !p.inputFormatContext.EventFlags().Has(astiav.FormatEventFlagMetadataUpdated) ||
+			time.Since(p.lastOnMetaDataCheck) < onMetaDataCheckInterval {
+			return
+		}
+	}
+	p.lastOnMetaDataCheck = time.Now()
+
+	fields := c.m.FromLibAVDictionaryToMap(p.inputFormatContext.Metadata())
+	if len(fields) == 0 || reflect.DeepEqual(fields, p.lastOnMetaData) {
+		return
+	}
+
+	if c.sendTimedMetadata(&entities.TimedMetadata{
+		Type:   entities.TimedMetadataOnMetaData,
+		PTSMS:  p.lastVideoPTSMS,
+		Fields: fields,
+	}, donut) {
+		p.lastOnMetaData = fields
+	}
+}
+
+// sendTimedMetadata logs the send errors (i.e. the data channel isn't open yet), the stream goes on without them.
+func (c *libAVPipeline) sendTimedMetadata(md *entities.TimedMetadata, donut *entities.DonutParameters) bool {
+	if donut.OnTimedMetadata == nil {
+		return true
+	}
+	if err := donut.OnTimedMetadata(md); err != nil {
+		c.l.Warnw("failed to send timed metadata", "type", md.Type, "error", err)
+		return false
+	}
+	return true
+}
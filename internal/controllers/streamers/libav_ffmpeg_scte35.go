@@ -0,0 +1,71 @@
+package streamers
+
+import (
+	"github.com/asticode/go-astiav"
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// mpegTSTimeBase is the 90kHz clock used by the SCTE-35 splice times
+var mpegTSTimeBase = astiav.NewRational(1, 90000)
+
+func isSCTE35Stream(is *astiav.Stream) bool {
+	return is.CodecParameters().MediaType() == astiav.MediaTypeData &&
+		is.CodecParameters().CodecID().Name() == "scte_35"
+}
+
+// onSpliceInfoPacket parses the splice info section, the resulting event is either sent
+// right away (immediate splices) or once the video reaches its splice time.
+func (c *libAVPipeline) onSpliceInfoPacket(p *libAVParams, pkt *astiav.Packet, donut *entities.DonutParameters) {
+	info, err := controllers.ParseSpliceInfoSection(pkt.Data())
+	if err != nil {
+		c.l.Warnw("ignoring invalid scte-35 packet", "error", err)
+		return
+	}
+	ev, ok := c.m.FromSpliceInfoToEntitySpliceEvent(info)
+	if !ok {
+		return
+	}
+	c.l.Infof("scte-35 %s event=%d pts=%d immediate=%t", ev.Type, ev.EventID, ev.PTS, ev.Immediate)
+
+	if ev.Immediate || !p.hasVideo {
+		c.sendSpliceEvent(ev, donut)
+		return
+	}
+	p.pendingSplices = append(p.pendingSplices, ev)
+}
+
+// sendDueSpliceEvents sends the pending events whose splice time has been reached by the video packet.
+func (c *libAVPipeline) sendDueSpliceEvents(p *libAVParams, pkt *astiav.Packet, s *streamContext, donut *entities.DonutParameters) {
+	if len(p.pendingSplices) == 0 || pkt.Pts() == astiav.NoPtsValue {
+		return
+	}
+	pts := astiav.RescaleQ(pkt.Pts(), s.inputStream.TimeBase(), mpegTSTimeBase) & entities.MaxPTS33Bits
+
+	pending := p.pendingSplices[:0]
+	for _, ev := range p.pendingSplices {
+		if !isPTSReached(pts, ev.PTS) {
+			pending = append(pending, ev)
+			continue
+		}
+		c.sendSpliceEvent(ev, donut)
+	}
+	p.pendingSplices = pending
+}
+
+// sendSpliceEvent logs the send errors (i.e. the data channel isn't open yet), the stream goes on without them.
+func (c *libAVPipeline) sendSpliceEvent(ev entities.SpliceEvent, donut *entities.DonutParameters) {
+	if donut.OnSpliceEvent == nil {
+		return
+	}
+	if err := donut.OnSpliceEvent(&ev); err != nil {
+		c.l.Warnw("failed to send scte-35 event", "event", ev.EventID, "error", err)
+	}
+}
+
+// isPTSReached compares 33 bits timestamps taking the wrap around into account,
+// a target more than half of the range ahead is considered in the past.
+func isPTSReached(current, target int64) bool {
+	ahead := (target - current) & entities.MaxPTS33Bits
+	return ahead == 0 || ahead > entities.MaxPTS33Bits/2
+}
@@ -0,0 +1,124 @@
+package streamers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// maxTrackedPackets bounds the read timestamps kept to measure the pipeline latency
+const maxTrackedPackets = 512
+
+// streamerStats holds the counters of a single streaming session,
+// they're updated by the streaming loop and read by the stats reporter.
+type streamerStats struct {
+	inputBytes    atomic.Int64
+	outputBytes   atomic.Int64
+	inputFrames   atomic.Int64
+	outputFrames  atomic.Int64
+	droppedFrames atomic.Int64
+	encoderQueue  atomic.Int64
+
+	mu              sync.Mutex
+	readAt          map[int64]time.Time
+	pipelineLatency time.Duration
+	avOffset        time.Duration
+	audioCorrection time.Duration
+
+	// previous snapshot, used to compute the rates
+	lastSnapshotAt time.Time
+	lastInBytes    int64
+	lastOutBytes   int64
+	lastInFrames   int64
+	lastOutFrames  int64
+}
+
+func newStreamerStats() *streamerStats {
+	return &streamerStats{
+		readAt:         make(map[int64]time.Time),
+		lastSnapshotAt: time.Now(),
+	}
+}
+
+func (s *streamerStats) onVideoPacketRead(pts int64) {
+	s.inputFrames.Add(1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.readAt) >= maxTrackedPackets {
+		// the output is not keeping up (or pts is missing), starting over
+		s.readAt = make(map[int64]time.Time)
+	}
+	s.readAt[pts] = time.Now()
+}
+
+func (s *streamerStats) onVideoFrameSent(pts int64, size int) {
+	s.outputFrames.Add(1)
+	s.outputBytes.Add(int64(size))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if readAt, ok := s.readAt[pts]; ok {
+		s.pipelineLatency = time.Since(readAt)
+		delete(s.readAt, pts)
+	}
+}
+
+func (s *streamerStats) onAVSync(offset, audioCorrection time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.avOffset = offset
+	s.audioCorrection = audioCorrection
+}
+
+func (s *streamerStats) snapshot() entities.StreamerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(s.lastSnapshotAt).Seconds()
+	inBytes, outBytes := s.inputBytes.Load(), s.outputBytes.Load()
+	inFrames, outFrames := s.inputFrames.Load(), s.outputFrames.Load()
+
+	st := entities.StreamerStats{
+		DroppedFrames:     s.droppedFrames.Load(),
+		EncoderQueueDepth: s.encoderQueue.Load(),
+		PipelineLatencyMS: s.pipelineLatency.Milliseconds(),
+		AVOffsetMS:        s.avOffset.Milliseconds(),
+		AudioCorrectionMS: s.audioCorrection.Milliseconds(),
+	}
+	if elapsed > 0 {
+		st.InputBitRate = int64(float64(inBytes-s.lastInBytes) * 8 / elapsed)
+		st.OutputBitRate = int64(float64(outBytes-s.lastOutBytes) * 8 / elapsed)
+		st.InputFPS = float64(inFrames-s.lastInFrames) / elapsed
+		st.OutputFPS = float64(outFrames-s.lastOutFrames) / elapsed
+	}
+
+	s.lastSnapshotAt = now
+	s.lastInBytes, s.lastOutBytes = inBytes, outBytes
+	s.lastInFrames, s.lastOutFrames = inFrames, outFrames
+
+	return st
+}
+
+func (c *libAVPipeline) reportStats(p *libAVParams, donut *entities.DonutParameters, done <-chan struct{}) {
+	if donut.OnStats == nil || c.c.StatsIntervalMS <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(c.c.StatsIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-donut.Ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			donut.OnStats(p.stats.snapshot())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package streamers
+
+import (
+	"strings"
+
+	"github.com/asticode/go-astiav"
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// subtitleContext is the selected subtitle stream. astiav doesn't wrap avcodec_decode_subtitle2 nor AVSubtitle,
+// and libav only decodes teletext through libzvbi (an optional dependency), therefore the text based subtitles
+// and teletext are decoded by the controllers. The bitmap ones are not supported.
+type subtitleContext struct {
+	inputStream *astiav.Stream
+	stream      entities.Stream
+	teletext    *controllers.TeletextDecoder
+}
+
+// prepareSubtitles selects the subtitle stream matching the requested language,
+// falling back to the first supported one.
+func (c *libAVPipeline) prepareSubtitles(p *libAVParams, candidates []*astiav.Stream, donut *entities.DonutParameters) error {
+	var selected *astiav.Stream
+	var selectedStream entities.Stream
+	for _, is := range candidates {
+		st := c.m.FromLibAVStreamToEntityStream(is)
+		if !entities.IsSupportedSubtitle(st.Codec) {
+			c.l.Infof("skipping unsupported subtitle %s index = %d", is.CodecParameters().CodecID().Name(), is.Index())
+			continue
+		}
+		if selected == nil {
+			selected, selectedStream = is, st
+		}
+		if matchesLanguage(st.Language, donut.Recipe.Subtitle.Language) {
+			selected, selectedStream = is, st
+			break
+		}
+	}
+	if selected == nil {
+		return nil
+	}
+
+	c.l.Infof("reading %s subtitles (%s) index = %d", selectedStream.Codec, selectedStream.Language, selected.Index())
+	p.subtitle = &subtitleContext{
+		inputStream: selected,
+		stream:      selectedStream,
+	}
+	if selectedStream.Codec == entities.DVBTeletext {
+		p.subtitle.teletext = controllers.NewTeletextDecoder(
+			controllers.TeletextSubtitlePage(selected.CodecParameters().ExtraData()),
+		)
+	}
+
+	if donut.OnStream != nil {
+		return donut.OnStream(&selectedStream)
+	}
+	return nil
+}
+
+// matchesLanguage compares the stream language, which may hold many (i.e. teletext "eng,por"), to the requested one.
+func matchesLanguage(streamLanguage, language string) bool {
+	if language == "" {
+		return false
+	}
+	for _, l := range strings.Split(streamLanguage, ",") {
+		if strings.EqualFold(strings.TrimSpace(l), language) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *libAVPipeline) onSubtitlePacket(p *libAVParams, pkt *astiav.Packet, donut *entities.DonutParameters) error {
+	if donut.OnCue == nil || pkt.Pts() == astiav.NoPtsValue {
+		return nil
+	}
+	s := p.subtitle
+	startTime := astiav.RescaleQ(pkt.Pts(), s.inputStream.TimeBase(), millisecondTimeBase)
+
+	if s.teletext != nil {
+		for _, cue := range s.teletext.Decode(pkt.Data(), startTime) {
+			cue.Language = s.stream.Language
+			if err := donut.OnCue(&cue); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	text, err := controllers.ParseSubtitleText(s.stream.Codec, pkt.Data())
+	if err != nil {
+		c.l.Warnw("ignoring invalid subtitle packet", "error", err)
+		return nil
+	}
+	cue := &entities.Cue{
+		Type:      "subtitles",
+		StartTime: startTime,
+		Text:      text,
+		Language:  s.stream.Language,
+	}
+	if pkt.Duration() > 0 {
+		cue.EndTime = startTime + astiav.RescaleQ(pkt.Duration(), s.inputStream.TimeBase(), millisecondTimeBase)
+	}
+	return donut.OnCue(cue)
+}
@@ -0,0 +1,220 @@
+package streamers_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asticode/go-astiav"
+	"github.com/flavioribeiro/donut/internal/controllers/streamers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"github.com/flavioribeiro/donut/internal/web"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+func selectStreamer(t *testing.T) (streamers.DonutStreamer, *mapper.Mapper) {
+	var s []streamers.DonutStreamer
+	var m *mapper.Mapper
+	fxtest.New(t,
+		web.Dependencies(false),
+		fx.Populate(
+			fx.Annotate(
+				&s,
+				fx.ParamTags(`group:"streamers"`),
+			),
+		),
+		fx.Populate(&m),
+	)
+	return s[0], m
+}
+
+// lavfiTestSource is a short synthetic video, the lavfi input format comes from libavdevice.
+var lavfiTestSource = entities.DonutAppetizer{
+	URL:    "testsrc2=size=320x240:rate=30:duration=2",
+	Format: "lavfi",
+}
+
+func skipWithoutEncoder(t *testing.T, m *mapper.Mapper, codec entities.Codec) {
+	for _, name := range m.FromStreamCodecToLibAVEncoderNames(codec) {
+		if astiav.FindEncoderByName(name) != nil {
+			return
+		}
+	}
+	t.Skipf("there is no %s encoder available", codec)
+}
+
+func transcodeRecipe(codec entities.Codec) entities.DonutRecipe {
+	return entities.DonutRecipe{
+		Input: lavfiTestSource,
+		Video: entities.DonutMediaTask{
+			Action: entities.DonutTranscode,
+			Codec:  codec,
+			CodecContextOptions: []entities.LibAVOptionsCodecContext{
+				entities.SetBitRate(500_000),
+				entities.SetGopSize(30),
+			},
+			EncoderOptions: entities.LowLatencyEncoderOptions,
+		},
+	}
+}
+
+func transcodeTestSource(t *testing.T, codec entities.Codec) {
+	astiav.RegisterAllDevices()
+	streamer, m := selectStreamer(t)
+	skipWithoutEncoder(t, m, codec)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var frames int
+	var streamErr error
+	streamer.Stream(&entities.DonutParameters{
+		Ctx:    ctx,
+		Cancel: cancel,
+		Recipe: transcodeRecipe(codec),
+		OnError: func(err error) {
+			streamErr = err
+			cancel()
+		},
+		OnVideoFrame: func(data []byte, c entities.MediaFrameContext) error {
+			assert.NotEmpty(t, data)
+			frames++
+			return nil
+		},
+	})
+
+	assert.Nil(t, streamErr)
+	assert.Greater(t, frames, 0)
+	assert.Nil(t, ctx.Err())
+}
+
+func TestLibAVFFmpegStreamer_Transcode_H264(t *testing.T) {
+	transcodeTestSource(t, entities.H264)
+}
+
+func TestLibAVFFmpegStreamer_Transcode_VP8(t *testing.T) {
+	transcodeTestSource(t, entities.VP8)
+}
+
+func TestLibAVFFmpegStreamer_Transcode_VP9(t *testing.T) {
+	transcodeTestSource(t, entities.VP9)
+}
+
+func TestLibAVFFmpegStreamer_Transcode_AV1(t *testing.T) {
+	transcodeTestSource(t, entities.AV1)
+}
+
+// TestLibAVFFmpegStreamer_ConcurrentSessions is meant to run with -race, each session streams with its own pipeline.
+func TestLibAVFFmpegStreamer_ConcurrentSessions(t *testing.T) {
+	astiav.RegisterAllDevices()
+	streamer, m := selectStreamer(t)
+	skipWithoutEncoder(t, m, entities.VP8)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	const sessions = 4
+	frames := make([]int, sessions)
+	errs := make([]error, sessions)
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lastPTS := -1
+			streamer.Stream(&entities.DonutParameters{
+				Ctx:       ctx,
+				Cancel:    cancel,
+				SessionID: fmt.Sprintf("session-%d", i),
+				Recipe:    transcodeRecipe(entities.VP8),
+				OnError: func(err error) {
+					errs[i] = err
+				},
+				OnVideoFrame: func(data []byte, c entities.MediaFrameContext) error {
+					// each session has its own timestamps and durations
+					assert.Greater(t, c.PTS, lastPTS)
+					if frames[i] > 0 {
+						assert.InDelta(t, time.Second/30, c.Duration, float64(time.Millisecond))
+					}
+					lastPTS = c.PTS
+					frames[i]++
+					return nil
+				},
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Nil(t, ctx.Err())
+	for i := 0; i < sessions; i++ {
+		assert.Nil(t, errs[i], "session %d", i)
+		assert.Greater(t, frames[i], 0, "session %d", i)
+	}
+}
+
+// TestLibAVFFmpegStreamer_Transcode_Opus feeds AAC sized frames (1024 samples) to the Opus encoder (960 samples),
+// none of the audio must be truncated nor replaced with silence by the A/V sync.
+func TestLibAVFFmpegStreamer_Transcode_Opus(t *testing.T) {
+	astiav.RegisterAllDevices()
+	streamer, m := selectStreamer(t)
+	skipWithoutEncoder(t, m, entities.Opus)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var frames int
+	var deltas []time.Duration
+	var lastPTS time.Duration
+	var streamErr error
+	streamer.Stream(&entities.DonutParameters{
+		Ctx:    ctx,
+		Cancel: cancel,
+		Recipe: entities.DonutRecipe{
+			Input: entities.DonutAppetizer{
+				URL:    "testsrc2=size=320x240:rate=30:duration=2[out0];sine=frequency=440:sample_rate=48000:samples_per_frame=1024:duration=2[out1]",
+				Format: "lavfi",
+			},
+			Video: entities.DonutMediaTask{
+				Action: entities.DonutBypass,
+			},
+			Audio: entities.DonutMediaTask{
+				Action:            entities.DonutTranscode,
+				Codec:             entities.Opus,
+				DonutStreamFilter: entities.AudioResamplerFixedFrameFilter(48000, 960),
+				CodecContextOptions: []entities.LibAVOptionsCodecContext{
+					entities.SetSampleRate(48000),
+					entities.SetSampleFormat("fltp"),
+				},
+			},
+		},
+		OnError: func(err error) {
+			streamErr = err
+			cancel()
+		},
+		OnVideoFrame: func(data []byte, c entities.MediaFrameContext) error {
+			return nil
+		},
+		OnAudioFrame: func(data []byte, c entities.MediaFrameContext) error {
+			pts, ok := c.PTSDuration()
+			assert.True(t, ok)
+			if frames > 0 {
+				deltas = append(deltas, pts-lastPTS)
+			}
+			lastPTS = pts
+			frames++
+			return nil
+		},
+	})
+
+	assert.Nil(t, streamErr)
+	// 2s of audio are 100 frames of 20ms, minus the encoder delay
+	assert.InDelta(t, 100, frames, 3)
+	for i, delta := range deltas {
+		assert.Equal(t, 20*time.Millisecond, delta, "frame %d", i+1)
+	}
+}
@@ -10,25 +10,23 @@ import (
 
 type eia608Reader struct {
 	frame gocaption.EIA608Frame
+	codec entities.Codec
 }
 
-func newEIA608Reader() (r *eia608Reader) {
-	return &eia608Reader{}
+func newEIA608Reader(codec entities.Codec) (r *eia608Reader) {
+	return &eia608Reader{codec: codec}
 }
 
 func (r *eia608Reader) parse(data []byte) (string, error) {
-	nalus, err := controllers.ParseNALUs(data)
+	units, err := controllers.ParseVideoUnits(r.codec, data)
 	if err != nil {
 		return "", err
 	}
-	for _, nal := range nalus.Units {
+	for _, unit := range units {
 		// ANSI/SCTE 128-1 2020
-		// Note that SEI payload is a SEI payloadType of 4 which contains the itu_t_t35_payload_byte for the terminal provider
-		if nal.UnitType == entities.SupplementalEnhancementInformation && nal.SEI.PayloadType == 4 {
-			// ANSI/SCTE 128-1 2020
-			// Caption, AFD and bar data shall be carried in the SEI raw byte sequence payload (RBSP)
-			// syntax of the video Elementary Stream.
-			cea708Data := nal.RBSPByte[2:] // skip payload type and length bytes
+		// Caption, AFD and bar data shall be carried in the SEI raw byte sequence payload (RBSP)
+		// syntax of the video Elementary Stream, as the itu_t_t35_payload_byte (AV1 uses a metadata OBU instead).
+		for _, cea708Data := range unit.ITUTT35Payloads() {
 			cea708, err := gocaption.CEA708ToCCData(cea708Data)
 			if err != nil {
 				return "", err
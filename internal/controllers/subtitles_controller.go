@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+var (
+	subtitleMarkupTags = regexp.MustCompile(`<[^>]*>`)
+	assOverrideTags    = regexp.MustCompile(`\{[^}]*\}`)
+)
+
+// ParseSubtitleText extracts the plain text of a text based subtitle packet.
+// The bitmap based subtitles (i.e. DVB subtitles) have no text to extract.
+func ParseSubtitleText(codec entities.Codec, data []byte) (string, error) {
+	var text string
+	switch codec {
+	case entities.WebVTT, entities.SubRip, entities.Text:
+		text = subtitleMarkupTags.ReplaceAllString(string(data), "")
+	case entities.ASS:
+		// ReadOrder,Layer,Style,Name,MarginL,MarginR,MarginV,Effect,Text
+		fields := strings.SplitN(string(data), ",", 9)
+		if len(fields) < 9 {
+			return "", fmt.Errorf("%w invalid ass event %q", entities.ErrUnsupportedSubtitle, string(data))
+		}
+		text = assOverrideTags.ReplaceAllString(fields[8], "")
+		text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+	case entities.MovText:
+		// 3GPP TS 26.245 the text is prefixed by its 16 bits length and followed by the style boxes
+		if len(data) < 2 {
+			return "", nil
+		}
+		length := int(binary.BigEndian.Uint16(data[:2]))
+		if 2+length > len(data) {
+			return "", fmt.Errorf("%w invalid mov_text length %d", entities.ErrUnsupportedSubtitle, length)
+		}
+		text = string(data[2 : 2+length])
+	default:
+		return "", fmt.Errorf("%w %s", entities.ErrUnsupportedSubtitle, codec)
+	}
+	return strings.TrimSpace(strings.TrimRight(text, "\x00")), nil
+}
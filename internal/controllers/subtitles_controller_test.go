@@ -0,0 +1,91 @@
+package controllers_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubtitleText(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		codec    entities.Codec
+		data     []byte
+		expected string
+	}{
+		{
+			name:     "webvtt markup",
+			codec:    entities.WebVTT,
+			data:     []byte("<v Roger>Hello <b>world</b></v>\n"),
+			expected: "Hello world",
+		},
+		{
+			name:     "subrip italics",
+			codec:    entities.SubRip,
+			data:     []byte("<i>Hello</i>\nworld"),
+			expected: "Hello\nworld",
+		},
+		{
+			name:     "null terminated text",
+			codec:    entities.Text,
+			data:     []byte("Hello\x00\x00"),
+			expected: "Hello",
+		},
+		{
+			name:     "ass event with override tags and line breaks",
+			codec:    entities.ASS,
+			data:     []byte(`0,0,Default,,0,0,0,,{\an8}Hello,\Nworld{\i1}!{\i0}\hagain`),
+			expected: "Hello,\nworld! again",
+		},
+		{
+			name:     "mov_text with a style box",
+			codec:    entities.MovText,
+			data:     append([]byte{0x00, 0x05, 'H', 'e', 'l', 'l', 'o'}, 0x00, 0x00, 0x00, 0x0c, 's', 't', 'y', 'l', 0x00, 0x00, 0x00, 0x00),
+			expected: "Hello",
+		},
+		{
+			name:  "empty mov_text",
+			codec: entities.MovText,
+			data:  []byte{0x00, 0x00},
+		},
+		{
+			name:  "mov_text without the length",
+			codec: entities.MovText,
+			data:  []byte{0x00},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			text, err := controllers.ParseSubtitleText(tt.codec, tt.data)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, text)
+		})
+	}
+}
+
+func TestParseSubtitleText_Invalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		codec entities.Codec
+		data  []byte
+	}{
+		{name: "ass event without the text field", codec: entities.ASS, data: []byte("0,0,Default,,0,0,0")},
+		{name: "mov_text longer than the packet", codec: entities.MovText, data: []byte{0x00, 0x05, 'H'}},
+		{name: "bitmap subtitles", codec: entities.DVBSubtitle, data: []byte{0x20, 0x00}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := controllers.ParseSubtitleText(tt.codec, tt.data)
+			assert.ErrorIs(t, err, entities.ErrUnsupportedSubtitle)
+		})
+	}
+}
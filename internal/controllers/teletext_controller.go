@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"math/bits"
+	"strings"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+const (
+	// EN 300 472 p.7
+	teletextDataUnitNonSubtitle = 0x02
+	teletextDataUnitSubtitle    = 0x03
+	teletextDataUnitSize        = 44
+	// framing code after reversing the bits of the transmission order
+	teletextFramingCode = 0x27
+
+	teletextRows    = 24
+	teletextColumns = 40
+)
+
+// TeletextDecoder decodes the EBU teletext subtitle pages (ETS 300 706) carried in DVB (EN 300 472).
+// A page is considered ready once the next page header of its magazine arrives,
+// each ready page becomes a cue lasting until the next one.
+type TeletextDecoder struct {
+	// page is the selected page number (i.e. 0x888), zero accepts any subtitle page
+	page int
+
+	current  *teletextPage
+	lastText string
+}
+
+type teletextPage struct {
+	number    int
+	startTime int64
+	rows      [teletextRows]string
+}
+
+func NewTeletextDecoder(page int) *TeletextDecoder {
+	return &TeletextDecoder{page: page}
+}
+
+// TeletextSubtitlePage returns the subtitle page announced by the teletext descriptor,
+// libav keeps its (type<<3|magazine, page) pairs as the stream extradata. It returns zero when absent.
+func TeletextSubtitlePage(extradata []byte) int {
+	for i := 0; i+1 < len(extradata); i += 2 {
+		teletextType := extradata[i] >> 3
+		// 0x02 subtitle page, 0x05 subtitle page for hearing impaired people
+		if teletextType != 0x02 && teletextType != 0x05 {
+			continue
+		}
+		magazine := int(extradata[i] & 0x07)
+		if magazine == 0 {
+			magazine = 8
+		}
+		return magazine<<8 | int(extradata[i+1])
+	}
+	return 0
+}
+
+// Decode reads the PES data field of a teletext packet, returning the cues of the pages that became ready.
+func (d *TeletextDecoder) Decode(data []byte, ptsMS int64) []entities.Cue {
+	// data_identifier (EBU data 0x10 to 0x1f)
+	if len(data) < 1 || data[0] < 0x10 || data[0] > 0x1f {
+		return nil
+	}
+	data = data[1:]
+
+	var cues []entities.Cue
+	for len(data) >= 2 {
+		unitID, unitLength := data[0], int(data[1])
+		if 2+unitLength > len(data) {
+			break
+		}
+		unit := data[2 : 2+unitLength]
+		data = data[2+unitLength:]
+
+		if (unitID != teletextDataUnitSubtitle && unitID != teletextDataUnitNonSubtitle) || unitLength != teletextDataUnitSize {
+			continue
+		}
+		if cue, ok := d.decodePacket(unit, ptsMS); ok {
+			cues = append(cues, cue)
+		}
+	}
+	return cues
+}
+
+func (d *TeletextDecoder) decodePacket(unit []byte, ptsMS int64) (entities.Cue, bool) {
+	// the data unit is sent in transmission order (least significant bit first),
+	// the first byte (field parity and line offset) is kept as it is.
+	packet := make([]byte, teletextDataUnitSize-1)
+	for i := range packet {
+		packet[i] = bits.Reverse8(unit[i+1])
+	}
+	if packet[0] != teletextFramingCode {
+		return entities.Cue{}, false
+	}
+
+	address := unham84(packet[1]) | unham84(packet[2])<<4
+	magazine := int(address & 0x07)
+	if magazine == 0 {
+		magazine = 8
+	}
+	row := int(address >> 3)
+	payload := packet[3:]
+
+	if row == 0 {
+		return d.onPageHeader(magazine, payload, ptsMS)
+	}
+	if row < teletextRows && d.current != nil && d.current.number>>8 == magazine {
+		d.current.rows[row] = decodeTeletextRow(payload)
+	}
+	return entities.Cue{}, false
+}
+
+// ETS 300 706 p.26
+func (d *TeletextDecoder) onPageHeader(magazine int, payload []byte, ptsMS int64) (entities.Cue, bool) {
+	if d.current != nil && d.current.number>>8 != magazine {
+		// the other magazines are transmitted in parallel
+		return entities.Cue{}, false
+	}
+
+	var cue entities.Cue
+	var ready bool
+	if d.current != nil {
+		cue, ready = d.finishPage()
+	}
+
+	units, tens := unham84(payload[0]), unham84(payload[1])
+	number := magazine<<8 | int(tens)<<4 | int(units)
+	// C6 subtitle flag
+	subtitle := unham84(payload[5])&0x08 != 0
+
+	d.current = nil
+	if (d.page == 0 && subtitle) || number == d.page {
+		d.current = &teletextPage{
+			number:    number,
+			startTime: ptsMS,
+		}
+	}
+	return cue, ready
+}
+
+func (d *TeletextDecoder) finishPage() (entities.Cue, bool) {
+	var lines []string
+	for _, r := range d.current.rows {
+		if r = strings.TrimSpace(r); r != "" {
+			lines = append(lines, r)
+		}
+	}
+	text := strings.Join(lines, "\n")
+
+	// pages are retransmitted while on screen
+	if text == d.lastText {
+		return entities.Cue{}, false
+	}
+	d.lastText = text
+	return entities.Cue{
+		Type:      "subtitles",
+		StartTime: d.current.startTime,
+		Text:      text,
+	}, true
+}
+
+// decodeTeletextRow maps the characters (odd parity, 7 bits) to text,
+// the spacing attributes (i.e. colors, start/end box) are displayed as spaces.
+// The national character sets are not taken into account.
+func decodeTeletextRow(payload []byte) string {
+	row := make([]byte, 0, teletextColumns)
+	for _, b := range payload[:teletextColumns] {
+		c := b & 0x7f
+		if c < 0x20 {
+			c = ' '
+		}
+		row = append(row, c)
+	}
+	return string(row)
+}
+
+// unham84 decodes a hamming 8/4 byte, the data bits are the odd ones (D1 at bit 1 to D4 at bit 7).
+func unham84(b byte) byte {
+	return (b>>1)&0x01 | (b>>2)&0x02 | (b>>3)&0x04 | (b>>4)&0x08
+}
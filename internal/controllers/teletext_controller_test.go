@@ -0,0 +1,171 @@
+package controllers_test
+
+import (
+	"math/bits"
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+// ETS 300 706 p.111
+var hamming84 = [16]byte{0x15, 0x02, 0x49, 0x5e, 0x64, 0x73, 0x38, 0x2f, 0xd0, 0xc7, 0x8c, 0x9b, 0xa1, 0xb6, 0xfd, 0xea}
+
+// teletextPacket is a subtitle data unit (EN 300 472) carrying the row of the magazine, in transmission order.
+func teletextPacket(magazine, row int, payload []byte) []byte {
+	packet := []byte{0x27, hamming84[(row&0x01)<<3|magazine&0x07], hamming84[row>>1]}
+	packet = append(packet, payload...)
+	unit := []byte{0x03, 44, 0xe0}
+	for _, b := range packet {
+		unit = append(unit, bits.Reverse8(b))
+	}
+	return unit
+}
+
+// teletextHeader is the page header (row 0), subtitle sets the C6 flag.
+func teletextHeader(page int, subtitle bool) []byte {
+	control := byte(0)
+	if subtitle {
+		control = 0x08
+	}
+	payload := []byte{hamming84[page&0x0f], hamming84[page>>4&0x0f], hamming84[0], hamming84[0], hamming84[0], hamming84[control], hamming84[0], hamming84[0]}
+	payload = append(payload, teletextText("")[:32]...)
+	return teletextPacket(page>>8, 0, payload)
+}
+
+// teletextText is a 40 characters row with odd parity, boxed as the subtitles are.
+func teletextText(text string) []byte {
+	row := make([]byte, 40)
+	for i := range row {
+		c := byte(' ')
+		if i == 0 {
+			// start box
+			c = 0x0b
+		} else if i-1 < len(text) {
+			c = text[i-1]
+		}
+		if bits.OnesCount8(c)%2 == 0 {
+			c |= 0x80
+		}
+		row[i] = c
+	}
+	return row
+}
+
+// teletextPES is the PES data field with the EBU data identifier.
+func teletextPES(units ...[]byte) []byte {
+	data := []byte{0x10}
+	for _, unit := range units {
+		data = append(data, unit...)
+	}
+	return data
+}
+
+func TestTeletextDecoder(t *testing.T) {
+	t.Parallel()
+	d := controllers.NewTeletextDecoder(0x888)
+
+	// magazine 8 is sent as 0
+	assert.Empty(t, d.Decode(teletextPES(
+		teletextHeader(0x088, true),
+		teletextPacket(0, 20, teletextText("Hello")),
+		teletextPacket(0, 22, teletextText("World")),
+	), 1000))
+
+	// the page is ready along the next header of its magazine
+	cues := d.Decode(teletextPES(teletextHeader(0x088, true)), 3000)
+	assert.Equal(t, []entities.Cue{{Type: "subtitles", StartTime: 1000, Text: "Hello\nWorld"}}, cues)
+
+	// the page retransmitted while it's on screen isn't a new cue
+	assert.Empty(t, d.Decode(teletextPES(teletextPacket(0, 20, teletextText("Hello")), teletextPacket(0, 22, teletextText("World"))), 3000))
+	assert.Empty(t, d.Decode(teletextPES(teletextHeader(0x088, true)), 5000))
+
+	// the other magazines are sent in parallel
+	assert.Empty(t, d.Decode(teletextPES(teletextHeader(0x100, true), teletextPacket(1, 20, teletextText("Other"))), 5000))
+
+	// an empty page clears the screen
+	cues = d.Decode(teletextPES(teletextHeader(0x088, true)), 7000)
+	assert.Equal(t, []entities.Cue{{Type: "subtitles", StartTime: 5000}}, cues)
+}
+
+func TestTeletextDecoder_Pages(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		page     int
+		magazine int
+		header   []byte
+		expected bool
+	}{
+		{name: "the selected page", page: 0x801, header: teletextHeader(0x001, false), expected: true},
+		{name: "another page", page: 0x801, header: teletextHeader(0x088, true)},
+		{name: "any subtitle page", magazine: 1, header: teletextHeader(0x150, true), expected: true},
+		{name: "any page without the subtitle flag", magazine: 1, header: teletextHeader(0x150, false)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d := controllers.NewTeletextDecoder(tt.page)
+
+			d.Decode(teletextPES(tt.header, teletextPacket(tt.magazine, 22, teletextText("Hello"))), 0)
+			cues := d.Decode(teletextPES(tt.header), 1000)
+
+			if tt.expected {
+				assert.Len(t, cues, 1)
+				assert.Equal(t, "Hello", cues[0].Text)
+			} else {
+				assert.Empty(t, cues)
+			}
+		})
+	}
+}
+
+func TestTeletextDecoder_Invalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty"},
+		{name: "not an EBU data identifier", data: append([]byte{0x99}, teletextHeader(0x888, true)...)},
+		{name: "not a teletext data unit", data: teletextPES(append([]byte{0xff}, teletextHeader(0x888, true)[1:]...))},
+		{name: "unexpected data unit length", data: teletextPES([]byte{0x03, 0x02, 0xe0, 0x27})},
+		{name: "truncated data unit", data: teletextPES(teletextHeader(0x888, true)[:20])},
+		{name: "wrong framing code", data: teletextPES(append(teletextHeader(0x888, true)[:3], make([]byte, 43)...))},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d := controllers.NewTeletextDecoder(0)
+			d.Decode(teletextPES(teletextHeader(0x888, true), teletextPacket(0, 22, teletextText("Hello"))), 0)
+
+			// the invalid data is skipped, it doesn't end the page
+			assert.Empty(t, d.Decode(tt.data, 1000))
+			assert.Len(t, d.Decode(teletextPES(teletextHeader(0x888, true)), 2000), 1)
+		})
+	}
+}
+
+func TestTeletextSubtitlePage(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		extraData []byte
+		expected  int
+	}{
+		{name: "no descriptor"},
+		{name: "subtitle page of the magazine 8", extraData: []byte{0x01<<3 | 0x01, 0x00, 0x02<<3 | 0x00, 0x88}, expected: 0x888},
+		{name: "subtitle page for hearing impaired people", extraData: []byte{0x05<<3 | 0x04, 0x51}, expected: 0x451},
+		{name: "only the initial page", extraData: []byte{0x01<<3 | 0x01, 0x00}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, controllers.TeletextSubtitlePage(tt.extraData))
+		})
+	}
+}
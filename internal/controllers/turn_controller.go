@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/pion/turn/v2"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// NewTURNCredentials returns the username and password used to authenticate against the TURN servers.
+// When a shared secret is configured, it generates time-limited credentials following the TURN REST API:
+//
+//	username = <expiration unix timestamp>:<user>
+//	password = base64(hmac-sha1(secret, username))
+func NewTURNCredentials(c *entities.Config, now time.Time) (string, string, error) {
+	if c.TurnSharedSecret != "" {
+		expiration := now.Add(time.Duration(c.TurnCredentialTTLSeconds) * time.Second).Unix()
+		username := strconv.FormatInt(expiration, 10)
+		if c.TurnUsername != "" {
+			username = fmt.Sprintf("%s:%s", username, c.TurnUsername)
+		}
+		return username, turnRESTPassword(c.TurnSharedSecret, username), nil
+	}
+
+	if c.TurnUsername == "" || c.TurnPassword == "" {
+		return "", "", entities.ErrMissingTURNCredentials
+	}
+	return c.TurnUsername, c.TurnPassword, nil
+}
+
+func turnRESTPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewEmbeddedTURNServer starts an in-process TURN server when enabled, otherwise it returns nil.
+func NewEmbeddedTURNServer(c *entities.Config, l *zap.SugaredLogger, lc fx.Lifecycle) (*turn.Server, error) {
+	if !c.EnableEmbeddedTURN {
+		return nil, nil
+	}
+
+	publicIP, err := validateEmbeddedTURNConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	udpListener, err := net.ListenPacket("udp4", fmt.Sprintf("0.0.0.0:%d", c.EmbeddedTURNPort))
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       c.EmbeddedTURNRealm,
+		AuthHandler: newTURNAuthHandler(c, l),
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+					RelayAddress: publicIP,
+					Address:      "0.0.0.0",
+				},
+			},
+		},
+	})
+	if err != nil {
+		udpListener.Close()
+		return nil, err
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			l.Infow("Starting embedded TURN server",
+				"port", c.EmbeddedTURNPort,
+				"publicIP", c.EmbeddedTURNPublicIP,
+			)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return server.Close()
+		},
+	})
+
+	return server, nil
+}
+
+// validateEmbeddedTURNConfig fails the startup on a config the embedded TURN server would start with,
+// but couldn't relay nor authenticate anyone, it returns the public IP to relay from.
+func validateEmbeddedTURNConfig(c *entities.Config) (net.IP, error) {
+	if strings.TrimSpace(c.EmbeddedTURNRealm) == "" {
+		return nil, fmt.Errorf("%w: the realm must not be empty", entities.ErrInvalidEmbeddedTURNConfig)
+	}
+	if c.EmbeddedTURNPort <= 0 || c.EmbeddedTURNPort > 65535 {
+		return nil, fmt.Errorf("%w: invalid port %d", entities.ErrInvalidEmbeddedTURNConfig, c.EmbeddedTURNPort)
+	}
+
+	// the relay listens on udp4, the public IP is the one sent to the clients
+	publicIP := net.ParseIP(c.EmbeddedTURNPublicIP).To4()
+	if publicIP == nil || publicIP.IsUnspecified() {
+		return nil, fmt.Errorf("%w: invalid public ipv4 %q", entities.ErrInvalidEmbeddedTURNConfig, c.EmbeddedTURNPublicIP)
+	}
+
+	if c.TurnSharedSecret != "" {
+		if c.TurnCredentialTTLSeconds <= 0 {
+			return nil, fmt.Errorf("%w: invalid credential ttl %ds", entities.ErrInvalidEmbeddedTURNConfig, c.TurnCredentialTTLSeconds)
+		}
+	} else if c.TurnUsername == "" || c.TurnPassword == "" {
+		return nil, entities.ErrMissingTURNCredentials
+	}
+	return publicIP, nil
+}
+
+// EmbeddedTURNServerURL is the url clients use to reach the embedded TURN server.
+func EmbeddedTURNServerURL(c *entities.Config) string {
+	return fmt.Sprintf("turn:%s:%d?transport=udp", c.EmbeddedTURNPublicIP, c.EmbeddedTURNPort)
+}
+
+func newTURNAuthHandler(c *entities.Config, l *zap.SugaredLogger) turn.AuthHandler {
+	return func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+		if c.TurnSharedSecret != "" {
+			expiration, err := strconv.ParseInt(strings.SplitN(username, ":", 2)[0], 10, 64)
+			if err != nil {
+				l.Warnw("invalid time-limited turn username", "username", username, "addr", srcAddr)
+				return nil, false
+			}
+			if expiration < time.Now().Unix() {
+				l.Warnw("expired time-limited turn username", "username", username, "addr", srcAddr)
+				return nil, false
+			}
+			return turn.GenerateAuthKey(username, realm, turnRESTPassword(c.TurnSharedSecret, username)), true
+		}
+
+		if c.TurnUsername != "" && username == c.TurnUsername {
+			return turn.GenerateAuthKey(username, realm, c.TurnPassword), true
+		}
+
+		l.Warnw("unknown turn username", "username", username, "addr", srcAddr)
+		return nil, false
+	}
+}
@@ -0,0 +1,204 @@
+package controllers_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func restPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewTURNCredentials(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name             string
+		c                entities.Config
+		expectedUsername string
+		expectedPassword string
+		expectedErr      error
+	}{
+		{
+			name:             "the static credentials",
+			c:                entities.Config{TurnUsername: "donut", TurnPassword: "secret"},
+			expectedUsername: "donut",
+			expectedPassword: "secret",
+		},
+		{
+			name:        "the static credentials require a password",
+			c:           entities.Config{TurnUsername: "donut"},
+			expectedErr: entities.ErrMissingTURNCredentials,
+		},
+		{
+			name:             "the time-limited credentials expire after the ttl",
+			c:                entities.Config{TurnSharedSecret: "shared", TurnCredentialTTLSeconds: 3600},
+			expectedUsername: "1700003600",
+			expectedPassword: restPassword("shared", "1700003600"),
+		},
+		{
+			name:             "the time-limited credentials take precedence over the static ones",
+			c:                entities.Config{TurnSharedSecret: "shared", TurnCredentialTTLSeconds: 60, TurnUsername: "donut", TurnPassword: "secret"},
+			expectedUsername: "1700000060:donut",
+			expectedPassword: restPassword("shared", "1700000060:donut"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			username, password, err := controllers.NewTURNCredentials(&tt.c, now)
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Equal(t, tt.expectedUsername, username)
+			assert.Equal(t, tt.expectedPassword, password)
+		})
+	}
+}
+
+func TestNewEmbeddedTURNServer_InvalidConfig(t *testing.T) {
+	t.Parallel()
+	valid := entities.Config{
+		EnableEmbeddedTURN:   true,
+		EmbeddedTURNPort:     3478,
+		EmbeddedTURNRealm:    "donut",
+		EmbeddedTURNPublicIP: "127.0.0.1",
+		TurnUsername:         "donut",
+		TurnPassword:         "secret",
+	}
+	tests := []struct {
+		name        string
+		change      func(c *entities.Config)
+		expectedErr error
+	}{
+		{
+			name:        "an empty realm",
+			change:      func(c *entities.Config) { c.EmbeddedTURNRealm = " " },
+			expectedErr: entities.ErrInvalidEmbeddedTURNConfig,
+		},
+		{
+			name:        "an invalid port",
+			change:      func(c *entities.Config) { c.EmbeddedTURNPort = 70000 },
+			expectedErr: entities.ErrInvalidEmbeddedTURNConfig,
+		},
+		{
+			name:        "an invalid public ip",
+			change:      func(c *entities.Config) { c.EmbeddedTURNPublicIP = "donut.example.com" },
+			expectedErr: entities.ErrInvalidEmbeddedTURNConfig,
+		},
+		{
+			name:        "an unspecified public ip",
+			change:      func(c *entities.Config) { c.EmbeddedTURNPublicIP = "0.0.0.0" },
+			expectedErr: entities.ErrInvalidEmbeddedTURNConfig,
+		},
+		{
+			name:        "an ipv6 public ip",
+			change:      func(c *entities.Config) { c.EmbeddedTURNPublicIP = "::1" },
+			expectedErr: entities.ErrInvalidEmbeddedTURNConfig,
+		},
+		{
+			name: "a shared secret without ttl",
+			change: func(c *entities.Config) {
+				c.TurnSharedSecret = "shared"
+				c.TurnCredentialTTLSeconds = 0
+			},
+			expectedErr: entities.ErrInvalidEmbeddedTURNConfig,
+		},
+		{
+			name:        "missing credentials",
+			change:      func(c *entities.Config) { c.TurnPassword = "" },
+			expectedErr: entities.ErrMissingTURNCredentials,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := valid
+			tt.change(&c)
+			server, err := controllers.NewEmbeddedTURNServer(&c, zap.NewNop().Sugar(), fxtest.NewLifecycle(t))
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Nil(t, server)
+		})
+	}
+}
+
+func TestNewEmbeddedTURNServer_Disabled(t *testing.T) {
+	t.Parallel()
+	server, err := controllers.NewEmbeddedTURNServer(&entities.Config{}, zap.NewNop().Sugar(), fxtest.NewLifecycle(t))
+	assert.Nil(t, err)
+	assert.Nil(t, server)
+}
+
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func allocate(t *testing.T, c *entities.Config, username, password string) error {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", c.EmbeddedTURNPort)
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Username:       username,
+		Password:       password,
+		Realm:          c.EmbeddedTURNRealm,
+		Conn:           conn,
+	})
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Nil(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	if err != nil {
+		return err
+	}
+	return relayConn.Close()
+}
+
+func TestNewEmbeddedTURNServer_Auth(t *testing.T) {
+	c := &entities.Config{
+		EnableEmbeddedTURN:       true,
+		EmbeddedTURNPort:         freeUDPPort(t),
+		EmbeddedTURNRealm:        "donut",
+		EmbeddedTURNPublicIP:     "127.0.0.1",
+		TurnSharedSecret:         "shared",
+		TurnCredentialTTLSeconds: 60,
+	}
+	lc := fxtest.NewLifecycle(t)
+	server, err := controllers.NewEmbeddedTURNServer(c, zap.NewNop().Sugar(), lc)
+	assert.Nil(t, err)
+	assert.NotNil(t, server)
+	lc.RequireStart()
+	defer lc.RequireStop()
+
+	username, password, err := controllers.NewTURNCredentials(c, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, allocate(t, c, username, password), "the generated credentials are accepted")
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	assert.NotNil(t, allocate(t, c, expired, restPassword("shared", expired)), "the expired credentials are rejected")
+
+	assert.NotNil(t, allocate(t, c, username, restPassword("other", username)), "the password must be signed with the shared secret")
+	assert.NotNil(t, allocate(t, c, "donut", password), "the username must hold the expiration")
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+// ParseVideoUnits parses a video packet into the codec's units (NALs or OBUs),
+// H264 and H265 are expected in Annex-B while AV1 in the low overhead bitstream format.
+func ParseVideoUnits(codec entities.Codec, data []byte) ([]entities.VideoUnit, error) {
+	var units []entities.VideoUnit
+	switch codec {
+	case entities.H264:
+		nalus, err := ParseNALUs(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, nal := range nalus.Units {
+			units = append(units, nal)
+		}
+	case entities.H265:
+		nalus, err := ParseH265NALUs(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, nal := range nalus {
+			units = append(units, nal)
+		}
+	case entities.AV1:
+		obus, err := ParseOBUs(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, obu := range obus {
+			units = append(units, obu)
+		}
+	default:
+		return nil, fmt.Errorf("%w %s", entities.ErrUnsupportedVideoBitstream, codec)
+	}
+	return units, nil
+}
+
+// IsKeyframe tells whether any of the units starts a picture decodable on its own.
+func IsKeyframe(units []entities.VideoUnit) bool {
+	for _, unit := range units {
+		if unit.IsKeyframe() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"bytes"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+var annexBStartCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// VideoBypass holds the bypassed video until its first keyframe, the packets before it can't be decoded.
+// The keyframes are found parsing the bitstream (H264 IDR, H265 IRAP and AV1 key frames), the packet
+// flag is only used for the other codecs or when it can't be parsed. For H264 it also repeats the last
+// SPS and PPS before each keyframe missing them, since some sources send them only once (i.e. at the
+// extradata for RTMP/FLV) and the late viewers would never get them.
+type VideoBypass struct {
+	codec   entities.Codec
+	started bool
+	dropped int
+
+	// the last parameter sets seen, without the start code
+	sps []byte
+	pps []byte
+	// spsChanged is set when an SPS replaces a different one, i.e. on a resolution change
+	spsChanged bool
+}
+
+func NewVideoBypass(codec entities.Codec, extraData []byte) *VideoBypass {
+	b := &VideoBypass{codec: codec}
+	if codec != entities.H264 {
+		return b
+	}
+	// a malformed extradata only means the parameter sets will come in-band
+	nalus, _ := SplitH264ExtraData(extraData)
+	for _, nalu := range nalus {
+		b.cacheParameterSet(nalu)
+	}
+	return b
+}
+
+// Process returns the data to send, whether it's a keyframe and false while waiting for the first keyframe.
+// The keyframe is the packet flag, only used when the bitstream can't be parsed.
+func (b *VideoBypass) Process(data []byte, keyframe bool) ([]byte, bool, bool) {
+	if units, err := ParseVideoUnits(b.codec, data); err == nil {
+		keyframe = IsKeyframe(units)
+	}
+
+	var nalus [][]byte
+	var hasSPS, hasPPS bool
+	if b.codec == entities.H264 {
+		nalus = SplitAnnexB(data)
+		for _, nalu := range nalus {
+			switch entities.NALUnitType(nalu[0] & 0x1f) {
+			case entities.SequenceParameterSet:
+				hasSPS = true
+			case entities.PictureParameterSet:
+				hasPPS = true
+			}
+			b.cacheParameterSet(nalu)
+		}
+	}
+
+	if !b.started {
+		if !keyframe {
+			b.dropped++
+			return nil, false, false
+		}
+		b.started = true
+	}
+
+	if !keyframe || (hasSPS || b.sps == nil) && (hasPPS || b.pps == nil) {
+		return data, keyframe, true
+	}
+
+	// the parameter sets go after the access unit delimiter, which must be the first NAL
+	// Rec. ITU-T H.264 (08/2021) p.25
+	out := make([]byte, 0, len(data)+len(b.sps)+len(b.pps)+(len(nalus)+2)*len(annexBStartCode))
+	inserted := false
+	for _, nalu := range nalus {
+		if !inserted && entities.NALUnitType(nalu[0]&0x1f) != entities.AccessUnitDelimiter {
+			if !hasSPS && b.sps != nil {
+				out = append(append(out, annexBStartCode...), b.sps...)
+			}
+			if !hasPPS && b.pps != nil {
+				out = append(append(out, annexBStartCode...), b.pps...)
+			}
+			inserted = true
+		}
+		out = append(append(out, annexBStartCode...), nalu...)
+	}
+	return out, true, true
+}
+
+// Reset waits for the next keyframe again (i.e. once resumed), the parameter sets are kept.
+func (b *VideoBypass) Reset() {
+	b.started = false
+	b.dropped = 0
+}
+
+// Started tells whether the first keyframe was found.
+func (b *VideoBypass) Started() bool {
+	return b.started
+}
+
+// Dropped returns how many packets were dropped waiting for the keyframe.
+func (b *VideoBypass) Dropped() int {
+	return b.dropped
+}
+
+// SPSChanged returns the new SPS, only once, after it replaced a different one. The bypassed video isn't
+// decoded, so the SPS is the only way to tell its parameters changed. A malformed SPS is ignored.
+func (b *VideoBypass) SPSChanged() (*entities.SPS, bool) {
+	if !b.spsChanged {
+		return nil, false
+	}
+	b.spsChanged = false
+	nal, err := ParseNAL(b.sps)
+	if err != nil || nal.SPS == nil {
+		return nil, false
+	}
+	return nal.SPS, true
+}
+
+func (b *VideoBypass) cacheParameterSet(nalu []byte) {
+	if len(nalu) == 0 {
+		return
+	}
+	switch entities.NALUnitType(nalu[0] & 0x1f) {
+	case entities.SequenceParameterSet:
+		b.spsChanged = b.spsChanged || b.sps != nil && !bytes.Equal(b.sps, nalu)
+		b.sps = append(b.sps[:0], nalu...)
+	case entities.PictureParameterSet:
+		b.pps = append(b.pps[:0], nalu...)
+	}
+}
@@ -0,0 +1,222 @@
+package controllers_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	startCode4 = []byte{0x00, 0x00, 0x00, 0x01}
+	audNAL     = []byte{0x09, 0xf0}
+	nonIDRNAL  = []byte{0x41, 0x9a, 0x02, 0x04}
+)
+
+type bypassPacket struct {
+	data []byte
+	flag bool
+}
+
+func TestVideoBypass_DropsUntilKeyframe(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		codec    entities.Codec
+		packets  []bypassPacket
+		expected []bool
+	}{
+		{
+			name:  "h264 waits for an IDR even when the packet is flagged",
+			codec: entities.H264,
+			packets: []bypassPacket{
+				{data: annexB(startCode4, nonIDRNAL), flag: true},
+				{data: annexB(startCode4, nonIDRNAL)},
+				{data: annexB(startCode4, idrNAL)},
+				{data: annexB(startCode4, nonIDRNAL)},
+			},
+			expected: []bool{false, false, true, true},
+		},
+		{
+			name:  "h264 finds an IDR the packet isn't flagged with",
+			codec: entities.H264,
+			packets: []bypassPacket{
+				{data: annexB(startCode4, seiNAL, idrNAL)},
+			},
+			expected: []bool{true},
+		},
+		{
+			name:  "h264 uses the flag when it can't be parsed",
+			codec: entities.H264,
+			packets: []bypassPacket{
+				{data: annexB(startCode4, []byte{0x80 | 0x41, 0x9a})},
+				{data: annexB(startCode4, []byte{0x80 | 0x41, 0x9a}), flag: true},
+			},
+			expected: []bool{false, true},
+		},
+		{
+			name:  "h265 waits for an IRAP",
+			codec: entities.H265,
+			packets: []bypassPacket{
+				{data: annexB(startCode4, h265TrailRNAL), flag: true},
+				{data: annexB(startCode4, h265VPSNAL, h265SPSNAL, h265PPSNAL, h265IDRNAL)},
+			},
+			expected: []bool{false, true},
+		},
+		{
+			name:  "av1 waits for a key frame",
+			codec: entities.AV1,
+			packets: []bypassPacket{
+				{data: temporalUnit(av1TemporalDelimiterOBU, av1InterFrameOBU), flag: true},
+				{data: temporalUnit(av1TemporalDelimiterOBU, av1SequenceHeaderOBU, av1KeyFrameOBU)},
+			},
+			expected: []bool{false, true},
+		},
+		{
+			name:  "vp8 uses the flag",
+			codec: entities.VP8,
+			packets: []bypassPacket{
+				{data: []byte{0x01}},
+				{data: []byte{0x00}, flag: true},
+			},
+			expected: []bool{false, true},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			b := controllers.NewVideoBypass(tt.codec, nil)
+
+			dropped := 0
+			for i, pkt := range tt.packets {
+				wasStarted := b.Started()
+				_, keyframe, ok := b.Process(pkt.data, pkt.flag)
+				assert.Equal(t, tt.expected[i], ok, "packet %d", i)
+				if !ok {
+					dropped++
+				} else if !wasStarted {
+					assert.True(t, keyframe, "the first packet sent is a keyframe")
+				}
+			}
+			assert.Equal(t, dropped, b.Dropped())
+		})
+	}
+}
+
+func TestVideoBypass_ParameterSets(t *testing.T) {
+	t.Parallel()
+	avcC := append(append(append([]byte{0x01, 0x64, 0x00, 0x1e, 0xff, 0xe1, 0x00, byte(len(spsNAL))}, spsNAL...),
+		0x01, 0x00, byte(len(ppsNAL))), ppsNAL...)
+
+	tests := []struct {
+		name      string
+		extraData []byte
+		packets   [][]byte
+		expected  []byte
+	}{
+		{
+			name:      "prepended from the avcC extradata",
+			extraData: avcC,
+			packets:   [][]byte{annexB(startCode4, idrNAL)},
+			expected:  annexB(startCode4, spsNAL, ppsNAL, idrNAL),
+		},
+		{
+			name:      "prepended from the annex-b extradata",
+			extraData: annexB(startCode4, spsNAL, ppsNAL),
+			packets:   [][]byte{annexB([]byte{0x00, 0x00, 0x01}, idrNAL)},
+			expected:  annexB(startCode4, spsNAL, ppsNAL, idrNAL),
+		},
+		{
+			name: "prepended from the previous keyframe",
+			packets: [][]byte{
+				annexB(startCode4, spsNAL, ppsNAL, idrNAL),
+				annexB(startCode4, nonIDRNAL),
+				annexB(startCode4, seiNAL, idrNAL),
+			},
+			expected: annexB(startCode4, spsNAL, ppsNAL, seiNAL, idrNAL),
+		},
+		{
+			name:      "inserted after the access unit delimiter",
+			extraData: avcC,
+			packets:   [][]byte{annexB(startCode4, audNAL, seiNAL, idrNAL)},
+			expected:  annexB(startCode4, audNAL, spsNAL, ppsNAL, seiNAL, idrNAL),
+		},
+		{
+			name:      "kept when the keyframe has them",
+			extraData: avcC,
+			packets:   [][]byte{annexB([]byte{0x00, 0x00, 0x01}, audNAL, spsNAL, ppsNAL, idrNAL)},
+			expected:  annexB([]byte{0x00, 0x00, 0x01}, audNAL, spsNAL, ppsNAL, idrNAL),
+		},
+		{
+			name:      "not added to the other frames",
+			extraData: avcC,
+			packets:   [][]byte{annexB(startCode4, idrNAL), annexB(startCode4, nonIDRNAL)},
+			expected:  annexB(startCode4, nonIDRNAL),
+		},
+		{
+			name:     "not added without any",
+			packets:  [][]byte{annexB(startCode4, idrNAL)},
+			expected: annexB(startCode4, idrNAL),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			b := controllers.NewVideoBypass(entities.H264, tt.extraData)
+
+			var data []byte
+			for _, pkt := range tt.packets {
+				var ok bool
+				data, _, ok = b.Process(pkt, false)
+				assert.True(t, ok)
+			}
+			assert.Equal(t, tt.expected, data)
+		})
+	}
+}
+
+func TestVideoBypass_Reset(t *testing.T) {
+	t.Parallel()
+	b := controllers.NewVideoBypass(entities.H264, nil)
+	_, _, ok := b.Process(annexB(startCode4, spsNAL, ppsNAL, idrNAL), false)
+	assert.True(t, ok)
+
+	// i.e. resumed, the frames are dropped until the next keyframe, which gets the parameter sets seen
+	b.Reset()
+	assert.False(t, b.Started())
+	_, _, ok = b.Process(annexB(startCode4, nonIDRNAL), false)
+	assert.False(t, ok)
+	data, keyframe, ok := b.Process(annexB(startCode4, idrNAL), false)
+	assert.True(t, ok)
+	assert.True(t, keyframe)
+	assert.Equal(t, annexB(startCode4, spsNAL, ppsNAL, idrNAL), data)
+	assert.Equal(t, 1, b.Dropped())
+}
+
+func TestVideoBypass_SPSChanged(t *testing.T) {
+	t.Parallel()
+	// libx264 1280x720 30fps
+	sps720pNAL := []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60,
+	}
+	b := controllers.NewVideoBypass(entities.H264, annexB(startCode4, spsNAL, ppsNAL))
+
+	_, _, ok := b.Process(annexB(startCode4, spsNAL, ppsNAL, idrNAL), false)
+	assert.True(t, ok)
+	_, changed := b.SPSChanged()
+	assert.False(t, changed, "the SPS repeated from the extradata is not a change")
+
+	_, _, ok = b.Process(annexB(startCode4, sps720pNAL, ppsNAL, idrNAL), false)
+	assert.True(t, ok)
+	sps, changed := b.SPSChanged()
+	assert.True(t, changed)
+	assert.Equal(t, 1280, sps.Width())
+	assert.Equal(t, 720, sps.Height())
+
+	_, changed = b.SPSChanged()
+	assert.False(t, changed, "the change is only returned once")
+}
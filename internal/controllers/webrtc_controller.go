@@ -3,10 +3,14 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/flavioribeiro/donut/internal/entities"
 	"github.com/flavioribeiro/donut/internal/mapper"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
 	"go.uber.org/zap"
@@ -34,25 +38,33 @@ func NewWebRTCController(
 }
 
 func (c *WebRTCController) Setup(cancel context.CancelFunc, donutRecipe *entities.DonutRecipe, params entities.RequestParams) (*entities.WebRTCSetupResponse, error) {
-	response := &entities.WebRTCSetupResponse{}
+	response := &entities.WebRTCSetupResponse{
+		Reports: entities.NewReceptionReports(),
+	}
 	peer, err := c.CreatePeerConnection(cancel)
 	if err != nil {
 		return nil, err
 	}
 	response.Connection = peer
 
-	var videoTrack *webrtc.TrackLocalStaticSample
-	videoTrack, err = c.CreateTrack(peer, donutRecipe.Video.Codec, string(entities.VideoType), params.StreamID)
+	var videoTrack webrtc.TrackLocal
+	videoTrack, err = c.CreateTrack(peer, donutRecipe.Video, string(entities.VideoType), params.StreamID)
 	if err != nil {
 		return nil, err
 	}
+	if err = c.ReadReceptionReports(peer, videoTrack, entities.VideoType, response.Reports); err != nil {
+		return nil, err
+	}
 	response.Video = videoTrack
 
-	var audioTrack *webrtc.TrackLocalStaticSample
-	audioTrack, err = c.CreateTrack(peer, donutRecipe.Audio.Codec, string(entities.AudioType), params.StreamID)
+	var audioTrack webrtc.TrackLocal
+	audioTrack, err = c.CreateTrack(peer, donutRecipe.Audio, string(entities.AudioType), params.StreamID)
 	if err != nil {
 		return nil, err
 	}
+	if err = c.ReadReceptionReports(peer, audioTrack, entities.AudioType, response.Reports); err != nil {
+		return nil, err
+	}
 	response.Audio = audioTrack
 
 	metadataSender, err := c.CreateDataChannel(peer, entities.MetadataChannelID)
@@ -77,13 +89,12 @@ func (c *WebRTCController) Setup(cancel context.CancelFunc, donutRecipe *entitie
 func (c *WebRTCController) CreatePeerConnection(cancel context.CancelFunc) (*webrtc.PeerConnection, error) {
 	c.l.Infow("trying to set up web rtc conn")
 
-	peerConnectionConfiguration := webrtc.Configuration{}
-	if !c.c.EnableICEMux {
-		peerConnectionConfiguration.ICEServers = []webrtc.ICEServer{
-			{
-				URLs: c.c.StunServers,
-			},
-		}
+	iceServers, err := c.ICEServers()
+	if err != nil {
+		return nil, err
+	}
+	peerConnectionConfiguration := webrtc.Configuration{
+		ICEServers: iceServers,
 	}
 
 	peerConnection, err := c.api.NewPeerConnection(peerConnectionConfiguration)
@@ -115,19 +126,131 @@ func (c *WebRTCController) CreatePeerConnection(cancel context.CancelFunc) (*web
 	return peerConnection, nil
 }
 
-func (c *WebRTCController) CreateTrack(peer *webrtc.PeerConnection, codec entities.Codec, id string, streamId string) (*webrtc.TrackLocalStaticSample, error) {
-	codecCapability := c.m.FromTrackToRTPCodecCapability(codec)
-	webRTCtrack, err := webrtc.NewTrackLocalStaticSample(codecCapability, id, streamId)
+// ICEServers lists the STUN and TURN servers used by the peer connection.
+func (c *WebRTCController) ICEServers() ([]webrtc.ICEServer, error) {
+	var iceServers []webrtc.ICEServer
+	if !c.c.EnableICEMux {
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs: c.c.StunServers,
+		})
+	}
+
+	turnURLs := append([]string{}, c.c.TurnServers...)
+	if c.c.EnableEmbeddedTURN {
+		turnURLs = append(turnURLs, EmbeddedTURNServerURL(c.c))
+	}
+	if len(turnURLs) == 0 {
+		return iceServers, nil
+	}
+
+	username, password, err := NewTURNCredentials(c.c, time.Now())
 	if err != nil {
 		return nil, err
 	}
+	iceServers = append(iceServers, webrtc.ICEServer{
+		URLs:           turnURLs,
+		Username:       username,
+		Credential:     password,
+		CredentialType: webrtc.ICECredentialTypePassword,
+	})
+
+	return iceServers, nil
+}
+
+// CreateTrack creates either a sample track or, when the RTP timestamps come from the PTS, a track packetizing the frames itself.
+func (c *WebRTCController) CreateTrack(peer *webrtc.PeerConnection, task entities.DonutMediaTask, id string, streamId string) (webrtc.TrackLocal, error) {
+	codecCapability := c.m.FromTrackToRTPCodecCapability(task)
+	var webRTCtrack webrtc.TrackLocal
+	if c.c.RTPTimestampsFromPTS {
+		payloader, err := c.m.FromTrackToRTPPayloader(task)
+		if err != nil {
+			return nil, err
+		}
+		if webRTCtrack, err = NewRTPTimestampTrack(codecCapability, payloader, id, streamId); err != nil {
+			return nil, err
+		}
+	} else {
+		sampleTrack, err := webrtc.NewTrackLocalStaticSample(codecCapability, id, streamId)
+		if err != nil {
+			return nil, err
+		}
+		webRTCtrack = sampleTrack
+	}
 
-	if _, err := peer.AddTrack(webRTCtrack); err != nil {
+	sender, err := peer.AddTrack(webRTCtrack)
+	if err != nil {
 		return nil, err
 	}
+	if task.Fmtp != "" {
+		// pion answers with the fmtp lines offered by the client, the negotiated one (i.e. with the server level)
+		// is set as the transceiver codec, taking the payload type of the offered payload it matches
+		if err := c.setTransceiverCodec(peer, sender, codecCapability); err != nil {
+			return nil, err
+		}
+	}
 	return webRTCtrack, nil
 }
 
+func (c *WebRTCController) setTransceiverCodec(peer *webrtc.PeerConnection, sender *webrtc.RTPSender, codec webrtc.RTPCodecCapability) error {
+	for _, t := range peer.GetTransceivers() {
+		if t.Sender() == sender {
+			return t.SetCodecPreferences([]webrtc.RTPCodecParameters{{RTPCodecCapability: codec}})
+		}
+	}
+	return fmt.Errorf("there is no transceiver for the track %s", sender.Track().ID())
+}
+
+// ReadReceptionReports reads the RTCP packets sent by the client for the given track,
+// keeping its last reception report. Reading RTCP is also required by the interceptors (i.e. NACK).
+func (c *WebRTCController) ReadReceptionReports(peer *webrtc.PeerConnection, track webrtc.TrackLocal, mediaType entities.MediaType, reports *entities.ReceptionReports) error {
+	var sender *webrtc.RTPSender
+	for _, s := range peer.GetSenders() {
+		if s.Track() == track {
+			sender = s
+		}
+	}
+	if sender == nil {
+		return fmt.Errorf("there is no sender for the track %s", track.ID())
+	}
+
+	go func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, pkt := range packets {
+				rr, ok := pkt.(*rtcp.ReceiverReport)
+				if !ok {
+					continue
+				}
+				for _, r := range rr.Reports {
+					reports.Update(c.m.FromRTCPReceptionReportToEntity(r, mediaType, time.Now()))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// PeerStats collects the transport stats of the peer connection.
+func (c *WebRTCController) PeerStats(setup *entities.WebRTCSetupResponse) entities.PeerStats {
+	st := entities.PeerStats{
+		Reports: setup.Reports.Snapshot(),
+	}
+	for _, s := range setup.Connection.GetStats() {
+		switch stats := s.(type) {
+		case webrtc.ICECandidatePairStats:
+			if stats.Nominated {
+				st.RoundTripTimeMS = stats.CurrentRoundTripTime * 1000
+			}
+		case webrtc.TransportStats:
+			st.BytesSent += stats.BytesSent
+		}
+	}
+	return st
+}
+
 func (c *WebRTCController) CreateDataChannel(peer *webrtc.PeerConnection, channelID string) (*webrtc.DataChannel, error) {
 	metadataSender, err := peer.CreateDataChannel(channelID, nil)
 	if err != nil {
@@ -160,15 +283,30 @@ func (c *WebRTCController) GatheringWebRTC(peer *webrtc.PeerConnection) (*webrtc
 	return peer.LocalDescription(), nil
 }
 
-func (c *WebRTCController) SendMediaSample(mediaTrack *webrtc.TrackLocalStaticSample, data []byte, mediaCtx entities.MediaFrameContext) error {
-	if err := mediaTrack.WriteSample(media.Sample{Data: data, Duration: mediaCtx.Duration}); err != nil {
-		return err
+func (c *WebRTCController) SendMediaSample(mediaTrack webrtc.TrackLocal, data []byte, mediaCtx entities.MediaFrameContext) error {
+	switch track := mediaTrack.(type) {
+	case *RTPTimestampTrack:
+		return track.WriteFrame(data, mediaCtx)
+	case *webrtc.TrackLocalStaticSample:
+		return track.WriteSample(media.Sample{Data: data, Duration: mediaCtx.Duration})
 	}
-	return nil
+	return fmt.Errorf("unsupported track %T", mediaTrack)
 }
 
 func (c *WebRTCController) SendMetadata(metaTrack *webrtc.DataChannel, st *entities.Stream) error {
-	msg := c.m.FromStreamToEntityMessage(*st)
+	return c.SendMessage(metaTrack, c.m.FromStreamToEntityMessage(*st))
+}
+
+// SendStats sends the stats message once the data channel is open, the periodic stats sent before
+// the client is connected are dropped since the latest ones are also served over HTTP.
+func (c *WebRTCController) SendStats(metaTrack *webrtc.DataChannel, stats entities.SessionStats) error {
+	if metaTrack.ReadyState() != webrtc.DataChannelStateOpen {
+		return nil
+	}
+	return c.SendMessage(metaTrack, c.m.FromStatsToEntityMessage(stats))
+}
+
+func (c *WebRTCController) SendMessage(metaTrack *webrtc.DataChannel, msg entities.Message) error {
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
 		return err
@@ -180,6 +318,36 @@ func (c *WebRTCController) SendMetadata(metaTrack *webrtc.DataChannel, st *entit
 	return nil
 }
 
+// HandleCommands dispatches the commands sent by the client, either through the server data channel
+// or through the ones created by the client, replying on the same channel.
+func (c *WebRTCController) HandleCommands(setup *entities.WebRTCSetupResponse, registry *CommandRegistry) {
+	c.handleDataChannelCommands(setup.Data, registry)
+	setup.Connection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		c.handleDataChannelCommands(dc, registry)
+	})
+}
+
+func (c *WebRTCController) handleDataChannelCommands(dc *webrtc.DataChannel, registry *CommandRegistry) {
+	dc.OnMessage(func(dcMsg webrtc.DataChannelMessage) {
+		cmd := entities.Command{}
+		if err := json.Unmarshal(dcMsg.Data, &cmd); err != nil {
+			c.l.Warnw("ignoring invalid data channel message",
+				"channel", dc.Label(),
+				"error", err,
+			)
+			return
+		}
+
+		response := registry.Dispatch(cmd)
+		if err := c.SendMessage(dc, c.m.FromCommandResponseToEntityMessage(response)); err != nil {
+			c.l.Errorw("error while replying command",
+				"command", cmd.Type,
+				"error", err,
+			)
+		}
+	})
+}
+
 func NewWebRTCSettingsEngine(c *entities.Config, tcpListener net.Listener, udpListener net.PacketConn) webrtc.SettingEngine {
 	settingEngine := webrtc.SettingEngine{}
 
@@ -195,14 +363,55 @@ func NewWebRTCMediaEngine() (*webrtc.MediaEngine, error) {
 	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
 		return nil, err
 	}
+
+	// the default codecs don't include AV1 nor the H264 main and constrained high profiles,
+	// pion matches the H264 payloads by their profile and packetization mode
+	videoRTCPFeedback := []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}}
+	for _, codec := range []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f", RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        39,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/rtx", ClockRate: 90000, SDPFmtpLine: "apt=39"},
+			PayloadType:        40,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640c1f", RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        41,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/rtx", ClockRate: 90000, SDPFmtpLine: "apt=41"},
+			PayloadType:        42,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1, ClockRate: 90000, SDPFmtpLine: "level-idx=5;profile=0;tier=0", RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        45,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: "video/rtx", ClockRate: 90000, SDPFmtpLine: "apt=45"},
+			PayloadType:        46,
+		},
+	} {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
 	return mediaEngine, nil
 }
 
-func NewWebRTCAPI(mediaEngine *webrtc.MediaEngine, settingEngine webrtc.SettingEngine) *webrtc.API {
+func NewWebRTCAPI(mediaEngine *webrtc.MediaEngine, settingEngine webrtc.SettingEngine) (*webrtc.API, error) {
+	// interceptors generate the sender reports and handle NACKs
+	interceptorRegistry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
+		return nil, err
+	}
+
 	return webrtc.NewAPI(
 		webrtc.WithSettingEngine(settingEngine),
 		webrtc.WithMediaEngine(mediaEngine),
-	)
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+	), nil
 }
 
 func NewTCPICEServer(c *entities.Config) (net.Listener, error) {
@@ -0,0 +1,153 @@
+package controllers_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// clientOffer is the offer of a pion client receiving video, it has the pion default H264 payloads.
+func clientOffer(t *testing.T) webrtc.SessionDescription {
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	assert.Nil(t, err)
+	t.Cleanup(func() { client.Close() })
+	_, err = client.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+	assert.Nil(t, err)
+	offer, err := client.CreateOffer(nil)
+	assert.Nil(t, err)
+	return offer
+}
+
+func TestWebRTCController_CreateTrackAnswer(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		task     entities.DonutMediaTask
+		expected string
+	}{
+		{
+			name: "the negotiated H264 fmtp line is answered",
+			task: entities.DonutMediaTask{
+				Action: entities.DonutBypass,
+				Codec:  entities.H264,
+				Fmtp:   "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e033",
+			},
+			expected: "a=fmtp:125 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e033\r\n",
+		},
+		{
+			name:     "the offered fmtp line is answered otherwise",
+			task:     entities.DonutMediaTask{Action: entities.DonutTranscode, Codec: entities.VP9},
+			expected: "a=fmtp:98 profile-id=0\r\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			mediaEngine, err := controllers.NewWebRTCMediaEngine()
+			assert.Nil(t, err)
+			api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+			c := controllers.NewWebRTCController(&entities.Config{}, zap.NewNop().Sugar(), api, mapper.NewMapper(zap.NewNop().Sugar()))
+
+			peer, err := api.NewPeerConnection(webrtc.Configuration{})
+			assert.Nil(t, err)
+			defer peer.Close()
+			_, err = c.CreateTrack(peer, tt.task, string(entities.VideoType), "stream")
+			assert.Nil(t, err)
+			assert.Nil(t, c.SetRemoteDescription(peer, clientOffer(t)))
+
+			answer, err := peer.CreateAnswer(nil)
+			assert.Nil(t, err)
+			assert.Contains(t, answer.SDP, tt.expected)
+		})
+	}
+}
+
+func TestWebRTCController_SendStatsBeforeTheChannelIsOpen(t *testing.T) {
+	t.Parallel()
+	mediaEngine, err := controllers.NewWebRTCMediaEngine()
+	assert.Nil(t, err)
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	c := controllers.NewWebRTCController(&entities.Config{}, zap.NewNop().Sugar(), api, mapper.NewMapper(zap.NewNop().Sugar()))
+
+	peer, err := api.NewPeerConnection(webrtc.Configuration{})
+	assert.Nil(t, err)
+	defer peer.Close()
+	dc, err := c.CreateDataChannel(peer, entities.MetadataChannelID)
+	assert.Nil(t, err)
+
+	assert.Equal(t, webrtc.DataChannelStateConnecting, dc.ReadyState())
+	assert.Nil(t, c.SendStats(dc, entities.SessionStats{}))
+	assert.NotNil(t, c.SendMessage(dc, entities.Message{}))
+}
+
+// connect negotiates the server peer with a pion client, the client data channels are handed to onDataChannel.
+func connect(t *testing.T, server *webrtc.PeerConnection, onDataChannel func(dc *webrtc.DataChannel)) {
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	assert.Nil(t, err)
+	t.Cleanup(func() { client.Close() })
+	client.OnDataChannel(onDataChannel)
+
+	offer, err := server.CreateOffer(nil)
+	assert.Nil(t, err)
+	gathered := webrtc.GatheringCompletePromise(server)
+	assert.Nil(t, server.SetLocalDescription(offer))
+	<-gathered
+	assert.Nil(t, client.SetRemoteDescription(*server.LocalDescription()))
+
+	answer, err := client.CreateAnswer(nil)
+	assert.Nil(t, err)
+	gathered = webrtc.GatheringCompletePromise(client)
+	assert.Nil(t, client.SetLocalDescription(answer))
+	<-gathered
+	assert.Nil(t, server.SetRemoteDescription(*client.LocalDescription()))
+}
+
+func TestWebRTCController_HandleCommands(t *testing.T) {
+	t.Parallel()
+	mediaEngine, err := controllers.NewWebRTCMediaEngine()
+	assert.Nil(t, err)
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	c := controllers.NewWebRTCController(&entities.Config{}, zap.NewNop().Sugar(), api, mapper.NewMapper(zap.NewNop().Sugar()))
+
+	peer, err := api.NewPeerConnection(webrtc.Configuration{})
+	assert.Nil(t, err)
+	defer peer.Close()
+	dc, err := c.CreateDataChannel(peer, entities.MetadataChannelID)
+	assert.Nil(t, err)
+	c.HandleCommands(&entities.WebRTCSetupResponse{Connection: peer, Data: dc}, controllers.NewCommandRegistry())
+
+	responses := make(chan entities.Message, 2)
+	connect(t, peer, func(clientDC *webrtc.DataChannel) {
+		clientDC.OnMessage(func(msg webrtc.DataChannelMessage) {
+			m := entities.Message{}
+			assert.Nil(t, json.Unmarshal(msg.Data, &m))
+			responses <- m
+		})
+		clientDC.OnOpen(func() {
+			assert.Nil(t, clientDC.SendText("not a command"))
+			cmd, err := json.Marshal(entities.Command{Version: entities.DataChannelProtocolVersion, ID: "1", Type: entities.CommandPing})
+			assert.Nil(t, err)
+			assert.Nil(t, clientDC.Send(cmd))
+		})
+	})
+
+	select {
+	case m := <-responses:
+		assert.Equal(t, entities.MessageTypeCommandResponse, m.Type)
+		assert.Equal(t, string(entities.CommandPing), m.Message)
+		payload, ok := m.Payload.(map[string]interface{})
+		assert.True(t, ok)
+		assert.Equal(t, "1", payload["ID"])
+	case <-time.After(10 * time.Second):
+		t.Fatal("the command wasn't replied")
+	}
+	assert.Len(t, responses, 0, "the invalid messages are ignored")
+}
@@ -0,0 +1,233 @@
+package entities
+
+import "fmt"
+
+// OBU is an AV1 open bitstream unit.
+// ref https://aomediacodec.github.io/av1-spec/#obu-syntax
+type OBU struct {
+	Type       OBUType
+	TemporalID byte
+	SpatialID  byte
+	// Payload is the obu data without the header and the size field
+	Payload []byte
+
+	// SequenceHeader is only set for sequence header OBUs
+	SequenceHeader *AV1SequenceHeader
+	// Metadata is only set for metadata OBUs
+	Metadata *AV1Metadata
+	// FrameType is only meaningful for frame and frame header OBUs
+	FrameType         AV1FrameType
+	ShowExistingFrame bool
+}
+
+type OBUType byte
+
+const (
+	// ref https://aomediacodec.github.io/av1-spec/#obu-header-semantics
+	OBUSequenceHeader       = OBUType(1)
+	OBUTemporalDelimiter    = OBUType(2)
+	OBUFrameHeader          = OBUType(3)
+	OBUTileGroup            = OBUType(4)
+	OBUMetadata             = OBUType(5)
+	OBUFrame                = OBUType(6)
+	OBURedundantFrameHeader = OBUType(7)
+	OBUTileList             = OBUType(8)
+	OBUPadding              = OBUType(15)
+)
+
+type AV1FrameType byte
+
+const (
+	AV1KeyFrame       = AV1FrameType(0)
+	AV1InterFrame     = AV1FrameType(1)
+	AV1IntraOnlyFrame = AV1FrameType(2)
+	AV1SwitchFrame    = AV1FrameType(3)
+)
+
+// AV1SequenceHeader keeps the fields up to the max frame size.
+// ref https://aomediacodec.github.io/av1-spec/#sequence-header-obu-syntax
+type AV1SequenceHeader struct {
+	Profile                   byte
+	StillPicture              bool
+	ReducedStillPictureHeader bool
+	// Level and Tier of the first operating point, the level index 5 is the level 3.1
+	LevelIdx byte
+	Tier     byte
+
+	TimingInfoPresent     bool
+	NumUnitsInDisplayTick uint32
+	TimeScale             uint32
+
+	MaxFrameWidth  uint32
+	MaxFrameHeight uint32
+}
+
+type AV1MetadataType uint64
+
+const (
+	// ref https://aomediacodec.github.io/av1-spec/#metadata-obu-semantics
+	AV1MetadataHDRCLL     = AV1MetadataType(1)
+	AV1MetadataHDRMDCV    = AV1MetadataType(2)
+	AV1MetadataScalablity = AV1MetadataType(3)
+	AV1MetadataITUTT35    = AV1MetadataType(4)
+	AV1MetadataTimecode   = AV1MetadataType(5)
+)
+
+type AV1Metadata struct {
+	Type AV1MetadataType
+	// Payload is the metadata content, for ITU-T T.35 it starts at the country code
+	Payload []byte
+}
+
+// IsKeyframe tells whether it's a shown key frame.
+func (o OBU) IsKeyframe() bool {
+	return (o.Type == OBUFrame || o.Type == OBUFrameHeader) && !o.ShowExistingFrame && o.FrameType == AV1KeyFrame
+}
+
+func (o OBU) ITUTT35Payloads() [][]byte {
+	if o.Metadata != nil && o.Metadata.Type == AV1MetadataITUTT35 {
+		return [][]byte{o.Metadata.Payload}
+	}
+	return nil
+}
+
+// ParsePayload parses the OBU payload according to its type,
+// the frame headers depend on the sequence header in use (nil when unknown).
+func (o *OBU) ParsePayload(sequenceHeader *AV1SequenceHeader) error {
+	var err error
+	switch o.Type {
+	case OBUSequenceHeader:
+		o.SequenceHeader, err = ParseAV1SequenceHeader(o.Payload)
+	case OBUMetadata:
+		o.Metadata, err = parseAV1Metadata(o.Payload)
+	case OBUFrame, OBUFrameHeader:
+		err = o.parseFrameType(sequenceHeader)
+	}
+	return err
+}
+
+// ref https://aomediacodec.github.io/av1-spec/#uncompressed-header-syntax
+func (o *OBU) parseFrameType(sequenceHeader *AV1SequenceHeader) error {
+	if sequenceHeader != nil && sequenceHeader.ReducedStillPictureHeader {
+		o.FrameType = AV1KeyFrame
+		return nil
+	}
+	r := newBitReader(o.Payload)
+	o.ShowExistingFrame = r.flag()
+	if !o.ShowExistingFrame {
+		o.FrameType = AV1FrameType(r.u(2))
+	}
+	if r.err != nil {
+		return fmt.Errorf("av1 frame header: %w", r.err)
+	}
+	return nil
+}
+
+func ParseAV1SequenceHeader(payload []byte) (*AV1SequenceHeader, error) {
+	r := newBitReader(payload)
+	s := &AV1SequenceHeader{
+		Profile:                   byte(r.u(3)),
+		StillPicture:              r.flag(),
+		ReducedStillPictureHeader: r.flag(),
+	}
+
+	if s.ReducedStillPictureHeader {
+		s.LevelIdx = byte(r.u(5))
+	} else {
+		var decoderModelInfoPresent bool
+		var bufferDelayLength int
+		s.TimingInfoPresent = r.flag()
+		if s.TimingInfoPresent {
+			s.NumUnitsInDisplayTick = r.u(32)
+			s.TimeScale = r.u(32)
+			// equal_picture_interval
+			if r.flag() {
+				// num_ticks_per_picture_minus_1
+				readUVLC(r)
+			}
+			decoderModelInfoPresent = r.flag()
+			if decoderModelInfoPresent {
+				bufferDelayLength = int(r.u(5)) + 1
+				// num_units_in_decoding_tick, buffer_removal_time_length_minus_1 and frame_presentation_time_length_minus_1
+				r.u(32)
+				r.u(5)
+				r.u(5)
+			}
+		}
+		initialDisplayDelayPresent := r.flag()
+		operatingPoints := int(r.u(5)) + 1
+		for i := 0; i < operatingPoints && r.err == nil; i++ {
+			// operating_point_idc
+			r.u(12)
+			levelIdx := byte(r.u(5))
+			var tier byte
+			if levelIdx > 7 {
+				tier = byte(r.u(1))
+			}
+			if i == 0 {
+				s.LevelIdx, s.Tier = levelIdx, tier
+			}
+			// decoder_model_present_for_this_op
+			if decoderModelInfoPresent && r.flag() {
+				// decoder_buffer_delay, encoder_buffer_delay and low_delay_mode_flag
+				r.u(bufferDelayLength)
+				r.u(bufferDelayLength)
+				r.u(1)
+			}
+			// initial_display_delay_present_for_this_op
+			if initialDisplayDelayPresent && r.flag() {
+				r.u(4)
+			}
+		}
+	}
+
+	frameWidthBits := int(r.u(4)) + 1
+	frameHeightBits := int(r.u(4)) + 1
+	s.MaxFrameWidth = r.u(frameWidthBits) + 1
+	s.MaxFrameHeight = r.u(frameHeightBits) + 1
+
+	if r.err != nil {
+		return nil, fmt.Errorf("av1 sequence header: %w", r.err)
+	}
+	return s, nil
+}
+
+// ref https://aomediacodec.github.io/av1-spec/#metadata-obu-syntax
+func parseAV1Metadata(payload []byte) (*AV1Metadata, error) {
+	metadataType, n, err := ReadLEB128(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &AV1Metadata{
+		Type:    AV1MetadataType(metadataType),
+		Payload: payload[n:],
+	}, nil
+}
+
+// ref https://aomediacodec.github.io/av1-spec/#variable-length-unsigned-n-bit-number-syntax
+func readUVLC(r *bitReader) uint32 {
+	leadingZeros := 0
+	for !r.flag() && r.err == nil {
+		leadingZeros++
+	}
+	if leadingZeros >= 32 {
+		return 1<<32 - 1
+	}
+	return r.u(leadingZeros) + (1<<leadingZeros - 1)
+}
+
+// ReadLEB128 reads an unsigned little endian base 128 integer, returning it and the bytes read.
+// ref https://aomediacodec.github.io/av1-spec/#leb128
+func ReadLEB128(data []byte) (uint64, int, error) {
+	var value uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(data) {
+			return 0, 0, fmt.Errorf("%w truncated leb128", ErrInvalidBitstream)
+		}
+		value |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return value, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("%w leb128 longer than 8 bytes", ErrInvalidBitstream)
+}
@@ -0,0 +1,132 @@
+package entities_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+// main profile level 4.0 1920x1080 sequence header OBU payload
+var av1SequenceHeader = []byte{0x00, 0x00, 0x00, 0x42, 0xab, 0xbf, 0xc3, 0x78}
+
+func TestParseAV1SequenceHeader(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		payload  []byte
+		expected *entities.AV1SequenceHeader
+	}{
+		{
+			name:     "high tier operating point",
+			payload:  av1SequenceHeader,
+			expected: &entities.AV1SequenceHeader{LevelIdx: 8, MaxFrameWidth: 1920, MaxFrameHeight: 1080},
+		},
+		{
+			name:    "reduced still picture header",
+			payload: []byte{0x19, 0x26, 0x67, 0xf7, 0x7e},
+			expected: &entities.AV1SequenceHeader{
+				StillPicture:              true,
+				ReducedStillPictureHeader: true,
+				LevelIdx:                  4,
+				MaxFrameWidth:             640,
+				MaxFrameHeight:            480,
+			},
+		},
+		{
+			name:    "timing info",
+			payload: []byte{0x24, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x78, 0x00, 0x00, 0x0b, 0x55, 0x3f, 0xd6, 0x7c},
+			expected: &entities.AV1SequenceHeader{
+				Profile:               1,
+				LevelIdx:              5,
+				TimingInfoPresent:     true,
+				NumUnitsInDisplayTick: 1,
+				TimeScale:             30,
+				MaxFrameWidth:         1280,
+				MaxFrameHeight:        720,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			header, err := entities.ParseAV1SequenceHeader(tt.payload)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, header)
+		})
+	}
+}
+
+func TestParseAV1SequenceHeader_Truncated(t *testing.T) {
+	t.Parallel()
+	for size := 0; size < len(av1SequenceHeader)-1; size++ {
+		_, err := entities.ParseAV1SequenceHeader(av1SequenceHeader[:size])
+		assert.ErrorIs(t, err, entities.ErrInvalidBitstream, "%d bytes", size)
+	}
+}
+
+func TestReadLEB128(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		data      []byte
+		value     uint64
+		read      int
+		expectErr bool
+	}{
+		{name: "zero", data: []byte{0x00}, value: 0, read: 1},
+		{name: "single byte", data: []byte{0x7f, 0xff}, value: 127, read: 1},
+		{name: "two bytes", data: []byte{0x80, 0x01}, value: 128, read: 2},
+		{name: "three bytes", data: []byte{0xe5, 0x8e, 0x26}, value: 624485, read: 3},
+		{name: "padded", data: []byte{0x81, 0x80, 0x00}, value: 1, read: 3},
+		{name: "eight bytes", data: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, value: 1<<56 - 1, read: 8},
+		{name: "empty", expectErr: true},
+		{name: "truncated", data: []byte{0x80, 0x80}, expectErr: true},
+		{name: "longer than eight bytes", data: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, expectErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			value, read, err := entities.ReadLEB128(tt.data)
+
+			if tt.expectErr {
+				assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.value, value)
+			assert.Equal(t, tt.read, read)
+		})
+	}
+}
+
+func FuzzParseAV1SequenceHeader(f *testing.F) {
+	f.Add(av1SequenceHeader)
+	f.Add([]byte{0x19, 0x26, 0x67, 0xf7, 0x7e})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		header, err := entities.ParseAV1SequenceHeader(payload)
+		if err != nil {
+			return
+		}
+		assert.Greater(t, header.MaxFrameWidth, uint32(0))
+		assert.Greater(t, header.MaxFrameHeight, uint32(0))
+	})
+}
+
+func FuzzReadLEB128(f *testing.F) {
+	f.Add([]byte{0xe5, 0x8e, 0x26})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, read, err := entities.ReadLEB128(data)
+		if err != nil {
+			return
+		}
+		assert.LessOrEqual(t, read, len(data))
+		assert.LessOrEqual(t, read, 8)
+		assert.Less(t, value, uint64(1)<<(7*read))
+	})
+}
@@ -0,0 +1,99 @@
+package entities
+
+import "time"
+
+// avOffsetWindow is the number of audio outputs averaged to measure the A/V offset,
+// a single measure depends on how the source interleaves the audio and video.
+const avOffsetWindow = 32
+
+type AVSyncCorrection int
+
+const (
+	AVSyncNone AVSyncCorrection = iota
+	// AVSyncInsertSilence asks for a silent frame before the current audio frame
+	AVSyncInsertSilence
+	// AVSyncDropAudio asks to drop the current audio frame
+	AVSyncDropAudio
+)
+
+// AVSync measures the offset between the audio and video outputs and corrects the audio to keep it close
+// to the video. Whenever the audio gets ahead of the video (or falls behind it) by more than the threshold,
+// a frame of silence is inserted (or a frame is dropped). The silence delays the audio heard by the viewer
+// and the drop advances it, so the offset is measured with the corrections applied. Offsets bigger than
+// maxCorrection are taken as discontinuities and left alone.
+type AVSync struct {
+	threshold     time.Duration
+	maxCorrection time.Duration
+
+	video    time.Duration
+	hasVideo bool
+	offsets  []time.Duration
+
+	correction time.Duration
+}
+
+func NewAVSync(threshold, maxCorrection time.Duration) *AVSync {
+	return &AVSync{
+		threshold:     threshold,
+		maxCorrection: maxCorrection,
+	}
+}
+
+// OnVideoOutput records the PTS of the last video frame sent.
+func (s *AVSync) OnVideoOutput(pts time.Duration) {
+	s.video, s.hasVideo = pts, true
+}
+
+// OnAudioOutput measures the offset between the audio frame sent at pts and the last video frame.
+func (s *AVSync) OnAudioOutput(pts time.Duration) {
+	if !s.hasVideo {
+		return
+	}
+	if len(s.offsets) == avOffsetWindow {
+		s.offsets = s.offsets[1:]
+	}
+	s.offsets = append(s.offsets, pts-s.video)
+}
+
+// Offset returns the average audio minus video PTS minus the correction, it's positive when the audio is ahead.
+func (s *AVSync) Offset() (time.Duration, bool) {
+	if len(s.offsets) == 0 {
+		return 0, false
+	}
+	var sum time.Duration
+	for _, o := range s.offsets {
+		sum += o
+	}
+	return sum/time.Duration(len(s.offsets)) - s.correction, true
+}
+
+// CorrectAudio is called before encoding an audio frame of frameDuration, the silence inserted has the
+// same duration. Nothing is corrected until there's a full window of measures.
+func (s *AVSync) CorrectAudio(frameDuration time.Duration) AVSyncCorrection {
+	if s.threshold <= 0 || len(s.offsets) < avOffsetWindow {
+		return AVSyncNone
+	}
+	offset, _ := s.Offset()
+	if offset > s.maxCorrection || -offset > s.maxCorrection {
+		return AVSyncNone
+	}
+	if offset >= s.threshold {
+		s.correction += frameDuration
+		return AVSyncInsertSilence
+	}
+	if -offset >= s.threshold {
+		s.correction -= frameDuration
+		return AVSyncDropAudio
+	}
+	return AVSyncNone
+}
+
+// ResetAudio restarts the audio measures (i.e. switching the audio stream), the correction total is kept.
+func (s *AVSync) ResetAudio() {
+	s.offsets = s.offsets[:0]
+}
+
+// Correction returns the silence inserted minus the audio dropped so far.
+func (s *AVSync) Correction() time.Duration {
+	return s.correction
+}
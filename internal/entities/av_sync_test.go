@@ -0,0 +1,133 @@
+package entities_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+const opusFrame = 20 * time.Millisecond
+
+func TestAVSync_Offset(t *testing.T) {
+	t.Parallel()
+	s := entities.NewAVSync(80*time.Millisecond, time.Second)
+
+	s.OnAudioOutput(0)
+	_, ok := s.Offset()
+	assert.False(t, ok, "there is no video yet")
+
+	// the audio is sent 120ms ahead, the interleaving makes it vary by a frame
+	for i := 0; i < 100; i++ {
+		video := time.Duration(i) * 40 * time.Millisecond
+		s.OnVideoOutput(video)
+		s.OnAudioOutput(video + 100*time.Millisecond + time.Duration(i%2)*40*time.Millisecond)
+	}
+
+	offset, ok := s.Offset()
+	assert.True(t, ok)
+	assert.Equal(t, 120*time.Millisecond, offset)
+}
+
+// sendAV sends 20ms audio frames, offset from the video, and a video frame every 40ms whose PTS advance
+// videoRate times faster than the audio ones. The audio timestamps are always consistent, only the video drifts.
+func sendAV(s *entities.AVSync, frames int, audioOffset time.Duration, videoRate float64) []entities.AVSyncCorrection {
+	var corrections []entities.AVSyncCorrection
+	for i := 0; i < frames; i++ {
+		audio := time.Duration(i) * opusFrame
+		if i%2 == 0 {
+			s.OnVideoOutput(time.Duration(float64(audio) * videoRate))
+		}
+		c := s.CorrectAudio(opusFrame)
+		if c != entities.AVSyncNone {
+			corrections = append(corrections, c)
+		}
+		if c != entities.AVSyncDropAudio {
+			s.OnAudioOutput(audio + audioOffset)
+		}
+	}
+	return corrections
+}
+
+func TestAVSync_InsertsSilenceWhenAudioIsAhead(t *testing.T) {
+	t.Parallel()
+	s := entities.NewAVSync(80*time.Millisecond, time.Second)
+
+	corrections := sendAV(s, 500, 130*time.Millisecond, 1)
+
+	// the interleaving puts the audio 140ms ahead on average, a frame of silence is inserted until
+	// it's less than 80ms ahead
+	assert.Equal(t, []entities.AVSyncCorrection{
+		entities.AVSyncInsertSilence, entities.AVSyncInsertSilence, entities.AVSyncInsertSilence, entities.AVSyncInsertSilence,
+	}, corrections)
+	assert.Equal(t, 4*opusFrame, s.Correction())
+	offset, ok := s.Offset()
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Millisecond, offset)
+}
+
+func TestAVSync_DropsAudioWhenTheVideoDrifts(t *testing.T) {
+	t.Parallel()
+	s := entities.NewAVSync(80*time.Millisecond, time.Second)
+
+	// 20s where the video gains 400ms over the audio
+	corrections := sendAV(s, 1000, 0, 1.02)
+
+	assert.GreaterOrEqual(t, len(corrections), 15)
+	for _, c := range corrections {
+		assert.Equal(t, entities.AVSyncDropAudio, c)
+	}
+	assert.Equal(t, -time.Duration(len(corrections))*opusFrame, s.Correction())
+	offset, ok := s.Offset()
+	assert.True(t, ok)
+	assert.Less(t, -offset, 80*time.Millisecond+opusFrame, "the drift is corrected")
+}
+
+func TestAVSync_InsertsSilenceWhenTheVideoDrifts(t *testing.T) {
+	t.Parallel()
+	s := entities.NewAVSync(80*time.Millisecond, time.Second)
+
+	// 20s where the video loses 400ms to the audio
+	corrections := sendAV(s, 1000, 0, 0.98)
+
+	assert.GreaterOrEqual(t, len(corrections), 15)
+	for _, c := range corrections {
+		assert.Equal(t, entities.AVSyncInsertSilence, c)
+	}
+	assert.Equal(t, time.Duration(len(corrections))*opusFrame, s.Correction())
+	offset, ok := s.Offset()
+	assert.True(t, ok)
+	assert.Less(t, offset, 80*time.Millisecond+opusFrame, "the drift is corrected")
+}
+
+func TestAVSync_IgnoresDiscontinuities(t *testing.T) {
+	t.Parallel()
+	s := entities.NewAVSync(80*time.Millisecond, time.Second)
+
+	assert.Empty(t, sendAV(s, 100, time.Hour, 1))
+	assert.Equal(t, time.Duration(0), s.Correction())
+}
+
+func TestAVSync_ResetAudio(t *testing.T) {
+	t.Parallel()
+	s := entities.NewAVSync(80*time.Millisecond, time.Second)
+	assert.Len(t, sendAV(s, 100, 130*time.Millisecond, 1), 4)
+
+	// the new audio stream is measured from scratch, the silence already heard still counts
+	s.ResetAudio()
+	_, ok := s.Offset()
+	assert.False(t, ok)
+	assert.Empty(t, sendAV(s, 100, 130*time.Millisecond, 1))
+	assert.Equal(t, 4*opusFrame, s.Correction())
+}
+
+func TestAVSync_Disabled(t *testing.T) {
+	t.Parallel()
+	s := entities.NewAVSync(0, time.Second)
+
+	assert.Empty(t, sendAV(s, 1000, 0, 1.02))
+	offset, ok := s.Offset()
+	assert.True(t, ok)
+	assert.Less(t, offset, -350*time.Millisecond, "the drift is only measured")
+}
@@ -0,0 +1,75 @@
+package entities
+
+import "fmt"
+
+// bitReader reads the fixed and variable length fields of the RBSPs (most significant bit first).
+// The first read past the end sets err, the following reads return zero, so it's checked once at the end.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+	err  error
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+// u reads n bits (up to 32) as an unsigned integer, u(n) at the spec.
+func (r *bitReader) u(n int) uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+n > len(r.data)*8 {
+		r.err = fmt.Errorf("%w reading %d bits at %d of %d", ErrInvalidBitstream, n, r.pos, len(r.data)*8)
+		return 0
+	}
+	var v uint32
+	for i := 0; i < n; i++ {
+		bit := r.data[r.pos/8] >> (7 - r.pos%8) & 0x01
+		v = v<<1 | uint32(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) flag() bool {
+	return r.u(1) == 1
+}
+
+// ue reads an unsigned exp-Golomb code, ue(v) at the spec.
+// Rec. ITU-T H.264 (08/2021) p.209
+func (r *bitReader) ue() uint32 {
+	leadingZeroBits := 0
+	for r.u(1) == 0 {
+		if r.err != nil {
+			return 0
+		}
+		leadingZeroBits++
+		if leadingZeroBits > 31 {
+			r.err = fmt.Errorf("%w exp-Golomb code longer than 32 bits", ErrInvalidBitstream)
+			return 0
+		}
+	}
+	return uint32(1<<leadingZeroBits-1) + r.u(leadingZeroBits)
+}
+
+// se reads a signed exp-Golomb code, se(v) at the spec.
+func (r *bitReader) se() int32 {
+	k := r.ue()
+	if k%2 == 1 {
+		return int32(k/2 + 1)
+	}
+	return -int32(k / 2)
+}
+
+// moreRBSPData tells whether there's data before the rbsp_trailing_bits (stop bit followed by zeros).
+func (r *bitReader) moreRBSPData() bool {
+	if r.err != nil {
+		return false
+	}
+	last := len(r.data)*8 - 1
+	for last >= 0 && r.data[last/8]>>(7-last%8)&0x01 == 0 {
+		last--
+	}
+	return r.pos < last
+}
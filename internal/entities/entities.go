@@ -2,8 +2,10 @@ package entities
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/asticode/go-astiav"
@@ -16,16 +18,28 @@ const (
 
 type WebRTCSetupResponse struct {
 	Connection *webrtc.PeerConnection
-	Video      *webrtc.TrackLocalStaticSample
-	Audio      *webrtc.TrackLocalStaticSample
+	Video      webrtc.TrackLocal
+	Audio      webrtc.TrackLocal
 	Data       *webrtc.DataChannel
 	LocalSDP   *webrtc.SessionDescription
+	Reports    *ReceptionReports
 }
 
 type RequestParams struct {
 	StreamURL string
 	StreamID  string
 	Offer     webrtc.SessionDescription
+
+	// AudioStreamIndex selects the input audio stream by its index
+	AudioStreamIndex *int
+	// AudioLanguage selects the input audio stream by its language (i.e. eng, por)
+	AudioLanguage string
+	// SubtitleLanguage is the preferred subtitle language (i.e. eng, por)
+	SubtitleLanguage string
+	// Record starts recording the session, its SessionID is ignored
+	Record *RecordingParams
+	// Output is how the session is delivered, WebRTC when empty
+	Output DonutOutput
 }
 
 func (p *RequestParams) Valid() error {
@@ -47,6 +61,14 @@ func (p *RequestParams) Valid() error {
 		return ErrUnsupportedStreamURL
 	}
 
+	if p.Output != "" && p.Output != DonutWebRTC && !p.Output.IsMuxed() {
+		return fmt.Errorf("%w %q", ErrUnsupportedOutput, p.Output)
+	}
+
+	if p.Record != nil {
+		return p.Record.validOptions()
+	}
+
 	return nil
 }
 
@@ -54,18 +76,87 @@ func (p *RequestParams) String() string {
 	if p == nil {
 		return ""
 	}
-	return fmt.Sprintf("RequestParams {StreamURL: %s, StreamID: %s}", p.StreamURL, p.StreamID)
+	audioStreamIndex := "default"
+	if p.AudioStreamIndex != nil {
+		audioStreamIndex = fmt.Sprint(*p.AudioStreamIndex)
+	}
+	return fmt.Sprintf("RequestParams {StreamURL: %s, StreamID: %s, AudioStreamIndex: %s, AudioLanguage: %s, SubtitleLanguage: %s}",
+		p.StreamURL, p.StreamID, audioStreamIndex, p.AudioLanguage, p.SubtitleLanguage)
 }
 
+// DataChannelProtocolVersion is the version of the messages exchanged through the data channel.
+const DataChannelProtocolVersion = 1
+
 type MessageType string
 
 const (
-	MessageTypeMetadata MessageType = "metadata"
+	// server -> client
+	MessageTypeMetadata        MessageType = "metadata"
+	MessageTypeStats           MessageType = "stats"
+	MessageTypeCaptions        MessageType = "captions"
+	MessageTypeEvent           MessageType = "event"
+	MessageTypeTimedMetadata   MessageType = "timedMetadata"
+	MessageTypeCommandResponse MessageType = "commandResponse"
+	// mediaSource precedes every MSE init segment with its MIME type (i.e. to create the SourceBuffer)
+	MessageTypeMediaSource MessageType = "mediaSource"
 )
 
 type Message struct {
+	Version int
 	Type    MessageType
+	// Message is a human readable summary of the payload
 	Message string
+	Payload interface{} `json:",omitempty"`
+}
+
+type CommandType string
+
+const (
+	// client -> server
+	CommandSwitchAudioTrack CommandType = "switchAudioTrack"
+	CommandRequestKeyframe  CommandType = "requestKeyframe"
+	CommandChangeQuality    CommandType = "changeQuality"
+	CommandPause            CommandType = "pause"
+	CommandResume           CommandType = "resume"
+	CommandPing             CommandType = "ping"
+)
+
+// Command is sent by the client through the data channel.
+type Command struct {
+	Version int
+	// ID is echoed back in the response so the client can match them
+	ID   string
+	Type CommandType
+	Args json.RawMessage
+}
+
+type CommandResponse struct {
+	ID     string
+	Type   CommandType
+	Result interface{} `json:",omitempty"`
+	Error  string      `json:",omitempty"`
+}
+
+type CommandHandler func(cmd Command) (interface{}, error)
+
+type PingArgs struct {
+	// ClientTime is the client clock (ms), it's echoed back to compute the round trip time
+	ClientTime int64
+}
+
+type PongResult struct {
+	ClientTime int64
+	ServerTime int64
+}
+
+type ChangeQualityArgs struct {
+	BitRate int64
+}
+
+// SwitchAudioTrackArgs selects the audio stream either by its index or language.
+type SwitchAudioTrackArgs struct {
+	Index    *int
+	Language string
 }
 
 type Codec string
@@ -80,12 +171,24 @@ const (
 	AV1          Codec = "av1"
 	AAC          Codec = "aac"
 	Opus         Codec = "opus"
+
+	// Subtitles
+	WebVTT      Codec = "webvtt"
+	SubRip      Codec = "subrip"
+	ASS         Codec = "ass"
+	MovText     Codec = "mov_text"
+	Text        Codec = "text"
+	DVBTeletext Codec = "dvb_teletext"
+	DVBSubtitle Codec = "dvb_subtitle"
 )
 
 const (
-	UnknownType MediaType = "unknownMediaType"
-	VideoType   MediaType = "video"
-	AudioType   MediaType = "audio"
+	UnknownType  MediaType = "unknownMediaType"
+	VideoType    MediaType = "video"
+	AudioType    MediaType = "audio"
+	SubtitleType MediaType = "subtitle"
+	// DataType streams are neither played nor captions (i.e. SCTE-35, ID3)
+	DataType MediaType = "data"
 )
 
 type Stream struct {
@@ -93,6 +196,34 @@ type Stream struct {
 	Type  MediaType
 	Id    uint16
 	Index uint16
+
+	// Profile is the codec profile name (i.e. High, Main, LC)
+	Profile string
+	// Level is the codec level as reported by libav (i.e. 31 for h264 level 3.1)
+	Level   int
+	BitRate int64
+
+	// Video
+	Width       int
+	Height      int
+	FrameRate   float64
+	PixelFormat string
+
+	// Audio
+	SampleRate    int
+	SampleFormat  string
+	Channels      int
+	ChannelLayout string
+
+	Language string
+
+	// Fmtp is the SDP fmtp line (i.e. packetization-mode=1;profile-level-id=42e01f),
+	// the server ones are derived from the bitstream. It's only set for H264 for now.
+	Fmtp string
+
+	// Action is the recipe action (bypass/transcode) applied to the stream,
+	// it's empty for the streams not being sent (i.e. audio tracks not selected)
+	Action DonutMediaTaskAction
 }
 
 type MediaFrameContext struct {
@@ -102,12 +233,39 @@ type MediaFrameContext struct {
 	PTS int
 	// Media frame duration
 	Duration time.Duration
+	// TimeBaseNum/TimeBaseDen is the time base of the DTS and PTS
+	TimeBaseNum int
+	TimeBaseDen int
+	// Keyframe is set for the video frames the decoding can start from
+	Keyframe bool
+}
+
+// PTSDuration returns the PTS as a duration, it's false without PTS or time base.
+func (c MediaFrameContext) PTSDuration() (time.Duration, bool) {
+	if c.PTS == NoTimestamp || c.TimeBaseNum <= 0 || c.TimeBaseDen <= 0 {
+		return 0, false
+	}
+	// the seconds are split from the remainder, the nanoseconds of a long stream overflow otherwise
+	ticks, den := int64(c.PTS)*int64(c.TimeBaseNum), int64(c.TimeBaseDen)
+	return time.Duration(ticks/den)*time.Second + time.Duration(ticks%den)*time.Second/time.Duration(den), true
 }
 
 type StreamInfo struct {
 	Streams []Stream
 }
 
+// ParseFmtp returns the parameters of a fmtp line.
+func ParseFmtp(line string) map[string]string {
+	params := map[string]string{}
+	for _, param := range strings.Split(line, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+	return params
+}
+
 func (s *StreamInfo) VideoStreams() []Stream {
 	var result []Stream
 	for _, s := range s.Streams {
@@ -128,23 +286,111 @@ func (s *StreamInfo) AudioStreams() []Stream {
 	return result
 }
 
+type StreamerStats struct {
+	// bit rates in bits per second
+	InputBitRate  int64
+	OutputBitRate int64
+	// video frames per second
+	InputFPS      float64
+	OutputFPS     float64
+	DroppedFrames int64
+	// EncoderQueueDepth is the number of frames sent to the encoders and not yet encoded
+	EncoderQueueDepth int64
+	// PipelineLatencyMS is the time between reading a video packet and delivering it
+	PipelineLatencyMS int64
+	// AVOffsetMS is the audio minus video output PTS with the corrections applied, it's positive when the audio is ahead
+	AVOffsetMS int64
+	// AudioCorrectionMS is the silence inserted minus the audio dropped to keep the A/V sync
+	AudioCorrectionMS int64
+}
+
+// ReceptionReport summarizes the last RTCP receiver report sent by the client.
+type ReceptionReport struct {
+	MediaType       MediaType
+	SSRC            uint32
+	FractionLost    float64
+	TotalLost       uint32
+	JitterMS        float64
+	RoundTripTimeMS float64
+}
+
+type PeerStats struct {
+	BytesSent uint64
+	// RoundTripTimeMS is measured by the ICE connectivity checks
+	RoundTripTimeMS float64
+	Reports         []ReceptionReport
+}
+
+type SessionStats struct {
+	SessionID string
+	// Timestamp unix time in milliseconds
+	Timestamp int64
+	// LatencyMS is the end-to-end latency estimate (pipeline latency and half the round trip time)
+	LatencyMS float64
+	Streamer  StreamerStats
+	Peer      PeerStats
+}
+
+// ReceptionReports keeps the last RTCP reception report per media type.
+type ReceptionReports struct {
+	mu      sync.Mutex
+	reports map[MediaType]ReceptionReport
+}
+
+func NewReceptionReports() *ReceptionReports {
+	return &ReceptionReports{reports: make(map[MediaType]ReceptionReport)}
+}
+
+func (r *ReceptionReports) Update(report ReceptionReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports[report.MediaType] = report
+}
+
+func (r *ReceptionReports) Snapshot() []ReceptionReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []ReceptionReport
+	for _, report := range r.reports {
+		result = append(result, report)
+	}
+	return result
+}
+
 type Cue struct {
 	Type      string
 	StartTime int64
-	Text      string
+	// EndTime is zero when unknown, the cue lasts until the next one
+	EndTime  int64
+	Text     string
+	Language string
 }
 
 type DonutParameters struct {
 	Cancel context.CancelFunc
 	Ctx    context.Context
+	// SessionID tags the streamer logs
+	SessionID string
 
 	Recipe DonutRecipe
 
-	OnClose      func()
-	OnError      func(err error)
-	OnStream     func(st *Stream) error
-	OnVideoFrame func(data []byte, c MediaFrameContext) error
-	OnAudioFrame func(data []byte, c MediaFrameContext) error
+	OnClose  func()
+	OnError  func(err error)
+	OnStream func(st *Stream) error
+	// OnOutputStream is called with the streams sent through OnVideoFrame/OnAudioFrame once they're prepared
+	OnOutputStream func(st *OutputStream) error
+	OnVideoFrame   func(data []byte, c MediaFrameContext) error
+	OnAudioFrame   func(data []byte, c MediaFrameContext) error
+	OnStats        func(st StreamerStats)
+	// OnSpliceEvent is called when the video reaches a SCTE-35 splice point
+	OnSpliceEvent func(ev *SpliceEvent) error
+	// OnTimedMetadata is called with the ID3 and FLV (onMetaData/onTextData) metadata
+	OnTimedMetadata func(md *TimedMetadata) error
+	// OnCue is called with the subtitles cues
+	OnCue func(c *Cue) error
+
+	// RegisterCommand lets the streamer handle the commands sent by the client
+	RegisterCommand func(t CommandType, h CommandHandler)
 }
 
 type DonutMediaTaskAction string
@@ -163,6 +409,44 @@ func AudioResamplerFilter(sampleRate int) *DonutStreamFilter {
 	return &filter
 }
 
+// AudioResamplerFixedFrameFilter also splits the audio into frames of frameSize samples,
+// as the encoders with a fixed frame size (i.e. AAC) require.
+func AudioResamplerFixedFrameFilter(sampleRate, frameSize int) *DonutStreamFilter {
+	filter := DonutStreamFilter(fmt.Sprintf("aresample=%d,asetnsamples=n=%d:p=0", sampleRate, frameSize))
+	return &filter
+}
+
+// DonutOutput is how a session is delivered to the viewers.
+type DonutOutput string
+
+var DonutWebRTC DonutOutput = "webrtc"
+var DonutHLS DonutOutput = "hls"
+
+// DonutMSE streams fragmented MP4 through a WebSocket, it's played with Media Source Extensions.
+var DonutMSE DonutOutput = "mse"
+
+// DonutRestream pushes the session to RTMP/SRT destinations.
+var DonutRestream DonutOutput = "restream"
+
+// IsFragmentedMP4 tells whether the output is packaged to fragmented MP4 (i.e. HLS and MSE).
+func (o DonutOutput) IsFragmentedMP4() bool {
+	return o == DonutHLS || o == DonutMSE
+}
+
+// IsMuxed tells whether the output is muxed by libav (i.e. fMP4, FLV), the H264 is bypassed and the audio is AAC.
+func (o DonutOutput) IsMuxed() bool {
+	return o.IsFragmentedMP4() || o == DonutRestream
+}
+
+// MuxedClientStreamInfo is what the HLS and MSE players (and the restream destinations) are expected
+// to decode, H264 and AAC.
+func MuxedClientStreamInfo() *StreamInfo {
+	return &StreamInfo{Streams: []Stream{
+		{Codec: H264, Type: VideoType},
+		{Codec: AAC, Type: AudioType},
+	}}
+}
+
 // TODO: split entities per domain or files avoiding name collision.
 
 // DonutMediaTask is a transformation template to apply over a media.
@@ -181,6 +465,32 @@ type DonutMediaTask struct {
 
 	// DonutStreamFilter is a regular filter
 	DonutStreamFilter *DonutStreamFilter
+
+	// StreamIndex and Language select the input stream when there are many of the same media type,
+	// the first one is used by default. Only audio supports it for now.
+	StreamIndex *int
+	Language    string
+
+	// Fmtp is the fmtp line negotiated with the client for the track, the codec default one is used when empty.
+	Fmtp string
+
+	// EncoderOptions are the private options by libav encoder name (i.e. libvpx),
+	// only the ones of the encoder in use are applied.
+	EncoderOptions map[string]DonutEncoderOptions
+}
+
+// DonutEncoderOptions are the private options of a libav encoder, they're passed as it is when opening it.
+type DonutEncoderOptions map[string]string
+
+// LowLatencyEncoderOptions are the encoder presets meant for real time,
+// they disable the look ahead and the frame reordering which add latency.
+var LowLatencyEncoderOptions = map[string]DonutEncoderOptions{
+	"libx264":    {"preset": "veryfast", "tune": "zerolatency"},
+	"libvpx":     {"deadline": "realtime", "cpu-used": "8", "lag-in-frames": "0", "error-resilient": "default"},
+	"libvpx-vp9": {"deadline": "realtime", "cpu-used": "8", "lag-in-frames": "0", "error-resilient": "default", "row-mt": "1"},
+	"libaom-av1": {"usage": "realtime", "cpu-used": "8", "lag-in-frames": "0", "row-mt": "1"},
+	// pred-struct=1 is the low delay prediction structure
+	"libsvtav1": {"preset": "12", "svtav1-params": "pred-struct=1"},
 }
 
 type DonutInputOptionKey string
@@ -214,6 +524,14 @@ type DonutRecipe struct {
 	Input DonutAppetizer
 	Video DonutMediaTask
 	Audio DonutMediaTask
+
+	Subtitle DonutSubtitleTask
+}
+
+// DonutSubtitleTask selects the subtitle stream sent as cues.
+type DonutSubtitleTask struct {
+	// Language is the preferred language, the first supported stream is used when there's no match
+	Language string
 }
 
 type LibAVOptionsCodecContext func(c *astiav.CodecContext)
@@ -283,6 +601,21 @@ type Config struct {
 	EnableICEMux       bool     `require:"true" default:"false"`
 	StunServers        []string `required:"true" default:"stun:stun.l.google.com:19302,stun:stun1.l.google.com:19302,stun:stun2.l.google.com:19302,stun:stun4.l.google.com:19302"`
 
+	// TURN servers, i.e. turn:turn.example.com:3478?transport=udp
+	TurnServers  []string
+	TurnUsername string
+	TurnPassword string
+	// TurnSharedSecret enables time-limited credentials (TURN REST API), it takes precedence over the static ones.
+	// ref https://datatracker.ietf.org/doc/html/draft-uberti-behave-turn-rest-00
+	TurnSharedSecret         string
+	TurnCredentialTTLSeconds int `required:"true" default:"86400"`
+
+	// Embedded TURN server, it's useful for small deployments without a coturn.
+	EnableEmbeddedTURN   bool   `required:"true" default:"false"`
+	EmbeddedTURNPort     int    `required:"true" default:"3478"`
+	EmbeddedTURNRealm    string `required:"true" default:"donut"`
+	EmbeddedTURNPublicIP string `required:"true" default:"127.0.0.1"`
+
 	SRTConnectionLatencyMS int32 `required:"true" default:"300"`
 	// MPEG-TS consists of single units of 188 bytes. Multiplying 188*7 we get 1316,
 	// which is the maximum product of 188 that is less than MTU 1500 (188*8=1504)
@@ -290,4 +623,51 @@ type Config struct {
 	SRTReadBufferSizeBytes int `required:"true" default:"1316"`
 
 	ProbingSize int `required:"true" default:"120"`
+
+	StatsIntervalMS int `required:"true" default:"1000"`
+
+	// RTPTimestampsFromPTS packetizes the frames with the RTP timestamps rescaled from their PTS,
+	// instead of synthesizing them from the frames duration, which drifts over long sessions.
+	RTPTimestampsFromPTS bool `required:"true" default:"false"`
+
+	// A/V sync correction of the transcoded audio, the drifts below the threshold are ignored and the ones
+	// above the max correction are taken as discontinuities. A zero threshold disables the correction.
+	AVSyncThresholdMS     int `required:"true" default:"80"`
+	AVSyncMaxCorrectionMS int `required:"true" default:"1000"`
+
+	// Recordings are written to RecordingsDir/<session id>, a new file is started once a file reaches
+	// the max duration or size (zero disables the limit).
+	RecordingsDir           string `required:"true" default:"./recordings"`
+	RecordingMaxDurationSec int    `required:"true" default:"3600"`
+	RecordingMaxSizeMB      int    `required:"true" default:"0"`
+	RecordingBufferedFrames int    `required:"true" default:"512"`
+
+	// HLS segments are cut at the first keyframe after the segment duration, the parts (LL-HLS) are
+	// cut at every part duration. A zero part duration disables LL-HLS. The playlist keeps the last
+	// HLSPlaylistSegments segments.
+	HLSSegmentDurationMS int `required:"true" default:"2000"`
+	HLSPartDurationMS    int `required:"true" default:"500"`
+	HLSPlaylistSegments  int `required:"true" default:"6"`
+
+	// MSE fragments are cut at every video frame, or at the keyframes when MSEFragmentPerGOP is set.
+	// Up to MSEBufferedFragments wait for the WebSocket, once it's full the fragments are dropped
+	// until the next keyframe.
+	MSEFragmentPerGOP    bool `required:"true" default:"false"`
+	MSEBufferedFragments int  `required:"true" default:"256"`
+
+	// Restream destinations are reconnected after RestreamReconnectMinMS, doubling up to RestreamReconnectMaxMS,
+	// at most RestreamMaxReconnects times in a row (zero is unlimited). Up to RestreamBufferedFrames wait for
+	// each destination, once it's full the frames are dropped. The stopped restreams are listed for
+	// RestreamRetentionSec. Stopping doesn't wait longer than RestreamCloseTimeoutMS for a stuck destination.
+	RestreamReconnectMinMS int `required:"true" default:"1000"`
+	RestreamReconnectMaxMS int `required:"true" default:"30000"`
+	RestreamMaxReconnects  int `required:"true" default:"0"`
+	RestreamBufferedFrames int `required:"true" default:"512"`
+	RestreamRetentionSec   int `required:"true" default:"3600"`
+	RestreamCloseTimeoutMS int `required:"true" default:"5000"`
+
+	// Snapshots are served from the cache for SnapshotCacheTTLMS, when there's no session playing
+	// the stream it's probed for at most SnapshotProbeTimeoutMS.
+	SnapshotCacheTTLMS     int `required:"true" default:"5000"`
+	SnapshotProbeTimeoutMS int `required:"true" default:"10000"`
 }
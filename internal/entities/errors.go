@@ -7,11 +7,13 @@ import (
 
 var ErrHTTPGetOnly = errors.New("you must use http GET verb")
 var ErrHTTPPostOnly = errors.New("you must use http POST verb")
+var ErrHTTPMethodNotAllowed = errors.New("http verb is not allowed")
 var ErrMissingParamsOffer = errors.New("ParamsOffer must not be nil")
 
 var ErrMissingStreamURL = errors.New("stream URL must not be nil")
 var ErrMissingStreamID = errors.New("stream ID must not be nil")
 var ErrUnsupportedStreamURL = errors.New("unsupported stream")
+var ErrUnsupportedOutput = errors.New("unsupported output")
 
 var ErrMissingSRTHost = errors.New("SRTHost must not be nil")
 var ErrMissingSRTPort = errors.New("SRTPort must be valid")
@@ -20,11 +22,43 @@ var ErrMissingSRTStreamID = errors.New("SRTStreamID must not be empty")
 var ErrMissingWebRTCSetup = errors.New("WebRTCController.SetupPeerConnection must be called first")
 var ErrMissingRemoteOffer = errors.New("nil offer, in order to connect one must pass a valid offer")
 var ErrMissingRequestParams = errors.New("RequestParams must not be nil")
+var ErrMissingSession = errors.New("there is no session")
+var ErrSessionClosed = errors.New("the session is over")
+var ErrUnsupportedCommand = errors.New("unsupported command")
+var ErrUnsupportedProtocolVersion = errors.New("unsupported data channel protocol version")
+var ErrInvalidCommandArgs = errors.New("invalid command arguments")
+
+var ErrInvalidSpliceInfo = errors.New("invalid SCTE-35 splice info section")
+var ErrInvalidID3 = errors.New("invalid ID3 tag")
+var ErrUnsupportedSubtitle = errors.New("unsupported subtitle")
+var ErrInvalidProfileLevelID = errors.New("invalid H264 profile-level-id")
+var ErrInvalidBitstream = errors.New("invalid bitstream")
+var ErrUnsupportedVideoBitstream = errors.New("unsupported video bitstream")
+var ErrUnsupportedRTPPayloader = errors.New("there is no rtp payloader for the codec")
+
+var ErrMissingRecordingParams = errors.New("RecordingParams must not be nil")
+var ErrUnsupportedRecordingFormat = errors.New("unsupported recording format")
+var ErrInvalidRecordingParams = errors.New("invalid recording params")
+var ErrMissingRecording = errors.New("there is no recording")
+var ErrRecordingInProgress = errors.New("the session is already being recorded")
+
+var ErrMissingRestreamParams = errors.New("RestreamParams must not be nil")
+var ErrMissingRestreamDestination = errors.New("restream requires at least one destination")
+var ErrUnsupportedRestreamDestination = errors.New("unsupported restream destination")
+var ErrMissingRestream = errors.New("there is no restream")
+
+var ErrUnsupportedSnapshotFormat = errors.New("unsupported snapshot format")
+var ErrInvalidSnapshotSize = errors.New("invalid snapshot size")
+var ErrMissingKeyframe = errors.New("there is no keyframe")
+
+var ErrMissingTURNCredentials = errors.New("TURN requires either a shared secret or an username and password")
+var ErrInvalidEmbeddedTURNConfig = errors.New("invalid embedded TURN config")
 
 var ErrMissingProcess = errors.New("there is no process running")
 var ErrMissingProber = errors.New("there is no prober")
 var ErrMissingStreamer = errors.New("there is no streamer")
 var ErrMissingCompatibleStreams = errors.New("there is no compatible streams")
+var ErrMissingAudioStream = errors.New("there is no matching audio stream")
 
 // FFmpeg/LibAV
 var ErrFFMpegLibAV = errors.New("ffmpeg/libav error")
@@ -32,3 +66,4 @@ var ErrFFmpegLibAVNotFound = fmt.Errorf("%w input not found", ErrFFMpegLibAV)
 var ErrFFmpegLibAVFormatContextIsNil = fmt.Errorf("%w format context is nil", ErrFFMpegLibAV)
 var ErrFFmpegLibAVFormatContextOpenInputFailed = fmt.Errorf("%w format context open input has failed", ErrFFMpegLibAV)
 var ErrFFmpegLibAVFindStreamInfo = fmt.Errorf("%w could not find stream info", ErrFFMpegLibAV)
+var ErrFFmpegLibAVEncoderNotFound = fmt.Errorf("%w encoder not found", ErrFFMpegLibAV)
@@ -0,0 +1,118 @@
+package entities
+
+import (
+	"sort"
+	"time"
+)
+
+// maxFrameDuration is the biggest timestamp delta taken as a frame duration, a bigger one is a discontinuity.
+const maxFrameDuration = 10 * time.Second
+
+// reorderWindow is the number of recent timestamps used to find the frame interval of reordered streams,
+// it's the max number of reference frames.
+const reorderWindow = 16
+
+// FrameDuration computes the frames duration from the deltas between their timestamps, in the stream time base.
+// It's kept per stream (and session) since it depends on the previous frames. The decode timestamps should be
+// given whenever present, the presentation ones are out of order with B-frames. Once they're seen out of order,
+// the duration is the smallest gap between the recent timestamps instead.
+type FrameDuration struct {
+	timeBaseNum int64
+	timeBaseDen int64
+
+	last    int64
+	hasLast bool
+	current time.Duration
+
+	reordered bool
+	recent    []int64
+}
+
+// NewFrameDuration creates it for the time base num/den, the fallback is used until there are two frames
+// (i.e. 1/fps for video and frame_size/sample_rate for audio).
+func NewFrameDuration(timeBaseNum, timeBaseDen int, fallback time.Duration) *FrameDuration {
+	return &FrameDuration{
+		timeBaseNum: int64(timeBaseNum),
+		timeBaseDen: int64(timeBaseDen),
+		current:     fallback,
+	}
+}
+
+// Next returns the duration of the frame at ts, it's the delta to the previous frame.
+// The previous duration is kept when the delta is not usable (reordered, repeated or discontinuous timestamps).
+func (d *FrameDuration) Next(ts int64) time.Duration {
+	if !d.hasLast {
+		d.last, d.hasLast = ts, true
+		d.recent = append(d.recent[:0], ts)
+		return d.current
+	}
+
+	delta := ts - d.last
+	// the MPEG-TS 33 bits timestamps rolled over
+	if delta < -MaxPTS33Bits/2 && delta+MaxPTS33Bits+1 > 0 {
+		delta += MaxPTS33Bits + 1
+	}
+	if delta == 0 || delta > d.maxTicks() || -delta > d.maxTicks() {
+		// repeated timestamp or discontinuity, the next delta starts from here
+		d.last = ts
+		d.recent = append(d.recent[:0], ts)
+		return d.current
+	}
+
+	if len(d.recent) == reorderWindow {
+		d.recent = d.recent[1:]
+	}
+	d.recent = append(d.recent, ts)
+	if delta < 0 {
+		d.reordered = true
+	} else {
+		// the last timestamp is kept when out of order, so that the next delta spans from the highest one
+		d.last = ts
+	}
+
+	if d.reordered {
+		if gap, ok := d.smallestRecentGap(); ok {
+			d.current = d.toDuration(gap)
+		}
+		return d.current
+	}
+	d.current = d.toDuration(delta)
+	return d.current
+}
+
+func (d *FrameDuration) smallestRecentGap() (int64, bool) {
+	sorted := append([]int64(nil), d.recent...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var smallest int64
+	for i := 1; i < len(sorted); i++ {
+		gap := sorted[i] - sorted[i-1]
+		if gap > 0 && (smallest == 0 || gap < smallest) {
+			smallest = gap
+		}
+	}
+	return smallest, smallest > 0
+}
+
+// Current returns the last duration, it's useful for the frames without timestamps.
+func (d *FrameDuration) Current() time.Duration {
+	return d.current
+}
+
+// Reset forgets the previous timestamps, the current duration is kept until there are two frames again.
+func (d *FrameDuration) Reset() {
+	d.hasLast = false
+	d.reordered = false
+	d.recent = d.recent[:0]
+}
+
+func (d *FrameDuration) toDuration(ticks int64) time.Duration {
+	return time.Duration(ticks * d.timeBaseNum * int64(time.Second) / d.timeBaseDen)
+}
+
+// maxTicks is the maxFrameDuration in the time base, it also keeps the conversion to nanoseconds from overflowing.
+func (d *FrameDuration) maxTicks() int64 {
+	if d.timeBaseNum <= 0 || d.timeBaseDen <= 0 {
+		return 0
+	}
+	return d.timeBaseDen * int64(maxFrameDuration/time.Second) / d.timeBaseNum
+}
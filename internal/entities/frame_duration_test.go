@@ -0,0 +1,110 @@
+package entities_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+// ticks converts the 90kHz timestamps
+func ticks(n int64) time.Duration {
+	return time.Duration(n) * time.Second / 90000
+}
+
+func nextDurations(d *entities.FrameDuration, timestamps ...int64) []time.Duration {
+	var durations []time.Duration
+	for _, ts := range timestamps {
+		durations = append(durations, d.Next(ts))
+	}
+	return durations
+}
+
+func TestFrameDuration_NTSCFrameRate(t *testing.T) {
+	t.Parallel()
+	d := entities.NewFrameDuration(1, 90000, time.Second/30)
+
+	durations := nextDurations(d, 0, 3003, 6006, 9009)
+
+	ntsc := ticks(3003)
+	assert.Equal(t, []time.Duration{time.Second / 30, ntsc, ntsc, ntsc}, durations)
+	assert.Equal(t, 33366666*time.Nanosecond, ntsc)
+}
+
+func TestFrameDuration_VariableFrameRate(t *testing.T) {
+	t.Parallel()
+	d := entities.NewFrameDuration(1, 1000, 40*time.Millisecond)
+
+	durations := nextDurations(d, 1000, 1033, 1100, 1116, 1216)
+
+	assert.Equal(t, []time.Duration{
+		40 * time.Millisecond, 33 * time.Millisecond, 67 * time.Millisecond, 16 * time.Millisecond, 100 * time.Millisecond,
+	}, durations)
+}
+
+func TestFrameDuration_Audio(t *testing.T) {
+	t.Parallel()
+	d := entities.NewFrameDuration(1, 48000, 20*time.Millisecond)
+
+	durations := nextDurations(d, 0, 960, 1920, 2880)
+
+	assert.Equal(t, []time.Duration{20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond}, durations)
+}
+
+func TestFrameDuration_ReorderedPresentationTimestamps(t *testing.T) {
+	t.Parallel()
+	d := entities.NewFrameDuration(1, 90000, 0)
+
+	// I P B B P B B P in decode order without decode timestamps
+	durations := nextDurations(d, 0, 9000, 3000, 6000, 18000, 12000, 15000, 27000)
+
+	// the first P is taken as a 3 frames gap until the B-frames show the reordering
+	assert.Equal(t, []time.Duration{
+		0, ticks(9000), ticks(3000), ticks(3000), ticks(3000), ticks(3000), ticks(3000), ticks(3000),
+	}, durations)
+}
+
+func TestFrameDuration_DecodeTimestampsWithBFrames(t *testing.T) {
+	t.Parallel()
+	d := entities.NewFrameDuration(1, 90000, 0)
+	frame := ticks(3000)
+
+	// the decode timestamps of I P B B are increasing even though the presentation ones are not
+	durations := nextDurations(d, -6000, -3000, 0, 3000)
+
+	assert.Equal(t, []time.Duration{0, frame, frame, frame}, durations)
+}
+
+func TestFrameDuration_MPEGTSWrapAround(t *testing.T) {
+	t.Parallel()
+	d := entities.NewFrameDuration(1, 90000, 0)
+	frame := ticks(3003)
+
+	durations := nextDurations(d, entities.MaxPTS33Bits-3003, entities.MaxPTS33Bits, 3002, 6005)
+
+	assert.Equal(t, []time.Duration{0, frame, frame, frame}, durations)
+}
+
+func TestFrameDuration_Discontinuity(t *testing.T) {
+	t.Parallel()
+	d := entities.NewFrameDuration(1, 90000, 0)
+	frame := ticks(3000)
+
+	// a jump forward of an hour, then the source restarts from zero
+	durations := nextDurations(d, 0, 3000, 3000+3600*90000, 6000+3600*90000, 0, 3000, 3000)
+
+	assert.Equal(t, []time.Duration{0, frame, frame, frame, frame, frame, frame}, durations)
+}
+
+func TestFrameDuration_Reset(t *testing.T) {
+	t.Parallel()
+	d := entities.NewFrameDuration(1, 1000, 0)
+
+	nextDurations(d, 0, 20)
+	d.Reset()
+
+	assert.Equal(t, 20*time.Millisecond, d.Next(5000))
+	assert.Equal(t, 23*time.Millisecond, d.Next(5023))
+	assert.Equal(t, 23*time.Millisecond, d.Current())
+}
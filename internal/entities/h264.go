@@ -1,5 +1,10 @@
 package entities
 
+import (
+	"encoding/hex"
+	"fmt"
+)
+
 type NALUs struct {
 	Units []NAL
 }
@@ -10,12 +15,12 @@ type NAL struct {
 	UnitType    NALUnitType
 	RBSPByte    []byte
 	HeaderBytes []byte
-	SEI
-}
+	// SEIs is only set for the SEI NALs, one NAL might carry several messages
+	SEIs []SEI
 
-type SEI struct {
-	PayloadType int
-	PayloadSize int
+	// SPS and PPS are only set for the parameter sets NALs
+	SPS *SPS
+	PPS *PPS
 }
 
 type NALUnitType byte
@@ -64,52 +69,131 @@ func (n *NAL) ParseRBSP() error {
 		if err != nil {
 			return err
 		}
+	case SequenceParameterSet:
+		sps, err := ParseSPS(n.RBSPByte)
+		if err != nil {
+			return err
+		}
+		n.SPS = sps
+	case PictureParameterSet:
+		pps, err := ParsePPS(n.RBSPByte)
+		if err != nil {
+			return err
+		}
+		n.PPS = pps
 	}
 
 	return nil
 }
 
 func (n *NAL) parseSEI() error {
-	numBits := 0
-	byteOffset := 0
-	n.SEI.PayloadType = 0
-	n.SEI.PayloadSize = 0
-	nextBits := n.RBSPByte[byteOffset]
-
-	for {
-		if nextBits == 0xff {
-			n.PayloadType += 255
-			numBits += 8
-			byteOffset += numBits / 8
-			numBits = numBits % 8
-			nextBits = n.RBSPByte[byteOffset]
-			continue
+	seis, err := parseSEIMessages(n.RBSPByte)
+	if err != nil {
+		return err
+	}
+	n.SEIs = seis
+	return nil
+}
+
+// IsKeyframe tells whether it's an IDR picture slice.
+func (n NAL) IsKeyframe() bool {
+	return n.UnitType == CodedSliceIDRPicture
+}
+
+func (n NAL) ITUTT35Payloads() [][]byte {
+	return itutT35Payloads(n.SEIs)
+}
+
+// H264ProfileLevelID is the profile-level-id fmtp parameter, the profile_idc, the constraint flags (profile-iop)
+// and the level_idc as found at the SPS.
+// ref https://datatracker.ietf.org/doc/html/rfc6184#section-8.1
+type H264ProfileLevelID struct {
+	ProfileIDC byte
+	ProfileIOP byte
+	LevelIDC   byte
+}
+
+func ParseH264ProfileLevelID(s string) (H264ProfileLevelID, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 3 {
+		return H264ProfileLevelID{}, fmt.Errorf("%w %q", ErrInvalidProfileLevelID, s)
+	}
+	return H264ProfileLevelID{ProfileIDC: b[0], ProfileIOP: b[1], LevelIDC: b[2]}, nil
+}
+
+func (p H264ProfileLevelID) String() string {
+	return hex.EncodeToString([]byte{p.ProfileIDC, p.ProfileIOP, p.LevelIDC})
+}
+
+type H264Profile string
+
+const (
+	H264ConstrainedBaseline H264Profile = "Constrained Baseline"
+	H264Baseline            H264Profile = "Baseline"
+	H264Main                H264Profile = "Main"
+	H264ConstrainedHigh     H264Profile = "Constrained High"
+	H264High                H264Profile = "High"
+)
+
+// the constraint flags patterns follow the ones used by the browsers (libwebrtc h264_profile_level_id)
+var h264ProfilePatterns = []struct {
+	profileIDC byte
+	mask       byte
+	value      byte
+	profile    H264Profile
+}{
+	{0x42, 0x4f, 0x40, H264ConstrainedBaseline}, // x1xx0000
+	{0x4d, 0x8f, 0x80, H264ConstrainedBaseline}, // 1xxx0000
+	{0x58, 0xcf, 0xc0, H264ConstrainedBaseline}, // 11xx0000
+	{0x42, 0x4f, 0x00, H264Baseline},            // x0xx0000
+	{0x58, 0xcf, 0x80, H264Baseline},            // 10xx0000
+	{0x4d, 0xaf, 0x00, H264Main},                // 0x0x0000
+	{0x64, 0xff, 0x00, H264High},
+	{0x64, 0xff, 0x0c, H264ConstrainedHigh},
+}
+
+// Profile returns the profile, it's empty for the ones not supported by WebRTC.
+func (p H264ProfileLevelID) Profile() H264Profile {
+	for _, pattern := range h264ProfilePatterns {
+		if p.ProfileIDC == pattern.profileIDC && p.ProfileIOP&pattern.mask == pattern.value {
+			return pattern.profile
 		}
-		break
 	}
+	return ""
+}
+
+// h264DecodableProfiles lists the stream profiles each decoder profile is able to decode
+var h264DecodableProfiles = map[H264Profile][]H264Profile{
+	H264ConstrainedBaseline: {H264ConstrainedBaseline},
+	H264Baseline:            {H264ConstrainedBaseline, H264Baseline},
+	H264Main:                {H264ConstrainedBaseline, H264Main},
+	H264ConstrainedHigh:     {H264ConstrainedBaseline, H264ConstrainedHigh},
+	H264High:                {H264ConstrainedBaseline, H264Main, H264ConstrainedHigh, H264High},
+}
 
-	n.PayloadType += int(nextBits)
-	numBits += 8
-	byteOffset += numBits / 8
-	numBits = numBits % 8
-	nextBits = n.RBSPByte[byteOffset]
-
-	// read size
-	for {
-		if nextBits == 0xff {
-			n.PayloadSize += 255
-			numBits += 8
-			byteOffset += numBits / 8
-			numBits = numBits % 8
-			nextBits = n.RBSPByte[byteOffset]
-			continue
+// DecodesProfile tells whether a decoder offering p is able to decode the stream profile, the level is not taken into account.
+func (p H264ProfileLevelID) DecodesProfile(stream H264ProfileLevelID) bool {
+	for _, profile := range h264DecodableProfiles[p.Profile()] {
+		if profile == stream.Profile() {
+			return true
 		}
-		break
 	}
+	return false
+}
 
-	n.PayloadSize += int(nextBits)
-	numBits += 8
-	byteOffset += numBits / 8
+// LevelAtMost tells whether the level is lower or equal to the max one.
+func (p H264ProfileLevelID) LevelAtMost(max H264ProfileLevelID) bool {
+	return p.levelOrder() <= max.levelOrder()
+}
 
-	return nil
+// levelOrder places the level 1b between the levels 1 and 1.1
+func (p H264ProfileLevelID) levelOrder() int {
+	// level 1b is signaled by the constraint_set3_flag for the baseline and main profiles,
+	// and by the level_idc 9 for the other ones
+	isLevel1bWithFlag := p.LevelIDC == 11 && p.ProfileIOP&0x10 != 0 &&
+		(p.ProfileIDC == 0x42 || p.ProfileIDC == 0x4d || p.ProfileIDC == 0x58)
+	if isLevel1bWithFlag || p.LevelIDC == 9 {
+		return 105
+	}
+	return int(p.LevelIDC) * 10
 }
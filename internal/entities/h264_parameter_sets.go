@@ -0,0 +1,400 @@
+package entities
+
+import "fmt"
+
+// SPS is the sequence parameter set, only the fields useful for streaming are kept.
+// Rec. ITU-T H.264 (08/2021) p.45
+type SPS struct {
+	ProfileIDC byte
+	// ConstraintFlags are the constraint_set0_flag to constraint_set5_flag (most significant bits first)
+	ConstraintFlags byte
+	LevelIDC        byte
+	ID              uint32
+
+	ChromaFormatIDC      uint32
+	SeparateColourPlane  bool
+	BitDepthLuma         uint32
+	BitDepthChroma       uint32
+	Log2MaxFrameNum      uint32
+	PicOrderCntType      uint32
+	MaxNumRefFrames      uint32
+	FrameMBsOnly         bool
+	PicWidthInMBs        uint32
+	PicHeightInMapUnits  uint32
+	FrameCropLeftOffset  uint32
+	FrameCropRightOffset uint32
+	FrameCropTopOffset   uint32
+	FrameCropBotOffset   uint32
+
+	// VUI is nil when the vui_parameters_present_flag is not set
+	VUI *VUI
+}
+
+// VUI is the video usability information, only the HRD fields needed by the picture timing SEI are kept
+// and the bitstream restrictions are skipped.
+// Rec. ITU-T H.264 (08/2021) p.444
+type VUI struct {
+	AspectRatioIDC          byte
+	SarWidth                uint32
+	SarHeight               uint32
+	VideoFullRange          bool
+	ColourPrimaries         byte
+	TransferCharacteristics byte
+	MatrixCoefficients      byte
+
+	TimingInfoPresent bool
+	NumUnitsInTick    uint32
+	TimeScale         uint32
+	FixedFrameRate    bool
+
+	NalHRDParametersPresent bool
+	VclHRDParametersPresent bool
+	CpbRemovalDelayLength   uint32
+	DpbOutputDelayLength    uint32
+	TimeOffsetLength        uint32
+	PicStructPresent        bool
+}
+
+// CpbDpbDelaysPresent tells whether the picture timing SEI has the cpb_removal_delay and dpb_output_delay.
+func (v *VUI) CpbDpbDelaysPresent() bool {
+	return v.NalHRDParametersPresent || v.VclHRDParametersPresent
+}
+
+// PPS is the picture parameter set.
+// Rec. ITU-T H.264 (08/2021) p.47
+type PPS struct {
+	ID                             uint32
+	SPSID                          uint32
+	EntropyCodingMode              bool
+	BottomFieldPicOrderInFrame     bool
+	NumSliceGroups                 uint32
+	NumRefIdxL0DefaultActive       uint32
+	NumRefIdxL1DefaultActive       uint32
+	WeightedPred                   bool
+	WeightedBipredIDC              uint32
+	PicInitQP                      int32
+	PicInitQS                      int32
+	ChromaQPIndexOffset            int32
+	DeblockingFilterControlPresent bool
+	ConstrainedIntraPred           bool
+	RedundantPicCntPresent         bool
+	Transform8x8Mode               bool
+	SecondChromaQPIndexOffset      int32
+}
+
+// ProfileLevelID returns the profile-level-id fmtp parameter.
+func (s *SPS) ProfileLevelID() H264ProfileLevelID {
+	return H264ProfileLevelID{ProfileIDC: s.ProfileIDC, ProfileIOP: s.ConstraintFlags, LevelIDC: s.LevelIDC}
+}
+
+// Width returns the width with the cropping applied.
+func (s *SPS) Width() int {
+	cropUnitX := uint32(1)
+	if s.chromaArrayType() != 0 {
+		cropUnitX = s.subWidthC()
+	}
+	return int(s.PicWidthInMBs)*16 - int(s.FrameCropLeftOffset+s.FrameCropRightOffset)*int(cropUnitX)
+}
+
+// Height returns the height of the frame (both fields when interlaced) with the cropping applied.
+func (s *SPS) Height() int {
+	frameHeightFactor := uint32(2)
+	if s.FrameMBsOnly {
+		frameHeightFactor = 1
+	}
+	cropUnitY := frameHeightFactor
+	if s.chromaArrayType() != 0 {
+		cropUnitY *= s.subHeightC()
+	}
+	return int(frameHeightFactor*s.PicHeightInMapUnits)*16 - int(s.FrameCropTopOffset+s.FrameCropBotOffset)*int(cropUnitY)
+}
+
+// FrameRate returns the frame rate from the VUI timing info, it's zero when absent.
+// The time scale counts fields, hence the two ticks per frame.
+func (s *SPS) FrameRate() float64 {
+	if s.VUI == nil || !s.VUI.TimingInfoPresent || s.VUI.NumUnitsInTick == 0 {
+		return 0
+	}
+	return float64(s.VUI.TimeScale) / float64(2*s.VUI.NumUnitsInTick)
+}
+
+func (s *SPS) chromaArrayType() uint32 {
+	if s.SeparateColourPlane {
+		return 0
+	}
+	return s.ChromaFormatIDC
+}
+
+// Rec. ITU-T H.264 (08/2021) p.24 table 6-1
+func (s *SPS) subWidthC() uint32 {
+	if s.ChromaFormatIDC == 3 {
+		return 1
+	}
+	return 2
+}
+
+func (s *SPS) subHeightC() uint32 {
+	if s.ChromaFormatIDC == 1 {
+		return 2
+	}
+	return 1
+}
+
+// the profiles having the chroma format and bit depth fields
+var h264HighProfiles = map[byte]bool{100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true, 118: true, 128: true, 138: true, 139: true, 134: true, 135: true}
+
+// ParseSPS parses the RBSP of a sequence parameter set NAL (without the header).
+func ParseSPS(rbsp []byte) (*SPS, error) {
+	r := newBitReader(rbsp)
+	s := &SPS{
+		ProfileIDC:      byte(r.u(8)),
+		ConstraintFlags: byte(r.u(8)),
+		LevelIDC:        byte(r.u(8)),
+		ID:              r.ue(),
+		ChromaFormatIDC: 1,
+		BitDepthLuma:    8,
+		BitDepthChroma:  8,
+	}
+
+	if h264HighProfiles[s.ProfileIDC] {
+		s.ChromaFormatIDC = r.ue()
+		if s.ChromaFormatIDC == 3 {
+			s.SeparateColourPlane = r.flag()
+		}
+		s.BitDepthLuma = r.ue() + 8
+		s.BitDepthChroma = r.ue() + 8
+		// qpprime_y_zero_transform_bypass_flag
+		r.u(1)
+		// seq_scaling_matrix_present_flag
+		if r.flag() {
+			lists := 8
+			if s.ChromaFormatIDC == 3 {
+				lists = 12
+			}
+			skipScalingLists(r, lists)
+		}
+	}
+
+	s.Log2MaxFrameNum = r.ue() + 4
+	s.PicOrderCntType = r.ue()
+	if s.PicOrderCntType == 0 {
+		// log2_max_pic_order_cnt_lsb_minus4
+		r.ue()
+	} else if s.PicOrderCntType == 1 {
+		// delta_pic_order_always_zero_flag, offset_for_non_ref_pic, offset_for_top_to_bottom_field
+		r.u(1)
+		r.se()
+		r.se()
+		numRefFramesInPicOrderCntCycle := r.ue()
+		for i := uint32(0); i < numRefFramesInPicOrderCntCycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+
+	s.MaxNumRefFrames = r.ue()
+	// gaps_in_frame_num_value_allowed_flag
+	r.u(1)
+	s.PicWidthInMBs = r.ue() + 1
+	s.PicHeightInMapUnits = r.ue() + 1
+	s.FrameMBsOnly = r.flag()
+	if !s.FrameMBsOnly {
+		// mb_adaptive_frame_field_flag
+		r.u(1)
+	}
+	// direct_8x8_inference_flag
+	r.u(1)
+	if r.flag() {
+		s.FrameCropLeftOffset = r.ue()
+		s.FrameCropRightOffset = r.ue()
+		s.FrameCropTopOffset = r.ue()
+		s.FrameCropBotOffset = r.ue()
+	}
+	if r.flag() {
+		s.VUI = parseVUI(r)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("sps: %w", r.err)
+	}
+	if s.Width() <= 0 || s.Height() <= 0 {
+		return nil, fmt.Errorf("sps: %w cropping is bigger than the picture", ErrInvalidBitstream)
+	}
+	return s, nil
+}
+
+// Rec. ITU-T H.264 (08/2021) p.444
+func parseVUI(r *bitReader) *VUI {
+	v := &VUI{}
+	// aspect_ratio_info_present_flag
+	if r.flag() {
+		v.AspectRatioIDC = byte(r.u(8))
+		// Extended_SAR
+		if v.AspectRatioIDC == 255 {
+			v.SarWidth = r.u(16)
+			v.SarHeight = r.u(16)
+		}
+	}
+	// overscan_info_present_flag
+	if r.flag() {
+		r.u(1)
+	}
+	// video_signal_type_present_flag
+	if r.flag() {
+		// video_format
+		r.u(3)
+		v.VideoFullRange = r.flag()
+		// colour_description_present_flag
+		if r.flag() {
+			v.ColourPrimaries = byte(r.u(8))
+			v.TransferCharacteristics = byte(r.u(8))
+			v.MatrixCoefficients = byte(r.u(8))
+		}
+	}
+	// chroma_loc_info_present_flag
+	if r.flag() {
+		r.ue()
+		r.ue()
+	}
+	v.TimingInfoPresent = r.flag()
+	if v.TimingInfoPresent {
+		v.NumUnitsInTick = r.u(32)
+		v.TimeScale = r.u(32)
+		v.FixedFrameRate = r.flag()
+	}
+	v.NalHRDParametersPresent = r.flag()
+	if v.NalHRDParametersPresent {
+		parseHRD(r, v)
+	}
+	v.VclHRDParametersPresent = r.flag()
+	if v.VclHRDParametersPresent {
+		parseHRD(r, v)
+	}
+	if v.CpbDpbDelaysPresent() {
+		// low_delay_hrd_flag
+		r.u(1)
+	}
+	v.PicStructPresent = r.flag()
+	return v
+}
+
+// parseHRD skips the CPB specifications and keeps the lengths of the picture timing SEI fields.
+// Rec. ITU-T H.264 (08/2021) p.447
+func parseHRD(r *bitReader, v *VUI) {
+	cpbCnt := r.ue() + 1
+	// bit_rate_scale and cpb_size_scale
+	r.u(4)
+	r.u(4)
+	for i := uint32(0); i < cpbCnt && r.err == nil; i++ {
+		// bit_rate_value_minus1, cpb_size_value_minus1 and cbr_flag
+		r.ue()
+		r.ue()
+		r.u(1)
+	}
+	// initial_cpb_removal_delay_length_minus1
+	r.u(5)
+	v.CpbRemovalDelayLength = r.u(5) + 1
+	v.DpbOutputDelayLength = r.u(5) + 1
+	v.TimeOffsetLength = r.u(5)
+}
+
+// skipScalingLists skips the scaling_list() syntax, the default and flat lists are good enough for us.
+// Rec. ITU-T H.264 (08/2021) p.46
+func skipScalingLists(r *bitReader, lists int) {
+	for i := 0; i < lists && r.err == nil; i++ {
+		// scaling_list_present_flag
+		if !r.flag() {
+			continue
+		}
+		size := 16
+		if i >= 6 {
+			size = 64
+		}
+		lastScale, nextScale := int32(8), int32(8)
+		for j := 0; j < size && r.err == nil; j++ {
+			if nextScale != 0 {
+				nextScale = (lastScale + r.se() + 256) % 256
+			}
+			if nextScale != 0 {
+				lastScale = nextScale
+			}
+		}
+	}
+}
+
+// ParsePPS parses the RBSP of a picture parameter set NAL (without the header).
+// The chroma format is assumed to be 4:2:0 when reading the 8x8 scaling lists.
+func ParsePPS(rbsp []byte) (*PPS, error) {
+	r := newBitReader(rbsp)
+	p := &PPS{
+		ID:                         r.ue(),
+		SPSID:                      r.ue(),
+		EntropyCodingMode:          r.flag(),
+		BottomFieldPicOrderInFrame: r.flag(),
+		NumSliceGroups:             r.ue() + 1,
+	}
+
+	if p.NumSliceGroups > 1 {
+		skipSliceGroups(r, p.NumSliceGroups)
+	}
+
+	p.NumRefIdxL0DefaultActive = r.ue() + 1
+	p.NumRefIdxL1DefaultActive = r.ue() + 1
+	p.WeightedPred = r.flag()
+	p.WeightedBipredIDC = r.u(2)
+	p.PicInitQP = 26 + r.se()
+	p.PicInitQS = 26 + r.se()
+	p.ChromaQPIndexOffset = r.se()
+	p.DeblockingFilterControlPresent = r.flag()
+	p.ConstrainedIntraPred = r.flag()
+	p.RedundantPicCntPresent = r.flag()
+	p.SecondChromaQPIndexOffset = p.ChromaQPIndexOffset
+
+	if r.moreRBSPData() {
+		p.Transform8x8Mode = r.flag()
+		// pic_scaling_matrix_present_flag
+		if r.flag() {
+			lists := 6
+			if p.Transform8x8Mode {
+				lists += 2
+			}
+			skipScalingLists(r, lists)
+		}
+		p.SecondChromaQPIndexOffset = r.se()
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("pps: %w", r.err)
+	}
+	return p, nil
+}
+
+// skipSliceGroups skips the flexible macroblock ordering fields, only allowed by the baseline and extended profiles.
+func skipSliceGroups(r *bitReader, numSliceGroups uint32) {
+	sliceGroupMapType := r.ue()
+	switch sliceGroupMapType {
+	case 0:
+		// run_length_minus1
+		for i := uint32(0); i < numSliceGroups && r.err == nil; i++ {
+			r.ue()
+		}
+	case 2:
+		// top_left and bottom_right
+		for i := uint32(0); i < numSliceGroups-1 && r.err == nil; i++ {
+			r.ue()
+			r.ue()
+		}
+	case 3, 4, 5:
+		// slice_group_change_direction_flag and slice_group_change_rate_minus1
+		r.u(1)
+		r.ue()
+	case 6:
+		picSizeInMapUnits := r.ue() + 1
+		bits := 0
+		for (uint32(1) << bits) < numSliceGroups {
+			bits++
+		}
+		for i := uint32(0); i < picSizeInMapUnits && r.err == nil; i++ {
+			r.u(bits)
+		}
+	}
+}
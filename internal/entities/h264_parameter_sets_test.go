@@ -0,0 +1,143 @@
+package entities_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSPS_HighWithTiming(t *testing.T) {
+	t.Parallel()
+	// libx264 1280x720 30fps
+	nal, err := controllers.ParseNAL([]byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00,
+		0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60,
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, nal.SPS)
+	sps := nal.SPS
+	assert.Equal(t, byte(100), sps.ProfileIDC)
+	assert.Equal(t, byte(31), sps.LevelIDC)
+	assert.Equal(t, uint32(1), sps.ChromaFormatIDC)
+	assert.Equal(t, uint32(8), sps.BitDepthLuma)
+	assert.Equal(t, uint32(4), sps.MaxNumRefFrames)
+	assert.True(t, sps.FrameMBsOnly)
+	assert.Equal(t, 1280, sps.Width())
+	assert.Equal(t, 720, sps.Height())
+	assert.NotNil(t, sps.VUI)
+	assert.Equal(t, byte(1), sps.VUI.AspectRatioIDC)
+	assert.Equal(t, uint32(1), sps.VUI.NumUnitsInTick)
+	assert.Equal(t, uint32(60), sps.VUI.TimeScale)
+	assert.Equal(t, float64(30), sps.FrameRate())
+	assert.Equal(t, "64001f", sps.ProfileLevelID().String())
+	assert.Equal(t, entities.H264High, sps.ProfileLevelID().Profile())
+}
+
+func TestParseSPS_HighWithCropping(t *testing.T) {
+	t.Parallel()
+	// libx264 640x360 (368 coded lines) 29.97fps
+	nal, err := controllers.ParseNAL([]byte{
+		0x67, 0x64, 0x00, 0x1e, 0xac, 0xd9, 0x40, 0xa0, 0x2f, 0xf9, 0x70, 0x11, 0x00, 0x00, 0x03, 0x03,
+		0xe9, 0x00, 0x00, 0xea, 0x60, 0x0f, 0x16, 0x2d, 0x96,
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, nal.SPS)
+	sps := nal.SPS
+	assert.Equal(t, uint32(40), sps.PicWidthInMBs)
+	assert.Equal(t, uint32(23), sps.PicHeightInMapUnits)
+	assert.Equal(t, uint32(4), sps.FrameCropBotOffset)
+	assert.Equal(t, 640, sps.Width())
+	assert.Equal(t, 360, sps.Height())
+	assert.Equal(t, uint32(1001), sps.VUI.NumUnitsInTick)
+	assert.Equal(t, uint32(60000), sps.VUI.TimeScale)
+	assert.InDelta(t, 29.97, sps.FrameRate(), 0.001)
+}
+
+func TestParseSPS_BaselineWithColourDescription(t *testing.T) {
+	t.Parallel()
+	// IP camera 640x480 baseline without timing info
+	nal, err := controllers.ParseNAL([]byte{
+		0x67, 0x42, 0x00, 0x29, 0xe2, 0x90, 0x14, 0x07, 0xb6, 0x02, 0xdc, 0x04, 0x04, 0x06, 0x90, 0x78,
+		0x91, 0x15,
+	})
+
+	assert.Nil(t, err)
+	sps := nal.SPS
+	assert.Equal(t, byte(66), sps.ProfileIDC)
+	assert.Equal(t, byte(41), sps.LevelIDC)
+	assert.Equal(t, 640, sps.Width())
+	assert.Equal(t, 480, sps.Height())
+	assert.True(t, sps.VUI.VideoFullRange)
+	assert.Equal(t, byte(1), sps.VUI.ColourPrimaries)
+	assert.Equal(t, byte(1), sps.VUI.TransferCharacteristics)
+	assert.Equal(t, byte(1), sps.VUI.MatrixCoefficients)
+	assert.False(t, sps.VUI.TimingInfoPresent)
+	assert.Equal(t, float64(0), sps.FrameRate())
+	assert.Equal(t, entities.H264Baseline, sps.ProfileLevelID().Profile())
+}
+
+func TestParseSPS_ConstrainedBaseline(t *testing.T) {
+	t.Parallel()
+	// WebRTC 320x240
+	nal, err := controllers.ParseNAL([]byte{
+		0x67, 0x42, 0xc0, 0x0d, 0x8c, 0x8d, 0x40, 0xa0, 0xfd, 0x00, 0xf0, 0x88, 0x46, 0xa0,
+	})
+
+	assert.Nil(t, err)
+	sps := nal.SPS
+	assert.Equal(t, byte(0xc0), sps.ConstraintFlags)
+	assert.Equal(t, uint32(15), sps.Log2MaxFrameNum)
+	assert.Equal(t, 320, sps.Width())
+	assert.Equal(t, 240, sps.Height())
+	assert.Equal(t, "42c00d", sps.ProfileLevelID().String())
+	assert.Equal(t, entities.H264ConstrainedBaseline, sps.ProfileLevelID().Profile())
+}
+
+func TestParseSPS_Truncated(t *testing.T) {
+	t.Parallel()
+
+	sps, err := entities.ParseSPS([]byte{0x64, 0x00, 0x1f, 0xac, 0xd9})
+
+	assert.Nil(t, sps)
+	assert.ErrorIs(t, err, entities.ErrInvalidBitstream)
+}
+
+func TestParsePPS_High(t *testing.T) {
+	t.Parallel()
+	// libx264 high profile defaults
+	nal, err := controllers.ParseNAL([]byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, nal.PPS)
+	pps := nal.PPS
+	assert.Equal(t, uint32(0), pps.ID)
+	assert.Equal(t, uint32(0), pps.SPSID)
+	assert.True(t, pps.EntropyCodingMode)
+	assert.Equal(t, uint32(1), pps.NumSliceGroups)
+	assert.Equal(t, uint32(3), pps.NumRefIdxL0DefaultActive)
+	assert.True(t, pps.WeightedPred)
+	assert.Equal(t, uint32(2), pps.WeightedBipredIDC)
+	assert.Equal(t, int32(23), pps.PicInitQP)
+	assert.Equal(t, int32(-2), pps.ChromaQPIndexOffset)
+	assert.True(t, pps.DeblockingFilterControlPresent)
+	assert.True(t, pps.Transform8x8Mode)
+	assert.Equal(t, int32(-2), pps.SecondChromaQPIndexOffset)
+}
+
+func TestParsePPS_Baseline(t *testing.T) {
+	t.Parallel()
+
+	nal, err := controllers.ParseNAL([]byte{0x68, 0x1a, 0x34, 0xe3, 0xc8})
+
+	assert.Nil(t, err)
+	pps := nal.PPS
+	assert.Equal(t, uint32(12), pps.ID)
+	assert.Equal(t, uint32(12), pps.SPSID)
+	assert.False(t, pps.EntropyCodingMode)
+	assert.False(t, pps.Transform8x8Mode)
+	assert.Equal(t, int32(26), pps.PicInitQP)
+}
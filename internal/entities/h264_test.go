@@ -0,0 +1,142 @@
+package entities_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseH264ProfileLevelID(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		value    string
+		expected entities.H264ProfileLevelID
+		err      bool
+	}{
+		{name: "constrained baseline 3.1", value: "42e01f", expected: entities.H264ProfileLevelID{ProfileIDC: 0x42, ProfileIOP: 0xe0, LevelIDC: 0x1f}},
+		{name: "upper case", value: "640C1F", expected: entities.H264ProfileLevelID{ProfileIDC: 0x64, ProfileIOP: 0x0c, LevelIDC: 0x1f}},
+		{name: "empty", value: "", err: true},
+		{name: "too short", value: "42e0", err: true},
+		{name: "too long", value: "42e01f00", err: true},
+		{name: "not hex", value: "42e0zz", err: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p, err := entities.ParseH264ProfileLevelID(tt.value)
+			if tt.err {
+				assert.True(t, errors.Is(err, entities.ErrInvalidProfileLevelID))
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, p)
+		})
+	}
+}
+
+func TestH264ProfileLevelID_Profile(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value    string
+		expected entities.H264Profile
+	}{
+		{value: "42e01f", expected: entities.H264ConstrainedBaseline},
+		{value: "42401f", expected: entities.H264ConstrainedBaseline},
+		{value: "42c01f", expected: entities.H264ConstrainedBaseline},
+		{value: "4d801f", expected: entities.H264ConstrainedBaseline},
+		{value: "58c01f", expected: entities.H264ConstrainedBaseline},
+		{value: "42001f", expected: entities.H264Baseline},
+		{value: "42a01f", expected: entities.H264Baseline},
+		{value: "58801f", expected: entities.H264Baseline},
+		{value: "4d001f", expected: entities.H264Main},
+		{value: "4d401f", expected: entities.H264Main},
+		{value: "64001f", expected: entities.H264High},
+		{value: "640c1f", expected: entities.H264ConstrainedHigh},
+		// the lower constraint flags are reserved
+		{value: "42e11f", expected: ""},
+		// high 10
+		{value: "6e001f", expected: ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.value, func(t *testing.T) {
+			t.Parallel()
+			p, err := entities.ParseH264ProfileLevelID(tt.value)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, p.Profile())
+		})
+	}
+}
+
+func TestH264ProfileLevelID_DecodesProfile(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		decoder  string
+		stream   string
+		expected bool
+	}{
+		{decoder: "42e01f", stream: "42e01f", expected: true},
+		{decoder: "42e01f", stream: "42001f", expected: false},
+		{decoder: "42e01f", stream: "4d001f", expected: false},
+		{decoder: "42001f", stream: "42e01f", expected: true},
+		{decoder: "42001f", stream: "42001f", expected: true},
+		{decoder: "4d001f", stream: "42e01f", expected: true},
+		{decoder: "4d001f", stream: "42001f", expected: false},
+		{decoder: "4d001f", stream: "64001f", expected: false},
+		{decoder: "640c1f", stream: "640c1f", expected: true},
+		{decoder: "640c1f", stream: "64001f", expected: false},
+		{decoder: "64001f", stream: "4d001f", expected: true},
+		{decoder: "64001f", stream: "640c1f", expected: true},
+		{decoder: "64001f", stream: "42001f", expected: false},
+		// the level isn't taken into account
+		{decoder: "42e00a", stream: "42e033", expected: true},
+		{decoder: "6e001f", stream: "6e001f", expected: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.decoder+" "+tt.stream, func(t *testing.T) {
+			t.Parallel()
+			decoder, err := entities.ParseH264ProfileLevelID(tt.decoder)
+			assert.Nil(t, err)
+			stream, err := entities.ParseH264ProfileLevelID(tt.stream)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, decoder.DecodesProfile(stream))
+		})
+	}
+}
+
+func TestH264ProfileLevelID_LevelAtMost(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		level    string
+		max      string
+		expected bool
+	}{
+		{name: "same level", level: "42e01f", max: "42e01f", expected: true},
+		{name: "lower level", level: "42e01e", max: "42e01f", expected: true},
+		{name: "higher level", level: "42e020", max: "42e01f", expected: false},
+		{name: "the profile isn't taken into account", level: "64001f", max: "42e01f", expected: true},
+		{name: "1 below 1b", level: "42e00a", max: "42f00b", expected: true},
+		{name: "1b with the constraint flag below 1.1", level: "42f00b", max: "42e00b", expected: true},
+		{name: "1.1 above 1b with the constraint flag", level: "42e00b", max: "42f00b", expected: false},
+		{name: "1b with the level 9 below 1.1", level: "640009", max: "64000b", expected: true},
+		{name: "1b with the level 9 above 1", level: "640009", max: "64000a", expected: false},
+		{name: "the constraint flag isn't 1b for the high profile", level: "64100b", max: "640009", expected: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			level, err := entities.ParseH264ProfileLevelID(tt.level)
+			assert.Nil(t, err)
+			max, err := entities.ParseH264ProfileLevelID(tt.max)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, level.LevelAtMost(max))
+		})
+	}
+}
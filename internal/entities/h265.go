@@ -0,0 +1,249 @@
+package entities
+
+import "fmt"
+
+// H265NAL is a HEVC NAL unit, it has a 2 bytes header.
+// Rec. ITU-T H.265 (08/2021) p.66
+type H265NAL struct {
+	UnitType    H265NALUnitType
+	LayerID     byte
+	TemporalID  byte
+	RBSPByte    []byte
+	HeaderBytes []byte
+	// SEIs is only set for the prefix and suffix SEI NALs
+	SEIs []SEI
+
+	// VPS, SPS and PPS are only set for the parameter sets NALs
+	VPS *H265VPS
+	SPS *H265SPS
+	PPS *H265PPS
+}
+
+type H265NALUnitType byte
+
+const (
+	// Rec. ITU-T H.265 (08/2021) p.68
+	H265TrailN       = H265NALUnitType(0)
+	H265TrailR       = H265NALUnitType(1)
+	H265TSAN         = H265NALUnitType(2)
+	H265TSAR         = H265NALUnitType(3)
+	H265STSAN        = H265NALUnitType(4)
+	H265STSAR        = H265NALUnitType(5)
+	H265RADLN        = H265NALUnitType(6)
+	H265RADLR        = H265NALUnitType(7)
+	H265RASLN        = H265NALUnitType(8)
+	H265RASLR        = H265NALUnitType(9)
+	H265BLAWLP       = H265NALUnitType(16)
+	H265BLAWRADL     = H265NALUnitType(17)
+	H265BLANLP       = H265NALUnitType(18)
+	H265IDRWRADL     = H265NALUnitType(19)
+	H265IDRNLP       = H265NALUnitType(20)
+	H265CRA          = H265NALUnitType(21)
+	H265VPSNUT       = H265NALUnitType(32)
+	H265SPSNUT       = H265NALUnitType(33)
+	H265PPSNUT       = H265NALUnitType(34)
+	H265AUD          = H265NALUnitType(35)
+	H265EOS          = H265NALUnitType(36)
+	H265EOB          = H265NALUnitType(37)
+	H265FD           = H265NALUnitType(38)
+	H265PrefixSEINUT = H265NALUnitType(39)
+	H265SuffixSEINUT = H265NALUnitType(40)
+)
+
+// H265ProfileTierLevel only keeps the general profile, tier and level.
+// Rec. ITU-T H.265 (08/2021) p.51
+type H265ProfileTierLevel struct {
+	ProfileSpace           byte
+	Tier                   byte
+	ProfileIDC             byte
+	ProfileCompatibilities uint32
+	// LevelIDC is 30 times the level (i.e. 93 for 3.1)
+	LevelIDC byte
+}
+
+// Rec. ITU-T H.265 (08/2021) p.42
+type H265VPS struct {
+	ID               byte
+	MaxLayers        byte
+	MaxSubLayers     byte
+	ProfileTierLevel H265ProfileTierLevel
+}
+
+// H265SPS only keeps the fields up to the bit depth.
+// Rec. ITU-T H.265 (08/2021) p.45
+type H265SPS struct {
+	VPSID            byte
+	MaxSubLayers     byte
+	ProfileTierLevel H265ProfileTierLevel
+	ID               uint32
+
+	ChromaFormatIDC        uint32
+	SeparateColourPlane    bool
+	PicWidthInLumaSamples  uint32
+	PicHeightInLumaSamples uint32
+	ConfWinLeftOffset      uint32
+	ConfWinRightOffset     uint32
+	ConfWinTopOffset       uint32
+	ConfWinBottomOffset    uint32
+	BitDepthLuma           uint32
+	BitDepthChroma         uint32
+}
+
+// H265PPS only keeps the identifiers.
+type H265PPS struct {
+	ID    uint32
+	SPSID uint32
+}
+
+// IsKeyframe tells whether it's an intra random access point (IRAP) picture slice.
+func (n H265NAL) IsKeyframe() bool {
+	return n.UnitType >= H265BLAWLP && n.UnitType <= H265CRA
+}
+
+func (n H265NAL) ITUTT35Payloads() [][]byte {
+	return itutT35Payloads(n.SEIs)
+}
+
+func (n *H265NAL) ParseRBSP() error {
+	var err error
+	switch n.UnitType {
+	case H265PrefixSEINUT, H265SuffixSEINUT:
+		n.SEIs, err = parseSEIMessages(n.RBSPByte)
+	case H265VPSNUT:
+		n.VPS, err = ParseH265VPS(n.RBSPByte)
+	case H265SPSNUT:
+		n.SPS, err = ParseH265SPS(n.RBSPByte)
+	case H265PPSNUT:
+		n.PPS, err = ParseH265PPS(n.RBSPByte)
+	}
+	return err
+}
+
+// Width returns the width with the conformance window applied.
+func (s *H265SPS) Width() int {
+	return int(s.PicWidthInLumaSamples) - int(s.ConfWinLeftOffset+s.ConfWinRightOffset)*int(s.subWidthC())
+}
+
+// Height returns the height with the conformance window applied.
+func (s *H265SPS) Height() int {
+	return int(s.PicHeightInLumaSamples) - int(s.ConfWinTopOffset+s.ConfWinBottomOffset)*int(s.subHeightC())
+}
+
+// Rec. ITU-T H.265 (08/2021) p.27 table 6-1
+func (s *H265SPS) subWidthC() uint32 {
+	if s.ChromaFormatIDC == 1 || s.ChromaFormatIDC == 2 {
+		return 2
+	}
+	return 1
+}
+
+func (s *H265SPS) subHeightC() uint32 {
+	if s.ChromaFormatIDC == 1 {
+		return 2
+	}
+	return 1
+}
+
+func ParseH265VPS(rbsp []byte) (*H265VPS, error) {
+	r := newBitReader(rbsp)
+	v := &H265VPS{}
+	v.ID = byte(r.u(4))
+	// vps_base_layer_internal_flag and vps_base_layer_available_flag
+	r.u(2)
+	v.MaxLayers = byte(r.u(6)) + 1
+	v.MaxSubLayers = byte(r.u(3)) + 1
+	// vps_temporal_id_nesting_flag and vps_reserved_0xffff_16bits
+	r.u(1)
+	r.u(16)
+	v.ProfileTierLevel = parseH265ProfileTierLevel(r, v.MaxSubLayers-1)
+
+	if r.err != nil {
+		return nil, fmt.Errorf("h265 vps: %w", r.err)
+	}
+	return v, nil
+}
+
+func ParseH265SPS(rbsp []byte) (*H265SPS, error) {
+	r := newBitReader(rbsp)
+	s := &H265SPS{}
+	s.VPSID = byte(r.u(4))
+	s.MaxSubLayers = byte(r.u(3)) + 1
+	// sps_temporal_id_nesting_flag
+	r.u(1)
+	s.ProfileTierLevel = parseH265ProfileTierLevel(r, s.MaxSubLayers-1)
+	s.ID = r.ue()
+	s.ChromaFormatIDC = r.ue()
+	if s.ChromaFormatIDC == 3 {
+		s.SeparateColourPlane = r.flag()
+	}
+	s.PicWidthInLumaSamples = r.ue()
+	s.PicHeightInLumaSamples = r.ue()
+	// conformance_window_flag
+	if r.flag() {
+		s.ConfWinLeftOffset = r.ue()
+		s.ConfWinRightOffset = r.ue()
+		s.ConfWinTopOffset = r.ue()
+		s.ConfWinBottomOffset = r.ue()
+	}
+	s.BitDepthLuma = r.ue() + 8
+	s.BitDepthChroma = r.ue() + 8
+
+	if r.err != nil {
+		return nil, fmt.Errorf("h265 sps: %w", r.err)
+	}
+	if s.Width() <= 0 || s.Height() <= 0 {
+		return nil, fmt.Errorf("h265 sps: %w conformance window is bigger than the picture", ErrInvalidBitstream)
+	}
+	return s, nil
+}
+
+func ParseH265PPS(rbsp []byte) (*H265PPS, error) {
+	r := newBitReader(rbsp)
+	p := &H265PPS{
+		ID:    r.ue(),
+		SPSID: r.ue(),
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("h265 pps: %w", r.err)
+	}
+	return p, nil
+}
+
+// Rec. ITU-T H.265 (08/2021) p.51
+func parseH265ProfileTierLevel(r *bitReader, maxSubLayersMinus1 byte) H265ProfileTierLevel {
+	ptl := H265ProfileTierLevel{
+		ProfileSpace:           byte(r.u(2)),
+		Tier:                   byte(r.u(1)),
+		ProfileIDC:             byte(r.u(5)),
+		ProfileCompatibilities: r.u(32),
+	}
+	// progressive, interlaced, non packed and frame only flags followed by 43 bits of constraints and 1 bit
+	r.u(16)
+	r.u(32)
+	ptl.LevelIDC = byte(r.u(8))
+
+	subLayerProfilePresent := make([]bool, maxSubLayersMinus1)
+	subLayerLevelPresent := make([]bool, maxSubLayersMinus1)
+	for i := range subLayerProfilePresent {
+		subLayerProfilePresent[i] = r.flag()
+		subLayerLevelPresent[i] = r.flag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		// reserved_zero_2bits
+		for i := maxSubLayersMinus1; i < 8; i++ {
+			r.u(2)
+		}
+	}
+	for i := range subLayerProfilePresent {
+		if subLayerProfilePresent[i] {
+			// sub layer profile space to the constraint flags (88 bits)
+			r.u(32)
+			r.u(32)
+			r.u(24)
+		}
+		if subLayerLevelPresent[i] {
+			r.u(8)
+		}
+	}
+	return ptl
+}
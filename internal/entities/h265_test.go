@@ -0,0 +1,130 @@
+package entities_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+// main profile level 4 (level_idc 120) parameter sets without their NAL header and emulation prevention
+var (
+	h265VPSRBSP = []byte{0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x78, 0x80}
+	// 1920x1088 cropped to 1920x1080
+	h265SPSRBSP = []byte{
+		0x01, 0x01, 0x60, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x78, 0xa0, 0x03, 0xc0,
+		0x80, 0x11, 0x07, 0xcb, 0xc0,
+	}
+)
+
+func TestParseH265VPS(t *testing.T) {
+	t.Parallel()
+	vps, err := entities.ParseH265VPS(h265VPSRBSP)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &entities.H265VPS{
+		MaxLayers:    1,
+		MaxSubLayers: 1,
+		ProfileTierLevel: entities.H265ProfileTierLevel{
+			ProfileIDC:             1,
+			ProfileCompatibilities: 0x60000000,
+			LevelIDC:               120,
+		},
+	}, vps)
+}
+
+func TestParseH265SPS(t *testing.T) {
+	t.Parallel()
+	sps, err := entities.ParseH265SPS(h265SPSRBSP)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &entities.H265SPS{
+		MaxSubLayers: 1,
+		ProfileTierLevel: entities.H265ProfileTierLevel{
+			ProfileIDC:             1,
+			ProfileCompatibilities: 0x60000000,
+			LevelIDC:               120,
+		},
+		ChromaFormatIDC:        1,
+		PicWidthInLumaSamples:  1920,
+		PicHeightInLumaSamples: 1088,
+		ConfWinBottomOffset:    4,
+		BitDepthLuma:           8,
+		BitDepthChroma:         8,
+	}, sps)
+	assert.Equal(t, 1920, sps.Width())
+	assert.Equal(t, 1080, sps.Height())
+}
+
+func TestParseH265_Invalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		parse func([]byte) error
+		rbsp  []byte
+	}{
+		{
+			name:  "truncated vps",
+			parse: func(rbsp []byte) error { _, err := entities.ParseH265VPS(rbsp); return err },
+			rbsp:  h265VPSRBSP[:12],
+		},
+		{
+			name:  "truncated sps profile tier level",
+			parse: func(rbsp []byte) error { _, err := entities.ParseH265SPS(rbsp); return err },
+			rbsp:  h265SPSRBSP[:10],
+		},
+		{
+			name:  "truncated sps picture size",
+			parse: func(rbsp []byte) error { _, err := entities.ParseH265SPS(rbsp); return err },
+			rbsp:  h265SPSRBSP[:15],
+		},
+		{
+			// 16x16 with a conformance window right offset of 8 (16 luma samples)
+			name:  "sps conformance window bigger than the picture",
+			parse: func(rbsp []byte) error { _, err := entities.ParseH265SPS(rbsp); return err },
+			rbsp:  []byte{0x01, 0x01, 0x60, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x78, 0xa0, 0x88, 0x47, 0x13, 0xf0},
+		},
+		{
+			name:  "empty pps",
+			parse: func(rbsp []byte) error { _, err := entities.ParseH265PPS(rbsp); return err },
+		},
+		{
+			name:  "pps exp-Golomb longer than 32 bits",
+			parse: func(rbsp []byte) error { _, err := entities.ParseH265PPS(rbsp); return err },
+			rbsp:  []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.ErrorIs(t, tt.parse(tt.rbsp), entities.ErrInvalidBitstream)
+		})
+	}
+}
+
+func FuzzParseH265SPS(f *testing.F) {
+	f.Add(h265SPSRBSP)
+	f.Add(h265VPSRBSP)
+
+	f.Fuzz(func(t *testing.T, rbsp []byte) {
+		sps, err := entities.ParseH265SPS(rbsp)
+		if err != nil {
+			return
+		}
+		assert.Greater(t, sps.Width(), 0)
+		assert.Greater(t, sps.Height(), 0)
+	})
+}
+
+func FuzzParseH265VPS(f *testing.F) {
+	f.Add(h265VPSRBSP)
+
+	f.Fuzz(func(t *testing.T, rbsp []byte) {
+		vps, err := entities.ParseH265VPS(rbsp)
+		if err != nil {
+			return
+		}
+		assert.GreaterOrEqual(t, vps.MaxSubLayers, byte(1))
+	})
+}
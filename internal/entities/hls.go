@@ -0,0 +1,160 @@
+package entities
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// HLSStream is a session packaged to HLS, PlaylistURL is its media playlist.
+type HLSStream struct {
+	SessionID   string
+	PlaylistURL string
+}
+
+// HLSPlaylistPath is the playlist name, the segments are served along it under HLSPathPrefix/<session id>/.
+const (
+	HLSPathPrefix   = "/hls/"
+	HLSPlaylistPath = "index.m3u8"
+)
+
+func HLSPlaylistURL(sessionID string) string {
+	return HLSPathPrefix + sessionID + "/" + HLSPlaylistPath
+}
+
+// HLSPart is a partial segment (LL-HLS), a single fMP4 fragment.
+type HLSPart struct {
+	Duration time.Duration
+	// Independent parts start with a keyframe
+	Independent bool
+}
+
+// HLSSegment is made of its parts, it's only listed as a segment once complete.
+type HLSSegment struct {
+	Sequence int
+	Duration time.Duration
+	Parts    []HLSPart
+	Complete bool
+	// InitSequence is the init segment (EXT-X-MAP) of the segment, a new one is created whenever the
+	// streams change and the segment is marked as a discontinuity.
+	InitSequence  int
+	Discontinuity bool
+}
+
+// HLSPlaylist is the sliding window of a live HLS media playlist.
+type HLSPlaylist struct {
+	TargetDuration time.Duration
+	// PartTarget is the LL-HLS part duration, zero disables the parts
+	PartTarget time.Duration
+	// DiscontinuitySequence counts the discontinuities that left the window
+	DiscontinuitySequence int
+	Segments              []HLSSegment
+	Ended                 bool
+}
+
+// HLSRenderedPartsSegments is the number of segments, from the end, with their parts listed.
+const HLSRenderedPartsSegments = 3
+
+func HLSInitURI(initSequence int) string {
+	return fmt.Sprintf("init%d.mp4", initSequence)
+}
+
+func HLSSegmentURI(sequence int) string {
+	return fmt.Sprintf("seg%d.m4s", sequence)
+}
+
+func HLSPartURI(sequence, part int) string {
+	return fmt.Sprintf("part%d.%d.m4s", sequence, part)
+}
+
+// NextPart is the part being written, the one announced by the preload hint.
+func (p *HLSPlaylist) NextPart() (int, int) {
+	if len(p.Segments) == 0 {
+		return 0, 0
+	}
+	last := p.Segments[len(p.Segments)-1]
+	if last.Complete {
+		return last.Sequence + 1, 0
+	}
+	return last.Sequence, len(last.Parts)
+}
+
+// HasPart tells whether the part (or the whole segment when part is negative) is already listed,
+// it's how the blocking playlist reloads wait for _HLS_msn and _HLS_part.
+func (p *HLSPlaylist) HasPart(sequence, part int) bool {
+	if p.Ended {
+		return true
+	}
+	nextSequence, nextPart := p.NextPart()
+	if part < 0 {
+		return sequence < nextSequence
+	}
+	return sequence < nextSequence || (sequence == nextSequence && part < nextPart)
+}
+
+// HLSMaxSequenceAhead is how far ahead of the segment being written a blocking playlist reload may be,
+// the further ones get a 400 (Bad Request) as LL-HLS requires.
+const HLSMaxSequenceAhead = 2
+
+// IsTooFarAhead tells whether the blocking playlist reload of the segment must be rejected.
+func (p *HLSPlaylist) IsTooFarAhead(sequence int) bool {
+	nextSequence, _ := p.NextPart()
+	return sequence > nextSequence+HLSMaxSequenceAhead
+}
+
+// M3U8 renders the media playlist.
+func (p *HLSPlaylist) M3U8() string {
+	var b strings.Builder
+	lowLatency := p.PartTarget > 0
+
+	version := 7
+	if lowLatency {
+		version = 9
+	}
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(p.TargetDuration.Seconds())))
+	if len(p.Segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.Segments[0].Sequence)
+	}
+	if p.DiscontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.DiscontinuitySequence)
+	}
+	if lowLatency {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%s\n", hlsSeconds(3*p.PartTarget))
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%s\n", hlsSeconds(p.PartTarget))
+	}
+
+	for i, seg := range p.Segments {
+		if i > 0 && seg.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if i == 0 || seg.InitSequence != p.Segments[i-1].InitSequence {
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", HLSInitURI(seg.InitSequence))
+		}
+		if lowLatency && i >= len(p.Segments)-HLSRenderedPartsSegments {
+			for j, part := range seg.Parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%s,URI=%q", hlsSeconds(part.Duration), HLSPartURI(seg.Sequence, j))
+				if part.Independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+				b.WriteString("\n")
+			}
+		}
+		if seg.Complete {
+			fmt.Fprintf(&b, "#EXTINF:%s,\n%s\n", hlsSeconds(seg.Duration), HLSSegmentURI(seg.Sequence))
+		}
+	}
+
+	if p.Ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	} else if lowLatency {
+		sequence, part := p.NextPart()
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=%q\n", HLSPartURI(sequence, part))
+	}
+	return b.String()
+}
+
+func hlsSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
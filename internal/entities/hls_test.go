@@ -0,0 +1,112 @@
+package entities_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func llhlsPlaylist() entities.HLSPlaylist {
+	part := 500 * time.Millisecond
+	parts := []entities.HLSPart{{Duration: part, Independent: true}, {Duration: part}, {Duration: part}, {Duration: part}}
+	return entities.HLSPlaylist{
+		TargetDuration: 2 * time.Second,
+		PartTarget:     part,
+		Segments: []entities.HLSSegment{
+			{Sequence: 10, Duration: 2 * time.Second, Parts: parts, Complete: true},
+			{Sequence: 11, Duration: 2 * time.Second, Parts: parts, Complete: true, InitSequence: 1, Discontinuity: true},
+			{Sequence: 12, Duration: 2 * time.Second, Parts: parts, Complete: true, InitSequence: 1},
+			{Sequence: 13, Duration: time.Second, Parts: parts[:2], InitSequence: 1},
+		},
+	}
+}
+
+func TestHLSPlaylist_M3U8(t *testing.T) {
+	t.Parallel()
+	p := llhlsPlaylist()
+
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500
+#EXT-X-PART-INF:PART-TARGET=0.500
+#EXT-X-MAP:URI="init0.mp4"
+#EXTINF:2.000,
+seg10.m4s
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="init1.mp4"
+#EXT-X-PART:DURATION=0.500,URI="part11.0.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="part11.1.m4s"
+#EXT-X-PART:DURATION=0.500,URI="part11.2.m4s"
+#EXT-X-PART:DURATION=0.500,URI="part11.3.m4s"
+#EXTINF:2.000,
+seg11.m4s
+#EXT-X-PART:DURATION=0.500,URI="part12.0.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="part12.1.m4s"
+#EXT-X-PART:DURATION=0.500,URI="part12.2.m4s"
+#EXT-X-PART:DURATION=0.500,URI="part12.3.m4s"
+#EXTINF:2.000,
+seg12.m4s
+#EXT-X-PART:DURATION=0.500,URI="part13.0.m4s",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.500,URI="part13.1.m4s"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="part13.2.m4s"
+`, p.M3U8())
+}
+
+func TestHLSPlaylist_M3U8WithoutParts(t *testing.T) {
+	t.Parallel()
+	p := llhlsPlaylist()
+	p.PartTarget = 0
+	p.Segments = p.Segments[:1]
+	p.Ended = true
+
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-MAP:URI="init0.mp4"
+#EXTINF:2.000,
+seg10.m4s
+#EXT-X-ENDLIST
+`, p.M3U8())
+}
+
+func TestHLSPlaylist_HasPart(t *testing.T) {
+	t.Parallel()
+	p := llhlsPlaylist()
+
+	sequence, part := p.NextPart()
+	assert.Equal(t, 13, sequence)
+	assert.Equal(t, 2, part)
+
+	assert.True(t, p.HasPart(12, -1))
+	assert.True(t, p.HasPart(13, 1))
+	assert.False(t, p.HasPart(13, 2))
+	assert.False(t, p.HasPart(13, -1), "the segment is still in progress")
+	assert.False(t, p.HasPart(14, 0))
+
+	p.Segments[3].Complete = true
+	sequence, part = p.NextPart()
+	assert.Equal(t, 14, sequence)
+	assert.Equal(t, 0, part)
+	assert.True(t, p.HasPart(13, -1))
+
+	p.Ended = true
+	assert.True(t, p.HasPart(20, 0), "nothing is waited for once it ends")
+}
+
+func TestHLSPlaylist_IsTooFarAhead(t *testing.T) {
+	t.Parallel()
+	p := llhlsPlaylist()
+
+	assert.False(t, p.IsTooFarAhead(13))
+	assert.False(t, p.IsTooFarAhead(15))
+	assert.True(t, p.IsTooFarAhead(16))
+
+	p.Segments[3].Complete = true
+	assert.False(t, p.IsTooFarAhead(16))
+	assert.True(t, p.IsTooFarAhead(17))
+}
@@ -0,0 +1,23 @@
+package entities
+
+// OutputStream is a stream sent through OnVideoFrame/OnAudioFrame, the codec is the output one
+// (i.e. Opus when the audio is transcoded) and ExtraData is its global header (i.e. the H264 SPS/PPS or the OpusHead).
+type OutputStream struct {
+	Stream
+	ExtraData []byte `json:"-"`
+	// FrameSize is the number of samples per audio frame, when it's fixed
+	FrameSize int
+}
+
+// MediaSink consumes the frames sent to the client (i.e. to record them), it must not block the streaming.
+type MediaSink interface {
+	OnOutputStream(st OutputStream) error
+	OnFrame(mediaType MediaType, data []byte, c MediaFrameContext) error
+	Close() error
+}
+
+// MediaSource describes the MSE init segment that follows it, the SourceBuffer is created with its MIME type.
+type MediaSource struct {
+	SessionID string
+	MimeType  string
+}
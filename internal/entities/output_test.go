@@ -0,0 +1,33 @@
+package entities_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDonutOutput_IsFragmentedMP4(t *testing.T) {
+	t.Parallel()
+	assert.True(t, entities.DonutHLS.IsFragmentedMP4())
+	assert.True(t, entities.DonutMSE.IsFragmentedMP4())
+	assert.False(t, entities.DonutWebRTC.IsFragmentedMP4())
+	assert.False(t, entities.DonutRestream.IsFragmentedMP4())
+	assert.True(t, entities.DonutRestream.IsMuxed())
+	assert.False(t, entities.DonutWebRTC.IsMuxed())
+}
+
+func TestRequestParams_ValidatesOutput(t *testing.T) {
+	t.Parallel()
+	params := &entities.RequestParams{
+		StreamURL: "srt://localhost:40052",
+		StreamID:  "stream-id",
+	}
+	for _, output := range []entities.DonutOutput{"", entities.DonutWebRTC, entities.DonutHLS, entities.DonutMSE, entities.DonutRestream} {
+		params.Output = output
+		assert.NoError(t, params.Valid())
+	}
+
+	params.Output = "dash"
+	assert.ErrorIs(t, params.Valid(), entities.ErrUnsupportedOutput)
+}
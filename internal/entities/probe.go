@@ -0,0 +1,86 @@
+package entities
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ProbeReport describes a source and how each of its streams would be sent to a WebRTC viewer,
+// it's how the sources are checked before the viewers play them.
+type ProbeReport struct {
+	StreamURL string
+	StreamID  string
+	// Container is the libav input format (i.e. mpegts, flv)
+	Container string
+	BitRate   int64
+	// DurationMS is zero for the live sources
+	DurationMS int64
+	Streams    []ProbedStream
+}
+
+// ProbedStream is a source stream, its Action is the recipe one (bypass/transcode) against a browser
+// offering the common codecs, Note tells to what or why it isn't sent.
+type ProbedStream struct {
+	Stream
+	// CodecName is the libav codec name, it names the codecs donut doesn't map (i.e. scte_35, timed_id3)
+	CodecName string
+	Note      string `json:",omitempty"`
+}
+
+// BrowserClientStreamInfo is what the common browsers offer, it stands for the client when there's
+// no offer (i.e. probing).
+func BrowserClientStreamInfo() *StreamInfo {
+	h264 := func(profileLevelID string) Stream {
+		return Stream{
+			Codec: H264,
+			Type:  VideoType,
+			Fmtp:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + profileLevelID,
+		}
+	}
+	return &StreamInfo{Streams: []Stream{
+		{Codec: VP8, Type: VideoType},
+		h264("42001f"),
+		h264("42e01f"),
+		h264("4d001f"),
+		h264("64001f"),
+		{Codec: VP9, Type: VideoType},
+		{Codec: AV1, Type: VideoType},
+		{Codec: Opus, Type: AudioType},
+	}}
+}
+
+// IsSupportedSubtitle tells whether the subtitle is sent as captions, the text based ones and teletext
+// are decoded. The bitmap ones are not supported.
+func IsSupportedSubtitle(codec Codec) bool {
+	switch codec {
+	case WebVTT, SubRip, ASS, MovText, Text, DVBTeletext:
+		return true
+	}
+	return false
+}
+
+// SplitStreamURL takes the stream id from the url when it's part of it, the RTMP stream key (the last
+// path segment) or the SRT streamid query parameter. The stream url is returned as the signaling expects it.
+func SplitStreamURL(streamURL string) (string, string) {
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return streamURL, ""
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "rtmp", "rtmps":
+		i := strings.LastIndex(u.Path, "/")
+		if i < 0 || i == len(u.Path)-1 {
+			return streamURL, ""
+		}
+		streamID := u.Path[i+1:]
+		u.Path = u.Path[:i]
+		return u.String(), streamID
+	case "srt":
+		query := u.Query()
+		streamID := query.Get("streamid")
+		query.Del("streamid")
+		u.RawQuery = query.Encode()
+		return u.String(), streamID
+	}
+	return streamURL, ""
+}
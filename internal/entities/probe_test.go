@@ -0,0 +1,37 @@
+package entities_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStreamURL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		url               string
+		expectedStreamURL string
+		expectedStreamID  string
+	}{
+		{url: "rtmp://localhost:1935/live/app", expectedStreamURL: "rtmp://localhost:1935/live", expectedStreamID: "app"},
+		{url: "rtmps://live.example.com/app/key", expectedStreamURL: "rtmps://live.example.com/app", expectedStreamID: "key"},
+		{url: "rtmp://localhost:1935/", expectedStreamURL: "rtmp://localhost:1935/"},
+		{url: "srt://localhost:40052?streamid=stream-id", expectedStreamURL: "srt://localhost:40052", expectedStreamID: "stream-id"},
+		{url: "srt://localhost:40052?latency=200&streamid=stream-id", expectedStreamURL: "srt://localhost:40052?latency=200", expectedStreamID: "stream-id"},
+		{url: "srt://localhost:40052", expectedStreamURL: "srt://localhost:40052"},
+		{url: "http://localhost/live.ts", expectedStreamURL: "http://localhost/live.ts"},
+	}
+	for _, tt := range tests {
+		streamURL, streamID := entities.SplitStreamURL(tt.url)
+		assert.Equal(t, tt.expectedStreamURL, streamURL, tt.url)
+		assert.Equal(t, tt.expectedStreamID, streamID, tt.url)
+	}
+}
+
+func TestIsSupportedSubtitle(t *testing.T) {
+	t.Parallel()
+	assert.True(t, entities.IsSupportedSubtitle(entities.WebVTT))
+	assert.True(t, entities.IsSupportedSubtitle(entities.DVBTeletext))
+	assert.False(t, entities.IsSupportedSubtitle(entities.DVBSubtitle))
+}
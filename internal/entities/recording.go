@@ -0,0 +1,73 @@
+package entities
+
+import (
+	"fmt"
+	"time"
+)
+
+type RecordingFormat string
+
+const (
+	// RecordingFMP4 is a fragmented MP4, it's playable even when the recording is interrupted
+	RecordingFMP4     RecordingFormat = "mp4"
+	RecordingMatroska RecordingFormat = "mkv"
+)
+
+// RecordingParams starts a recording, either along the signaling request or through the recordings API.
+// The zero rotation values fall back to the config ones.
+type RecordingParams struct {
+	SessionID      string
+	Format         RecordingFormat
+	MaxDurationSec int
+	MaxSizeMB      int
+}
+
+func (p *RecordingParams) Valid() error {
+	if p == nil {
+		return ErrMissingRecordingParams
+	}
+	if p.SessionID == "" {
+		return ErrMissingSession
+	}
+	return p.validOptions()
+}
+
+// validOptions validates everything but the session, it's unknown yet at the signaling request.
+func (p *RecordingParams) validOptions() error {
+	if p.Format != RecordingFMP4 && p.Format != RecordingMatroska {
+		return fmt.Errorf("%w %q", ErrUnsupportedRecordingFormat, p.Format)
+	}
+	if p.MaxDurationSec < 0 || p.MaxSizeMB < 0 {
+		return fmt.Errorf("%w rotation limits must not be negative", ErrInvalidRecordingParams)
+	}
+	return nil
+}
+
+// Recording is a session being (or that was) recorded, a new file is started whenever one reaches
+// the rotation limits.
+type Recording struct {
+	ID        string
+	SessionID string
+	Format    RecordingFormat
+	StartedAt time.Time
+	StoppedAt *time.Time `json:",omitempty"`
+	Files     []string
+	Bytes     int64
+	// DroppedFrames are the frames lost because the disk didn't keep up or the recording failed
+	DroppedFrames int64
+	Error         string `json:",omitempty"`
+}
+
+// RecordingRotation tells when the current recording file must be closed and a new one started.
+type RecordingRotation struct {
+	MaxDuration time.Duration
+	MaxSize     int64
+}
+
+// ShouldRotate is checked at the keyframes, so every file starts with one.
+func (r RecordingRotation) ShouldRotate(fileDuration time.Duration, fileSize int64) bool {
+	if r.MaxDuration > 0 && fileDuration >= r.MaxDuration {
+		return true
+	}
+	return r.MaxSize > 0 && fileSize >= r.MaxSize
+}
@@ -0,0 +1,54 @@
+package entities_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordingParams_Valid(t *testing.T) {
+	t.Parallel()
+
+	var missing *entities.RecordingParams
+	assert.ErrorIs(t, missing.Valid(), entities.ErrMissingRecordingParams)
+
+	params := &entities.RecordingParams{Format: entities.RecordingFMP4}
+	assert.ErrorIs(t, params.Valid(), entities.ErrMissingSession)
+
+	params.SessionID = "session"
+	assert.NoError(t, params.Valid())
+
+	params.Format = "avi"
+	assert.ErrorIs(t, params.Valid(), entities.ErrUnsupportedRecordingFormat)
+
+	params.Format = entities.RecordingMatroska
+	params.MaxSizeMB = -1
+	assert.ErrorIs(t, params.Valid(), entities.ErrInvalidRecordingParams)
+}
+
+func TestRequestParams_ValidatesRecordingWithoutSession(t *testing.T) {
+	t.Parallel()
+	params := &entities.RequestParams{
+		StreamURL: "srt://localhost:40052",
+		StreamID:  "stream-id",
+		Record:    &entities.RecordingParams{Format: entities.RecordingMatroska},
+	}
+	assert.NoError(t, params.Valid())
+
+	params.Record.Format = "avi"
+	assert.ErrorIs(t, params.Valid(), entities.ErrUnsupportedRecordingFormat)
+}
+
+func TestRecordingRotation_ShouldRotate(t *testing.T) {
+	t.Parallel()
+
+	noLimits := entities.RecordingRotation{}
+	assert.False(t, noLimits.ShouldRotate(24*time.Hour, 1<<40))
+
+	rotation := entities.RecordingRotation{MaxDuration: time.Hour, MaxSize: 100 << 20}
+	assert.False(t, rotation.ShouldRotate(59*time.Minute, 99<<20))
+	assert.True(t, rotation.ShouldRotate(time.Hour, 0))
+	assert.True(t, rotation.ShouldRotate(0, 100<<20))
+}
@@ -0,0 +1,98 @@
+package entities
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RestreamParams relays the Source stream to the Destinations (rtmp://, rtmps:// or srt:// urls), the
+// video is bypassed when it's H264 and transcoded otherwise, the audio is transcoded to AAC.
+type RestreamParams struct {
+	Source       RequestParams
+	Destinations []string
+}
+
+func (p *RestreamParams) Valid() error {
+	if p == nil {
+		return ErrMissingRestreamParams
+	}
+	if len(p.Destinations) == 0 {
+		return ErrMissingRestreamDestination
+	}
+	for _, destination := range p.Destinations {
+		if _, err := RestreamFormat(destination); err != nil {
+			return err
+		}
+	}
+	return p.Source.Valid()
+}
+
+// RestreamFormat is the libav format name muxed to the destination, FLV for RTMP and MPEG-TS for SRT.
+func RestreamFormat(destination string) (string, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %s", ErrUnsupportedRestreamDestination, destination, err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "rtmp", "rtmps":
+		return "flv", nil
+	case "srt":
+		return "mpegts", nil
+	}
+	return "", fmt.Errorf("%w %q", ErrUnsupportedRestreamDestination, destination)
+}
+
+type RestreamState string
+
+const (
+	// RestreamConnecting waits for the next keyframe to connect, the output starts with one
+	RestreamConnecting   RestreamState = "connecting"
+	RestreamLive         RestreamState = "live"
+	RestreamReconnecting RestreamState = "reconnecting"
+	// RestreamFailed gave up reconnecting
+	RestreamFailed  RestreamState = "failed"
+	RestreamStopped RestreamState = "stopped"
+)
+
+// Restream is a session relayed to its destinations, each one is pushed (and reconnected) on its own.
+type Restream struct {
+	SessionID    string
+	StartedAt    time.Time
+	StoppedAt    *time.Time `json:",omitempty"`
+	Destinations []RestreamDestination
+}
+
+type RestreamDestination struct {
+	URL        string
+	State      RestreamState
+	Reconnects int
+	Bytes      int64
+	// DroppedFrames are the frames lost while reconnecting or because the destination didn't keep up
+	DroppedFrames int64
+	Error         string `json:",omitempty"`
+}
+
+// RestreamBackoff is the delay before reconnecting a destination, it doubles at every attempt
+// from Min up to Max. MaxAttempts (zero is unlimited) are made before giving up.
+type RestreamBackoff struct {
+	Min         time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+// Delay returns the delay before the attempt (the first one is 1), false once there are no attempts left.
+func (b RestreamBackoff) Delay(attempt int) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+		return 0, false
+	}
+	delay := b.Min
+	for i := 1; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	return delay, true
+}
@@ -0,0 +1,66 @@
+package entities_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestreamParams_Valid(t *testing.T) {
+	t.Parallel()
+
+	var missing *entities.RestreamParams
+	assert.ErrorIs(t, missing.Valid(), entities.ErrMissingRestreamParams)
+
+	params := &entities.RestreamParams{
+		Source: entities.RequestParams{StreamURL: "srt://localhost:40052", StreamID: "stream-id"},
+	}
+	assert.ErrorIs(t, params.Valid(), entities.ErrMissingRestreamDestination)
+
+	params.Destinations = []string{"rtmp://localhost/live/key", "srt://localhost:9000?streamid=out"}
+	assert.NoError(t, params.Valid())
+
+	params.Destinations = append(params.Destinations, "http://localhost/live")
+	assert.ErrorIs(t, params.Valid(), entities.ErrUnsupportedRestreamDestination)
+
+	params.Destinations = []string{"rtmp://localhost/live/key"}
+	params.Source.StreamID = ""
+	assert.ErrorIs(t, params.Valid(), entities.ErrMissingStreamID)
+}
+
+func TestRestreamFormat(t *testing.T) {
+	t.Parallel()
+	format, err := entities.RestreamFormat("rtmps://live.example.com/app/key")
+	assert.NoError(t, err)
+	assert.Equal(t, "flv", format)
+
+	format, err = entities.RestreamFormat("SRT://localhost:9000")
+	assert.NoError(t, err)
+	assert.Equal(t, "mpegts", format)
+
+	_, err = entities.RestreamFormat("localhost:9000")
+	assert.ErrorIs(t, err, entities.ErrUnsupportedRestreamDestination)
+}
+
+func TestRestreamBackoff_Delay(t *testing.T) {
+	t.Parallel()
+	backoff := entities.RestreamBackoff{Min: time.Second, Max: 5 * time.Second, MaxAttempts: 5}
+
+	var delays []time.Duration
+	for attempt := 1; attempt <= 5; attempt++ {
+		delay, ok := backoff.Delay(attempt)
+		assert.True(t, ok)
+		delays = append(delays, delay)
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	_, ok := backoff.Delay(6)
+	assert.False(t, ok)
+
+	backoff.MaxAttempts = 0
+	delay, ok := backoff.Delay(100)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, delay)
+}
@@ -0,0 +1,91 @@
+package entities
+
+import (
+	"math"
+	"time"
+)
+
+// NoTimestamp is the libav AV_NOPTS_VALUE, the frames without a timestamp carry it.
+const NoTimestamp = math.MinInt64
+
+// RTPTimestamper maps the source presentation timestamps to the RTP clock (i.e. 90kHz for video and 48kHz
+// for Opus). Each timestamp is rescaled from the first one instead of adding up the frames duration,
+// so the rounding errors don't accumulate and both tracks keep the source timeline, hence the A/V sync.
+type RTPTimestamper struct {
+	clockRate int64
+	initial   uint32
+
+	// the source timestamp mapped to the current base
+	base      int64
+	baseRTP   uint32
+	hasBase   bool
+	lastPTS   int64
+	timeBase  [2]int64
+	last      uint32
+	hasLast   bool
+	lastDelta time.Duration
+}
+
+// NewRTPTimestamper creates it for the clock rate, the initial RTP timestamp should be random (RFC 3550 5.1).
+func NewRTPTimestamper(clockRate uint32, initial uint32) *RTPTimestamper {
+	return &RTPTimestamper{
+		clockRate: int64(clockRate),
+		initial:   initial,
+	}
+}
+
+// Next returns the RTP timestamp of the frame at pts in the time base num/den. When the frame has no
+// timestamp, or the timeline jumps (discontinuity, 33 bits wrap or time base change), the RTP timeline
+// goes on from the previous frame plus its duration and the next timestamps are rescaled from there.
+func (t *RTPTimestamper) Next(pts int64, timeBaseNum, timeBaseDen int, duration time.Duration) uint32 {
+	validTimeBase := timeBaseNum > 0 && timeBaseDen > 0
+	if pts == NoTimestamp || !validTimeBase {
+		return t.advance(duration)
+	}
+
+	timeBase := [2]int64{int64(timeBaseNum), int64(timeBaseDen)}
+	if !t.hasBase || timeBase != t.timeBase || t.isDiscontinuity(pts, timeBase) {
+		t.base, t.timeBase, t.hasBase = pts, timeBase, true
+		t.baseRTP = t.initial
+		if t.hasLast {
+			t.baseRTP = t.last + t.toRTP(t.lastDelta)
+		}
+	}
+	t.lastPTS = pts
+	t.lastDelta = duration
+	t.last = t.baseRTP + uint32(t.rescale(pts-t.base))
+	t.hasLast = true
+	return t.last
+}
+
+func (t *RTPTimestamper) advance(duration time.Duration) uint32 {
+	if !t.hasLast {
+		t.last, t.hasLast = t.initial, true
+	} else {
+		t.last += t.toRTP(t.lastDelta)
+	}
+	t.lastDelta = duration
+	// the next timestamped frame starts a new base, the missing ones broke the rescaling
+	t.hasBase = false
+	return t.last
+}
+
+func (t *RTPTimestamper) isDiscontinuity(pts int64, timeBase [2]int64) bool {
+	maxTicks := timeBase[1] * int64(maxFrameDuration/time.Second) / timeBase[0]
+	delta := pts - t.lastPTS
+	return delta > maxTicks || -delta > maxTicks
+}
+
+// rescale converts the ticks to the clock rate rounding to the nearest, like av_rescale_q.
+func (t *RTPTimestamper) rescale(ticks int64) int64 {
+	num := ticks * t.timeBase[0] * t.clockRate
+	den := t.timeBase[1]
+	if num < 0 {
+		return -((-num + den/2) / den)
+	}
+	return (num + den/2) / den
+}
+
+func (t *RTPTimestamper) toRTP(d time.Duration) uint32 {
+	return uint32((int64(d)*t.clockRate + int64(time.Second)/2) / int64(time.Second))
+}
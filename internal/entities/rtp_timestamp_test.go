@@ -0,0 +1,113 @@
+package entities_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTPTimestamper_NoDriftOverLongStream(t *testing.T) {
+	t.Parallel()
+	// the RTP timestamps wrap around during the session
+	video := entities.NewRTPTimestamper(90000, math.MaxUint32-1000)
+	audio := entities.NewRTPTimestamper(48000, 0)
+	// the pion sample tracks truncate the duration to whole ticks, 3002 instead of 3003 at 29.97fps
+	sampleTrackVideo := uint32(math.MaxUint32 - 1000)
+
+	// 6 hours of 29.97fps video with the FLV millisecond timestamps and 20ms Opus audio
+	const hours = 6
+	videoFrames := int64(hours * 3600 * 30000 / 1001)
+	audioFrames := int64(hours * 3600 * 50)
+
+	var lastVideo, lastAudio uint32
+	var lastVideoPTS, lastAudioPTS int64
+	for i := int64(0); i < videoFrames; i++ {
+		pts := (i*1001*1000 + 15000) / 30000
+		duration := time.Duration(1001) * time.Second / 30000
+		lastVideo = video.Next(pts, 1, 1000, duration)
+		lastVideoPTS = pts
+		if !assert.Equal(t, uint32(math.MaxUint32-1000)+uint32(pts*90), lastVideo, "video frame %d", i) {
+			return
+		}
+		if i > 0 {
+			sampleTrackVideo += uint32(duration.Seconds() * 90000)
+		}
+	}
+	for i := int64(0); i < audioFrames; i++ {
+		pts := i * 960
+		lastAudio = audio.Next(pts, 1, 48000, 20*time.Millisecond)
+		lastAudioPTS = pts
+		if !assert.Equal(t, uint32(pts), lastAudio, "audio frame %d", i) {
+			return
+		}
+	}
+
+	// both tracks keep the source A/V offset
+	sourceOffset := time.Duration(lastVideoPTS)*time.Millisecond - time.Duration(lastAudioPTS)*time.Second/48000
+	rtpOffset := time.Duration(lastVideo-uint32(math.MaxUint32-1000))*time.Second/90000 - time.Duration(lastAudio)*time.Second/48000
+	assert.Equal(t, sourceOffset, rtpOffset)
+
+	// while the sample track drifts by more than 5 seconds
+	drift := time.Duration(lastVideo-sampleTrackVideo) * time.Second / 90000
+	assert.Greater(t, drift, 5*time.Second)
+}
+
+func TestRTPTimestamper_NTSCTimeBase(t *testing.T) {
+	t.Parallel()
+	ts := entities.NewRTPTimestamper(90000, 100)
+
+	var got []uint32
+	for _, pts := range []int64{0, 1, 2, 3} {
+		got = append(got, ts.Next(pts, 1001, 30000, 0))
+	}
+
+	assert.Equal(t, []uint32{100, 100 + 3003, 100 + 6006, 100 + 9009}, got)
+}
+
+func TestRTPTimestamper_ReorderedPresentationTimestamps(t *testing.T) {
+	t.Parallel()
+	ts := entities.NewRTPTimestamper(90000, 0)
+
+	var got []uint32
+	for _, pts := range []int64{0, 9000, 3000, 6000} {
+		got = append(got, ts.Next(pts, 1, 90000, ticks(3000)))
+	}
+
+	assert.Equal(t, []uint32{0, 9000, 3000, 6000}, got)
+}
+
+func TestRTPTimestamper_MissingTimestamps(t *testing.T) {
+	t.Parallel()
+	ts := entities.NewRTPTimestamper(48000, 0)
+
+	got := []uint32{
+		ts.Next(0, 1, 48000, 20*time.Millisecond),
+		ts.Next(entities.NoTimestamp, 1, 48000, 20*time.Millisecond),
+		ts.Next(entities.NoTimestamp, 1, 48000, 20*time.Millisecond),
+		// the timeline goes on from the previous frame
+		ts.Next(500000, 1, 48000, 20*time.Millisecond),
+		ts.Next(500960, 1, 48000, 20*time.Millisecond),
+	}
+
+	assert.Equal(t, []uint32{0, 960, 1920, 2880, 3840}, got)
+}
+
+func TestRTPTimestamper_Discontinuity(t *testing.T) {
+	t.Parallel()
+	ts := entities.NewRTPTimestamper(90000, 0)
+
+	got := []uint32{
+		ts.Next(900000, 1, 90000, ticks(3000)),
+		ts.Next(903000, 1, 90000, ticks(3000)),
+		// the source restarted
+		ts.Next(0, 1, 90000, ticks(3000)),
+		ts.Next(3000, 1, 90000, ticks(3000)),
+		// the time base changed (i.e. switching the audio stream)
+		ts.Next(200, 1, 1000, ticks(3000)),
+	}
+
+	assert.Equal(t, []uint32{0, 3000, 6000, 9000, 12000}, got)
+}
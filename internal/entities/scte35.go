@@ -0,0 +1,151 @@
+package entities
+
+// ANSI/SCTE 35 2022 p.31
+type SpliceCommandType byte
+
+const (
+	SpliceNull           = SpliceCommandType(0x00)
+	SpliceSchedule       = SpliceCommandType(0x04)
+	SpliceInsertCommand  = SpliceCommandType(0x05)
+	TimeSignalCommand    = SpliceCommandType(0x06)
+	BandwidthReservation = SpliceCommandType(0x07)
+	PrivateCommand       = SpliceCommandType(0xff)
+)
+
+// ANSI/SCTE 35 2022 p.61
+type SpliceDescriptorTag byte
+
+const (
+	AvailDescriptor        = SpliceDescriptorTag(0x00)
+	DTMFDescriptor         = SpliceDescriptorTag(0x01)
+	SegmentationDescriptor = SpliceDescriptorTag(0x02)
+	TimeDescriptor         = SpliceDescriptorTag(0x03)
+	AudioDescriptor        = SpliceDescriptorTag(0x04)
+)
+
+// SpliceInfo is the splice_info_section carried by the SCTE-35 data streams.
+// The PTS values are in 90kHz units.
+type SpliceInfo struct {
+	ProtocolVersion byte
+	Encrypted       bool
+	PTSAdjustment   int64
+	Tier            uint16
+	CommandType     SpliceCommandType
+
+	// only one of them is set, according to the CommandType
+	SpliceInsert *SpliceInsert
+	TimeSignal   *SpliceTime
+
+	Segmentations []Segmentation
+}
+
+// SplicePTS returns the time (with the pts adjustment applied) the splice is meant to happen,
+// it returns false for immediate splices or commands without time.
+func (s *SpliceInfo) SplicePTS() (int64, bool) {
+	var t *SpliceTime
+	switch {
+	case s.SpliceInsert != nil && !s.SpliceInsert.Immediate:
+		t = &s.SpliceInsert.SpliceTime
+	case s.TimeSignal != nil:
+		t = s.TimeSignal
+	}
+	if t == nil || !t.Specified {
+		return 0, false
+	}
+	return (t.PTS + s.PTSAdjustment) & MaxPTS33Bits, true
+}
+
+// MaxPTS33Bits is the mask for the 33 bits MPEG-TS timestamps.
+const MaxPTS33Bits = int64(1)<<33 - 1
+
+type SpliceTime struct {
+	Specified bool
+	PTS       int64
+}
+
+// ANSI/SCTE 35 2022 p.36
+type SpliceInsert struct {
+	EventID         uint32
+	Cancel          bool
+	OutOfNetwork    bool
+	ProgramSplice   bool
+	Immediate       bool
+	SpliceTime      SpliceTime
+	AutoReturn      bool
+	BreakDuration   int64
+	UniqueProgramID uint16
+	AvailNum        byte
+	AvailsExpected  byte
+}
+
+// ANSI/SCTE 35 2022 p.66
+type SegmentationType byte
+
+const (
+	SegmentationBreakStart                                  = SegmentationType(0x22)
+	SegmentationBreakEnd                                    = SegmentationType(0x23)
+	SegmentationProviderAdvertisementStart                  = SegmentationType(0x30)
+	SegmentationProviderAdvertisementEnd                    = SegmentationType(0x31)
+	SegmentationDistributorAdvertisementStart               = SegmentationType(0x32)
+	SegmentationDistributorAdvertisementEnd                 = SegmentationType(0x33)
+	SegmentationProviderPlacementOpportunityStart           = SegmentationType(0x34)
+	SegmentationProviderPlacementOpportunityEnd             = SegmentationType(0x35)
+	SegmentationDistributorPlacementOpportunityStart        = SegmentationType(0x36)
+	SegmentationDistributorPlacementOpportunityEnd          = SegmentationType(0x37)
+	SegmentationProviderOverlayPlacementOpportunityStart    = SegmentationType(0x38)
+	SegmentationProviderOverlayPlacementOpportunityEnd      = SegmentationType(0x39)
+	SegmentationDistributorOverlayPlacementOpportunityStart = SegmentationType(0x3a)
+	SegmentationDistributorOverlayPlacementOpportunityEnd   = SegmentationType(0x3b)
+)
+
+// IsAdBreakStart tells whether the segmentation starts an ad break or placement opportunity.
+func (t SegmentationType) IsAdBreakStart() bool {
+	return t == SegmentationBreakStart ||
+		(t >= SegmentationProviderAdvertisementStart && t <= SegmentationDistributorOverlayPlacementOpportunityEnd && t%2 == 0)
+}
+
+// IsAdBreakEnd tells whether the segmentation ends an ad break or placement opportunity.
+func (t SegmentationType) IsAdBreakEnd() bool {
+	return t == SegmentationBreakEnd ||
+		(t >= SegmentationProviderAdvertisementStart && t <= SegmentationDistributorOverlayPlacementOpportunityEnd && t%2 == 1)
+}
+
+// ANSI/SCTE 35 2022 p.62
+type Segmentation struct {
+	EventID          uint32
+	Cancel           bool
+	Duration         int64
+	UPIDType         byte
+	UPID             []byte
+	TypeID           SegmentationType
+	SegmentNum       byte
+	SegmentsExpected byte
+}
+
+type SpliceEventType string
+
+const (
+	// SpliceEventCueOut starts an ad break
+	SpliceEventCueOut SpliceEventType = "cueOut"
+	// SpliceEventCueIn returns to the network
+	SpliceEventCueIn SpliceEventType = "cueIn"
+	// SpliceEventCancel cancels a previously announced event
+	SpliceEventCancel SpliceEventType = "cancel"
+	// SpliceEventSignal is any other time signal
+	SpliceEventSignal SpliceEventType = "signal"
+)
+
+// SpliceEvent is the ad break cue forwarded to the client,
+// it's sent when the video reaches the splice time.
+type SpliceEvent struct {
+	Type    SpliceEventType
+	EventID uint32
+	// PTS in 90kHz units, zero for immediate splices
+	PTS        int64
+	Immediate  bool
+	DurationMS int64
+	AutoReturn bool
+	// SegmentationTypeID is set for time signals with segmentation descriptors
+	SegmentationTypeID SegmentationType
+	Info               SpliceInfo
+}
@@ -0,0 +1,266 @@
+package entities
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// SEI is a sei_message, the same syntax is used by H264 and H265.
+// Rec. ITU-T H.264 (08/2021) p.54
+type SEI struct {
+	PayloadType int
+	PayloadSize int
+	Payload     []byte
+}
+
+const (
+	// Rec. ITU-T H.264 (08/2021) p.338
+	SEIPayloadTypeBufferingPeriod = 0
+	SEIPayloadTypePicTiming       = 1
+	// SEIPayloadTypeUserDataRegisteredITUTT35 carries the captions (both H264 and H265)
+	SEIPayloadTypeUserDataRegisteredITUTT35 = 4
+	SEIPayloadTypeUserDataUnregistered      = 5
+	SEIPayloadTypeRecoveryPoint             = 6
+)
+
+// SEIUserDataRegistered is the user data registered by Rec. ITU-T T.35.
+// Rec. ITU-T H.264 (08/2021) p.357
+type SEIUserDataRegistered struct {
+	CountryCode byte
+	// CountryCodeExtension is only present when the country code is 0xff
+	CountryCodeExtension byte
+	Payload              []byte
+}
+
+// SEIUserDataUnregistered is the user data identified by an UUID (i.e. x264 writes its options with one).
+// Rec. ITU-T H.264 (08/2021) p.358
+type SEIUserDataUnregistered struct {
+	UUID    [16]byte
+	Payload []byte
+}
+
+// SEIRecoveryPoint tells where the decoding can start without an IDR (i.e. open GOP and intra refresh streams).
+// Rec. ITU-T H.264 (08/2021) p.358
+type SEIRecoveryPoint struct {
+	RecoveryFrameCnt      uint32
+	ExactMatch            bool
+	BrokenLink            bool
+	ChangingSliceGroupIDC byte
+}
+
+// SEIPicTiming only has the clock timestamps when the SPS signals the pic_struct.
+// Rec. ITU-T H.264 (08/2021) p.341
+type SEIPicTiming struct {
+	CpbRemovalDelay uint32
+	DpbOutputDelay  uint32
+	PicStruct       byte
+	ClockTimestamps []SEIClockTimestamp
+}
+
+// SEIClockTimestamp is the timecode of a frame or field.
+// When FullTimestamp is not set the missing units are the same as the previous clock timestamp's.
+type SEIClockTimestamp struct {
+	CTType         byte
+	NuitFieldBased bool
+	CountingType   byte
+	FullTimestamp  bool
+	Discontinuity  bool
+	CntDropped     bool
+	NFrames        byte
+	Seconds        byte
+	Minutes        byte
+	Hours          byte
+	TimeOffset     int32
+}
+
+// String returns the SMPTE timecode (hh:mm:ss:ff).
+func (t SEIClockTimestamp) String() string {
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", t.Hours, t.Minutes, t.Seconds, t.NFrames)
+}
+
+// UUIDString returns the UUID in its canonical form.
+func (u *SEIUserDataUnregistered) UUIDString() string {
+	h := hex.EncodeToString(u.UUID[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (s SEI) UserDataRegistered() (*SEIUserDataRegistered, error) {
+	if err := s.expectPayloadType(SEIPayloadTypeUserDataRegisteredITUTT35); err != nil {
+		return nil, err
+	}
+	if len(s.Payload) < 1 {
+		return nil, fmt.Errorf("%w empty user data registered", ErrInvalidBitstream)
+	}
+	u := &SEIUserDataRegistered{CountryCode: s.Payload[0], Payload: s.Payload[1:]}
+	if u.CountryCode == 0xff {
+		if len(s.Payload) < 2 {
+			return nil, fmt.Errorf("%w missing country code extension", ErrInvalidBitstream)
+		}
+		u.CountryCodeExtension = s.Payload[1]
+		u.Payload = s.Payload[2:]
+	}
+	return u, nil
+}
+
+func (s SEI) UserDataUnregistered() (*SEIUserDataUnregistered, error) {
+	if err := s.expectPayloadType(SEIPayloadTypeUserDataUnregistered); err != nil {
+		return nil, err
+	}
+	u := &SEIUserDataUnregistered{}
+	if len(s.Payload) < len(u.UUID) {
+		return nil, fmt.Errorf("%w user data unregistered shorter than its uuid", ErrInvalidBitstream)
+	}
+	copy(u.UUID[:], s.Payload)
+	u.Payload = s.Payload[len(u.UUID):]
+	return u, nil
+}
+
+// RecoveryPoint decodes the H264 recovery point, H265 uses a different syntax.
+func (s SEI) RecoveryPoint() (*SEIRecoveryPoint, error) {
+	if err := s.expectPayloadType(SEIPayloadTypeRecoveryPoint); err != nil {
+		return nil, err
+	}
+	r := newBitReader(s.Payload)
+	p := &SEIRecoveryPoint{
+		RecoveryFrameCnt:      r.ue(),
+		ExactMatch:            r.flag(),
+		BrokenLink:            r.flag(),
+		ChangingSliceGroupIDC: byte(r.u(2)),
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("recovery point: %w", r.err)
+	}
+	return p, nil
+}
+
+// the NumClockTS by pic_struct, Rec. ITU-T H.264 (08/2021) p.386 table D-1
+var numClockTS = []int{1, 1, 1, 2, 2, 3, 3, 2, 3}
+
+// PicTiming decodes the H264 picture timing, its syntax depends on the VUI of the active SPS.
+func (s SEI) PicTiming(sps *SPS) (*SEIPicTiming, error) {
+	if err := s.expectPayloadType(SEIPayloadTypePicTiming); err != nil {
+		return nil, err
+	}
+	if sps == nil || sps.VUI == nil {
+		return nil, fmt.Errorf("%w picture timing requires the sps vui", ErrInvalidBitstream)
+	}
+	vui := sps.VUI
+	r := newBitReader(s.Payload)
+	p := &SEIPicTiming{}
+	if vui.CpbDpbDelaysPresent() {
+		p.CpbRemovalDelay = r.u(int(vui.CpbRemovalDelayLength))
+		p.DpbOutputDelay = r.u(int(vui.DpbOutputDelayLength))
+	}
+	if vui.PicStructPresent {
+		p.PicStruct = byte(r.u(4))
+		if int(p.PicStruct) >= len(numClockTS) {
+			return nil, fmt.Errorf("%w reserved pic_struct %d", ErrInvalidBitstream, p.PicStruct)
+		}
+		for i := 0; i < numClockTS[p.PicStruct] && r.err == nil; i++ {
+			// clock_timestamp_flag
+			if r.flag() {
+				p.ClockTimestamps = append(p.ClockTimestamps, parseClockTimestamp(r, vui.TimeOffsetLength))
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("pic timing: %w", r.err)
+	}
+	return p, nil
+}
+
+func parseClockTimestamp(r *bitReader, timeOffsetLength uint32) SEIClockTimestamp {
+	t := SEIClockTimestamp{
+		CTType:         byte(r.u(2)),
+		NuitFieldBased: r.flag(),
+		CountingType:   byte(r.u(5)),
+		FullTimestamp:  r.flag(),
+		Discontinuity:  r.flag(),
+		CntDropped:     r.flag(),
+		NFrames:        byte(r.u(8)),
+	}
+	if t.FullTimestamp {
+		t.Seconds = byte(r.u(6))
+		t.Minutes = byte(r.u(6))
+		t.Hours = byte(r.u(5))
+	} else if r.flag() {
+		t.Seconds = byte(r.u(6))
+		if r.flag() {
+			t.Minutes = byte(r.u(6))
+			if r.flag() {
+				t.Hours = byte(r.u(5))
+			}
+		}
+	}
+	if timeOffsetLength > 0 {
+		// time_offset is a two's complement i(v)
+		n := int(timeOffsetLength)
+		v := r.u(n)
+		t.TimeOffset = int32(v)
+		if v>>(n-1)&0x01 == 1 {
+			t.TimeOffset = int32(int64(v) - int64(1)<<n)
+		}
+	}
+	return t
+}
+
+func (s SEI) expectPayloadType(payloadType int) error {
+	if s.PayloadType != payloadType {
+		return fmt.Errorf("%w sei payload type %d is not %d", ErrInvalidBitstream, s.PayloadType, payloadType)
+	}
+	return nil
+}
+
+// parseSEIMessages reads the sei_messages until the rbsp_trailing_bits, the payload type and size are coded
+// as sequences of 0xff plus the last byte. A payload size past the end is clamped, some muxers miscount it.
+// Rec. ITU-T H.264 (08/2021) p.54
+func parseSEIMessages(rbsp []byte) ([]SEI, error) {
+	var seis []SEI
+	offset := 0
+	readFFCoded := func() (int, error) {
+		v := 0
+		for offset < len(rbsp) && rbsp[offset] == 0xff {
+			v += 255
+			offset++
+		}
+		if offset >= len(rbsp) {
+			return 0, fmt.Errorf("%w truncated sei message", ErrInvalidBitstream)
+		}
+		v += int(rbsp[offset])
+		offset++
+		return v, nil
+	}
+
+	for {
+		var sei SEI
+		var err error
+		if sei.PayloadType, err = readFFCoded(); err != nil {
+			return nil, err
+		}
+		if sei.PayloadSize, err = readFFCoded(); err != nil {
+			return nil, err
+		}
+		end := offset + sei.PayloadSize
+		if end > len(rbsp) {
+			end = len(rbsp)
+		}
+		sei.Payload = rbsp[offset:end]
+		seis = append(seis, sei)
+		offset = end
+
+		// more_rbsp_data, only the stop bit (0x80) is left
+		if offset >= len(rbsp) || (offset == len(rbsp)-1 && rbsp[offset] == 0x80) {
+			return seis, nil
+		}
+	}
+}
+
+func itutT35Payloads(seis []SEI) [][]byte {
+	var payloads [][]byte
+	for _, sei := range seis {
+		if sei.PayloadType == SEIPayloadTypeUserDataRegisteredITUTT35 {
+			payloads = append(payloads, sei.Payload)
+		}
+	}
+	return payloads
+}
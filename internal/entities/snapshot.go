@@ -0,0 +1,98 @@
+package entities
+
+import (
+	"fmt"
+	"time"
+)
+
+type SnapshotFormat string
+
+const (
+	SnapshotJPEG SnapshotFormat = "jpeg"
+	SnapshotPNG  SnapshotFormat = "png"
+	SnapshotWebP SnapshotFormat = "webp"
+)
+
+func (f SnapshotFormat) ContentType() string {
+	return "image/" + string(f)
+}
+
+// SnapshotParams asks for a picture of the stream, it's scaled down to Width and/or Height keeping
+// its aspect ratio (zero keeps the source size).
+type SnapshotParams struct {
+	StreamURL string
+	StreamID  string
+	Format    SnapshotFormat
+	Width     int
+	Height    int
+}
+
+// SnapshotMaxDimension bounds the requested size, the snapshots are previews.
+const SnapshotMaxDimension = 4096
+
+func (p *SnapshotParams) Valid() error {
+	if p.Format != SnapshotJPEG && p.Format != SnapshotPNG && p.Format != SnapshotWebP {
+		return fmt.Errorf("%w %q", ErrUnsupportedSnapshotFormat, p.Format)
+	}
+	if p.Width < 0 || p.Height < 0 || p.Width > SnapshotMaxDimension || p.Height > SnapshotMaxDimension {
+		return fmt.Errorf("%w %dx%d", ErrInvalidSnapshotSize, p.Width, p.Height)
+	}
+	params := p.RequestParams()
+	return params.Valid()
+}
+
+// RequestParams are the params of the stream, as if it was played.
+func (p *SnapshotParams) RequestParams() RequestParams {
+	return RequestParams{StreamURL: p.StreamURL, StreamID: p.StreamID}
+}
+
+// Key identifies the snapshots that are alike, they're cached by it.
+func (p *SnapshotParams) Key() string {
+	return fmt.Sprintf("%s|%s|%s|%dx%d", p.StreamURL, p.StreamID, p.Format, p.Width, p.Height)
+}
+
+// SnapshotSize is the size of the snapshot of a source picture, it's never scaled up and its
+// dimensions are even (as the yuv420 encoders require).
+func SnapshotSize(sourceWidth, sourceHeight, width, height int) (int, int) {
+	if sourceWidth <= 0 || sourceHeight <= 0 {
+		return 0, 0
+	}
+	if width <= 0 || width > sourceWidth {
+		width = sourceWidth
+	}
+	if height <= 0 || height > sourceHeight {
+		height = sourceHeight
+	}
+	// the smallest scale fits the picture in the requested box
+	if width*sourceHeight < height*sourceWidth {
+		height = width * sourceHeight / sourceWidth
+	} else {
+		width = height * sourceWidth / sourceHeight
+	}
+	return evenDimension(width), evenDimension(height)
+}
+
+func evenDimension(d int) int {
+	if d < 2 {
+		return 2
+	}
+	return d &^ 1
+}
+
+// Snapshot is an encoded picture of a stream, SessionID is the session it was taken from (empty when
+// the stream was probed).
+type Snapshot struct {
+	Data       []byte
+	Format     SnapshotFormat
+	Width      int
+	Height     int
+	SessionID  string
+	CapturedAt time.Time
+}
+
+// Keyframe is the last video keyframe sent by a session, along its stream, the snapshots are decoded from it.
+type Keyframe struct {
+	Stream     OutputStream
+	Data       []byte
+	CapturedAt time.Time
+}
@@ -0,0 +1,53 @@
+package entities_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotParams_Valid(t *testing.T) {
+	t.Parallel()
+	params := &entities.SnapshotParams{StreamURL: "srt://localhost:40052", StreamID: "stream-id", Format: entities.SnapshotJPEG}
+	assert.NoError(t, params.Valid())
+
+	params.Format = "gif"
+	assert.ErrorIs(t, params.Valid(), entities.ErrUnsupportedSnapshotFormat)
+
+	params.Format = entities.SnapshotWebP
+	params.Width = -1
+	assert.ErrorIs(t, params.Valid(), entities.ErrInvalidSnapshotSize)
+
+	params.Width = entities.SnapshotMaxDimension + 1
+	assert.ErrorIs(t, params.Valid(), entities.ErrInvalidSnapshotSize)
+
+	params.Width = 320
+	params.StreamID = ""
+	assert.ErrorIs(t, params.Valid(), entities.ErrMissingStreamID)
+}
+
+func TestSnapshotSize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name                          string
+		width, height                 int
+		expectedWidth, expectedHeight int
+	}{
+		{name: "source size", expectedWidth: 1920, expectedHeight: 1080},
+		{name: "width only", width: 320, expectedWidth: 320, expectedHeight: 180},
+		{name: "height only", height: 360, expectedWidth: 640, expectedHeight: 360},
+		{name: "fits the box", width: 320, height: 320, expectedWidth: 320, expectedHeight: 180},
+		{name: "never scaled up", width: 3840, expectedWidth: 1920, expectedHeight: 1080},
+		{name: "even dimensions", width: 301, expectedWidth: 300, expectedHeight: 168},
+	}
+	for _, tt := range tests {
+		width, height := entities.SnapshotSize(1920, 1080, tt.width, tt.height)
+		assert.Equal(t, tt.expectedWidth, width, tt.name)
+		assert.Equal(t, tt.expectedHeight, height, tt.name)
+	}
+
+	width, height := entities.SnapshotSize(0, 0, 320, 0)
+	assert.Zero(t, width)
+	assert.Zero(t, height)
+}
@@ -0,0 +1,32 @@
+package entities
+
+type TimedMetadataType string
+
+const (
+	// TimedMetadataID3 is the ID3 timed metadata carried in MPEG-TS
+	TimedMetadataID3 TimedMetadataType = "id3"
+	// TimedMetadataOnMetaData is the FLV/RTMP AMF onMetaData
+	TimedMetadataOnMetaData TimedMetadataType = "onMetaData"
+	// TimedMetadataOnTextData is the FLV/RTMP AMF onTextData
+	TimedMetadataOnTextData TimedMetadataType = "onTextData"
+)
+
+// ID3Frame is a single ID3v2 frame, text frames have their Value decoded
+// while the binary ones (i.e. PRIV) keep the raw Data.
+// ref https://id3.org/id3v2.4.0-frames
+type ID3Frame struct {
+	ID          string
+	Description string `json:",omitempty"`
+	Value       string `json:",omitempty"`
+	Data        []byte `json:",omitempty"`
+}
+
+// TimedMetadata is the metadata found in the input data streams.
+type TimedMetadata struct {
+	Type TimedMetadataType
+	// PTSMS is the position in the media timeline, in milliseconds
+	PTSMS  int64
+	Frames []ID3Frame        `json:",omitempty"`
+	Fields map[string]string `json:",omitempty"`
+	Text   string            `json:",omitempty"`
+}
@@ -0,0 +1,11 @@
+package entities
+
+// VideoUnit is the common view over the H264/H265 NAL units and the AV1 OBUs,
+// it allows the keyframe detection and the caption extraction regardless of the codec.
+type VideoUnit interface {
+	// IsKeyframe tells whether the decoding can start at this unit (i.e. H264 IDR, H265 IRAP, AV1 key frame)
+	IsKeyframe() bool
+	// ITUTT35Payloads returns the user data registered by Rec. ITU-T T.35 (i.e. CEA-708 captions),
+	// starting at the country code.
+	ITUTT35Payloads() [][]byte
+}
@@ -3,9 +3,13 @@ package mapper
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/asticode/go-astiav"
 	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3"
 	"go.uber.org/zap"
 )
@@ -19,30 +23,118 @@ func NewMapper(l *zap.SugaredLogger) *Mapper {
 	return &Mapper{l: l}
 }
 
-func (m *Mapper) FromTrackToRTPCodecCapability(codec entities.Codec) webrtc.RTPCodecCapability {
-	// TODO: enrich codec capability, check if it's necessary
-	response := webrtc.RTPCodecCapability{}
+func (m *Mapper) FromTrackToRTPCodecCapability(task entities.DonutMediaTask) webrtc.RTPCodecCapability {
+	// the fmtp lines must match the ones registered at the media engine
+	codec := task.Codec
+	response := webrtc.RTPCodecCapability{ClockRate: 90000}
 
 	if codec == entities.H264 {
 		response.MimeType = webrtc.MimeTypeH264
+		response.SDPFmtpLine = "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"
 	} else if codec == entities.H265 {
 		response.MimeType = webrtc.MimeTypeH265
+	} else if codec == entities.VP8 {
+		response.MimeType = webrtc.MimeTypeVP8
+	} else if codec == entities.VP9 {
+		response.MimeType = webrtc.MimeTypeVP9
+		response.SDPFmtpLine = "profile-id=0"
+	} else if codec == entities.AV1 {
+		// ref https://aomediacodec.github.io/av1-rtp-spec/#72-sdp-parameters
+		response.MimeType = webrtc.MimeTypeAV1
+		response.SDPFmtpLine = "level-idx=5;profile=0;tier=0"
 	} else if codec == entities.Opus {
 		response.MimeType = webrtc.MimeTypeOpus
+		response.ClockRate = 48000
+		response.Channels = 2
+		response.SDPFmtpLine = "minptime=10;useinbandfec=1"
 	} else {
 		m.l.Info("[[[[TODO: mapper not implemented]]]] for ", codec)
 	}
 
+	if task.Fmtp != "" {
+		response.SDPFmtpLine = task.Fmtp
+	}
 	return response
 }
 
+// FromTrackToRTPPayloader returns the payloader for the codec, they're the same ones used by the pion sample tracks.
+func (m *Mapper) FromTrackToRTPPayloader(task entities.DonutMediaTask) (rtp.Payloader, error) {
+	codec := task.Codec
+	if codec == entities.H264 {
+		return &codecs.H264Payloader{}, nil
+	} else if codec == entities.VP8 {
+		return &codecs.VP8Payloader{EnablePictureID: true}, nil
+	} else if codec == entities.VP9 {
+		return &codecs.VP9Payloader{}, nil
+	} else if codec == entities.AV1 {
+		return &codecs.AV1Payloader{}, nil
+	} else if codec == entities.Opus {
+		return &codecs.OpusPayloader{}, nil
+	}
+	return nil, fmt.Errorf("%w %s", entities.ErrUnsupportedRTPPayloader, codec)
+}
+
+// FromH264ProfileLevelIDToFmtp returns the fmtp line for the profile-level-id,
+// the packetization mode is the non interleaved one used by the RTP payloader.
+func (m *Mapper) FromH264ProfileLevelIDToFmtp(p entities.H264ProfileLevelID) string {
+	return "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + p.String()
+}
+
+// FromH264StreamToClientFmtp matches the server H264 stream profile-level-id against the client offered H264 payloads,
+// returning the fmtp line to be used by the track. It returns false when the client can't decode the stream.
+// The level is only taken into account when the client doesn't allow level asymmetry.
+// ref https://datatracker.ietf.org/doc/html/rfc6184#section-8.2.2
+func (m *Mapper) FromH264StreamToClientFmtp(server entities.Stream, client *entities.StreamInfo) (string, bool) {
+	serverPLI, serverErr := entities.ParseH264ProfileLevelID(entities.ParseFmtp(server.Fmtp)["profile-level-id"])
+	if serverErr != nil {
+		m.l.Infof("unknown server H264 profile-level-id for %q, assuming any H264 client is compatible", server.Fmtp)
+	}
+
+	for _, st := range client.VideoStreams() {
+		if st.Codec != entities.H264 {
+			continue
+		}
+		if serverErr != nil {
+			return "", true
+		}
+
+		params := entities.ParseFmtp(st.Fmtp)
+		// the RTP payloader fragments the NALs (FU-A), which requires the non interleaved mode
+		if params["packetization-mode"] != "1" {
+			continue
+		}
+		// the default one is baseline level 1
+		clientPLI := entities.H264ProfileLevelID{ProfileIDC: 0x42, LevelIDC: 0x0a}
+		if v, ok := params["profile-level-id"]; ok {
+			var err error
+			if clientPLI, err = entities.ParseH264ProfileLevelID(v); err != nil {
+				m.l.Infof("ignoring client H264 payload %q: %s", st.Fmtp, err)
+				continue
+			}
+		}
+		if !clientPLI.DecodesProfile(serverPLI) {
+			continue
+		}
+
+		answer := clientPLI
+		if params["level-asymmetry-allowed"] == "1" {
+			answer.LevelIDC = serverPLI.LevelIDC
+		} else if !serverPLI.LevelAtMost(clientPLI) {
+			continue
+		}
+		return m.FromH264ProfileLevelIDToFmtp(answer), true
+	}
+	return "", false
+}
+
 func (m *Mapper) FromWebRTCSessionDescriptionToStreamInfo(desc webrtc.SessionDescription) (*entities.StreamInfo, error) {
 	sdpDesc, err := desc.Unmarshal()
 	if err != nil {
 		return nil, err
 	}
 	result := &entities.StreamInfo{}
-	unique := map[entities.Codec]entities.Stream{}
+	// the streams are kept in the offer order, which is the client preference
+	unique := map[entities.Stream]bool{}
 
 	for _, desc := range sdpDesc.MediaDescriptions {
 		// Currently defined media (MediaName.Media) are "audio","video", "text", "application", and "message"
@@ -62,6 +154,15 @@ func (m *Mapper) FromWebRTCSessionDescriptionToStreamInfo(desc webrtc.SessionDes
 			mediaType = entities.AudioType
 		}
 
+		// Key:fmtp Value: 102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f
+		fmtps := map[string]string{}
+		for _, a := range desc.Attributes {
+			if a.Key == "fmtp" {
+				payloadType, fmtp, _ := strings.Cut(a.Value, " ")
+				fmtps[payloadType] = fmtp
+			}
+		}
+
 		for _, a := range desc.Attributes {
 			if strings.Contains(a.Key, "rtpmap") {
 				// Samples:
@@ -70,44 +171,32 @@ func (m *Mapper) FromWebRTCSessionDescriptionToStreamInfo(desc webrtc.SessionDes
 				// Key:rtpmap Value: 102 H264/90000
 				// Key:rtpmap Value: 47  AV1/90000
 				// Key:rtpmap Value: 111 opus/48000/2
+				payloadType, _, _ := strings.Cut(a.Value, " ")
+				st := entities.Stream{Type: mediaType}
 				if strings.Contains(a.Value, "H264") {
-					unique[entities.H264] = entities.Stream{
-						Codec: entities.H264,
-						Type:  mediaType,
-					}
+					st.Codec = entities.H264
+					// each H264 payload has its own profile and packetization mode
+					st.Fmtp = fmtps[payloadType]
 				} else if strings.Contains(a.Value, "H265") {
-					unique[entities.H265] = entities.Stream{
-						Codec: entities.H265,
-						Type:  mediaType,
-					}
+					st.Codec = entities.H265
 				} else if strings.Contains(a.Value, "VP8") {
-					unique[entities.VP8] = entities.Stream{
-						Codec: entities.VP8,
-						Type:  mediaType,
-					}
+					st.Codec = entities.VP8
 				} else if strings.Contains(a.Value, "VP9") {
-					unique[entities.VP9] = entities.Stream{
-						Codec: entities.VP9,
-						Type:  mediaType,
-					}
+					st.Codec = entities.VP9
 				} else if strings.Contains(a.Value, "AV1") {
-					unique[entities.AV1] = entities.Stream{
-						Codec: entities.AV1,
-						Type:  mediaType,
-					}
+					st.Codec = entities.AV1
 				} else if strings.Contains(a.Value, "opus") {
-					unique[entities.Opus] = entities.Stream{
-						Codec: entities.Opus,
-						Type:  mediaType,
-					}
+					st.Codec = entities.Opus
 				} else {
 					m.l.Info("[[[[TODO: mapper not implemented]]]] for ", a.Value)
+					continue
 				}
-			}
-		}
 
-		for _, v := range unique {
-			result.Streams = append(result.Streams, v)
+				if !unique[st] {
+					unique[st] = true
+					result.Streams = append(result.Streams, st)
+				}
+			}
 		}
 	}
 	return result, nil
@@ -124,9 +213,216 @@ func (m *Mapper) FromStreamInfoToEntityMessages(si *entities.StreamInfo) []entit
 }
 
 func (m *Mapper) FromStreamToEntityMessage(st entities.Stream) entities.Message {
+	msg := string(st.Codec)
+	if st.Type == entities.VideoType && st.Width > 0 {
+		msg = fmt.Sprintf("%s %dx%d@%.2f", msg, st.Width, st.Height, st.FrameRate)
+	}
+	if st.Type == entities.AudioType && st.SampleRate > 0 {
+		msg = fmt.Sprintf("%s %dHz %s", msg, st.SampleRate, st.ChannelLayout)
+	}
+	if st.Language != "" {
+		msg = fmt.Sprintf("%s [%s]", msg, st.Language)
+	}
+	if st.Action != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, st.Action)
+	}
+
 	return entities.Message{
+		Version: entities.DataChannelProtocolVersion,
 		Type:    entities.MessageTypeMetadata,
-		Message: string(st.Codec),
+		Message: msg,
+		Payload: st,
+	}
+}
+
+func (m *Mapper) FromStatsToEntityMessage(st entities.SessionStats) entities.Message {
+	return entities.Message{
+		Version: entities.DataChannelProtocolVersion,
+		Type:    entities.MessageTypeStats,
+		Message: fmt.Sprintf("in %dkbps out %dkbps %.1ffps latency %.0fms a/v %dms",
+			st.Streamer.InputBitRate/1000, st.Streamer.OutputBitRate/1000, st.Streamer.OutputFPS, st.LatencyMS, st.Streamer.AVOffsetMS),
+		Payload: st,
+	}
+}
+
+// FromRTCPReceptionReportToEntity maps a RTCP reception report, the round trip time is computed
+// from the last sender report (LSR) and its delay (DLSR), both in 1/65536 seconds.
+// ref https://datatracker.ietf.org/doc/html/rfc3550#section-6.4.1
+func (m *Mapper) FromRTCPReceptionReportToEntity(r rtcp.ReceptionReport, mediaType entities.MediaType, now time.Time) entities.ReceptionReport {
+	clockRate := float64(90000)
+	if mediaType == entities.AudioType {
+		clockRate = 48000
+	}
+
+	report := entities.ReceptionReport{
+		MediaType:    mediaType,
+		SSRC:         r.SSRC,
+		FractionLost: float64(r.FractionLost) / 256,
+		TotalLost:    r.TotalLost,
+		JitterMS:     float64(r.Jitter) / clockRate * 1000,
+	}
+
+	if r.LastSenderReport != 0 {
+		rtt := m.fromTimeToCompactNTP(now) - r.LastSenderReport - r.Delay
+		report.RoundTripTimeMS = float64(rtt) / 65536 * 1000
+	}
+
+	return report
+}
+
+// fromTimeToCompactNTP returns the middle 32 bits of the NTP timestamp.
+func (m *Mapper) fromTimeToCompactNTP(t time.Time) uint32 {
+	// seconds between 1900 (NTP epoch) and 1970 (unix epoch)
+	const ntpEpochOffset = 2208988800
+	seconds := uint64(t.Unix()) + ntpEpochOffset
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return uint32((seconds<<32 | fraction) >> 16)
+}
+
+// FromSpliceInfoToEntitySpliceEvent maps the splice commands that signal ad breaks,
+// it returns false for the ones without meaning to the client (i.e. splice_null heartbeats).
+func (m *Mapper) FromSpliceInfoToEntitySpliceEvent(info entities.SpliceInfo) (entities.SpliceEvent, bool) {
+	ev := entities.SpliceEvent{Info: info}
+	pts, hasPTS := info.SplicePTS()
+	ev.PTS = pts
+	ev.Immediate = !hasPTS
+
+	switch {
+	case info.SpliceInsert != nil:
+		insert := info.SpliceInsert
+		ev.EventID = insert.EventID
+		ev.AutoReturn = insert.AutoReturn
+		ev.DurationMS = insert.BreakDuration / 90
+		switch {
+		case insert.Cancel:
+			ev.Type = entities.SpliceEventCancel
+		case insert.OutOfNetwork:
+			ev.Type = entities.SpliceEventCueOut
+		default:
+			ev.Type = entities.SpliceEventCueIn
+		}
+	case info.TimeSignal != nil:
+		ev.Type = entities.SpliceEventSignal
+		if len(info.Segmentations) > 0 {
+			seg := info.Segmentations[0]
+			ev.EventID = seg.EventID
+			ev.SegmentationTypeID = seg.TypeID
+			ev.DurationMS = seg.Duration / 90
+			switch {
+			case seg.Cancel:
+				ev.Type = entities.SpliceEventCancel
+			case seg.TypeID.IsAdBreakStart():
+				ev.Type = entities.SpliceEventCueOut
+			case seg.TypeID.IsAdBreakEnd():
+				ev.Type = entities.SpliceEventCueIn
+			}
+		}
+	default:
+		return ev, false
+	}
+
+	return ev, true
+}
+
+func (m *Mapper) FromSpliceEventToEntityMessage(ev entities.SpliceEvent) entities.Message {
+	msg := fmt.Sprintf("scte35 %s event=%d", ev.Type, ev.EventID)
+	if ev.DurationMS > 0 {
+		msg = fmt.Sprintf("%s duration=%dms", msg, ev.DurationMS)
+	}
+	return entities.Message{
+		Version: entities.DataChannelProtocolVersion,
+		Type:    entities.MessageTypeEvent,
+		Message: msg,
+		Payload: ev,
+	}
+}
+
+func (m *Mapper) FromTimedMetadataToEntityMessage(md entities.TimedMetadata) entities.Message {
+	msg := fmt.Sprintf("%s @%dms", md.Type, md.PTSMS)
+	switch {
+	case md.Text != "":
+		msg = fmt.Sprintf("%s %s", msg, md.Text)
+	case len(md.Frames) > 0:
+		ids := make([]string, 0, len(md.Frames))
+		for _, f := range md.Frames {
+			ids = append(ids, f.ID)
+		}
+		msg = fmt.Sprintf("%s %s", msg, strings.Join(ids, ","))
+	}
+	return entities.Message{
+		Version: entities.DataChannelProtocolVersion,
+		Type:    entities.MessageTypeTimedMetadata,
+		Message: msg,
+		Payload: md,
+	}
+}
+
+// fromH264ExtraDataToFmtp derives the fmtp line from the SPS found at the extradata,
+// either avcC (i.e. flv) or Annex B (i.e. mpegts). It returns empty when there's no SPS.
+func (m *Mapper) fromH264ExtraDataToFmtp(extradata []byte) string {
+	// ISO/IEC 14496-15 AVCDecoderConfigurationRecord: version, profile, compatibility and level
+	if len(extradata) >= 4 && extradata[0] == 1 {
+		return m.FromH264ProfileLevelIDToFmtp(entities.H264ProfileLevelID{
+			ProfileIDC: extradata[1], ProfileIOP: extradata[2], LevelIDC: extradata[3],
+		})
+	}
+
+	for i := 0; i+6 < len(extradata); i++ {
+		isStartCode := extradata[i] == 0x00 && extradata[i+1] == 0x00 && extradata[i+2] == 0x01
+		if isStartCode && entities.NALUnitType(extradata[i+3]&0x1f) == entities.SequenceParameterSet {
+			return m.FromH264ProfileLevelIDToFmtp(entities.H264ProfileLevelID{
+				ProfileIDC: extradata[i+4], ProfileIOP: extradata[i+5], LevelIDC: extradata[i+6],
+			})
+		}
+	}
+	return ""
+}
+
+// FromLibAVDictionaryToMap returns all the dictionary entries, it returns nil for nil dictionaries.
+func (m *Mapper) FromLibAVDictionaryToMap(d *astiav.Dictionary) map[string]string {
+	if d == nil {
+		return nil
+	}
+	result := make(map[string]string)
+	var entry *astiav.DictionaryEntry
+	for {
+		// an empty key with ignore suffix iterates over all the entries
+		if entry = d.Get("", entry, astiav.NewDictionaryFlags(astiav.DictionaryFlagIgnoreSuffix)); entry == nil {
+			break
+		}
+		result[entry.Key()] = entry.Value()
+	}
+	return result
+}
+
+func (m *Mapper) FromCueToEntityMessage(c entities.Cue) entities.Message {
+	return entities.Message{
+		Version: entities.DataChannelProtocolVersion,
+		Type:    entities.MessageTypeCaptions,
+		Message: c.Text,
+		Payload: c,
+	}
+}
+
+func (m *Mapper) FromCommandResponseToEntityMessage(r entities.CommandResponse) entities.Message {
+	msg := string(r.Type)
+	if r.Error != "" {
+		msg = fmt.Sprintf("%s: %s", r.Type, r.Error)
+	}
+	return entities.Message{
+		Version: entities.DataChannelProtocolVersion,
+		Type:    entities.MessageTypeCommandResponse,
+		Message: msg,
+		Payload: r,
+	}
+}
+
+func (m *Mapper) FromMediaSourceToEntityMessage(ms entities.MediaSource) entities.Message {
+	return entities.Message{
+		Version: entities.DataChannelProtocolVersion,
+		Type:    entities.MessageTypeMediaSource,
+		Message: ms.MimeType,
+		Payload: ms,
 	}
 }
 
@@ -137,6 +433,10 @@ func (m *Mapper) FromLibAVStreamToEntityStream(libavStream *astiav.Stream) entit
 		st.Type = entities.AudioType
 	} else if libavStream.CodecParameters().MediaType() == astiav.MediaTypeVideo {
 		st.Type = entities.VideoType
+	} else if libavStream.CodecParameters().MediaType() == astiav.MediaTypeSubtitle {
+		st.Type = entities.SubtitleType
+	} else if libavStream.CodecParameters().MediaType() == astiav.MediaTypeData {
+		st.Type = entities.DataType
 	} else {
 		m.l.Info("[[[[TODO: mapper not implemented]]]] for ", libavStream.CodecParameters().MediaType())
 		st.Type = entities.UnknownType
@@ -159,6 +459,8 @@ func (m *Mapper) FromLibAVStreamToEntityStream(libavStream *astiav.Stream) entit
 		st.Codec = entities.VP9
 	} else if libavStream.CodecParameters().CodecID().Name() == "opus" {
 		st.Codec = entities.Opus
+	} else if st.Type == entities.SubtitleType {
+		st.Codec = m.fromLibAVSubtitleCodecID(libavStream.CodecParameters().CodecID())
 	} else {
 		m.l.Info("[[[[TODO: mapper not implemented]]]] for ", libavStream.CodecParameters().CodecID().Name())
 		st.Codec = entities.UnknownCodec
@@ -167,9 +469,167 @@ func (m *Mapper) FromLibAVStreamToEntityStream(libavStream *astiav.Stream) entit
 	st.Id = uint16(libavStream.ID())
 	st.Index = uint16(libavStream.Index())
 
+	codecParams := libavStream.CodecParameters()
+	st.Profile = m.fromLibAVProfileToString(st.Codec, codecParams.Profile())
+	st.BitRate = codecParams.BitRate()
+
+	if st.Type == entities.VideoType {
+		st.Width = codecParams.Width()
+		st.Height = codecParams.Height()
+		st.PixelFormat = codecParams.PixelFormat().Name()
+		if level := int(codecParams.Level()); level > 0 {
+			st.Level = level
+		}
+		if st.Codec == entities.H264 {
+			st.Fmtp = m.fromH264ExtraDataToFmtp(codecParams.ExtraData())
+		}
+		frameRate := libavStream.AvgFrameRate()
+		if frameRate.Num() == 0 || frameRate.Den() == 0 {
+			frameRate = libavStream.RFrameRate()
+		}
+		if frameRate.Den() != 0 {
+			st.FrameRate = frameRate.Float64()
+		}
+	}
+
+	if st.Type == entities.AudioType {
+		st.SampleRate = codecParams.SampleRate()
+		st.SampleFormat = codecParams.SampleFormat().Name()
+		st.Channels = codecParams.ChannelLayout().NbChannels()
+		st.ChannelLayout = codecParams.ChannelLayout().String()
+	}
+
+	if md := libavStream.Metadata(); md != nil {
+		if lang := md.Get("language", nil, astiav.NewDictionaryFlags()); lang != nil {
+			st.Language = lang.Value()
+		}
+	}
+
+	return st
+}
+
+func (m *Mapper) fromLibAVSubtitleCodecID(codecID astiav.CodecID) entities.Codec {
+	switch codecID {
+	case astiav.CodecIDWebvtt:
+		return entities.WebVTT
+	case astiav.CodecIDSubrip:
+		return entities.SubRip
+	case astiav.CodecIDAss:
+		return entities.ASS
+	case astiav.CodecIDMovText:
+		return entities.MovText
+	case astiav.CodecIDText:
+		return entities.Text
+	case astiav.CodecIDDvbTeletext:
+		return entities.DVBTeletext
+	case astiav.CodecIDDvbSubtitle:
+		return entities.DVBSubtitle
+	}
+	m.l.Info("[[[[TODO: mapper not implemented]]]] for ", codecID.Name())
+	return entities.UnknownCodec
+}
+
+// FromLibAVFrameToEntityStream updates the stream with the parameters of a decoded frame,
+// it's used to detect changes mid-stream (i.e. resolution or sample rate).
+func (m *Mapper) FromLibAVFrameToEntityStream(st entities.Stream, f *astiav.Frame) entities.Stream {
+	if st.Type == entities.VideoType {
+		st.Width = f.Width()
+		st.Height = f.Height()
+		st.PixelFormat = f.PixelFormat().Name()
+	}
+	if st.Type == entities.AudioType {
+		st.SampleRate = f.SampleRate()
+		st.SampleFormat = f.SampleFormat().Name()
+		st.Channels = f.ChannelLayout().NbChannels()
+		st.ChannelLayout = f.ChannelLayout().String()
+	}
 	return st
 }
 
+// FromH264SPSToEntityStream updates the video parameters from the SPS of a bypassed stream, the profile
+// and the fmtp are kept since they were negotiated already.
+func (m *Mapper) FromH264SPSToEntityStream(st entities.Stream, sps *entities.SPS) entities.Stream {
+	st.Width = sps.Width()
+	st.Height = sps.Height()
+	st.Level = int(sps.LevelIDC)
+	if frameRate := sps.FrameRate(); frameRate > 0 {
+		st.FrameRate = frameRate
+	}
+	return st
+}
+
+// ref https://github.com/FFmpeg/FFmpeg/blob/n5.1/libavcodec/profiles.c
+func (m *Mapper) fromLibAVProfileToString(codec entities.Codec, profile astiav.Profile) string {
+	switch codec {
+	case entities.H264:
+		switch profile {
+		case astiav.ProfileH264Baseline:
+			return "Baseline"
+		case astiav.ProfileH264ConstrainedBaseline:
+			return "Constrained Baseline"
+		case astiav.ProfileH264Main:
+			return "Main"
+		case astiav.ProfileH264Extended:
+			return "Extended"
+		case astiav.ProfileH264High:
+			return "High"
+		case astiav.ProfileH264High10:
+			return "High 10"
+		case astiav.ProfileH264High422:
+			return "High 4:2:2"
+		case astiav.ProfileH264High444Predictive:
+			return "High 4:4:4 Predictive"
+		}
+	case entities.H265:
+		switch profile {
+		case astiav.ProfileHevcMain:
+			return "Main"
+		case astiav.ProfileHevcMain10:
+			return "Main 10"
+		case astiav.ProfileHevcMainStillPicture:
+			return "Main Still Picture"
+		case astiav.ProfileHevcRext:
+			return "Rext"
+		}
+	case entities.VP9:
+		switch profile {
+		case astiav.ProfileVp90:
+			return "Profile 0"
+		case astiav.ProfileVp91:
+			return "Profile 1"
+		case astiav.ProfileVp92:
+			return "Profile 2"
+		case astiav.ProfileVp93:
+			return "Profile 3"
+		}
+	case entities.AV1:
+		switch profile {
+		case astiav.ProfileAv1Main:
+			return "Main"
+		case astiav.ProfileAv1High:
+			return "High"
+		case astiav.ProfileAv1Professional:
+			return "Professional"
+		}
+	case entities.AAC:
+		switch profile {
+		case astiav.ProfileAacMain:
+			return "Main"
+		case astiav.ProfileAacLow:
+			return "LC"
+		case astiav.ProfileAacHe:
+			return "HE-AAC"
+		case astiav.ProfileAacHeV2:
+			return "HE-AACv2"
+		case astiav.ProfileAacLd:
+			return "LD"
+		case astiav.ProfileAacEld:
+			return "ELD"
+		}
+	}
+	return ""
+}
+
 func (m *Mapper) FromStreamCodecToLibAVCodecID(codec entities.Codec) (astiav.CodecID, error) {
 	if codec == entities.H264 {
 		return astiav.CodecIDH264, nil
@@ -188,3 +648,86 @@ func (m *Mapper) FromStreamCodecToLibAVCodecID(codec entities.Codec) (astiav.Cod
 	// TODO: port error to entities
 	return astiav.CodecIDH264, fmt.Errorf("cannot find a libav codec id for donut codec id %+v", codec)
 }
+
+// FromStreamCodecToLibAVEncoderNames returns the preferred libav encoders for the codec, in order.
+// AV1 has no libav codec id mapped so it relies on them.
+func (m *Mapper) FromStreamCodecToLibAVEncoderNames(codec entities.Codec) []string {
+	if codec == entities.H264 {
+		return []string{"libx264"}
+	} else if codec == entities.VP8 {
+		return []string{"libvpx"}
+	} else if codec == entities.VP9 {
+		return []string{"libvpx-vp9"}
+	} else if codec == entities.AV1 {
+		// SVT-AV1 is way faster than libaom at the real time presets
+		return []string{"libsvtav1", "libaom-av1"}
+	} else if codec == entities.Opus {
+		return []string{"libopus"}
+	}
+	return nil
+}
+
+// FromLibAVCodecContextToEntityStream describes the encoder output, the codec is the recipe one
+// since not every donut codec has a libav codec id (i.e. AV1).
+func (m *Mapper) FromLibAVCodecContextToEntityStream(codec entities.Codec, cc *astiav.CodecContext) entities.Stream {
+	st := entities.Stream{Codec: codec, BitRate: cc.BitRate()}
+	if cc.MediaType() == astiav.MediaTypeVideo {
+		st.Type = entities.VideoType
+		st.Width = cc.Width()
+		st.Height = cc.Height()
+		st.PixelFormat = cc.PixelFormat().Name()
+		if frameRate := cc.Framerate(); frameRate.Den() != 0 {
+			st.FrameRate = frameRate.Float64()
+		}
+	} else if cc.MediaType() == astiav.MediaTypeAudio {
+		st.Type = entities.AudioType
+		st.SampleRate = cc.SampleRate()
+		st.SampleFormat = cc.SampleFormat().Name()
+		st.Channels = cc.ChannelLayout().NbChannels()
+		st.ChannelLayout = cc.ChannelLayout().String()
+	} else {
+		st.Type = entities.UnknownType
+	}
+	return st
+}
+
+// FromOutputStreamToLibAVCodecParameters fills the codec parameters used by the muxers.
+func (m *Mapper) FromOutputStreamToLibAVCodecParameters(st entities.OutputStream, cp *astiav.CodecParameters) error {
+	codecID, err := m.FromStreamCodecToLibAVCodecID(st.Codec)
+	if err != nil {
+		return err
+	}
+	cp.SetCodecID(codecID)
+
+	if st.Type == entities.VideoType {
+		cp.SetMediaType(astiav.MediaTypeVideo)
+		cp.SetWidth(st.Width)
+		cp.SetHeight(st.Height)
+	} else if st.Type == entities.AudioType {
+		cp.SetMediaType(astiav.MediaTypeAudio)
+		cp.SetSampleRate(st.SampleRate)
+		cp.SetChannelLayout(m.fromChannelsToLibAVChannelLayout(st.Channels))
+		cp.SetFrameSize(st.FrameSize)
+	} else {
+		return fmt.Errorf("cannot mux the donut media type %+v", st.Type)
+	}
+
+	if len(st.ExtraData) > 0 {
+		if err := cp.SetExtraData(st.ExtraData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mapper) fromChannelsToLibAVChannelLayout(channels int) astiav.ChannelLayout {
+	switch channels {
+	case 1:
+		return astiav.ChannelLayoutMono
+	case 6:
+		return astiav.ChannelLayout5Point1
+	case 8:
+		return astiav.ChannelLayout7Point1
+	}
+	return astiav.ChannelLayoutStereo
+}
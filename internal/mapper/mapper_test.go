@@ -0,0 +1,128 @@
+package mapper_test
+
+import (
+	"testing"
+
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/mapper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestMapper_FromH264StreamToClientFmtp(t *testing.T) {
+	t.Parallel()
+	h264 := func(fmtp string) entities.Stream {
+		return entities.Stream{Type: entities.VideoType, Codec: entities.H264, Fmtp: fmtp}
+	}
+
+	tests := []struct {
+		name     string
+		server   string
+		client   []entities.Stream
+		expected string
+		ok       bool
+	}{
+		{
+			name:     "the server level is answered when the client allows level asymmetry",
+			server:   "packetization-mode=1;profile-level-id=42e033",
+			client:   []entities.Stream{h264("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f")},
+			expected: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e033",
+			ok:       true,
+		},
+		{
+			name:     "the client level is answered when the server one fits it",
+			server:   "packetization-mode=1;profile-level-id=42e01e",
+			client:   []entities.Stream{h264("packetization-mode=1;profile-level-id=42e01f")},
+			expected: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+			ok:       true,
+		},
+		{
+			name:   "the server level above the client one without level asymmetry",
+			server: "packetization-mode=1;profile-level-id=42e020",
+			client: []entities.Stream{h264("packetization-mode=1;profile-level-id=42e01f")},
+		},
+		{
+			name:   "the client payload without packetization mode is the single NAL one",
+			server: "packetization-mode=1;profile-level-id=42e01f",
+			client: []entities.Stream{h264("level-asymmetry-allowed=1;profile-level-id=42e01f")},
+		},
+		{
+			name:   "the client payload without profile-level-id is baseline level 1",
+			server: "packetization-mode=1;profile-level-id=42e00b",
+			client: []entities.Stream{h264("packetization-mode=1")},
+		},
+		{
+			name:   "a baseline stream isn't decoded by a constrained baseline client",
+			server: "packetization-mode=1;profile-level-id=42001f",
+			client: []entities.Stream{h264("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f")},
+		},
+		{
+			name:   "the client payload profile-level-id is invalid",
+			server: "packetization-mode=1;profile-level-id=42e01f",
+			client: []entities.Stream{h264("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=zz")},
+		},
+		{
+			name:   "the first client payload decoding the stream is answered",
+			server: "packetization-mode=1;profile-level-id=4d001f",
+			client: []entities.Stream{
+				h264("level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=4d001f"),
+				h264("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"),
+				h264("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640c1f"),
+				h264("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64001f"),
+				h264("level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f"),
+			},
+			expected: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=64001f",
+			ok:       true,
+		},
+		{
+			name:   "any H264 client for an unknown server profile-level-id",
+			server: "",
+			client: []entities.Stream{
+				{Type: entities.VideoType, Codec: entities.VP8},
+				h264("packetization-mode=0;profile-level-id=42e01f"),
+			},
+			ok: true,
+		},
+		{
+			name:   "a client without H264",
+			server: "packetization-mode=1;profile-level-id=42e01f",
+			client: []entities.Stream{{Type: entities.VideoType, Codec: entities.VP8}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			m := mapper.NewMapper(zap.NewNop().Sugar())
+			fmtp, ok := m.FromH264StreamToClientFmtp(h264(tt.server), &entities.StreamInfo{Streams: tt.client})
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, fmtp)
+		})
+	}
+}
+
+func TestMapper_FromH264SPSToEntityStream(t *testing.T) {
+	t.Parallel()
+	m := mapper.NewMapper(zap.NewNop().Sugar())
+	st := entities.Stream{
+		Type:      entities.VideoType,
+		Codec:     entities.H264,
+		Profile:   "High",
+		Level:     30,
+		Width:     640,
+		Height:    360,
+		FrameRate: 25,
+		Fmtp:      "packetization-mode=1;profile-level-id=64001e",
+	}
+	sps := &entities.SPS{LevelIDC: 31, FrameMBsOnly: true, ChromaFormatIDC: 1, PicWidthInMBs: 80, PicHeightInMapUnits: 45}
+
+	expected := st
+	expected.Level = 31
+	expected.Width = 1280
+	expected.Height = 720
+	assert.Equal(t, expected, m.FromH264SPSToEntityStream(st, sps), "the frame rate is kept without timing info")
+
+	sps.VUI = &entities.VUI{TimingInfoPresent: true, NumUnitsInTick: 1, TimeScale: 60}
+	expected.FrameRate = 30
+	assert.Equal(t, expected, m.FromH264SPSToEntityStream(st, sps))
+}
@@ -66,6 +66,16 @@ func (t *testFFmpeg) Output() entities.RequestParams {
 	return t.output
 }
 
+// WithoutBitRate clears the bit rate, it's estimated by the libav parsers and varies between runs.
+func WithoutBitRate(streams []entities.Stream) []entities.Stream {
+	result := make([]entities.Stream, 0, len(streams))
+	for _, st := range streams {
+		st.BitRate = 0
+		result = append(result, st)
+	}
+	return result
+}
+
 func prepareFFmpegParameters(cmd string) []string {
 	result := []string{}
 
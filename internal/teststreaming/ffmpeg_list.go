@@ -28,8 +28,17 @@ var FFMPEG_LIVE_SRT_MPEG_TS_H264_AAC = testFFmpeg{
     	-c:a aac -b:a 96k -f mpegts srt://0.0.0.0:` + strconv.Itoa(outputPort+0) + `?mode=listener&smoother=live&transtype=live
 	`,
 	expectedStreams: []entities.Stream{
-		{Index: 0, Id: uint16(256), Codec: entities.H264, Type: entities.VideoType},
-		{Index: 1, Id: uint16(257), Codec: entities.AAC, Type: entities.AudioType},
+		{
+			Index: 0, Id: uint16(256), Codec: entities.H264, Type: entities.VideoType,
+			Profile: "Constrained Baseline", Level: 21,
+			Width: 512, Height: 288, FrameRate: 30, PixelFormat: "yuv420p",
+			Fmtp: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42c015",
+		},
+		{
+			Index: 1, Id: uint16(257), Codec: entities.AAC, Type: entities.AudioType,
+			Profile:    "LC",
+			SampleRate: 44100, SampleFormat: "fltp", Channels: 1, ChannelLayout: "mono",
+		},
 	},
 	output: entities.RequestParams{StreamURL: fmt.Sprintf("srt://127.0.0.1:%d", outputPort+0), StreamID: "stream-id"},
 }
@@ -42,8 +51,16 @@ var FFMPEG_LIVE_SRT_MPEG_TS_H265_AAC = testFFmpeg{
     	-c:a aac -b:a 96k -f mpegts srt://0.0.0.0:` + strconv.Itoa(outputPort+1) + `?mode=listener&smoother=live&transtype=live
 	`,
 	expectedStreams: []entities.Stream{
-		{Index: 0, Id: uint16(256), Codec: entities.H265, Type: entities.VideoType},
-		{Index: 1, Id: uint16(257), Codec: entities.AAC, Type: entities.AudioType},
+		{
+			Index: 0, Id: uint16(256), Codec: entities.H265, Type: entities.VideoType,
+			Profile: "Main", Level: 63,
+			Width: 512, Height: 288, FrameRate: 30, PixelFormat: "yuv420p",
+		},
+		{
+			Index: 1, Id: uint16(257), Codec: entities.AAC, Type: entities.AudioType,
+			Profile:    "LC",
+			SampleRate: 44100, SampleFormat: "fltp", Channels: 1, ChannelLayout: "mono",
+		},
 	},
 	output: entities.RequestParams{StreamURL: fmt.Sprintf("srt://127.0.0.1:%d", outputPort+1), StreamID: "stream-id"},
 }
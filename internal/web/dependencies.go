@@ -6,6 +6,8 @@ import (
 	"github.com/flavioribeiro/donut/internal/controllers"
 	"github.com/flavioribeiro/donut/internal/controllers/engine"
 	"github.com/flavioribeiro/donut/internal/controllers/probers"
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/controllers/snapshots"
 	"github.com/flavioribeiro/donut/internal/controllers/streamers"
 	"github.com/flavioribeiro/donut/internal/entities"
 	"github.com/flavioribeiro/donut/internal/mapper"
@@ -33,13 +35,28 @@ func Dependencies(enableICEMux bool) fx.Option {
 		// HTTP handlers
 		fx.Provide(handlers.NewSignalingHandler),
 		fx.Provide(handlers.NewIndexHandler),
+		fx.Provide(handlers.NewStatsHandler),
+		fx.Provide(handlers.NewRecordingsHandler),
+		fx.Provide(handlers.NewHLSHandler),
+		fx.Provide(handlers.NewRestreamsHandler),
+		fx.Provide(handlers.NewSnapshotHandler),
+		fx.Provide(handlers.NewProbeHandler),
 
 		// ICE mux servers
 		fx.Provide(controllers.NewTCPICEServer),
 		fx.Provide(controllers.NewUDPICEServer),
 
+		// Embedded TURN server
+		fx.Provide(controllers.NewEmbeddedTURNServer),
+
 		// Controllers
 		fx.Provide(controllers.NewWebRTCController),
+		fx.Provide(controllers.NewSessionController),
+		fx.Provide(sinks.NewRecordingController),
+		fx.Provide(sinks.NewHLSController),
+		fx.Provide(sinks.NewRestreamController),
+		fx.Provide(snapshots.NewSnapshotController),
+		fx.Provide(snapshots.NewLibAVSnapshotter),
 		fx.Provide(controllers.NewWebRTCSettingsEngine),
 		fx.Provide(controllers.NewWebRTCMediaEngine),
 		fx.Provide(controllers.NewWebRTCAPI),
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+)
+
+type HLSHandler struct {
+	l   *zap.SugaredLogger
+	hls *sinks.HLSController
+}
+
+func NewHLSHandler(l *zap.SugaredLogger, hls *sinks.HLSController) *HLSHandler {
+	return &HLSHandler{
+		l:   l,
+		hls: hls,
+	}
+}
+
+// ServeHTTP starts packaging a stream (POST /hls with the RequestParams, the offer is ignored), stops
+// it (DELETE /hls?id=<session id>) and serves its playlist and segments (GET /hls/<session id>/<file>).
+func (h *HLSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		return h.serveMedia(w, r)
+	case http.MethodPost:
+		params := entities.RequestParams{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			return err
+		}
+		stream, err := h.hls.Start(params)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Donut-Session-ID", stream.SessionID)
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(stream)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if err := h.hls.Stop(id); err != nil {
+			return fmt.Errorf("%w %s", err, id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return entities.ErrHTTPMethodNotAllowed
+}
+
+func (h *HLSHandler) serveMedia(w http.ResponseWriter, r *http.Request) error {
+	id, name, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, entities.HLSPathPrefix), "/")
+	packager, ok := h.hls.Packager(id)
+	if !ok {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	var sequence, part int
+	if name == entities.HLSPlaylistPath {
+		// blocking playlist reload (LL-HLS)
+		block := r.URL.Query().Has("_HLS_msn")
+		sequence, _ = strconv.Atoi(r.URL.Query().Get("_HLS_msn"))
+		part = -1
+		if v := r.URL.Query().Get("_HLS_part"); v != "" {
+			part, _ = strconv.Atoi(v)
+		}
+		playlist, ok := packager.Playlist(block, sequence, part)
+		if !ok {
+			http.Error(w, fmt.Sprintf("_HLS_msn=%d is too far ahead", sequence), http.StatusBadRequest)
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, err := w.Write([]byte(playlist))
+		return err
+	}
+
+	var data []byte
+	if _, err := fmt.Sscanf(name, "part%d.%d.m4s", &sequence, &part); err == nil {
+		data, ok = packager.Part(sequence, part)
+	} else if _, err := fmt.Sscanf(name, "seg%d.m4s", &sequence); err == nil {
+		data, ok = packager.Segment(sequence)
+	} else if _, err := fmt.Sscanf(name, "init%d.mp4", &sequence); err == nil {
+		data, ok = packager.Init(sequence)
+	} else {
+		ok = false
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "max-age=60")
+	_, err := w.Write(data)
+	return err
+}
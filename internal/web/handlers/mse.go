@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// serveMSE streams a session as fragmented MP4 through the WebSocket, the first message is the RequestParams
+// (the output defaults to mse). The fragments are binary messages, each init segment is preceded by a
+// mediaSource message with its MIME type. The other messages (metadata, stats, commands) follow the data
+// channel protocol.
+func (h *SignalingHandler) serveMSE(ws *websocket.Conn) {
+	defer ws.Close()
+	if err := h.streamMSE(ws); err != nil {
+		h.l.Errorw("error while streaming mse", "error", err)
+	}
+}
+
+func (h *SignalingHandler) streamMSE(ws *websocket.Conn) error {
+	params := entities.RequestParams{}
+	if err := websocket.JSON.Receive(ws, &params); err != nil {
+		return err
+	}
+	if params.Output == "" {
+		params.Output = entities.DonutMSE
+	}
+	if err := params.Valid(); err != nil {
+		return err
+	}
+	if params.Output != entities.DonutMSE {
+		return fmt.Errorf("%w %q through the websocket", entities.ErrUnsupportedOutput, params.Output)
+	}
+	h.l.Infof("RequestParams %s", params.String())
+
+	donutEngine, donutRecipe, err := h.prepareRecipe(&params)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	session, err := h.createSession(params)
+	if err != nil {
+		return err
+	}
+	l := h.l.With("session", session.ID)
+	fragmenter := sinks.NewMSEFragmenter(h.c, l, sinks.NewLibAVMuxerFactory(l, h.mapper))
+	if err := session.AddSink(fragmenter); err != nil {
+		session.CloseSinks()
+		h.sessions.Remove(session.ID)
+		return err
+	}
+
+	send := func(msg entities.Message) error {
+		return websocket.JSON.Send(ws, msg)
+	}
+	commands := controllers.NewCommandRegistry()
+	donutParameters := &entities.DonutParameters{
+		Cancel:    cancel,
+		Ctx:       ctx,
+		SessionID: session.ID,
+
+		Recipe: *donutRecipe,
+
+		OnClose: cancel,
+		OnError: func(err error) {
+			l.Errorw("error while streaming", "error", err)
+		},
+		OnStream: func(st *entities.Stream) error {
+			return send(h.mapper.FromStreamToEntityMessage(*st))
+		},
+		OnOutputStream: func(st *entities.OutputStream) error {
+			if err := session.OnOutputStream(*st); err != nil {
+				l.Warnw("error while sending the output stream to the sinks", "error", err)
+			}
+			return nil
+		},
+		OnVideoFrame: func(data []byte, c entities.MediaFrameContext) error {
+			h.sendToSinks(session, entities.VideoType, data, c)
+			return nil
+		},
+		OnAudioFrame: func(data []byte, c entities.MediaFrameContext) error {
+			h.sendToSinks(session, entities.AudioType, data, c)
+			return nil
+		},
+		OnSpliceEvent: func(ev *entities.SpliceEvent) error {
+			return send(h.mapper.FromSpliceEventToEntityMessage(*ev))
+		},
+		OnTimedMetadata: func(md *entities.TimedMetadata) error {
+			return send(h.mapper.FromTimedMetadataToEntityMessage(*md))
+		},
+		OnCue: func(c *entities.Cue) error {
+			return send(h.mapper.FromCueToEntityMessage(*c))
+		},
+		OnStats: func(st entities.StreamerStats) {
+			sessionStats := entities.SessionStats{
+				SessionID: session.ID,
+				Timestamp: time.Now().UnixMilli(),
+				LatencyMS: float64(st.PipelineLatencyMS),
+				Streamer:  st,
+			}
+			session.SetStats(sessionStats)
+			if err := send(h.mapper.FromStatsToEntityMessage(sessionStats)); err != nil {
+				l.Warnw("error while sending stats", "error", err)
+			}
+		},
+		RegisterCommand: commands.Register,
+	}
+	go h.serve(donutEngine, session, donutParameters)
+	go h.handleWebSocketCommands(ws, commands, l, cancel)
+
+	for fragment := range fragmenter.Fragments() {
+		if fragment.MimeType != "" {
+			ms := entities.MediaSource{SessionID: session.ID, MimeType: fragment.MimeType}
+			if err := send(h.mapper.FromMediaSourceToEntityMessage(ms)); err != nil {
+				return err
+			}
+		}
+		if err := websocket.Message.Send(ws, fragment.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleWebSocketCommands dispatches the commands sent by the client, the session is stopped once it leaves.
+func (h *SignalingHandler) handleWebSocketCommands(ws *websocket.Conn, commands *controllers.CommandRegistry, l *zap.SugaredLogger, cancel context.CancelFunc) {
+	defer cancel()
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			l.Infof("websocket is closed: %s", err)
+			return
+		}
+		cmd := entities.Command{}
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			l.Warnw("ignoring invalid websocket message", "error", err)
+			continue
+		}
+		response := commands.Dispatch(cmd)
+		if err := websocket.JSON.Send(ws, h.mapper.FromCommandResponseToEntityMessage(response)); err != nil {
+			l.Errorw("error while replying command", "command", cmd.Type, "error", err)
+		}
+	}
+}
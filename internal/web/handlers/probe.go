@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/flavioribeiro/donut/internal/controllers/engine"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"go.uber.org/zap"
+)
+
+type ProbeHandler struct {
+	l     *zap.SugaredLogger
+	donut *engine.DonutEngineController
+}
+
+func NewProbeHandler(l *zap.SugaredLogger, donut *engine.DonutEngineController) *ProbeHandler {
+	return &ProbeHandler{
+		l:     l,
+		donut: donut,
+	}
+}
+
+// ServeHTTP probes the source (GET /probe?streamURL=<url>&streamID=<id>), the stream id is taken from
+// the url when it's missing (the RTMP stream key or the SRT streamid).
+func (h *ProbeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return entities.ErrHTTPMethodNotAllowed
+	}
+
+	params := entities.RequestParams{
+		StreamURL: r.URL.Query().Get("streamURL"),
+		StreamID:  r.URL.Query().Get("streamID"),
+	}
+	report, err := h.donut.Probe(params)
+	if err != nil {
+		return err
+	}
+	h.l.Infof("ProbeReport %#v", report)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(report)
+}
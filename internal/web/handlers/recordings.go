@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+type RecordingsHandler struct {
+	recordings *sinks.RecordingController
+}
+
+func NewRecordingsHandler(recordings *sinks.RecordingController) *RecordingsHandler {
+	return &RecordingsHandler{
+		recordings: recordings,
+	}
+}
+
+// ServeHTTP lists the recordings (GET), or the one given by the id query parameter, starts
+// a recording of an active session (POST with the RecordingParams) and stops one (DELETE with the id).
+func (h *RecordingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	var result interface{}
+	switch r.Method {
+	case http.MethodGet:
+		if id := r.URL.Query().Get("id"); id != "" {
+			rec, ok := h.recordings.Get(id)
+			if !ok {
+				return fmt.Errorf("%w %s", entities.ErrMissingRecording, id)
+			}
+			result = rec
+		} else {
+			result = h.recordings.List()
+		}
+	case http.MethodPost:
+		params := entities.RecordingParams{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			return err
+		}
+		rec, err := h.recordings.Start(params)
+		if err != nil {
+			return err
+		}
+		result = rec
+	case http.MethodDelete:
+		rec, err := h.recordings.Stop(r.URL.Query().Get("id"))
+		if err != nil {
+			return err
+		}
+		result = rec
+	default:
+		return entities.ErrHTTPMethodNotAllowed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(result)
+}
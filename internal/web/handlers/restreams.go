@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+type RestreamsHandler struct {
+	restreams *sinks.RestreamController
+}
+
+func NewRestreamsHandler(restreams *sinks.RestreamController) *RestreamsHandler {
+	return &RestreamsHandler{
+		restreams: restreams,
+	}
+}
+
+// ServeHTTP lists the restreams (GET), or the one given by the id query parameter, starts relaying
+// a source to its destinations (POST with the RestreamParams) and stops one (DELETE with the id).
+func (h *RestreamsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	var result interface{}
+	switch r.Method {
+	case http.MethodGet:
+		if id := r.URL.Query().Get("id"); id != "" {
+			restream, ok := h.restreams.Get(id)
+			if !ok {
+				return fmt.Errorf("%w %s", entities.ErrMissingRestream, id)
+			}
+			result = restream
+		} else {
+			result = h.restreams.List()
+		}
+	case http.MethodPost:
+		params := entities.RestreamParams{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			return err
+		}
+		restream, err := h.restreams.Start(params)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("X-Donut-Session-ID", restream.SessionID)
+		result = restream
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if err := h.restreams.Stop(id); err != nil {
+			return fmt.Errorf("%w %s", err, id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		return entities.ErrHTTPMethodNotAllowed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(result)
+}
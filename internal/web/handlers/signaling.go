@@ -3,13 +3,18 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/flavioribeiro/donut/internal/controllers"
 	"github.com/flavioribeiro/donut/internal/controllers/engine"
+	"github.com/flavioribeiro/donut/internal/controllers/sinks"
 	"github.com/flavioribeiro/donut/internal/entities"
 	"github.com/flavioribeiro/donut/internal/mapper"
 	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
 )
 
 type SignalingHandler struct {
@@ -18,6 +23,8 @@ type SignalingHandler struct {
 	webRTCController *controllers.WebRTCController
 	mapper           *mapper.Mapper
 	donut            *engine.DonutEngineController
+	sessions         *controllers.SessionController
+	recordings       *sinks.RecordingController
 }
 
 func NewSignalingHandler(
@@ -26,6 +33,8 @@ func NewSignalingHandler(
 	webRTCController *controllers.WebRTCController,
 	mapper *mapper.Mapper,
 	donut *engine.DonutEngineController,
+	sessions *controllers.SessionController,
+	recordings *sinks.RecordingController,
 ) *SignalingHandler {
 	return &SignalingHandler{
 		c:                c,
@@ -33,54 +42,52 @@ func NewSignalingHandler(
 		webRTCController: webRTCController,
 		mapper:           mapper,
 		donut:            donut,
+		sessions:         sessions,
+		recordings:       recordings,
 	}
 }
 
 func (h *SignalingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		// the MSE sessions are signaled through the WebSocket they're streamed to,
+		// websocket.Handler rejects the handshakes without a valid Origin
+		websocket.Handler(h.serveMSE).ServeHTTP(w, r)
+		return nil
+	}
+
 	params, err := h.createAndValidateParams(r)
 	if err != nil {
 		return err
 	}
 	h.l.Infof("RequestParams %s", params.String())
 
-	donutEngine, err := h.donut.EngineFor(&params)
+	donutEngine, donutRecipe, err := h.prepareRecipe(&params)
 	if err != nil {
 		return err
 	}
-	h.l.Infof("DonutEngine %#v", donutEngine)
 
-	// server side media info
-	serverStreamInfo, err := donutEngine.ServerIngredients()
+	// We can't defer calling cancel here because it'll live alongside the stream.
+	ctx, cancel := context.WithCancel(context.Background())
+	webRTCResponse, err := h.webRTCController.Setup(cancel, donutRecipe, params)
 	if err != nil {
+		cancel()
 		return err
 	}
-	h.l.Infof("ServerIngredients %#v", serverStreamInfo)
+	h.l.Infof("WebRTCResponse %#v", webRTCResponse)
 
-	// client side media support
-	clientStreamInfo, err := donutEngine.ClientIngredients()
-	if err != nil {
-		return err
-	}
-	h.l.Infof("ClientIngredients %#v", clientStreamInfo)
+	commands := controllers.NewCommandRegistry()
+	h.webRTCController.HandleCommands(webRTCResponse, commands)
 
-	donutRecipe, err := donutEngine.RecipeFor(serverStreamInfo, clientStreamInfo)
-	if err != nil {
-		return err
-	}
-	h.l.Infof("DonutRecipe %#v", donutRecipe)
-
-	// We can't defer calling cancel here because it'll live alongside the stream.
-	ctx, cancel := context.WithCancel(context.Background())
-	webRTCResponse, err := h.webRTCController.Setup(cancel, donutRecipe, params)
+	session, err := h.createSession(params)
 	if err != nil {
 		cancel()
 		return err
 	}
-	h.l.Infof("WebRTCResponse %#v", webRTCResponse)
 
-	go donutEngine.Serve(&entities.DonutParameters{
-		Cancel: cancel,
-		Ctx:    ctx,
+	donutParameters := &entities.DonutParameters{
+		Cancel:    cancel,
+		Ctx:       ctx,
+		SessionID: session.ID,
 
 		Recipe: *donutRecipe,
 
@@ -94,15 +101,42 @@ func (h *SignalingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) err
 		OnStream: func(st *entities.Stream) error {
 			return h.webRTCController.SendMetadata(webRTCResponse.Data, st)
 		},
+		OnOutputStream: func(st *entities.OutputStream) error {
+			if err := session.OnOutputStream(*st); err != nil {
+				h.l.Warnw("error while sending the output stream to the sinks", "error", err)
+			}
+			return nil
+		},
 		OnVideoFrame: func(data []byte, c entities.MediaFrameContext) error {
+			h.sendToSinks(session, entities.VideoType, data, c)
 			return h.webRTCController.SendMediaSample(webRTCResponse.Video, data, c)
 		},
 		OnAudioFrame: func(data []byte, c entities.MediaFrameContext) error {
+			h.sendToSinks(session, entities.AudioType, data, c)
 			return h.webRTCController.SendMediaSample(webRTCResponse.Audio, data, c)
 		},
-	})
+		OnSpliceEvent: func(ev *entities.SpliceEvent) error {
+			return h.webRTCController.SendMessage(webRTCResponse.Data, h.mapper.FromSpliceEventToEntityMessage(*ev))
+		},
+		OnTimedMetadata: func(md *entities.TimedMetadata) error {
+			return h.webRTCController.SendMessage(webRTCResponse.Data, h.mapper.FromTimedMetadataToEntityMessage(*md))
+		},
+		OnCue: func(c *entities.Cue) error {
+			return h.webRTCController.SendMessage(webRTCResponse.Data, h.mapper.FromCueToEntityMessage(*c))
+		},
+		OnStats: func(st entities.StreamerStats) {
+			sessionStats := h.sessionStats(session.ID, st, webRTCResponse)
+			session.SetStats(sessionStats)
+			if err := h.webRTCController.SendStats(webRTCResponse.Data, sessionStats); err != nil {
+				h.l.Warnw("error while sending stats", "error", err)
+			}
+		},
+		RegisterCommand: commands.Register,
+	}
+	go h.serve(donutEngine, session, donutParameters)
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Donut-Session-ID", session.ID)
 	w.WriteHeader(http.StatusOK)
 
 	err = json.NewEncoder(w).Encode(*webRTCResponse.LocalSDP)
@@ -115,6 +149,81 @@ func (h *SignalingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) err
 	return nil
 }
 
+// prepareRecipe picks the engine for the request and prepares the recipe matching the server
+// and the client media.
+func (h *SignalingHandler) prepareRecipe(params *entities.RequestParams) (engine.DonutEngine, *entities.DonutRecipe, error) {
+	donutEngine, err := h.donut.EngineFor(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.l.Infof("DonutEngine %#v", donutEngine)
+
+	// server side media info
+	serverStreamInfo, err := donutEngine.ServerIngredients()
+	if err != nil {
+		return nil, nil, err
+	}
+	h.l.Infof("ServerIngredients %#v", serverStreamInfo)
+
+	// client side media support
+	clientStreamInfo, err := donutEngine.ClientIngredients()
+	if err != nil {
+		return nil, nil, err
+	}
+	h.l.Infof("ClientIngredients %#v", clientStreamInfo)
+
+	donutRecipe, err := donutEngine.RecipeFor(serverStreamInfo, clientStreamInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.l.Infof("DonutRecipe %#v", donutRecipe)
+	return donutEngine, donutRecipe, nil
+}
+
+// createSession creates the session and starts its recording, when requested.
+func (h *SignalingHandler) createSession(params entities.RequestParams) (*controllers.Session, error) {
+	session, err := h.sessions.Create(params)
+	if err != nil {
+		return nil, err
+	}
+	if params.Record != nil {
+		recordingParams := *params.Record
+		recordingParams.SessionID = session.ID
+		if _, err := h.recordings.Start(recordingParams); err != nil {
+			h.sessions.Remove(session.ID)
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// serve streams the session until it ends.
+func (h *SignalingHandler) serve(donutEngine engine.DonutEngine, session *controllers.Session, donutParameters *entities.DonutParameters) {
+	donutEngine.Serve(donutParameters)
+	if err := session.CloseSinks(); err != nil {
+		h.l.Errorw("error while closing the session sinks", "error", err)
+	}
+	h.sessions.Remove(session.ID)
+}
+
+// sendToSinks doesn't fail the streaming, the sinks (i.e. recordings) are secondary to the client.
+func (h *SignalingHandler) sendToSinks(session *controllers.Session, mediaType entities.MediaType, data []byte, c entities.MediaFrameContext) {
+	if err := session.OnFrame(mediaType, data, c); err != nil {
+		h.l.Warnw("error while sending the frame to the sinks", "error", err)
+	}
+}
+
+func (h *SignalingHandler) sessionStats(id string, st entities.StreamerStats, setup *entities.WebRTCSetupResponse) entities.SessionStats {
+	peer := h.webRTCController.PeerStats(setup)
+	return entities.SessionStats{
+		SessionID: id,
+		Timestamp: time.Now().UnixMilli(),
+		LatencyMS: float64(st.PipelineLatencyMS) + peer.RoundTripTimeMS/2,
+		Streamer:  st,
+		Peer:      peer,
+	}
+}
+
 func (h *SignalingHandler) createAndValidateParams(r *http.Request) (entities.RequestParams, error) {
 	if r.Method != http.MethodPost {
 		return entities.RequestParams{}, entities.ErrHTTPPostOnly
@@ -127,6 +236,9 @@ func (h *SignalingHandler) createAndValidateParams(r *http.Request) (entities.Re
 	if err := params.Valid(); err != nil {
 		return entities.RequestParams{}, err
 	}
+	if params.Output != "" && params.Output != entities.DonutWebRTC {
+		return entities.RequestParams{}, fmt.Errorf("%w %q at the signaling", entities.ErrUnsupportedOutput, params.Output)
+	}
 
 	return params, nil
 }
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/flavioribeiro/donut/internal/controllers/snapshots"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+type SnapshotHandler struct {
+	c         *entities.Config
+	snapshots *snapshots.SnapshotController
+}
+
+func NewSnapshotHandler(c *entities.Config, snapshots *snapshots.SnapshotController) *SnapshotHandler {
+	return &SnapshotHandler{
+		c:         c,
+		snapshots: snapshots,
+	}
+}
+
+// ServeHTTP returns a picture of the stream (GET /snapshot?streamURL=<url>&streamID=<id>), the format
+// (jpeg by default, png or webp), width and height query parameters are optional.
+func (h *SnapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return entities.ErrHTTPMethodNotAllowed
+	}
+
+	query := r.URL.Query()
+	params := entities.SnapshotParams{
+		StreamURL: query.Get("streamURL"),
+		StreamID:  query.Get("streamID"),
+		Format:    entities.SnapshotFormat(query.Get("format")),
+	}
+	if params.Format == "" {
+		params.Format = entities.SnapshotJPEG
+	}
+	for name, dimension := range map[string]*int{"width": &params.Width, "height": &params.Height} {
+		if v := query.Get(name); v != "" {
+			d, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%w %s=%q", entities.ErrInvalidSnapshotSize, name, v)
+			}
+			*dimension = d
+		}
+	}
+
+	snapshot, err := h.snapshots.Snapshot(params)
+	if err != nil {
+		return err
+	}
+
+	maxAge := time.Duration(h.c.SnapshotCacheTTLMS)*time.Millisecond - time.Since(snapshot.CapturedAt)
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Content-Type", snapshot.Format.ContentType())
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("Last-Modified", snapshot.CapturedAt.UTC().Format(http.TimeFormat))
+	if snapshot.SessionID != "" {
+		w.Header().Set("X-Donut-Session-ID", snapshot.SessionID)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(snapshot.Data)
+	return err
+}
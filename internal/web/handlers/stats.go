@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/flavioribeiro/donut/internal/controllers"
+	"github.com/flavioribeiro/donut/internal/entities"
+)
+
+type StatsHandler struct {
+	sessions *controllers.SessionController
+}
+
+func NewStatsHandler(sessions *controllers.SessionController) *StatsHandler {
+	return &StatsHandler{
+		sessions: sessions,
+	}
+}
+
+// ServeHTTP returns the latest stats of the session given by the id query parameter,
+// or the stats of all active sessions when it's absent. An unknown id is answered with a 404.
+func (h *StatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return entities.ErrHTTPGetOnly
+	}
+
+	var result interface{}
+	if id := r.URL.Query().Get("id"); id != "" {
+		s, ok := h.sessions.Get(id)
+		if !ok {
+			http.NotFound(w, r)
+			return nil
+		}
+		result = s.Stats()
+	} else {
+		stats := []entities.SessionStats{}
+		for _, s := range h.sessions.List() {
+			stats = append(stats, s.Stats())
+		}
+		result = stats
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(result)
+}
@@ -14,6 +14,12 @@ type ErrorHTTPHandler interface {
 func NewServeMux(
 	index *handlers.IndexHandler,
 	signaling *handlers.SignalingHandler,
+	stats *handlers.StatsHandler,
+	recordings *handlers.RecordingsHandler,
+	hls *handlers.HLSHandler,
+	restreams *handlers.RestreamsHandler,
+	snapshot *handlers.SnapshotHandler,
+	probe *handlers.ProbeHandler,
 	l *zap.SugaredLogger,
 ) *http.ServeMux {
 
@@ -25,6 +31,13 @@ func NewServeMux(
 	mux.Handle("/demo/", setHTTPNoCaching(http.StripPrefix("/demo/", fs)))
 
 	mux.Handle("/doSignaling", setCors(errorHandler(l, signaling)))
+	mux.Handle("/stats", setCors(errorHandler(l, stats)))
+	mux.Handle("/recordings", setCors(errorHandler(l, recordings)))
+	mux.Handle("/hls", setCors(errorHandler(l, hls)))
+	mux.Handle("/hls/", setCors(errorHandler(l, hls)))
+	mux.Handle("/restreams", setCors(errorHandler(l, restreams)))
+	mux.Handle("/snapshot", setCors(errorHandler(l, snapshot)))
+	mux.Handle("/probe", setCors(errorHandler(l, probe)))
 
 	return mux
 }
@@ -36,7 +49,7 @@ func setCors(next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
 			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
-			w.Header().Set("Access-Control-Expose-Headers", "Authorization")
+			w.Header().Set("Access-Control-Expose-Headers", "Authorization, X-Donut-Session-ID")
 		}
 		next.ServeHTTP(w, r)
 	})
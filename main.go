@@ -6,20 +6,26 @@ package main
 import (
 	"flag"
 	"net/http"
+	"os"
 
 	"github.com/flavioribeiro/donut/internal/web"
+	"github.com/pion/turn/v2"
 
 	"go.uber.org/fx"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "probe" {
+		os.Exit(probe(os.Args[2:]))
+	}
+
 	enableICEMux := false
 	flag.BoolVar(&enableICEMux, "enable-ice-mux", false, "Enable ICE Mux on :8081")
 	flag.Parse()
 
 	fx.New(
 		web.Dependencies(enableICEMux),
-		// Forcing the lifecycle initiation with NewHTTPServer
-		fx.Invoke(func(*http.Server) {}),
+		// Forcing the lifecycle initiation with NewHTTPServer and NewEmbeddedTURNServer
+		fx.Invoke(func(*http.Server, *turn.Server) {}),
 	).Run()
 }
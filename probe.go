@@ -0,0 +1,56 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/flavioribeiro/donut/internal/controllers/engine"
+	"github.com/flavioribeiro/donut/internal/entities"
+	"github.com/flavioribeiro/donut/internal/web"
+	"go.uber.org/fx"
+)
+
+// probe runs `donut probe [-stream-id <id>] <url>`, it prints the source probe report as JSON.
+func probe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	streamID := fs.String("stream-id", "", "SRT stream id or RTMP stream key, taken from the url when missing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: donut probe [-stream-id <id>] <url>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var report *entities.ProbeReport
+	var err error
+	app := fx.New(
+		web.Dependencies(false),
+		fx.NopLogger,
+		fx.Invoke(func(donut *engine.DonutEngineController) {
+			report, err = donut.Probe(entities.RequestParams{StreamURL: fs.Arg(0), StreamID: *streamID})
+		}),
+	)
+	if app.Err() != nil {
+		err = app.Err()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
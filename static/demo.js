@@ -4,11 +4,13 @@ window.metadataMessages = {}
 window.startSession = () => {
   let streamURL = document.getElementById('stream-url').value;
   let streamID = document.getElementById('stream-id').value;
+  let subtitleLanguage = document.getElementById('subtitle-language').value;
 
   setupWebRTC((pc, offer) => {
     let srtFullAddress = JSON.stringify({
       "streamURL": streamURL,
       "streamID": streamID,
+      "subtitleLanguage": subtitleLanguage,
       offer
     });
 
@@ -25,6 +27,114 @@ window.startSession = () => {
   });
 }
 
+// streams through a WebSocket as fragmented MP4, played with Media Source Extensions
+window.startMSESession = () => {
+  let streamURL = document.getElementById('stream-url').value;
+  let streamID = document.getElementById('stream-id').value;
+  let subtitleLanguage = document.getElementById('subtitle-language').value;
+
+  const scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+  const ws = new WebSocket(scheme + location.host + '/doSignaling');
+  ws.binaryType = 'arraybuffer';
+
+  const el = createVideoElement();
+  // the browsers only autoplay the muted media without a user gesture
+  el.muted = true;
+  const mediaSource = new MediaSource();
+  el.src = URL.createObjectURL(mediaSource);
+
+  // the init segments, each preceded by its mime type, and the fragments are appended in order
+  let sourceBuffer;
+  const queue = [];
+  const appendNext = () => {
+    if (mediaSource.readyState !== 'open' || queue.length === 0 || (sourceBuffer && sourceBuffer.updating)) {
+      return;
+    }
+    const next = queue[0];
+    if (typeof(next) === "string") {
+      queue.shift();
+      if (!sourceBuffer) {
+        sourceBuffer = mediaSource.addSourceBuffer(next);
+        // the timestamps start over along each init segment
+        sourceBuffer.mode = 'sequence';
+        sourceBuffer.onupdateend = appendNext;
+      } else if (sourceBuffer.changeType) {
+        sourceBuffer.changeType(next);
+      }
+      appendNext();
+      return;
+    }
+    if (!sourceBuffer) {
+      queue.shift();
+      log("dropping a fragment without its init segment", "error");
+      return;
+    }
+    queue.shift();
+    sourceBuffer.appendBuffer(next);
+  };
+  mediaSource.onsourceopen = appendNext;
+
+  ws.onopen = () => {
+    log("websocket is open");
+    ws.send(JSON.stringify({
+      "streamURL": streamURL,
+      "streamID": streamID,
+      "subtitleLanguage": subtitleLanguage,
+      "output": "mse"
+    }));
+    sendCommand(ws, "ping", { "ClientTime": Date.now() });
+  };
+  ws.onmessage = (event) => {
+    if (event.data instanceof ArrayBuffer) {
+      queue.push(event.data);
+      appendNext();
+      return;
+    }
+    let msg = JSON.parse(event.data);
+    if (msg.Type === "mediaSource") {
+      if (!MediaSource.isTypeSupported(msg.Message)) {
+        log("unsupported media source type " + msg.Message, "error");
+      }
+      queue.push(msg.Message);
+      appendNext();
+      return;
+    }
+    showMessage(msg);
+  };
+  ws.onclose = (e) => log("websocket is closed: " + e.code + " " + e.reason);
+  ws.onerror = () => log("websocket error", "error");
+}
+
+// renders the messages sent along the streaming (metadata, captions, stats, command responses)
+const showMessage = (msg) => {
+  if (msg.Type === "commandResponse" && msg.Payload.Type === "ping" && msg.Payload.Result) {
+    log("data channel rtt: " + (Date.now() - msg.Payload.Result.ClientTime) + "ms");
+    return;
+  }
+  if (msg.Message in metadataMessages) {
+    // avoid logging dup messages
+    return;
+  }
+
+  const el = document.createElement("p")
+  el.innerText = msg.Type.padEnd(8, ' ') + ": " + msg.Message
+
+  let metadata = document.getElementById('metadata');
+  metadata.insertBefore(el, metadata.firstChild);
+  metadataMessages[msg.Message] = true;
+}
+
+const createVideoElement = () => {
+  const el = document.createElement("video");
+  el.autoplay = true
+  el.controls = true;
+  el.width = "640";
+  el.height = "360";
+
+  document.getElementById('remoteVideos').appendChild(el);
+  return el;
+}
+
 const setupWebRTC = (setRemoteSDPfn) => {
   log("setting up web rtc");
   const pc = new RTCPeerConnection({
@@ -55,14 +165,8 @@ const setupWebRTC = (setRemoteSDPfn) => {
       return
     }
 
-    const el = document.createElement(event.track.kind);
+    const el = createVideoElement();
     el.srcObject = event.streams[0];
-    el.autoplay = true
-    el.controls = true;
-    el.width = "640";
-    el.height = "360";
-
-    document.getElementById('remoteVideos').appendChild(el);
   }
 
   pc.createDataChannel('metadata');
@@ -70,19 +174,16 @@ const setupWebRTC = (setRemoteSDPfn) => {
   pc.ondatachannel = (e) => {
     log("ondatachannel: " + JSON.stringify(e));
 
-    e.channel.onmessage = (event) => {
-      let msg = JSON.parse(event.data)
-      if (msg.Message in metadataMessages) {
-        // avoid logging dup messages
-        return;
-      }
-
-      const el = document.createElement("p")
-      el.innerText = msg.Type.padEnd(8, ' ') + ": " + msg.Message
+    const ping = () => sendCommand(e.channel, "ping", { "ClientTime": Date.now() });
+    // the channel might be open already once it's announced
+    if (e.channel.readyState === 'open') {
+      ping();
+    } else {
+      e.channel.onopen = ping;
+    }
 
-      let metadata = document.getElementById('metadata');
-      metadata.insertBefore(el, metadata.firstChild);
-      metadataMessages[msg.Message] = true;
+    e.channel.onmessage = (event) => {
+      showMessage(JSON.parse(event.data));
     };
   };
 
@@ -105,6 +206,19 @@ const setupWebRTC = (setRemoteSDPfn) => {
     }).catch(log, "error");
 }
 
+// sends a command (ping, pause, resume, requestKeyframe, changeQuality, switchAudioTrack) to donut,
+// through the data channel or the MSE WebSocket
+let commandID = 0
+const sendCommand = (channel, type, args = {}) => {
+  commandID++;
+  channel.send(JSON.stringify({
+    "Version": 1,
+    "ID": commandID.toString(),
+    "Type": type,
+    "Args": args
+  }));
+}
+
 const fetchRemoteDescription = async (bodyRequest) => {
   log("requesting remote sdp offer for: " + bodyRequest)
 